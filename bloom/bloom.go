@@ -0,0 +1,168 @@
+// Package bloom provides a Bloom filter of the hashes in the index, persisted
+// in the database, to tell quickly whether some content is certainly not in
+// the index (and needs no lookup) or is likely to be.
+package bloom
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// FalsePositiveRate is the rate of false positives filters are sized for.
+const FalsePositiveRate = 0.01
+
+// minCapacity is the minimum number of hashes filters are sized for.
+const minCapacity = 1 << 16
+
+// Filter is a Bloom filter of hashes; it is safe for concurrent use.
+type Filter struct {
+	mutex    sync.RWMutex
+	bits     []uint64
+	hashes   int
+	capacity int
+	count    int
+	// rowid is the highest entry rowid the filter accounts for.
+	rowid int64
+}
+
+// New returns an empty filter sized for the given number of hashes.
+func New(capacity int) *Filter {
+	capacity = max(capacity, minCapacity)
+	m := int(math.Ceil(-float64(capacity) * math.Log(FalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	return &Filter{
+		bits:     make([]uint64, (m+63)/64),
+		hashes:   max(k, 1),
+		capacity: capacity,
+	}
+}
+
+// positions returns the two base hashes the bit positions are derived from.
+func positions(hash string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(hash))
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+// Add adds a hash to the filter.
+func (f *Filter) Add(hash string) {
+	a, b := positions(hash)
+	n := uint64(len(f.bits) * 64)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := 0; i < f.hashes; i++ {
+		bit := (a + uint64(i)*b) % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// Test returns false if the hash is certainly not in the filter, true if it
+// probably is.
+func (f *Filter) Test(hash string) bool {
+	a, b := positions(hash)
+	n := uint64(len(f.bits) * 64)
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	for i := 0; i < f.hashes; i++ {
+		bit := (a + uint64(i)*b) % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the memory taken by the filter, in bytes.
+func (f *Filter) Size() int64 {
+	return int64(len(f.bits) * 8)
+}
+
+// Load returns the filter of the hashes in the index: the one stored in the
+// database if it accounts for all the entries and is not overfull, otherwise
+// a new one built from the entries (and stored).
+func Load(db *sql.DB) (*Filter, error) {
+	var latest sql.NullInt64
+	var entries int
+	if err := db.QueryRow("select max(rowid), count(*) from entries").Scan(&latest, &entries); err != nil {
+		slog.Error("error reading entries count", "error", err)
+		return nil, err
+	}
+	f := &Filter{}
+	var data []byte
+	err := db.QueryRow("select capacity, hashes, data, rowid from bloom where id = 1").Scan(&f.capacity, &f.hashes, &data, &f.rowid)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		slog.Debug("no Bloom filter in the database")
+	case err != nil:
+		slog.Error("error reading Bloom filter", "error", err)
+		return nil, err
+	case f.rowid == latest.Int64 && entries <= f.capacity && len(data)%8 == 0:
+		f.bits = make([]uint64, len(data)/8)
+		for i := range f.bits {
+			f.bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+		}
+		f.count = entries
+		slog.Debug("Bloom filter loaded", "capacity", f.capacity, "entries", entries)
+		return f, nil
+	default:
+		slog.Debug("Bloom filter is stale", "rowid", f.rowid, "latest", latest.Int64, "capacity", f.capacity, "entries", entries)
+	}
+	return rebuild(db, entries)
+}
+
+// rebuild builds a new filter from the entries, with room for them to double,
+// and stores it.
+func rebuild(db *sql.DB, entries int) (*Filter, error) {
+	start := time.Now()
+	f := New(entries * 2)
+	rows, err := db.Query("select distinct hash from entries")
+	if err != nil {
+		slog.Error("error reading hashes", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			slog.Error("error reading hash", "error", err)
+			return nil, err
+		}
+		f.Add(hash)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading hashes", "error", err)
+		return nil, err
+	}
+	slog.Debug("Bloom filter rebuilt", "capacity", f.capacity, "hashes", f.count, "elapsed", time.Since(start))
+	return f, Save(db, f)
+}
+
+// Save stores the filter in the database, as accounting for all the entries
+// currently in the index.
+func Save(db *sql.DB, f *Filter) error {
+	f.mutex.RLock()
+	data := make([]byte, len(f.bits)*8)
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(data[i*8:], word)
+	}
+	f.mutex.RUnlock()
+	var latest sql.NullInt64
+	if err := db.QueryRow("select max(rowid) from entries").Scan(&latest); err != nil {
+		slog.Error("error reading entries count", "error", err)
+		return err
+	}
+	f.rowid = latest.Int64
+	if _, err := db.Exec("insert or replace into bloom(id, capacity, hashes, data, rowid, updated) values(1, ?, ?, ?, ?, ?)", f.capacity, f.hashes, data, f.rowid, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		slog.Error("error storing Bloom filter", "error", err)
+		return err
+	}
+	return nil
+}
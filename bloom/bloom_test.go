@@ -0,0 +1,105 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dihedron/dedup/internal/testutil"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is applied to the index after the filter is stored.
+		change string
+		// stale is whether the stored filter should be rebuilt.
+		stale bool
+	}{
+		{name: "unchanged", stale: false},
+		{name: "no filter", change: "delete from bloom", stale: true},
+		{name: "entry added", change: "insert into entries(hash, path, bucket, size) values('new', '/new', 'b', 1)", stale: true},
+		{name: "entry replaced", change: "insert or replace into entries(hash, path, bucket, size) values('hash0', '/file0', 'b', 2)", stale: true},
+		{name: "latest entry deleted", change: "delete from entries where rowid = (select max(rowid) from entries)", stale: true},
+		{name: "other entry deleted", change: "delete from entries where rowid = (select min(rowid) from entries)", stale: false},
+		{name: "overfull", change: "update bloom set capacity = 1", stale: true},
+		{name: "corrupt", change: "update bloom set data = x'00'", stale: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			for i := 0; i < 10; i++ {
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size) values(?, ?, 'b', 1)", fmt.Sprintf("hash%d", i), fmt.Sprintf("/file%d", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f, err := Load(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if !f.Test(fmt.Sprintf("hash%d", i)) {
+					t.Fatalf("hash%d not in rebuilt filter", i)
+				}
+			}
+			// clear the stored filter, so that loading it is told apart from
+			// rebuilding it
+			if _, err := db.Exec("update bloom set data = zeroblob(length(data))"); err != nil {
+				t.Fatal(err)
+			}
+			if test.change != "" {
+				if _, err := db.Exec(test.change); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f, err = Load(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rebuilt := f.Test("hash5"); rebuilt != test.stale {
+				t.Errorf("expected rebuilt %t, got %t", test.stale, rebuilt)
+			}
+			if test.stale {
+				var rowid, latest int64
+				if err := db.QueryRow("select b.rowid, (select max(rowid) from entries) from bloom b").Scan(&rowid, &latest); err != nil {
+					t.Fatal(err)
+				}
+				if rowid != latest {
+					t.Errorf("expected rebuilt filter stored at rowid %d, got %d", latest, rowid)
+				}
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		hashes   int
+	}{
+		{name: "minimum capacity", capacity: 0, hashes: 1000},
+		{name: "large capacity", capacity: 200000, hashes: 100000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := New(test.capacity)
+			for i := 0; i < test.hashes; i++ {
+				f.Add(fmt.Sprintf("in%d", i))
+			}
+			for i := 0; i < test.hashes; i++ {
+				if !f.Test(fmt.Sprintf("in%d", i)) {
+					t.Fatalf("false negative for in%d", i)
+				}
+			}
+			positives := 0
+			for i := 0; i < test.hashes; i++ {
+				if f.Test(fmt.Sprintf("out%d", i)) {
+					positives++
+				}
+			}
+			if rate := float64(positives) / float64(test.hashes); rate > 2*FalsePositiveRate {
+				t.Errorf("false positive rate %f above %f", rate, 2*FalsePositiveRate)
+			}
+		})
+	}
+}
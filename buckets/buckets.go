@@ -0,0 +1,160 @@
+// Package buckets manages the metadata of the buckets in the index (the hash
+// algorithm, root paths and options they were indexed with), so that only
+// comparable buckets are compared or merged.
+package buckets
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Metadata describes a bucket.
+type Metadata struct {
+	// Name is the name of the bucket.
+	Name string `json:"name"`
+	// Reference is whether the bucket is a read-only reference.
+	Reference bool `json:"reference"`
+	// Algorithm is the hash algorithm used to detect duplicates in the bucket;
+	// it is empty if the bucket was indexed before it was recorded.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Roots are the paths that were indexed into the bucket.
+	Roots []string `json:"roots,omitempty"`
+	// Options are the indexing options the bucket was created with.
+	Options map[string]any `json:"options,omitempty"`
+	// Created is when the bucket was first indexed.
+	Created string `json:"created,omitempty"`
+	// Updated is when the bucket was last indexed.
+	Updated string `json:"updated,omitempty"`
+}
+
+// ErrIncompatible is returned when buckets indexed with different hash
+// algorithms are compared or merged.
+var ErrIncompatible = errors.New("incompatible buckets")
+
+// Load returns the metadata of the given bucket, or nil if the bucket has no
+// metadata.
+func Load(db *sql.DB, name string) (*Metadata, error) {
+	metadata := &Metadata{Name: name}
+	var roots, options string
+	err := db.QueryRow("select reference, coalesce(algorithm, ''), coalesce(roots, ''), coalesce(options, ''), coalesce(created, ''), coalesce(updated, '') from buckets where name = ?", name).
+		Scan(&metadata.Reference, &metadata.Algorithm, &roots, &options, &metadata.Created, &metadata.Updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		slog.Error("error reading bucket metadata", "bucket", name, "error", err)
+		return nil, err
+	}
+	if roots != "" {
+		if err := json.Unmarshal([]byte(roots), &metadata.Roots); err != nil {
+			slog.Error("error parsing bucket roots", "bucket", name, "error", err)
+			return nil, err
+		}
+	}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &metadata.Options); err != nil {
+			slog.Error("error parsing bucket options", "bucket", name, "error", err)
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// Register records that the given roots are being indexed into the bucket
+// with the given hash algorithm and options; it fails if the bucket already
+// holds entries hashed with a different algorithm. The options are only
+// recorded when the bucket is first indexed.
+func Register(db *sql.DB, name string, algorithm string, roots []string, options map[string]any) error {
+	metadata, err := Load(db, name)
+	if err != nil {
+		return err
+	}
+	if metadata != nil && metadata.Algorithm != "" && metadata.Algorithm != algorithm {
+		err := fmt.Errorf("%w: bucket %s was indexed with %s, not %s", ErrIncompatible, name, metadata.Algorithm, algorithm)
+		slog.Error("hash algorithm does not match bucket", "bucket", name, "bucket algorithm", metadata.Algorithm, "algorithm", algorithm, "error", err)
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if metadata == nil {
+		metadata = &Metadata{Name: name}
+	}
+	if metadata.Created == "" {
+		metadata.Created = now
+		metadata.Options = options
+	}
+	for _, root := range roots {
+		if !contains(metadata.Roots, root) {
+			metadata.Roots = append(metadata.Roots, root)
+		}
+	}
+	sort.Strings(metadata.Roots)
+	data, err := json.Marshal(metadata.Roots)
+	if err != nil {
+		slog.Error("error marshalling bucket roots", "bucket", name, "error", err)
+		return err
+	}
+	settings, err := json.Marshal(metadata.Options)
+	if err != nil {
+		slog.Error("error marshalling bucket options", "bucket", name, "error", err)
+		return err
+	}
+	_, err = db.Exec(`insert into buckets(name, algorithm, roots, options, created, updated) values(?, ?, ?, ?, ?, ?)
+		on conflict(name) do update set algorithm = excluded.algorithm, roots = excluded.roots, options = excluded.options, created = excluded.created, updated = excluded.updated`,
+		name, algorithm, string(data), string(settings), metadata.Created, now)
+	if err != nil {
+		slog.Error("error saving bucket metadata", "bucket", name, "error", err)
+	}
+	return err
+}
+
+// Compatible checks that the given buckets (all the buckets having entries,
+// if none is given) were indexed with the same hash algorithm, so that their
+// hashes can be compared; buckets with no recorded algorithm are assumed to
+// be compatible with any other.
+func Compatible(db *sql.DB, names ...string) error {
+	query := "select name, algorithm from buckets where algorithm is not null and name in (select distinct bucket from entries) order by name"
+	params := []any{}
+	if len(names) > 0 {
+		query = "select name, algorithm from buckets where algorithm is not null and name in (?" + strings.Repeat(", ?", len(names)-1) + ") order by name"
+		for _, name := range names {
+			params = append(params, name)
+		}
+	}
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		slog.Error("error querying bucket algorithms", "error", err)
+		return err
+	}
+	defer rows.Close()
+	first, algorithm := "", ""
+	for rows.Next() {
+		var name, current string
+		if err := rows.Scan(&name, &current); err != nil {
+			slog.Error("error reading bucket algorithm", "error", err)
+			return err
+		}
+		if first == "" {
+			first, algorithm = name, current
+		} else if current != algorithm {
+			err := fmt.Errorf("%w: bucket %s was indexed with %s, bucket %s with %s", ErrIncompatible, first, algorithm, name, current)
+			slog.Error("buckets indexed with different hash algorithms", "error", err)
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// contains returns whether the given value is in the slice.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
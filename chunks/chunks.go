@@ -0,0 +1,97 @@
+// Package chunks splits contents into variable-size, content-defined chunks,
+// the way chunk-based backup tools (e.g. restic, borg) do, so that identical
+// runs of bytes are found even when they are not aligned across files.
+package chunks
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// Options are the chunk size bounds; the average is only a target, and must
+// be a power of two.
+type Options struct {
+	Min     int
+	Average int
+	Max     int
+}
+
+// DefaultOptions are close to what restic uses.
+var DefaultOptions = Options{
+	Min:     512 * 1024,
+	Average: 1024 * 1024,
+	Max:     8 * 1024 * 1024,
+}
+
+// ErrInvalidOptions is returned when the chunk size bounds are inconsistent.
+var ErrInvalidOptions = errors.New("invalid chunk size bounds")
+
+// Validate checks that the chunk size bounds are consistent.
+func (o Options) Validate() error {
+	if o.Min <= 0 || o.Min > o.Average || o.Average > o.Max || o.Average&(o.Average-1) != 0 {
+		return ErrInvalidOptions
+	}
+	return nil
+}
+
+// gear is the table of random values the rolling hash is computed with; it is
+// generated deterministically, so that chunk boundaries are reproducible.
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	seed := uint64(0x6465647570)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunker reads contents and returns them one chunk at a time.
+type Chunker struct {
+	reader  *bufio.Reader
+	options Options
+	mask    uint64
+	buffer  []byte
+}
+
+// New returns a Chunker reading from r.
+func New(r io.Reader, options Options) (*Chunker, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return &Chunker{
+		reader:  bufio.NewReaderSize(r, 64*1024),
+		options: options,
+		mask:    uint64(options.Average - 1),
+		buffer:  make([]byte, 0, options.Max),
+	}, nil
+}
+
+// Next returns the next chunk, which is only valid until the following call,
+// or io.EOF when there are no more chunks.
+func (c *Chunker) Next() ([]byte, error) {
+	c.buffer = c.buffer[:0]
+	var hash uint64
+	for len(c.buffer) < c.options.Max {
+		b, err := c.reader.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		c.buffer = append(c.buffer, b)
+		hash = (hash << 1) + gear[b]
+		if len(c.buffer) >= c.options.Min && hash&c.mask == 0 {
+			break
+		}
+	}
+	if len(c.buffer) == 0 {
+		return nil, io.EOF
+	}
+	return c.buffer, nil
+}
@@ -0,0 +1,124 @@
+package accept
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Accept is the command that marks duplicate groups as accepted, so that they
+// no longer show up in reports nor are acted upon; the decision is recorded
+// along with who took it, when and why.
+type Accept struct {
+	base.Command
+	base.Database
+	// Groups are the identifiers of the duplicate groups to accept.
+	Groups []int64 `short:"G" long:"group" description:"The identifier of the duplicate group to accept (repeatable)." optional:"true"`
+	// Reason is why the groups are accepted.
+	Reason string `short:"r" long:"reason" description:"Why the duplicates are accepted." optional:"true"`
+	// Revoke removes the groups from the accepted ones.
+	Revoke bool `long:"revoke" description:"Revoke the acceptance of the given groups, so that they are reported again." optional:"true"`
+}
+
+// Info describes an accepted group.
+type Info struct {
+	Group   int64  `json:"group"`
+	Hash    string `json:"hash"`
+	User    string `json:"user,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Created string `json:"created"`
+}
+
+// Execute is the real implementation of the Accept command; with no groups,
+// it lists the accepted ones.
+func (cmd *Accept) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running accept command", "database", cmd.Database, "groups", cmd.Groups, "revoke", cmd.Revoke)
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if cmd.Revoke && len(cmd.Groups) == 0 {
+		err := errors.New("no groups to revoke")
+		slog.Error("nothing to revoke", "error", err)
+		return err
+	}
+
+	for _, id := range cmd.Groups {
+		if cmd.Revoke {
+			if _, err := db.Exec("delete from accepted where group_id = ?", id); err != nil {
+				slog.Error("error revoking accepted group", "group", id, "error", err)
+				return err
+			}
+			slog.Info("group acceptance revoked", "group", id)
+			continue
+		}
+		var count int
+		if err := db.QueryRow("select count(*) from duplicate_groups where id = ?", id).Scan(&count); err != nil {
+			slog.Error("error looking up duplicate group", "group", id, "error", err)
+			return err
+		}
+		if count == 0 {
+			err := fmt.Errorf("unknown duplicate group %d", id)
+			slog.Error("invalid group", "error", err)
+			return err
+		}
+		if _, err := db.Exec("insert into accepted(group_id, user, reason, created) values(?, ?, nullif(?, ''), ?) on conflict(group_id) do update set user = excluded.user, reason = excluded.reason, created = excluded.created",
+			id, username(), cmd.Reason, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			slog.Error("error accepting group", "group", id, "error", err)
+			return err
+		}
+		slog.Info("group accepted", "group", id, "reason", cmd.Reason)
+	}
+	if len(cmd.Groups) > 0 {
+		slog.Debug("command done")
+		return nil
+	}
+
+	rows, err := db.Query("select a.group_id, g.hash, coalesce(a.user, ''), coalesce(a.reason, ''), a.created from accepted a join duplicate_groups g on g.id = a.group_id order by a.group_id")
+	if err != nil {
+		slog.Error("error querying accepted groups", "error", err)
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		info := &Info{}
+		if err := rows.Scan(&info.Group, &info.Hash, &info.User, &info.Reason, &info.Created); err != nil {
+			slog.Error("error reading accepted group", "error", err)
+			return err
+		}
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(info)
+			if err != nil {
+				slog.Error("error marshalling accepted group to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else {
+			cmd.Output().Printf("#%d\t%s\t%s\t%s\t%s\n", info.Group, info.Created, info.User, info.Hash, info.Reason)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading accepted groups", "error", err)
+		return err
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// username returns the name of the user running the command.
+func username() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,73 @@
+package analyze
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Analyze is the command that opens the index database with DuckDB, through
+// its SQLite extension, for much faster aggregation queries on very large
+// indexes; the database is attached read-only as the default schema, so the
+// same tables and views as with the query command are available.
+type Analyze struct {
+	base.Command
+	base.Database
+	// DuckDB is the path to the DuckDB command line client.
+	DuckDB string `long:"duckdb" description:"The DuckDB command line client to run." optional:"true" default:"duckdb"`
+	// Arguments are the SQL statements to run; the interactive shell is
+	// started if none is given.
+	Arguments struct {
+		SQL []string `positional-arg-name:"sql" description:"The SQL statements to run (interactive shell if none)."`
+	} `positional-args:"yes"`
+}
+
+// Execute is the real implementation of the Analyze command.
+func (cmd *Analyze) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running analyze command", "database", cmd.Database, "duckdb", cmd.DuckDB)
+
+	client, err := exec.LookPath(cmd.DuckDB)
+	if err != nil {
+		err = fmt.Errorf("DuckDB client %q not found, install it from https://duckdb.org or use --duckdb: %w", cmd.DuckDB, err)
+		slog.Error("error locating DuckDB client", "error", err)
+		return err
+	}
+	path, err := filepath.Abs(cmd.Database.Database)
+	if err != nil {
+		slog.Error("error resolving database path", "path", cmd.Database.Database, "error", err)
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		slog.Error("error accessing database", "path", path, "error", err)
+		return err
+	}
+
+	setup := fmt.Sprintf("INSTALL sqlite; LOAD sqlite; ATTACH '%s' AS dedup (TYPE sqlite, READ_ONLY); USE dedup;", strings.ReplaceAll(path, "'", "''"))
+	arguments := []string{"-cmd", setup}
+	if cmd.AutomationFriendly {
+		arguments = append(arguments, "-json")
+	}
+	if len(cmd.Arguments.SQL) > 0 {
+		arguments = append(arguments, "-c", strings.Join(cmd.Arguments.SQL, " "))
+	}
+	c := exec.Command(client, arguments...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			slog.Error("error running DuckDB client", "error", err)
+		}
+		return err
+	}
+	slog.Debug("command done")
+	return nil
+}
@@ -0,0 +1,79 @@
+package archive
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dihedron/dedup/commands/index"
+)
+
+// Archive is the command that maintains content-addressed archives: each
+// distinct content is stored once, as an object named by its hash, and the
+// original paths referring to it are recorded in the index, so that whole
+// trees can be restored from the archive.
+type Archive struct {
+	// Store copies the indexed files into an archive.
+	Store Store `command:"store" description:"Copy the unique contents of the indexed files into a content-addressed archive."`
+	// Restore recreates files from an archive.
+	Restore Restore `command:"restore" description:"Recreate archived files from a content-addressed archive."`
+}
+
+// objectPath returns the path of the object holding the content with the given
+// hash in the archive; objects are spread across subdirectories named after
+// the first two characters of the hash, to keep directories small.
+func objectPath(store string, algorithm string, hash string) string {
+	prefix := hash
+	if len(prefix) > 2 {
+		prefix = prefix[:2]
+	}
+	return filepath.Join(store, "objects", algorithm, prefix, hash)
+}
+
+// transfer copies source to target, which must not exist, checking that the
+// content has the expected hash; the target is written under a temporary name
+// and only renamed into place once verified, so that an interrupted or failed
+// copy never leaves a corrupted file behind.
+func transfer(source string, target string, algorithm string, hash string, mode os.FileMode) (int64, error) {
+	h, err := index.NewHasher(algorithm)
+	if err != nil {
+		return 0, err
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return 0, err
+	}
+	temporary := out.Name()
+	size, err := io.Copy(io.MultiWriter(out, h), in)
+	if err == nil {
+		err = out.Chmod(mode)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != hash {
+		err = fmt.Errorf("content of %s does not match hash %s", source, hash)
+	}
+	if err == nil {
+		if _, serr := os.Lstat(target); serr == nil {
+			err = fmt.Errorf("file %s already exists", target)
+		} else {
+			err = os.Rename(temporary, target)
+		}
+	}
+	if err != nil {
+		os.Remove(temporary)
+		return 0, err
+	}
+	return size, nil
+}
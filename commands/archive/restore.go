@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+)
+
+// Restore is the command that recreates archived files from a content-addressed
+// archive, under a target directory.
+type Restore struct {
+	base.Command
+	base.Database
+	// Archive is the directory of the archive.
+	Archive string `short:"a" long:"archive" description:"The directory of the content-addressed archive." required:"true"`
+	// Target is the directory files are restored into.
+	Target string `short:"t" long:"target" description:"The directory to restore files into; files are recreated at their original path under it, less the prefix." required:"true"`
+	// Prefix restricts the restore to the files under the given original path.
+	Prefix string `short:"p" long:"prefix" description:"Only restore the files originally under this path, and strip it from the restored paths." optional:"true"`
+	// Link hardlinks the restored files with identical contents to each other.
+	Link bool `short:"l" long:"link" description:"Hardlink restored files having the same contents instead of copying each of them." optional:"true"`
+}
+
+// RestoreSummary contains the outcome of a restore.
+type RestoreSummary struct {
+	Restored int   `json:"restored"`
+	Linked   int   `json:"linked"`
+	Bytes    int64 `json:"bytes"`
+	Failed   int   `json:"failed"`
+}
+
+// Execute is the real implementation of the Restore command.
+func (cmd *Restore) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running archive restore command", "database", cmd.Database, "archive", cmd.Archive, "target", cmd.Target, "prefix", cmd.Prefix)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	store, err := filepath.Abs(cmd.Archive)
+	if err != nil {
+		slog.Error("error resolving archive directory", "path", cmd.Archive, "error", err)
+		return err
+	}
+	prefix := ""
+	if cmd.Prefix != "" {
+		if prefix, err = filepath.Abs(cmd.Prefix); err != nil {
+			slog.Error("error resolving prefix", "path", cmd.Prefix, "error", err)
+			return err
+		}
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select path, algorithm, hash, coalesce(mode, 420), coalesce(modified, '') from archived where store = ? order by hash, path", store)
+	if err != nil {
+		slog.Error("error querying archived files", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	summary := &RestoreSummary{}
+	restored := map[string]string{}
+	for rows.Next() {
+		var path, algorithm, hash, modified string
+		var mode int64
+		if err := rows.Scan(&path, &algorithm, &hash, &mode, &modified); err != nil {
+			slog.Error("error reading archived file", "error", err)
+			return err
+		}
+		// files archived by older versions may be recorded with paths
+		// relative to the directory the index was built from
+		if prefix != "" && !duplicates.Under(path, prefix) {
+			continue
+		}
+		relative := path
+		if prefix != "" {
+			relative = strings.TrimPrefix(duplicates.Absolute(path), prefix)
+			if relative == "" {
+				relative = filepath.Base(path)
+			}
+		}
+		target := filepath.Join(cmd.Target, relative)
+		if existing, ok := restored[hash]; ok && cmd.Link {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Link(existing, target)
+			}
+			if err == nil {
+				summary.Linked++
+				continue
+			}
+			slog.Warn("error linking restored file, copying instead", "existing", existing, "target", target, "error", err)
+		}
+		size, err := transfer(objectPath(store, algorithm, hash), target, algorithm, hash, os.FileMode(mode).Perm())
+		if err != nil {
+			slog.Error("error restoring file", "path", path, "target", target, "error", err)
+			summary.Failed++
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, modified); err == nil {
+			os.Chtimes(target, t, t)
+		}
+		restored[hash] = target
+		summary.Restored++
+		summary.Bytes += size
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading archived files", "error", err)
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("restored %d files (%d bytes), linked %d, %d failed\n", summary.Restored, summary.Bytes, summary.Linked, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+)
+
+// Store is the command that copies the indexed files into a content-addressed
+// archive; contents already in the archive are not copied again, so running it
+// periodically only adds what changed.
+type Store struct {
+	base.Command
+	base.Database
+	// Archive is the directory of the archive.
+	Archive string `short:"a" long:"archive" description:"The directory of the content-addressed archive." required:"true"`
+	// Bucket restricts the archival to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose entries should be archived (all buckets if not specified)." optional:"true"`
+}
+
+// StoreSummary contains the outcome of an archival run.
+type StoreSummary struct {
+	Objects    int   `json:"objects"`
+	Bytes      int64 `json:"bytes"`
+	Existing   int   `json:"existing"`
+	References int   `json:"references"`
+	Failed     int   `json:"failed"`
+}
+
+// Execute is the real implementation of the Store command.
+func (cmd *Store) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running archive store command", "database", cmd.Database, "archive", cmd.Archive, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	store, err := filepath.Abs(cmd.Archive)
+	if err != nil {
+		slog.Error("error resolving archive directory", "path", cmd.Archive, "error", err)
+		return err
+	}
+	if err := os.MkdirAll(store, 0755); err != nil {
+		slog.Error("error creating archive directory", "path", store, "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query := "select hash, path, coalesce(size, 0), coalesce(modified, ''), coalesce((select algorithm from buckets where name = entries.bucket), 'sha256') from entries"
+	params := []any{}
+	if cmd.Bucket != "" {
+		query += " where bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" order by hash, path", params...)
+	if err != nil {
+		slog.Error("error querying entries", "error", err)
+		return err
+	}
+	type entry struct {
+		hash      string
+		path      string
+		size      int64
+		modified  string
+		algorithm string
+	}
+	entries := []*entry{}
+	for rows.Next() {
+		e := &entry{}
+		if err := rows.Scan(&e.hash, &e.path, &e.size, &e.modified, &e.algorithm); err != nil {
+			rows.Close()
+			slog.Error("error reading entry", "error", err)
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading entries", "error", err)
+		return err
+	}
+
+	summary := &StoreSummary{}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, e := range entries {
+		info, err := os.Stat(e.path)
+		if err != nil || !info.Mode().IsRegular() {
+			slog.Warn("skipping file no longer on disk", "path", e.path, "error", err)
+			summary.Failed++
+			continue
+		}
+		object := objectPath(store, e.algorithm, e.hash)
+		if _, err := os.Stat(object); err == nil {
+			// the file must still have the archived content to refer to it
+			if _, err := index.Verify(e.path, e.algorithm, e.hash, e.size); err != nil {
+				slog.Warn("file changed since indexing, skipping", "path", e.path, "hash", e.hash, "error", err)
+				summary.Failed++
+				continue
+			}
+			summary.Existing++
+		} else {
+			size, err := transfer(e.path, object, e.algorithm, e.hash, 0444)
+			if err != nil {
+				// the file may have changed since it was indexed: another
+				// path with the same hash may still provide the content
+				slog.Warn("error storing object", "path", e.path, "hash", e.hash, "error", err)
+				summary.Failed++
+				continue
+			}
+			summary.Objects++
+			summary.Bytes += size
+		}
+		modified := e.modified
+		if modified == "" {
+			modified = info.ModTime().UTC().Format(time.RFC3339)
+		}
+		if err := record(db, store, duplicates.Absolute(e.path), e.algorithm, e.hash, e.size, info.Mode().Perm(), modified, now); err != nil {
+			return err
+		}
+		summary.References++
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("stored %d new objects (%d bytes), %d already archived, %d references recorded, %d failed\n", summary.Objects, summary.Bytes, summary.Existing, summary.References, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// record adds the reference from the original absolute path to the archived
+// object.
+func record(db *sql.DB, store string, path string, algorithm string, hash string, size int64, mode os.FileMode, modified string, archived string) error {
+	_, err := db.Exec("insert or replace into archived(store, path, algorithm, hash, size, mode, modified, archived) values(?, ?, ?, ?, ?, ?, ?, ?)", store, path, algorithm, hash, size, int64(mode), modified, archived)
+	if err != nil {
+		slog.Error("error recording archived file", "path", path, "error", err)
+	}
+	return err
+}
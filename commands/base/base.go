@@ -1,6 +1,7 @@
 package base
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log/slog"
@@ -8,27 +9,68 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/locale"
+	"github.com/dihedron/dedup/plugins"
 )
 
 type Command struct {
 	// LogLevel sets the verbosity level of the application logging.
-	LogLevel string `short:"L" long:"log-level" description:"The level of logging produced by the application." optional:"yes" choice:"off" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"warn" env:"CLOUDCTL_LOG_LEVEL"`
+	LogLevel string `short:"L" long:"log-level" description:"The level of logging produced by the application." optional:"yes" choice:"off" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"warn"`
+	// Quiet only logs errors, overriding the log level.
+	Quiet bool `short:"q" long:"quiet" description:"Only log errors (the command output and summary are still printed)." optional:"yes"`
+	// Verbose raises the log level, overriding it: once for informational
+	// messages, twice for debugging ones.
+	Verbose []bool `short:"v" long:"verbose" description:"Log more: once for informational messages (-v), twice for debugging ones (-vv)." optional:"yes"`
 	// LogStream is the output channel to use for logging.
-	LogStream string `short:"S" long:"log-stream" description:"The output stream to use for logging." optional:"yes" choice:"stdout" choice:"stderr" choice:"file" choice:"none" default:"stderr" env:"CLOUDCTL_LOG_STREAM"`
+	LogStream string `short:"S" long:"log-stream" description:"The output stream to use for logging." optional:"yes" choice:"stdout" choice:"stderr" choice:"file" choice:"syslog" choice:"journald" choice:"none" default:"stderr"`
+	// LogFile is the path of the log file.
+	LogFile string `long:"log-file" description:"The path of the log file (implies --log-stream=file)." optional:"yes"`
+	// LogMaxSize is the size beyond which the log file is rotated.
+	LogMaxSize int64 `long:"log-max-size" description:"Rotate the log file when it grows beyond this many bytes (0 for no limit)." optional:"yes" default:"0"`
+	// LogMaxAge is the age beyond which the log file is rotated.
+	LogMaxAge time.Duration `long:"log-max-age" description:"Rotate the log file when it gets older than this (e.g. 24h, 0 for no limit)." optional:"yes" default:"0"`
+	// LogKeep is the number of rotated log files that are kept.
+	LogKeep int `long:"log-keep" description:"The number of rotated log files to keep (0 to keep them all)." optional:"yes" default:"0"`
 	// LogStream is the type of logger to use.
-	LogFormat string `short:"F" long:"log-format" description:"The format of the logging messages." optional:"yes" choice:"text" choice:"json" default:"text" env:"CLOUDCTL_LOG_FORMAT"`
+	LogFormat string `short:"F" long:"log-format" description:"The format of the logging messages." optional:"yes" choice:"text" choice:"json" default:"text"`
 	// CPUProfile sets the (optional) path of the file for CPU profiling info.
 	CPUProfile string `short:"C" long:"cpu-profile" description:"The (optional) path where the CPU profiler will store its data." optional:"yes"`
 	// MemProfile sets the (optional) path of the file for memory profiling info.
 	MemProfile string `short:"M" long:"mem-profile" description:"The (optional) path where the memory profiler will store its data." optional:"yes"`
 	// AutomationFriendly enables automation-friendly JSON output.
 	AutomationFriendly bool `short:"A" long:"automation-friendly" description:"Whether to output in automation friendly JSON format." optional:"yes"`
+	// Color is whether the human readable output is colored.
+	Color string `long:"color" description:"Whether to color the output: auto colors it on terminals, unless NO_COLOR is set." optional:"yes" choice:"auto" choice:"always" choice:"never" default:"auto"`
+	// Lang is the language of the human readable output.
+	Lang string `long:"lang" description:"The language of the human readable summaries, e.g. it (detected from LC_ALL, LC_MESSAGES and LANG if not specified); only report, triage-downloads and doctor are translated so far." optional:"yes"`
+	// Plugins is the directory where plugins are discovered.
+	Plugins string `long:"plugins" description:"The directory where plugins (dedup-source-*, dedup-filter-*, dedup-hasher-*, dedup-action-*) are discovered (defaults to dedup/plugins in the user configuration directory)." optional:"yes"`
+
+	output *Output
+}
+
+// Output returns where the command writes its results and diagnostics.
+func (cmd *Command) Output() *Output {
+	if cmd.output == nil {
+		cmd.output = NewOutput(cmd.Color)
+	}
+	return cmd.output
 }
 
 // Init initialises the command consuming the standard, common arguments.
 func (cmd *Command) Init() {
+	language := cmd.Lang
+	if language == "" {
+		language = locale.Detect()
+	}
+	unsupported := !locale.Set(language)
+	plugins.SetDirectory(cmd.Plugins)
+
 	var err error
 	var stream io.Writer = os.Stderr
+	var target sink
 	options := &slog.HandlerOptions{
 		Level: slog.LevelWarn,
 	}
@@ -46,18 +88,41 @@ func (cmd *Command) Init() {
 	case "debug":
 		options.Level = slog.LevelDebug
 	}
+	switch {
+	case cmd.Quiet:
+		options.Level = slog.LevelError
+	case len(cmd.Verbose) == 1:
+		options.Level = slog.LevelInfo
+	case len(cmd.Verbose) > 1:
+		options.Level = slog.LevelDebug
+	}
 
+	if cmd.LogFile != "" {
+		cmd.LogStream = "file"
+	}
 	switch cmd.LogStream {
 	case "stdout":
 		stream = os.Stdout
 	case "stderr":
 		stream = os.Stderr
 	case "file":
-		exe, _ := os.Executable()
-		path := fmt.Sprintf("%s-%d.log", strings.Replace(exe, ".exe", "", -1), os.Getpid())
-		if stream, err = os.Create(path); err != nil {
+		path := cmd.LogFile
+		if path == "" {
+			exe, _ := os.Executable()
+			path = fmt.Sprintf("%s-%d.log", strings.Replace(exe, ".exe", "", -1), os.Getpid())
+		}
+		if stream, err = openRotatingFile(path, cmd.LogMaxSize, cmd.LogMaxAge, cmd.LogKeep); err != nil {
 			stream = io.Discard
 		}
+	case "syslog", "journald":
+		connect := syslogSink
+		if cmd.LogStream == "journald" {
+			connect = journaldSink
+		}
+		if target, err = connect(); err != nil {
+			// fall back to the standard error
+			cmd.Output().Warnf("cannot log to %s, logging to stderr: %v\n", cmd.LogStream, err)
+		}
 	case "none":
 		stream = io.Discard
 	}
@@ -69,8 +134,19 @@ func (cmd *Command) Init() {
 	case "json":
 		handler = slog.NewJSONHandler(stream, options)
 	}
+	if target != nil {
+		handler = newPriorityHandler(target, options, func(buffer *bytes.Buffer, options *slog.HandlerOptions) slog.Handler {
+			if cmd.LogFormat == "json" {
+				return slog.NewJSONHandler(buffer, options)
+			}
+			return slog.NewTextHandler(buffer, options)
+		})
+	}
 
 	slog.SetDefault(slog.New(handler))
+	if unsupported && cmd.Lang != "" {
+		slog.Warn("language not available, falling back to English", "lang", cmd.Lang, "available", locale.Languages())
+	}
 }
 
 func (cmd *Command) ProfileCPU() *Closer {
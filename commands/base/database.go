@@ -0,0 +1,30 @@
+package base
+
+import (
+	"database/sql"
+	"log/slog"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// driver is the name of the SQLite3 driver used to open the database; builds
+// with the sqlite_vtable tag replace it with one that registers the fs_tree
+// virtual table.
+var driver = "sqlite3"
+
+// Database contains the options common to all commands that work on the
+// SQLite3 database holding the index.
+type Database struct {
+	// Database is the path to the database to open/create on disk.
+	Database string `short:"d" long:"database" description:"Path to the database." required:"true" default:"./dedup.db"`
+}
+
+// Open opens the SQLite3 database.
+func (d *Database) Open() (*sql.DB, error) {
+	db, err := sql.Open(driver, d.Database+"?_journal=WAL&_timeout=5000&_fk=true")
+	if err != nil {
+		slog.Error("error opening SQLite database", "path", d.Database, "error", err)
+		return nil, err
+	}
+	return db, nil
+}
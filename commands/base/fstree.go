@@ -0,0 +1,205 @@
+//go:build sqlite_vtable
+
+package base
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// fsTreeDriver is the name of the SQLite3 driver with the fs_tree virtual
+// table registered on each connection.
+const fsTreeDriver = "sqlite3_fs_tree"
+
+func init() {
+	sql.Register(fsTreeDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.CreateModule("fs_tree", &fsTreeModule{})
+		},
+	})
+	driver = fsTreeDriver
+}
+
+// fsTreeModule is an eponymous-only virtual table exposing the live contents of
+// a directory tree as rows, so that they can be joined against stored entries:
+//
+//	SELECT t.path FROM fs_tree('/some/dir') t
+//	LEFT JOIN entries e ON e.path = t.path
+//	WHERE t.type = 'file' AND e.path IS NULL;
+type fsTreeModule struct{}
+
+// fsTreeRootColumn is the index of the hidden column holding the root of the tree.
+const fsTreeRootColumn = 6
+
+// EponymousOnlyModule implements sqlite3.EponymousOnlyModule.
+func (m *fsTreeModule) EponymousOnlyModule() {}
+
+// Create implements sqlite3.Module.
+func (m *fsTreeModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	err := c.DeclareVTab(fmt.Sprintf(`
+		CREATE TABLE %s (
+			path     TEXT,
+			name     TEXT,
+			type     TEXT,
+			size     INT,
+			mode     INT,
+			modified TEXT,
+			root     HIDDEN
+		)`, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &fsTreeTable{}, nil
+}
+
+// Connect implements sqlite3.Module.
+func (m *fsTreeModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+// DestroyModule implements sqlite3.Module.
+func (m *fsTreeModule) DestroyModule() {}
+
+// fsTreeTable is an instance of the fs_tree virtual table.
+type fsTreeTable struct{}
+
+// BestIndex implements sqlite3.VTab; the only usable constraint is equality on
+// the root of the tree, which is mandatory.
+func (t *fsTreeTable) BestIndex(constraints []sqlite3.InfoConstraint, orderBys []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	used := make([]bool, len(constraints))
+	found := false
+	for i, constraint := range constraints {
+		if constraint.Column == fsTreeRootColumn && constraint.Op == sqlite3.OpEQ && constraint.Usable {
+			used[i] = true
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("fs_tree requires the root directory, e.g. fs_tree('/some/dir')")
+	}
+	return &sqlite3.IndexResult{
+		Used: used,
+	}, nil
+}
+
+// Disconnect implements sqlite3.VTab.
+func (t *fsTreeTable) Disconnect() error { return nil }
+
+// Destroy implements sqlite3.VTab.
+func (t *fsTreeTable) Destroy() error { return nil }
+
+// Open implements sqlite3.VTab.
+func (t *fsTreeTable) Open() (sqlite3.VTabCursor, error) {
+	return &fsTreeCursor{}, nil
+}
+
+// fsTreeRow is a filesystem object in the tree.
+type fsTreeRow struct {
+	path     string
+	name     string
+	kind     string
+	size     int64
+	mode     int64
+	modified string
+}
+
+// fsTreeCursor walks the tree when the query is run.
+type fsTreeCursor struct {
+	root  string
+	rows  []fsTreeRow
+	index int
+}
+
+// Filter implements sqlite3.VTabCursor.
+func (c *fsTreeCursor) Filter(idxNum int, idxStr string, vals []any) error {
+	c.rows = nil
+	c.index = 0
+	if len(vals) == 0 {
+		return fmt.Errorf("fs_tree requires the root directory")
+	}
+	root, ok := vals[0].(string)
+	if !ok {
+		return fmt.Errorf("fs_tree root must be a string")
+	}
+	c.root = root
+	return filepath.WalkDir(root, func(path string, object fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("error visiting path", "path", path, "error", err)
+			return nil
+		}
+		info, err := object.Info()
+		if err != nil {
+			slog.Warn("error reading file info", "path", path, "error", err)
+			return nil
+		}
+		row := fsTreeRow{
+			path:     path,
+			name:     object.Name(),
+			size:     info.Size(),
+			mode:     int64(info.Mode().Perm()),
+			modified: info.ModTime().UTC().Format(time.RFC3339),
+		}
+		switch {
+		case object.Type().IsDir():
+			row.kind = "dir"
+		case object.Type().IsRegular():
+			row.kind = "file"
+		case object.Type()&fs.ModeSymlink != 0:
+			row.kind = "symlink"
+		default:
+			row.kind = "other"
+		}
+		c.rows = append(c.rows, row)
+		return nil
+	})
+}
+
+// Next implements sqlite3.VTabCursor.
+func (c *fsTreeCursor) Next() error {
+	c.index++
+	return nil
+}
+
+// EOF implements sqlite3.VTabCursor.
+func (c *fsTreeCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+// Column implements sqlite3.VTabCursor.
+func (c *fsTreeCursor) Column(ctx *sqlite3.SQLiteContext, col int) error {
+	row := c.rows[c.index]
+	switch col {
+	case 0:
+		ctx.ResultText(row.path)
+	case 1:
+		ctx.ResultText(row.name)
+	case 2:
+		ctx.ResultText(row.kind)
+	case 3:
+		ctx.ResultInt64(row.size)
+	case 4:
+		ctx.ResultInt64(row.mode)
+	case 5:
+		ctx.ResultText(row.modified)
+	case fsTreeRootColumn:
+		ctx.ResultText(c.root)
+	}
+	return nil
+}
+
+// Rowid implements sqlite3.VTabCursor.
+func (c *fsTreeCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+// Close implements sqlite3.VTabCursor.
+func (c *fsTreeCursor) Close() error {
+	return nil
+}
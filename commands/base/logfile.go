@@ -0,0 +1,100 @@
+package base
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a log file that is rotated when it grows beyond a given size
+// or gets older than a given age; rotated files get a timestamp suffix, and
+// only the most recent ones are kept.
+type rotatingFile struct {
+	lock    sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+	file    *os.File
+	size    int64
+	opened  time.Time
+}
+
+// openRotatingFile opens (appending to) the log file at the given path.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the log file, creating its directory if needed.
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), info.ModTime()
+	if f.size == 0 {
+		f.opened = time.Now()
+	}
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.size > 0 && (f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize || f.maxAge > 0 && time.Since(f.opened) > f.maxAge) {
+		if err := f.rotate(); err != nil {
+			// keep logging to the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "error rotating log file %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current log file with a timestamp suffix, opens a new one
+// and removes the rotated files beyond the retention limit.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", f.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(f.path, rotated); err != nil {
+		f.open()
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	if f.keep <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	// the timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for len(backups) > f.keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
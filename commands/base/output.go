@@ -0,0 +1,86 @@
+package base
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dihedron/dedup/locale"
+)
+
+// Style is a way of rendering text on a terminal.
+type Style string
+
+// The styles of the human readable output.
+const (
+	Bold   Style = "1"
+	Faint  Style = "2"
+	Red    Style = "31"
+	Green  Style = "32"
+	Yellow Style = "33"
+	Cyan   Style = "36"
+)
+
+// Output is where commands write their results, meant for the user, and their
+// diagnostics; results go to the standard output, diagnostics to the standard
+// error, and results are colored only if the standard output is a terminal,
+// unless told otherwise.
+type Output struct {
+	stdout io.Writer
+	stderr io.Writer
+	color  bool
+}
+
+// NewOutput creates an output for the given color mode (always, never or auto);
+// in auto mode, colors are used when the standard output is a terminal and the
+// NO_COLOR environment variable is not set.
+func NewOutput(mode string) *Output {
+	o := &Output{stdout: os.Stdout, stderr: os.Stderr}
+	switch mode {
+	case "always":
+		o.color = true
+	case "never":
+		o.color = false
+	default:
+		o.color = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(os.Stdout)
+	}
+	return o
+}
+
+// isTerminal returns whether the file is a terminal rather than a pipe or a
+// regular file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Printf writes a result to the standard output, in the selected language.
+func (o *Output) Printf(format string, args ...any) {
+	locale.Fprintf(o.stdout, format, args...)
+}
+
+// Println writes a result line to the standard output as is, never translated,
+// since it carries data such as paths and JSON documents.
+func (o *Output) Println(args ...any) {
+	fmt.Fprintln(o.stdout, args...)
+}
+
+// Write writes results to the standard output as they are, so that the output
+// can back an io.Writer, e.g. to align tables.
+func (o *Output) Write(p []byte) (int, error) {
+	return o.stdout.Write(p)
+}
+
+// Warnf writes a diagnostic message to the standard error, in the selected
+// language.
+func (o *Output) Warnf(format string, args ...any) {
+	locale.Fprintf(o.stderr, format, args...)
+}
+
+// Paint returns the text rendered in the given style, if colors are enabled.
+func (o *Output) Paint(style Style, text string) string {
+	if !o.color {
+		return text
+	}
+	return "\x1b[" + string(style) + "m" + text + "\x1b[0m"
+}
@@ -0,0 +1,60 @@
+package base
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// Preview contains the options common to all commands that change files on
+// disk, which can print what they would do instead of doing it.
+type Preview struct {
+	// DryRun prints what would be done without changing anything.
+	DryRun bool `short:"n" long:"dry-run" description:"Print what would be done, with target paths and bytes affected, without changing anything on disk or in the database." optional:"true"`
+}
+
+// Planned is an action that would be performed in a dry run.
+type Planned struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	Bytes  int64  `json:"bytes"`
+	// Error is the reason the action would fail, if it is known up-front.
+	Error string `json:"error,omitempty"`
+}
+
+// Would prints the action that would be performed on the given path, either
+// in human readable or in automation friendly (NDJSON) format.
+func (cmd *Command) Would(action string, path string, target string, bytes int64) {
+	out := cmd.Output()
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(&Planned{Action: action, Path: path, Target: target, Bytes: bytes})
+		if err != nil {
+			slog.Error("error marshalling planned action to JSON", "path", path, "error", err)
+			return
+		}
+		out.Println(string(data))
+		return
+	}
+	if target != "" {
+		out.Printf("%s %s -> %s (%d bytes)\n", out.Paint(Yellow, "would "+action), path, target, bytes)
+	} else {
+		out.Printf("%s %s (%d bytes)\n", out.Paint(Yellow, "would "+action), path, bytes)
+	}
+}
+
+// WouldFail prints the action that would fail on the given path, e.g. for lack
+// of privileges, either in human readable or in automation friendly (NDJSON)
+// format.
+func (cmd *Command) WouldFail(action string, path string, target string, bytes int64, reason error) {
+	out := cmd.Output()
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(&Planned{Action: action, Path: path, Target: target, Bytes: bytes, Error: reason.Error()})
+		if err != nil {
+			slog.Error("error marshalling planned action to JSON", "path", path, "error", err)
+			return
+		}
+		out.Println(string(data))
+		return
+	}
+	out.Printf("%s %s: %v\n", out.Paint(Red, "would fail to "+action), path, reason)
+}
@@ -1,8 +1,14 @@
 package base
 
 import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
 	"os"
 	"runtime/pprof"
+	"time"
 )
 
 type Closer struct {
@@ -15,3 +21,30 @@ func (c *Closer) Close() {
 		c.file.Close()
 	}
 }
+
+// ServeProfiles exposes the runtime profiles (as net/http/pprof does) on the
+// given address, so that long-running commands can be profiled live; it
+// returns a function shutting the server down.
+func ServeProfiles(address string) (func(), error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		slog.Error("error listening for profiling requests", "address", address, "error", err)
+		return nil, err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("error serving profiles", "address", address, "error", err)
+		}
+	}()
+	slog.Info("serving profiles", "address", listener.Addr().String())
+	return func() {
+		server.Close()
+	}, nil
+}
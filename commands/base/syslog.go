@@ -0,0 +1,95 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+)
+
+// journaldSocket is the socket where journald receives native log entries.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// identifier is how the application identifies itself in the system logs.
+const identifier = "dedup"
+
+// sink receives a formatted log message along with its level.
+type sink func(level slog.Level, message string) error
+
+// priorityHandler formats the log records like the wrapped handler does, then
+// hands them over to a sink along with their level, for targets such as syslog
+// and journald that have their own notion of priority and timestamps.
+type priorityHandler struct {
+	slog.Handler
+	lock   *sync.Mutex
+	buffer *bytes.Buffer
+	sink   sink
+}
+
+// newPriorityHandler returns a handler for the given sink, using the given
+// constructor to create the handler that formats the records.
+func newPriorityHandler(s sink, options *slog.HandlerOptions, format func(*bytes.Buffer, *slog.HandlerOptions) slog.Handler) slog.Handler {
+	// the target adds its own timestamps
+	o := *options
+	o.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	buffer := &bytes.Buffer{}
+	return &priorityHandler{
+		Handler: format(buffer, &o),
+		lock:    &sync.Mutex{},
+		buffer:  buffer,
+		sink:    s,
+	}
+}
+
+func (h *priorityHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.buffer.Reset()
+	if err := h.Handler.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.sink(r.Level, strings.TrimSuffix(h.buffer.String(), "\n"))
+}
+
+func (h *priorityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &priorityHandler{Handler: h.Handler.WithAttrs(attrs), lock: h.lock, buffer: h.buffer, sink: h.sink}
+}
+
+func (h *priorityHandler) WithGroup(name string) slog.Handler {
+	return &priorityHandler{Handler: h.Handler.WithGroup(name), lock: h.lock, buffer: h.buffer, sink: h.sink}
+}
+
+// priority returns the syslog priority (severity) of the given level.
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// journaldSink returns a sink sending the messages to journald using its
+// native protocol.
+func journaldSink() (sink, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, err
+	}
+	return func(level slog.Level, message string) error {
+		// the formatted message has no newlines, so the simple form will do
+		_, err := fmt.Fprintf(conn, "PRIORITY=%d\nSYSLOG_IDENTIFIER=%s\nMESSAGE=%s\n", priority(level), identifier, message)
+		return err
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package base
+
+import (
+	"errors"
+)
+
+// syslogSink returns an error, since syslog is not available on this platform.
+func syslogSink() (sink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+
+package base
+
+import (
+	"log/slog"
+	"log/syslog"
+)
+
+// syslogSink returns a sink sending the messages to the local syslog daemon.
+func syslogSink() (sink, error) {
+	writer, err := syslog.New(syslog.LOG_USER|syslog.LOG_INFO, identifier)
+	if err != nil {
+		return nil, err
+	}
+	return func(level slog.Level, message string) error {
+		switch priority(level) {
+		case 3:
+			return writer.Err(message)
+		case 4:
+			return writer.Warning(message)
+		case 6:
+			return writer.Info(message)
+		}
+		return writer.Debug(message)
+	}, nil
+}
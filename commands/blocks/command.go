@@ -0,0 +1,98 @@
+package blocks
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+)
+
+// Blocks is the command that deduplicates the identical extents of duplicate
+// files at the filesystem level (e.g. on btrfs and XFS), so that they share the
+// same storage on disk without deleting or linking any of them.
+type Blocks struct {
+	base.Command
+	base.Database
+	base.Preview
+	// Bucket restricts the deduplication to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose entries should be deduplicated (all buckets if not specified)." optional:"true"`
+	// Simulate computes the space that would be reclaimed without changing anything.
+	Simulate bool `short:"s" long:"simulate" description:"Only compute the space that would be reclaimed, without changing anything on disk." optional:"true"`
+}
+
+// Summary contains the outcome of a block-level deduplication run.
+type Summary struct {
+	Groups    int   `json:"groups"`
+	Files     int   `json:"files"`
+	Failed    int   `json:"failed"`
+	Bytes     int64 `json:"bytes"`
+	Simulated bool  `json:"simulated,omitempty"`
+}
+
+// Execute is the real implementation of the Blocks command.
+func (cmd *Blocks) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running blocks command", "database", cmd.Database, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	cmd.Simulate = cmd.Simulate || cmd.DryRun
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	summary := &Summary{Simulated: cmd.Simulate}
+	err = duplicates.Scan(db, &duplicates.Options{Bucket: cmd.Bucket}, func(group *duplicates.Group) error {
+		if group.Size == 0 {
+			return nil
+		}
+		summary.Groups++
+		if cmd.Simulate {
+			// the keeper's extents would be shared with every other file
+			summary.Files += len(group.Duplicates)
+			summary.Bytes += group.Shareable()
+			if cmd.DryRun {
+				for _, entry := range group.Duplicates {
+					cmd.Would("share extents of", entry.Path, group.Keeper.Path, group.Size)
+				}
+			}
+			return nil
+		}
+		source := group.Keeper.Path
+		for _, entry := range group.Duplicates {
+			deduped, err := dedupe(source, entry.Path, group.Size)
+			summary.Bytes += deduped
+			if err != nil {
+				slog.Error("error deduplicating file extents", "source", source, "target", entry.Path, "error", err)
+				summary.Failed++
+				continue
+			}
+			slog.Debug("file extents deduplicated", "source", source, "target", entry.Path, "bytes", deduped)
+			summary.Files++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if cmd.Simulate {
+		cmd.Output().Printf("would share the extents of %d files across %d groups, reclaiming up to %d bytes on disk\n", summary.Files, summary.Groups, summary.Bytes)
+	} else {
+		cmd.Output().Printf("deduplicated %d bytes in %d files across %d groups (%d failed)\n", summary.Bytes, summary.Files, summary.Groups, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
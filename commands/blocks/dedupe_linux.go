@@ -0,0 +1,68 @@
+//go:build linux
+
+package blocks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxDedupeLength is the maximum number of bytes submitted to the kernel in a
+// single FIDEDUPERANGE call; some filesystems (e.g. btrfs) silently cap larger
+// requests anyway.
+const maxDedupeLength = 16 * 1024 * 1024
+
+// ErrContentsDiffer is returned when the kernel reports that the ranges to be
+// deduplicated do not have the same contents.
+var ErrContentsDiffer = errors.New("file contents differ")
+
+// dedupe asks the kernel to share the extents of the source file with the
+// target file; the kernel compares the data before sharing, so the operation
+// never alters the visible contents of either file.
+func dedupe(source string, target string, size int64) (int64, error) {
+	src, err := os.Open(source)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	dst, err := os.Open(target)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	var offset int64
+	for offset < size {
+		length := size - offset
+		if length > maxDedupeLength {
+			length = maxDedupeLength
+		}
+		request := &unix.FileDedupeRange{
+			Src_offset: uint64(offset),
+			Src_length: uint64(length),
+			Info: []unix.FileDedupeRangeInfo{
+				{
+					Dest_fd:     int64(dst.Fd()),
+					Dest_offset: uint64(offset),
+				},
+			},
+		}
+		if err := unix.IoctlFileDedupeRange(int(src.Fd()), request); err != nil {
+			return offset, fmt.Errorf("FIDEDUPERANGE failed: %w", err)
+		}
+		info := request.Info[0]
+		switch {
+		case info.Status == unix.FILE_DEDUPE_RANGE_DIFFERS:
+			return offset, ErrContentsDiffer
+		case info.Status < 0:
+			return offset, fmt.Errorf("FIDEDUPERANGE failed: %w", unix.Errno(-info.Status))
+		case info.Bytes_deduped == 0:
+			return offset, fmt.Errorf("FIDEDUPERANGE made no progress at offset %d", offset)
+		}
+		offset += int64(info.Bytes_deduped)
+	}
+	return offset, nil
+}
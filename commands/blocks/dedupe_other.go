@@ -0,0 +1,16 @@
+//go:build !linux
+
+package blocks
+
+import (
+	"errors"
+)
+
+// ErrNotSupported is returned on platforms where block-level deduplication is
+// not available.
+var ErrNotSupported = errors.New("block-level deduplication is only supported on Linux")
+
+// dedupe always fails, since FIDEDUPERANGE is Linux-specific.
+func dedupe(source string, target string, size int64) (int64, error) {
+	return 0, ErrNotSupported
+}
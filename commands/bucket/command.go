@@ -0,0 +1,174 @@
+package bucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Bucket is the command that lists the buckets in the index and changes their
+// settings; buckets marked as reference (e.g. the master archive) are treated
+// as read-only, and copies in them are never selected for removal; buckets
+// holding snapshots of the same tree at different times (e.g. dated backups)
+// can be grouped into a series, to be compared with the snapshots command,
+// each recording when it was taken so that the series can be ordered.
+type Bucket struct {
+	base.Command
+	base.Database
+	// Reference marks the given buckets as read-only references.
+	Reference []string `short:"r" long:"reference" description:"Mark the bucket as a read-only reference, whose copies are never acted upon (repeatable)." optional:"true"`
+	// Writable clears the reference mark from the given buckets.
+	Writable []string `short:"w" long:"writable" description:"Clear the reference mark from the bucket (repeatable)." optional:"true"`
+	// Snapshots add buckets to series of snapshots, as bucket=series@time.
+	Snapshots []string `short:"s" long:"snapshot" description:"Mark the bucket as a snapshot in a series of snapshots of the same tree, as bucket=series@time, where time is when the snapshot was taken, as a date or an RFC3339 timestamp (repeatable)." optional:"true"`
+	// NotSnapshots remove buckets from their series of snapshots.
+	NotSnapshots []string `long:"not-snapshot" description:"Remove the bucket from its series of snapshots (repeatable)." optional:"true"`
+}
+
+// Info describes a bucket.
+type Info struct {
+	Name      string   `json:"name"`
+	Files     int64    `json:"files"`
+	Size      int64    `json:"size"`
+	Reference bool     `json:"reference"`
+	Series    string   `json:"series,omitempty"`
+	Taken     string   `json:"taken,omitempty"`
+	Algorithm string   `json:"algorithm,omitempty"`
+	Roots     []string `json:"roots,omitempty"`
+	Created   string   `json:"created,omitempty"`
+	Updated   string   `json:"updated,omitempty"`
+}
+
+// Execute is the real implementation of the Bucket command.
+func (cmd *Bucket) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running bucket command", "database", cmd.Database, "reference", cmd.Reference, "writable", cmd.Writable)
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, name := range cmd.Reference {
+		if _, err := db.Exec("insert into buckets(name, reference) values(?, 1) on conflict(name) do update set reference = 1", name); err != nil {
+			slog.Error("error marking bucket as reference", "bucket", name, "error", err)
+			return err
+		}
+		slog.Info("bucket marked as reference", "bucket", name)
+	}
+	for _, name := range cmd.Writable {
+		if _, err := db.Exec("update buckets set reference = 0 where name = ?", name); err != nil {
+			slog.Error("error clearing bucket reference mark", "bucket", name, "error", err)
+			return err
+		}
+		slog.Info("bucket marked as writable", "bucket", name)
+	}
+
+	for _, snapshot := range cmd.Snapshots {
+		name, series, ok := strings.Cut(snapshot, "=")
+		series, when, _ := strings.Cut(series, "@")
+		if !ok || name == "" || series == "" {
+			err := fmt.Errorf("invalid snapshot %q, must be bucket=series@time", snapshot)
+			slog.Error("error marking bucket as snapshot", "error", err)
+			return err
+		}
+		var taken any
+		if when != "" {
+			t, err := parseTime(when)
+			if err != nil {
+				slog.Error("error marking bucket as snapshot", "bucket", name, "time", when, "error", err)
+				return err
+			}
+			taken = t.UTC().Format(time.RFC3339)
+		}
+		// keep the time already recorded if none is given
+		if _, err := db.Exec("insert into buckets(name, series, taken) values(?, ?, ?) on conflict(name) do update set series = excluded.series, taken = coalesce(excluded.taken, buckets.taken)", name, series, taken); err != nil {
+			slog.Error("error marking bucket as snapshot", "bucket", name, "series", series, "error", err)
+			return err
+		}
+		slog.Info("bucket marked as snapshot", "bucket", name, "series", series, "taken", taken)
+	}
+	for _, name := range cmd.NotSnapshots {
+		if _, err := db.Exec("update buckets set series = null, taken = null where name = ?", name); err != nil {
+			slog.Error("error clearing bucket snapshot mark", "bucket", name, "error", err)
+			return err
+		}
+		slog.Info("bucket removed from its series", "bucket", name)
+	}
+
+	rows, err := db.Query(`
+		select name, coalesce(sum(files), 0), coalesce(sum(size), 0), max(reference), coalesce(max(series), ''), coalesce(max(taken), ''), coalesce(max(algorithm), ''), coalesce(max(roots), ''), coalesce(max(created), ''), coalesce(max(updated), '') from (
+			select coalesce(bucket, '') as name, count(*) as files, sum(coalesce(size, 0)) as size, 0 as reference, null as series, null as taken, null as algorithm, null as roots, null as created, null as updated from entries group by bucket
+			union all
+			select name, 0, 0, reference, series, taken, algorithm, roots, created, updated from buckets
+		) group by name order by name`)
+	if err != nil {
+		slog.Error("error querying buckets", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		info := &Info{}
+		var roots string
+		if err := rows.Scan(&info.Name, &info.Files, &info.Size, &info.Reference, &info.Series, &info.Taken, &info.Algorithm, &roots, &info.Created, &info.Updated); err != nil {
+			slog.Error("error reading bucket", "error", err)
+			return err
+		}
+		if roots != "" {
+			if err := json.Unmarshal([]byte(roots), &info.Roots); err != nil {
+				slog.Error("error parsing bucket roots", "bucket", info.Name, "error", err)
+				return err
+			}
+		}
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(info)
+			if err != nil {
+				slog.Error("error marshalling bucket to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else {
+			mode := "writable"
+			if info.Reference {
+				mode = "reference"
+			}
+			if info.Series != "" {
+				mode += ", snapshot of " + info.Series
+				if info.Taken != "" {
+					mode += " taken " + info.Taken
+				}
+			}
+			algorithm := info.Algorithm
+			if algorithm == "" {
+				algorithm = "unknown"
+			}
+			cmd.Output().Printf("%s\t%s\t%s\t%d files\t%d bytes\t%s\n", info.Name, mode, algorithm, info.Files, info.Size, strings.Join(info.Roots, ", "))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading buckets", "error", err)
+		return err
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// parseTime parses the time a snapshot was taken, as an RFC3339 timestamp or
+// as a date, in local time.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, must be a date (2006-01-02) or an RFC3339 timestamp", value)
+}
@@ -0,0 +1,201 @@
+package bursts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// extensions are the file extensions of the photos to fingerprint.
+var extensions = []string{".jpg", ".jpeg", ".png", ".gif"}
+
+// Bursts is the command that finds series of near-identical photos taken in
+// quick succession (e.g. camera bursts), so that they can be reviewed as a
+// whole instead of as isolated pairs: each indexed photo is fingerprinted with
+// its capture time (from EXIF, or the modification time) and a perceptual
+// hash, and photos taken close in time and looking alike are chained together.
+type Bursts struct {
+	base.Command
+	base.Database
+	// Bucket restricts the search to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose photos should be compared (all buckets if not specified)." optional:"true"`
+	// Window is the maximum time between consecutive shots of a series.
+	Window time.Duration `short:"w" long:"window" description:"The maximum time between consecutive shots of a series." optional:"true" default:"2s"`
+	// Threshold is the maximum number of differing bits between consecutive shots.
+	Threshold int `long:"threshold" description:"The maximum number of differing bits (out of 64) between the perceptual hashes of consecutive shots." optional:"true" default:"12"`
+	// Refresh recomputes the fingerprints already in the index.
+	Refresh bool `long:"refresh" description:"Recompute the fingerprints already stored in the index." optional:"true"`
+}
+
+// Photo is a photo in a series.
+type Photo struct {
+	Hash  string    `json:"hash"`
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	Taken time.Time `json:"taken"`
+
+	phash uint64
+	ready bool
+}
+
+// Series is a series of near-identical photos.
+type Series struct {
+	Photos []*Photo `json:"photos"`
+}
+
+// Execute is the real implementation of the Bursts command.
+func (cmd *Bursts) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running bursts command", "database", cmd.Database, "bucket", cmd.Bucket, "window", cmd.Window, "threshold", cmd.Threshold)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	photos, err := cmd.photos(db)
+	if err != nil {
+		return err
+	}
+	if err := cmd.fingerprint(db, photos); err != nil {
+		return err
+	}
+	series := cmd.chain(photos)
+
+	for i, s := range series {
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(s)
+			if err != nil {
+				slog.Error("error marshalling series to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else {
+			if i > 0 {
+				cmd.Output().Println()
+			}
+			first, last := s.Photos[0], s.Photos[len(s.Photos)-1]
+			cmd.Output().Printf("series of %d photos taken at %s over %s:\n", len(s.Photos), first.Taken.Format(time.DateTime), last.Taken.Sub(first.Taken))
+			for _, photo := range s.Photos {
+				cmd.Output().Printf("  %s (%d bytes)\n", photo.Path, photo.Size)
+			}
+		}
+	}
+	if !cmd.AutomationFriendly {
+		cmd.Output().Printf("%d series of near-identical photos\n", len(series))
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// photos returns one indexed photo per distinct content, along with its
+// stored fingerprint, if any.
+func (cmd *Bursts) photos(db *sql.DB) ([]*Photo, error) {
+	conditions := []string{}
+	params := []any{}
+	for _, extension := range extensions {
+		conditions = append(conditions, "lower(path) like ?")
+		params = append(params, "%"+extension)
+	}
+	query := "select entries.hash, min(path), coalesce(max(size), 0), coalesce(photos.taken, min(entries.modified), ''), photos.phash from entries left join photos on photos.hash = entries.hash where (" + strings.Join(conditions, " or ") + ")"
+	if cmd.Bucket != "" {
+		query += " and bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" group by entries.hash", params...)
+	if err != nil {
+		slog.Error("error querying photos", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	photos := []*Photo{}
+	for rows.Next() {
+		photo := &Photo{}
+		var taken string
+		var phash sql.NullString
+		if err := rows.Scan(&photo.Hash, &photo.Path, &photo.Size, &taken, &phash); err != nil {
+			slog.Error("error reading photo", "error", err)
+			return nil, err
+		}
+		photo.Taken, _ = time.Parse(time.RFC3339Nano, taken)
+		if phash.Valid && !cmd.Refresh {
+			if value, err := strconv.ParseUint(phash.String, 16, 64); err == nil {
+				photo.phash = value
+				photo.ready = true
+			}
+		}
+		photos = append(photos, photo)
+	}
+	return photos, rows.Err()
+}
+
+// fingerprint computes and stores the missing fingerprints; photos that cannot
+// be decoded are left out of the comparison.
+func (cmd *Bursts) fingerprint(db *sql.DB, photos []*Photo) error {
+	for _, photo := range photos {
+		if photo.ready {
+			continue
+		}
+		phash, err := differenceHash(photo.Path)
+		if err != nil {
+			slog.Warn("error fingerprinting photo", "path", photo.Path, "error", err)
+			continue
+		}
+		photo.phash = phash
+		photo.ready = true
+		var taken any
+		if t, err := takenAt(photo.Path); err == nil {
+			photo.Taken = t
+			taken = t.Format(time.RFC3339Nano)
+		}
+		if _, err := db.Exec("insert or replace into photos(hash, taken, phash, created) values(?, ?, ?, ?)", photo.Hash, taken, fmt.Sprintf("%016x", phash), time.Now().UTC().Format(time.RFC3339)); err != nil {
+			slog.Error("error storing photo fingerprint", "path", photo.Path, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// chain sorts the photos by capture time and chains the consecutive ones
+// taken within the window and looking alike into series.
+func (cmd *Bursts) chain(photos []*Photo) []*Series {
+	candidates := []*Photo{}
+	for _, photo := range photos {
+		if photo.ready && !photo.Taken.IsZero() {
+			candidates = append(candidates, photo)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].Taken.Equal(candidates[j].Taken) {
+			return candidates[i].Taken.Before(candidates[j].Taken)
+		}
+		return candidates[i].Path < candidates[j].Path
+	})
+	result := []*Series{}
+	var current *Series
+	for i, photo := range candidates {
+		if i > 0 {
+			previous := candidates[i-1]
+			if photo.Taken.Sub(previous.Taken) <= cmd.Window && distance(photo.phash, previous.phash) <= cmd.Threshold {
+				if current == nil {
+					current = &Series{Photos: []*Photo{previous}}
+					result = append(result, current)
+				}
+				current.Photos = append(current.Photos, photo)
+				continue
+			}
+		}
+		current = nil
+	}
+	return result
+}
@@ -0,0 +1,124 @@
+package bursts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// exif tags holding the capture time.
+const (
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagSubSecTimeOriginal = 0x9291
+)
+
+// errNoExif is returned when a file has no usable EXIF capture time.
+var errNoExif = errors.New("no EXIF capture time")
+
+// takenAt returns the capture time recorded in the EXIF metadata of the given
+// JPEG file, including fractions of a second when available, since shots in a
+// burst are often taken within the same second.
+func takenAt(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	marker := make([]byte, 2)
+	if _, err := io.ReadFull(r, marker); err != nil || marker[0] != 0xff || marker[1] != 0xd8 {
+		return time.Time{}, errNoExif
+	}
+	for {
+		if _, err := io.ReadFull(r, marker); err != nil || marker[0] != 0xff {
+			return time.Time{}, errNoExif
+		}
+		// start of scan: no more metadata
+		if marker[1] == 0xda {
+			return time.Time{}, errNoExif
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return time.Time{}, errNoExif
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return time.Time{}, errNoExif
+		}
+		if marker[1] == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseExif(segment[6:])
+		}
+	}
+}
+
+// parseExif extracts the capture time from a TIFF-structured EXIF block.
+func parseExif(data []byte) (time.Time, error) {
+	if len(data) < 8 {
+		return time.Time{}, errNoExif
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errNoExif
+	}
+	tags := map[uint16]string{}
+	var exif uint32
+	readIFD := func(offset uint32) {
+		if int(offset)+2 > len(data) {
+			return
+		}
+		count := int(order.Uint16(data[offset:]))
+		for i := 0; i < count; i++ {
+			entry := int(offset) + 2 + i*12
+			if entry+12 > len(data) {
+				return
+			}
+			tag := order.Uint16(data[entry:])
+			kind := order.Uint16(data[entry+2:])
+			n := order.Uint32(data[entry+4:])
+			switch {
+			case tag == tagExifIFD:
+				exif = order.Uint32(data[entry+8:])
+			case kind == 2 && (tag == tagDateTime || tag == tagDateTimeOriginal || tag == tagSubSecTimeOriginal):
+				// ASCII values longer than 4 bytes are stored at an offset
+				start := entry + 8
+				if n > 4 {
+					start = int(order.Uint32(data[entry+8:]))
+				}
+				if start+int(n) <= len(data) {
+					tags[tag] = strings.TrimRight(string(data[start:start+int(n)]), "\x00 ")
+				}
+			}
+		}
+	}
+	readIFD(order.Uint32(data[4:]))
+	if exif != 0 {
+		readIFD(exif)
+	}
+	value, ok := tags[tagDateTimeOriginal]
+	if !ok {
+		if value, ok = tags[tagDateTime]; !ok {
+			return time.Time{}, errNoExif
+		}
+	}
+	if fraction := tags[tagSubSecTimeOriginal]; fraction != "" {
+		value += "." + fraction
+	}
+	// EXIF times have no time zone, all photos are assumed to be in the same
+	t, err := time.Parse("2006:01:02 15:04:05.999999999", value)
+	if err != nil {
+		return time.Time{}, errNoExif
+	}
+	return t, nil
+}
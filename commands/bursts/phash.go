@@ -0,0 +1,67 @@
+package bursts
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+)
+
+// differenceHash decodes the given image and returns its difference hash: the
+// image is reduced to 9x8 grayscale cells, and each bit tells whether a cell is
+// brighter than the one to its right; near-identical shots have hashes that
+// differ in a few bits only.
+func differenceHash(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	var cells [8][9]float64
+	var counts [8][9]int
+	// sample at most about 256x256 pixels, which is plenty for 72 cells
+	step := 1
+	if width > 256 || height > 256 {
+		step = max(width, height) / 256
+	}
+	for y := 0; y < height; y += step {
+		for x := 0; x < width; x += step {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			cy, cx := y*8/height, x*9/width
+			cells[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cy][cx]++
+		}
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			left, right := average(cells[y][x], counts[y][x]), average(cells[y][x+1], counts[y][x+1])
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// average returns the average brightness of a cell.
+func average(sum float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// distance returns the number of differing bits between two hashes.
+func distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
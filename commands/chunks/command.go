@@ -0,0 +1,179 @@
+package chunks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/dihedron/dedup/chunks"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/zeebo/blake3"
+)
+
+// Chunks is the command that estimates how much a chunk-based backup tool
+// (e.g. restic, borg) would deduplicate the indexed files, by splitting their
+// contents into content-defined chunks and counting the distinct ones; this
+// helps size the storage needed for backups.
+type Chunks struct {
+	base.Command
+	base.Database
+	// Bucket restricts the estimate to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose entries should be chunked (all buckets if not specified)." optional:"true"`
+	// Min is the minimum chunk size.
+	Min int `long:"min" description:"The minimum chunk size, in bytes." optional:"true" default:"524288"`
+	// Average is the target average chunk size.
+	Average int `long:"average" description:"The target average chunk size, in bytes (must be a power of two)." optional:"true" default:"1048576"`
+	// Max is the maximum chunk size.
+	Max int `long:"max" description:"The maximum chunk size, in bytes." optional:"true" default:"8388608"`
+}
+
+// Estimate contains the outcome of the chunking.
+type Estimate struct {
+	// Files is the number of indexed files.
+	Files int `json:"files"`
+	// Bytes is the total size of the indexed files.
+	Bytes int64 `json:"bytes"`
+	// Contents is the number of distinct contents (i.e. after whole-file
+	// deduplication).
+	Contents int `json:"contents"`
+	// ContentBytes is the total size of the distinct contents.
+	ContentBytes int64 `json:"content_bytes"`
+	// Chunks is the number of chunks the distinct contents were split into.
+	Chunks int `json:"chunks"`
+	// UniqueChunks is the number of distinct chunks.
+	UniqueChunks int `json:"unique_chunks"`
+	// ChunkBytes is the total size of the distinct chunks, i.e. roughly the
+	// space a chunk-based backup would need (before compression).
+	ChunkBytes int64 `json:"chunk_bytes"`
+	// Failed is the number of contents that could not be read.
+	Failed int `json:"failed"`
+}
+
+// Execute is the real implementation of the Chunks command.
+func (cmd *Chunks) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running chunks command", "database", cmd.Database, "bucket", cmd.Bucket, "min", cmd.Min, "average", cmd.Average, "max", cmd.Max)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	options := chunks.Options{Min: cmd.Min, Average: cmd.Average, Max: cmd.Max}
+	if err := options.Validate(); err != nil {
+		slog.Error("invalid chunk sizes", "min", cmd.Min, "average", cmd.Average, "max", cmd.Max, "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// one path per distinct content is enough, since identical files have
+	// identical chunks
+	query := "select hash, count(*), coalesce(sum(size), 0), min(path) from entries"
+	params := []any{}
+	if cmd.Bucket != "" {
+		query += " where bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" group by hash", params...)
+	if err != nil {
+		slog.Error("error querying entries", "error", err)
+		return err
+	}
+	type content struct {
+		hash string
+		path string
+	}
+	contents := []content{}
+	estimate := &Estimate{}
+	for rows.Next() {
+		var c content
+		var files int
+		var bytes int64
+		if err := rows.Scan(&c.hash, &files, &bytes, &c.path); err != nil {
+			rows.Close()
+			slog.Error("error reading entry", "error", err)
+			return err
+		}
+		estimate.Files += files
+		estimate.Bytes += bytes
+		contents = append(contents, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading entries", "error", err)
+		return err
+	}
+
+	seen := map[[32]byte]struct{}{}
+	for _, c := range contents {
+		size, err := cmd.chunk(c.path, options, seen, estimate)
+		if err != nil {
+			slog.Warn("error chunking file", "path", c.path, "hash", c.hash, "error", err)
+			estimate.Failed++
+			continue
+		}
+		estimate.Contents++
+		estimate.ContentBytes += size
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(estimate)
+		if err != nil {
+			slog.Error("error marshalling estimate to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("%d files, %d bytes\n", estimate.Files, estimate.Bytes)
+		cmd.Output().Printf("whole-file deduplication: %d distinct contents, %d bytes (%s)\n", estimate.Contents, estimate.ContentBytes, ratio(estimate.ContentBytes, estimate.Bytes))
+		cmd.Output().Printf("chunk deduplication: %d unique chunks out of %d, %d bytes (%s)\n", estimate.UniqueChunks, estimate.Chunks, estimate.ChunkBytes, ratio(estimate.ChunkBytes, estimate.Bytes))
+		if estimate.Failed > 0 {
+			cmd.Output().Printf("%d contents could not be read and are not accounted for\n", estimate.Failed)
+		}
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// chunk splits the given file into chunks and accounts for the ones not seen
+// before; it returns the size of the file.
+func (cmd *Chunks) chunk(path string, options chunks.Options, seen map[[32]byte]struct{}, estimate *Estimate) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	chunker, err := chunks.New(f, options)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return size, nil
+		} else if err != nil {
+			return 0, err
+		}
+		size += int64(len(chunk))
+		estimate.Chunks++
+		sum := blake3.Sum256(chunk)
+		if _, ok := seen[sum]; !ok {
+			seen[sum] = struct{}{}
+			estimate.UniqueChunks++
+			estimate.ChunkBytes += int64(len(chunk))
+		}
+	}
+}
+
+// ratio returns the given size as a percentage of the total.
+func ratio(size int64, total int64) string {
+	if total == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%% of total", float64(size)*100/float64(total))
+}
@@ -0,0 +1,339 @@
+package clean
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/commands/move"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/journal"
+	"github.com/dihedron/dedup/manifest"
+	"github.com/dihedron/dedup/privileges"
+)
+
+// action is the name of the clean action in the journal.
+const action = "clean"
+
+// Clean is the command that deletes (or moves into a holding area) the local
+// files whose exact contents are present in a trusted archive, given either as
+// a bucket in the index or as a manifest: it is safe to delete them, since
+// they are already backed up. Each file is hashed again before being removed,
+// to make sure it still has the archived contents.
+type Clean struct {
+	base.Command
+	base.Database
+	base.Preview
+	// Archive is the bucket or manifest listing the archived contents.
+	Archive string `short:"i" long:"if-present-in" description:"The bucket in the index, or the manifest file, listing the contents of the trusted archive." required:"true"`
+	// Key is the public key the manifest must be signed with.
+	Key string `short:"k" long:"key" description:"The public key file the archive manifest must be signed with (required with a manifest, unless --insecure is given)." optional:"true"`
+	// Insecure allows trusting manifests without checking their signature.
+	Insecure bool `long:"insecure" description:"Trust the archive manifest without checking its signature (not recommended)." optional:"true"`
+	// Bucket restricts the clean to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose files should be cleaned (all buckets but the archive if not specified)." optional:"true"`
+	// Within restricts the clean to the files under the given directory.
+	Within string `short:"W" long:"within" description:"Only act on files under this directory." optional:"true"`
+	// Target is the holding area files are moved to instead of being deleted.
+	Target string `short:"t" long:"to" description:"Move the archived files into this directory instead of deleting them." optional:"true"`
+}
+
+// Summary contains the outcome of a clean.
+type Summary struct {
+	Archived int    `json:"archived"`
+	Deleted  int    `json:"deleted"`
+	Moved    int    `json:"moved"`
+	Bytes    int64  `json:"bytes"`
+	Skipped  int    `json:"skipped"`
+	Denied   int    `json:"denied,omitempty"`
+	Failed   int    `json:"failed"`
+	Run      string `json:"run,omitempty"`
+	DryRun   bool   `json:"dry_run,omitempty"`
+}
+
+// Execute is the real implementation of the Clean command.
+func (cmd *Clean) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running clean command", "database", cmd.Database, "archive", cmd.Archive, "bucket", cmd.Bucket, "target", cmd.Target)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	archived, algorithm, err := cmd.archived(db)
+	if err != nil {
+		return err
+	}
+
+	// collect the candidates first, since cleaning updates the entries read
+	candidates, err := cmd.candidates(db, archived)
+	if err != nil {
+		return err
+	}
+
+	// check all the removals up-front, so that those that would fail for lack
+	// of privileges are reported before anything is changed
+	denied := map[string]error{}
+	for _, entry := range candidates {
+		if err := cmd.preflight(entry); err != nil {
+			denied[entry.Path] = err
+			if !cmd.DryRun {
+				slog.Warn("archived file cannot be cleaned, skipping", "path", entry.Path, "error", err)
+			}
+		}
+	}
+
+	j := journal.New(db)
+	summary := &Summary{Archived: len(candidates), Run: j.Run(), DryRun: cmd.DryRun}
+	if cmd.DryRun {
+		summary.Run = ""
+	}
+	for _, entry := range candidates {
+		if err, ok := denied[entry.Path]; ok {
+			if cmd.DryRun && cmd.Target != "" {
+				cmd.WouldFail("move", entry.Path, move.Destination(cmd.Target, "", entry), entry.Size, err)
+			} else if cmd.DryRun {
+				cmd.WouldFail("delete", entry.Path, "", entry.Size, err)
+			}
+			summary.Denied++
+			continue
+		}
+		if _, err := index.Verify(entry.Path, algorithm, entry.Hash, entry.Size); err != nil {
+			slog.Warn("file does not have the archived contents anymore, skipping", "path", entry.Path, "error", err)
+			summary.Skipped++
+			continue
+		}
+		record := &journal.Record{Path: entry.Path, Target: cmd.Archive, Hash: entry.Hash, Bucket: entry.Bucket, Size: entry.Size}
+		if cmd.DryRun {
+			if cmd.Target != "" {
+				cmd.Would("move", entry.Path, move.Destination(cmd.Target, "", entry), entry.Size)
+				summary.Moved++
+			} else {
+				cmd.Would("delete", entry.Path, "", entry.Size)
+				summary.Deleted++
+			}
+			summary.Bytes += entry.Size
+			continue
+		}
+		if cmd.Target != "" {
+			err = move.Relocate(db, entry, move.Destination(cmd.Target, "", entry))
+		} else {
+			err = index.Discard(db, entry)
+		}
+		if err != nil {
+			err = &failures.ActionError{Action: action, Path: entry.Path, Err: err}
+			slog.Error("error cleaning archived file", "path", entry.Path, "error", err)
+			summary.Failed++
+			continue
+		}
+		record.Action = action
+		if err := j.Add(record); err != nil {
+			summary.Failed++
+			continue
+		}
+		slog.Info("archived file cleaned", "path", entry.Path, "archive", cmd.Archive)
+		if cmd.Target != "" {
+			summary.Moved++
+		} else {
+			summary.Deleted++
+		}
+		summary.Bytes += entry.Size
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if cmd.DryRun {
+		cmd.Output().Printf("would delete %d and move %d of %d archived files (%d bytes), %d skipped, %d denied\n", summary.Deleted, summary.Moved, summary.Archived, summary.Bytes, summary.Skipped, summary.Denied)
+	} else {
+		cmd.Output().Printf("deleted %d and moved %d of %d archived files (%d bytes), %d skipped, %d denied, %d failed (run %s)\n", summary.Deleted, summary.Moved, summary.Archived, summary.Bytes, summary.Skipped, summary.Denied, summary.Failed, summary.Run)
+	}
+	slog.Debug("command done")
+	if cmd.DryRun {
+		return nil
+	}
+	return failures.Partial(int64(summary.Failed + summary.Denied))
+}
+
+// preflight returns an error wrapping privileges.ErrDenied if the current user
+// is not allowed to clean the file of the given entry.
+func (cmd *Clean) preflight(entry *duplicates.Entry) error {
+	if cmd.Target != "" {
+		return move.Preflight(entry.Path, move.Destination(cmd.Target, "", entry))
+	}
+	return privileges.CanReplace(entry.Path)
+}
+
+// archived returns the sizes of the archived contents by hash, along with the
+// hash algorithm they were computed with; the archive is read from the
+// manifest file if one exists at the given path, from the bucket otherwise.
+func (cmd *Clean) archived(db *sql.DB) (map[string]int64, string, error) {
+	local := index.DefaultAlgorithm
+	if cmd.Bucket != "" {
+		metadata, err := buckets.Load(db, cmd.Bucket)
+		if err != nil {
+			return nil, "", err
+		}
+		if metadata != nil && metadata.Algorithm != "" {
+			local = metadata.Algorithm
+		}
+	}
+
+	archived := map[string]int64{}
+	if info, err := os.Stat(cmd.Archive); err == nil && info.Mode().IsRegular() {
+		m, err := cmd.load()
+		if err != nil {
+			return nil, "", err
+		}
+		// the files are hashed again with the algorithm of the manifest,
+		// which the bucket being cleaned must have been indexed with
+		if m.Algorithm == "" {
+			err := fmt.Errorf("manifest %s does not tell its hash algorithm", cmd.Archive)
+			slog.Error("error loading archive", "error", err)
+			return nil, "", err
+		}
+		if cmd.Bucket != "" && m.Algorithm != local {
+			err := fmt.Errorf("%w: archive was hashed with %s, index with %s", buckets.ErrIncompatible, m.Algorithm, local)
+			slog.Error("hash algorithms do not match", "error", err)
+			return nil, "", err
+		}
+		for _, root := range m.Roots {
+			for _, entry := range root.Entries {
+				archived[entry.Hash] = entry.Size
+			}
+		}
+		return archived, m.Algorithm, nil
+	}
+
+	metadata, err := buckets.Load(db, cmd.Archive)
+	if err != nil {
+		return nil, "", err
+	}
+	if metadata == nil {
+		err := fmt.Errorf("archive %s is neither a manifest file nor a bucket in the index", cmd.Archive)
+		slog.Error("error loading archive", "error", err)
+		return nil, "", err
+	}
+	if !metadata.Reference {
+		slog.Warn("archive bucket is not a read-only reference", "bucket", cmd.Archive)
+	}
+	names := []string{cmd.Archive}
+	if cmd.Bucket != "" {
+		names = append(names, cmd.Bucket)
+	}
+	if err := buckets.Compatible(db, names...); err != nil {
+		return nil, "", err
+	}
+	if metadata.Algorithm != "" {
+		local = metadata.Algorithm
+	}
+	rows, err := db.Query("select hash, coalesce(size, 0) from entries where bucket = ?", cmd.Archive)
+	if err != nil {
+		slog.Error("error querying archived entries", "bucket", cmd.Archive, "error", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		var size int64
+		if err := rows.Scan(&hash, &size); err != nil {
+			slog.Error("error reading archived entry", "error", err)
+			return nil, "", err
+		}
+		archived[hash] = size
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading archived entries", "error", err)
+		return nil, "", err
+	}
+	return archived, local, nil
+}
+
+// load reads and verifies the archive manifest, which must be signed with the
+// given key unless told to trust it as is: files are deleted on its word.
+func (cmd *Clean) load() (*manifest.Manifest, error) {
+	var key ed25519.PublicKey
+	if cmd.Key != "" {
+		var err error
+		if key, err = manifest.LoadPublicKey(cmd.Key); err != nil {
+			slog.Error("error loading public key", "path", cmd.Key, "error", err)
+			return nil, err
+		}
+	} else if !cmd.Insecure {
+		err := fmt.Errorf("a public key (--key) is required to verify the archive manifest, or --insecure to skip the check")
+		slog.Error("error loading archive", "error", err)
+		return nil, err
+	} else {
+		slog.Warn("INSECURE: the archive manifest will not be verified against a signature, it could be tampered with")
+		out := cmd.Output()
+		out.Warnf("%s: trusting the archive manifest without verifying its signature (--insecure)\n", out.Paint(base.Red, "WARNING"))
+	}
+	f, err := os.Open(cmd.Archive)
+	if err != nil {
+		slog.Error("error opening manifest", "path", cmd.Archive, "error", err)
+		return nil, err
+	}
+	defer f.Close()
+	m, err := manifest.Read(f, key)
+	if err != nil {
+		slog.Error("error reading manifest", "path", cmd.Archive, "error", err)
+		return nil, err
+	}
+	if !m.Signed {
+		slog.Warn("manifest is not signed", "path", cmd.Archive)
+	}
+	return m, nil
+}
+
+// candidates returns the local entries whose contents, hash and size, are in
+// the archive.
+func (cmd *Clean) candidates(db *sql.DB, archived map[string]int64) ([]*duplicates.Entry, error) {
+	// files in read-only reference buckets are never acted upon
+	query := "select hash, path, coalesce(bucket, ''), coalesce(size, 0) from entries where coalesce(bucket, '') != ? and coalesce((select reference from buckets where name = entries.bucket), 0) = 0"
+	params := []any{cmd.Archive}
+	if cmd.Bucket != "" {
+		query += " and bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" order by path", params...)
+	if err != nil {
+		slog.Error("error querying entries", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	candidates := []*duplicates.Entry{}
+	for rows.Next() {
+		entry := &duplicates.Entry{}
+		if err := rows.Scan(&entry.Hash, &entry.Path, &entry.Bucket, &entry.Size); err != nil {
+			slog.Error("error reading entry", "error", err)
+			return nil, err
+		}
+		if size, ok := archived[entry.Hash]; !ok || size != entry.Size {
+			continue
+		}
+		if cmd.Within != "" && !duplicates.Under(entry.Path, cmd.Within) {
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading entries", "error", err)
+		return nil, err
+	}
+	return candidates, nil
+}
@@ -0,0 +1,79 @@
+package clean
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/internal/testutil"
+	"github.com/dihedron/dedup/manifest"
+)
+
+func TestArchivedManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		signed   bool
+		key      bool
+		insecure bool
+		// bucket is the algorithm bucket "b" is indexed with, if cleaned.
+		bucket string
+		ok     bool
+	}{
+		{name: "signed", signed: true, key: true, ok: true},
+		{name: "signed, no key", signed: true},
+		{name: "unsigned", key: true},
+		{name: "unsigned, no key"},
+		{name: "unsigned, insecure", insecure: true, ok: true},
+		{name: "bucket with the same algorithm", signed: true, key: true, bucket: "blake3", ok: true},
+		{name: "bucket with another algorithm", signed: true, key: true, bucket: "sha256"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			dir := t.TempDir()
+			key := filepath.Join(dir, "key")
+			if err := manifest.GenerateKey(key); err != nil {
+				t.Fatal(err)
+			}
+			private, err := manifest.LoadPrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.signed {
+				private = nil
+			}
+			path := filepath.Join(dir, "manifest")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &manifest.Manifest{Algorithm: "blake3", Roots: []*manifest.Root{{Path: "/archive", Entries: []*manifest.Entry{{Hash: "h", Size: 1, Path: "a"}}}}}
+			if err := m.Write(f, private); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			cmd := &Clean{Archive: path, Insecure: test.insecure}
+			cmd.LogStream, cmd.LogFormat = "none", "text"
+			cmd.Color = "never"
+			cmd.Init()
+			if test.key {
+				cmd.Key = key + ".pub"
+			}
+			if test.bucket != "" {
+				cmd.Bucket = "b"
+				if err := buckets.Register(db, "b", test.bucket, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			archived, algorithm, err := cmd.archived(db)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected success %t, got %v", test.ok, err)
+			}
+			if test.ok && (algorithm != "blake3" || archived["h"] != 1) {
+				t.Errorf("expected the archive hashed with blake3, got %v with %s", archived, algorithm)
+			}
+		})
+	}
+}
@@ -1,14 +1,118 @@
 package command
 
 import (
+	"github.com/dihedron/dedup/commands/accept"
+	"github.com/dihedron/dedup/commands/analyze"
+	"github.com/dihedron/dedup/commands/archive"
+	"github.com/dihedron/dedup/commands/blocks"
+	"github.com/dihedron/dedup/commands/bucket"
+	"github.com/dihedron/dedup/commands/bursts"
+	"github.com/dihedron/dedup/commands/chunks"
+	"github.com/dihedron/dedup/commands/clean"
+	"github.com/dihedron/dedup/commands/consolidate"
+	"github.com/dihedron/dedup/commands/cp"
+	"github.com/dihedron/dedup/commands/doctor"
+	"github.com/dihedron/dedup/commands/drive"
+	"github.com/dihedron/dedup/commands/export"
+	"github.com/dihedron/dedup/commands/image"
 	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/commands/iso"
+	"github.com/dihedron/dedup/commands/known"
+	"github.com/dihedron/dedup/commands/mail"
+	"github.com/dihedron/dedup/commands/manifest"
+	"github.com/dihedron/dedup/commands/move"
+	"github.com/dihedron/dedup/commands/plan"
+	"github.com/dihedron/dedup/commands/plugin"
+	"github.com/dihedron/dedup/commands/query"
+	"github.com/dihedron/dedup/commands/replicate"
+	"github.com/dihedron/dedup/commands/report"
+	"github.com/dihedron/dedup/commands/s3"
+	"github.com/dihedron/dedup/commands/snapshots"
+	"github.com/dihedron/dedup/commands/symlink"
+	"github.com/dihedron/dedup/commands/tag"
+	"github.com/dihedron/dedup/commands/triage"
+	"github.com/dihedron/dedup/commands/update"
 	"github.com/dihedron/dedup/commands/version"
+	"github.com/dihedron/dedup/commands/videos"
+	"github.com/dihedron/dedup/commands/webdav"
 )
 
 // Commands is the set of root command groups.
 type Commands struct {
+	// Accept marks duplicate groups as accepted.
+	Accept accept.Accept `command:"accept" alias:"ok" description:"Accept duplicate groups as they are, hiding them from reports and actions."`
+	// Analyze opens the index database with DuckDB.
+	Analyze analyze.Analyze `command:"analyze" alias:"an" description:"Open the index database with DuckDB for fast analytical queries."`
+	// Archive stores unique contents in, and restores files from, content-addressed archives.
+	Archive archive.Archive `command:"archive" alias:"arc" description:"Store files into, and restore them from, a content-addressed archive."`
+	// Bench measures how fast files are digested with different settings.
+	Bench index.Bench `command:"bench" description:"Measure the digest throughput with different hash algorithms and numbers of workers."`
+	// Blocks deduplicates the extents of duplicate files at the filesystem level.
+	Blocks blocks.Blocks `command:"blocks" alias:"blk" description:"Deduplicate identical extents of duplicate files at the filesystem level."`
+	// Bucket lists the buckets and changes their settings.
+	Bucket bucket.Bucket `command:"bucket" alias:"bkt" description:"List the buckets in the index and mark them as read-only references."`
+	// Bursts groups series of near-identical photos taken in quick succession.
+	Bursts bursts.Bursts `command:"bursts" alias:"burst" description:"Report series of near-identical photos taken in quick succession (e.g. camera bursts)."`
+	// Chunks estimates how much chunk-based backups would deduplicate.
+	Chunks chunks.Chunks `command:"chunks" alias:"chk" description:"Estimate how much a chunk-based backup tool would deduplicate the indexed files."`
+	// Clean deletes the files whose contents are already in a trusted archive.
+	Clean clean.Clean `command:"clean" description:"Delete (or move away) the files whose contents are verified present in a trusted archive bucket or manifest."`
+	// ConsolidateBackups turns series of dated backup trees into hardlink farms.
+	ConsolidateBackups consolidate.Backups `command:"consolidate-backups" alias:"consolidate" description:"Replace identical files across a series of dated backup trees with hard links to the oldest copy."`
+	// Copy copies files, linking to existing copies of the same content.
+	Copy cp.Copy `command:"cp" alias:"copy" description:"Copy files, linking to or skipping content already present at the destination."`
+	// Docs generates the reference documentation of the commands.
+	Docs Docs `command:"docs" description:"Generate the man page or the Markdown reference of the commands."`
+	// Doctor checks the health of the index database.
+	Doctor doctor.Doctor `command:"doctor" alias:"dr" description:"Check the index database for corruption and inconsistent rows, and fix them."`
+	// Export dumps the index entries for analysis with external tools.
+	Export export.Export `command:"export" alias:"exp" description:"Export the index entries as NDJSON, CSV or Parquet."`
+	// GoogleDrive indexes the files in Google Drive by their reported checksums.
+	GoogleDrive drive.GoogleDrive `command:"gdrive" description:"Index the files in Google Drive by the checksums Drive reports, without downloading them."`
+	// HashWorker digests the files queued by index runs on other hosts.
+	HashWorker index.Worker `command:"hash-worker" alias:"worker" description:"Digest the files queued on Redis by index runs started with --queue, on hosts sharing the same storage."`
+	// Image indexes the files inside the layers of container images.
+	Image image.Image `command:"image" alias:"img" description:"Index the files inside the layers of container images, from tarballs or the Docker daemon."`
 	// Version prints the application's version information and exits.
 	Index index.Index `command:"index" alias:"idx" alias:"i" description:"Index the given directory(es) contents."`
+	// ISO indexes the files inside ISO9660 images.
+	ISO iso.ISO `command:"iso" description:"Index the files inside ISO9660 images, without mounting them."`
+	// Known reports the entries matching known hash sets.
+	Known known.Known `command:"known" alias:"kn" description:"Report the entries matching known hash sets."`
+	// Mail indexes the messages in mbox files and Maildir folders.
+	Mail mail.Mail `command:"mail" alias:"eml" description:"Index the messages in mbox files and Maildir folders, to find duplicate emails."`
+	// Manifest creates, compares and imports portable index manifests.
+	Manifest manifest.Manifest `command:"manifest" alias:"mf" description:"Create, compare and import portable (optionally signed) index manifests."`
+	// Move relocates duplicates into a holding area.
+	Move move.Move `command:"move" alias:"mv" description:"Move duplicates (all copies but the one to keep) into a holding area."`
+	// OneDrive indexes the files in OneDrive by their reported hashes.
+	OneDrive drive.OneDrive `command:"onedrive" description:"Index the files in OneDrive or SharePoint by the hashes Microsoft Graph reports, without downloading them."`
+	// Plan imports the decisions taken on an edited report and applies them.
+	Plan plan.Plan `command:"plan" alias:"pl" description:"Import the keep/delete decisions of an edited report and apply them."`
+	// Plugins lists and runs the plugins extending the tool.
+	Plugins plugin.Plugins `command:"plugins" alias:"plugin" description:"List the plugins extending the tool, and run source and action plugins."`
+	// Query runs SQL queries against the index database.
+	Query query.Query `command:"query" alias:"q" description:"Run SQL queries against the index database."`
+	// Replicate continuously copies the index database to another path.
+	Replicate replicate.Replicate `command:"replicate" alias:"repl" description:"Continuously replicate the index database to another path."`
+	// Report lists the groups of duplicates in the index.
+	Report report.Report `command:"report" alias:"rep" description:"Report the groups of duplicate files and the copy to keep of each."`
+	// S3 indexes the objects in a bucket of an S3-compatible object store.
+	S3 s3.S3 `command:"s3" description:"Index the objects in an S3-compatible bucket, reusing ETags and checksums instead of downloading them where possible."`
+	// SelfUpdate replaces the running binary with the latest release.
+	SelfUpdate update.SelfUpdate `command:"self-update" description:"Replace the running binary with the latest verified release."`
+	// SimilarVideos groups videos with the same footage in different encodings.
+	SimilarVideos videos.SimilarVideos `command:"similar-videos" alias:"sv" description:"Report videos with the same footage in different containers or encodings."`
+	// Snapshots compares the buckets of a series of snapshots.
+	Snapshots snapshots.Snapshots `command:"snapshots" alias:"snap" description:"Compare a series of snapshot buckets: contents common to all, unique to each, and the savings of hardlinking them."`
+	// Symlink replaces duplicates with symbolic links to the copy to keep.
+	Symlink symlink.Symlink `command:"symlink" alias:"ln" description:"Replace duplicates with symbolic links to the copy to keep."`
+	// Tag attaches labels and notes to entries and duplicate groups.
+	Tag tag.Tag `command:"tag" description:"Attach labels and notes to files and duplicate groups."`
+	// TriageDownloads reports the files in a folder that are already archived.
+	TriageDownloads triage.Downloads `command:"triage-downloads" alias:"triage" description:"Index a downloads folder and report which of its files are safe to delete, since identical copies are archived."`
 	// Version prints the application's version information and exits.
 	Version version.Version `command:"version" alias:"ver" alias:"v" description:"Show the application version and exit."`
+	// WebDAV indexes the files on a WebDAV server.
+	WebDAV webdav.WebDAV `command:"webdav" alias:"dav" description:"Index the files on a WebDAV server (e.g. Nextcloud, ownCloud) by streaming their contents."`
 }
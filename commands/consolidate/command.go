@@ -0,0 +1,250 @@
+package consolidate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/journal"
+)
+
+// action is the name of the consolidate action in the journal.
+const action = "consolidate"
+
+// Backups is the command that converts a series of dated backup trees (see
+// bucket --snapshot) into a hardlink farm: identical files across the
+// snapshots are replaced with hard links to the copy in the oldest snapshot,
+// by the time each snapshot was taken, so that each content is stored once.
+// Copies are only linked if they have the same modification time, owner and
+// permissions as their keeper, since links share them and restoring a backup
+// should give back the files as they were. Files already linked to their
+// keeper are skipped, so an interrupted run can simply be started again; all
+// replacements are journaled.
+type Backups struct {
+	base.Command
+	base.Database
+	base.Preview
+	// Series is the series of snapshots to consolidate.
+	Series string `short:"s" long:"series" description:"The series of snapshot buckets to consolidate." required:"true"`
+	// IgnoreOwner links files regardless of their owner, group and mode.
+	IgnoreOwner bool `long:"ignore-owner" description:"Link identical files even if their owner, group or permissions differ (links share them)." optional:"true"`
+	// IgnoreModified links files regardless of their modification time.
+	IgnoreModified bool `long:"ignore-mtime" description:"Link identical files even if their modification times differ (links share them, so the copies in later snapshots take the time of the oldest)." optional:"true"`
+}
+
+// Summary contains the outcome of a consolidation.
+type Summary struct {
+	Run     string `json:"run,omitempty"`
+	Groups  int    `json:"groups"`
+	Linked  int    `json:"linked"`
+	Already int    `json:"already"`
+	Skipped int    `json:"skipped"`
+	Failed  int    `json:"failed"`
+	Bytes   int64  `json:"bytes"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// Execute is the real implementation of the Backups command.
+func (cmd *Backups) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running consolidate-backups command", "database", cmd.Database, "series", cmd.Series)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	algorithm, err := cmd.algorithm(db)
+	if err != nil {
+		return err
+	}
+
+	// collect the copies first, oldest snapshot first, so that the keeper of
+	// each content is its first copy; times are stored as UTC RFC3339, so
+	// they sort chronologically
+	rows, err := db.Query(`
+		select e.hash, e.path, coalesce(e.bucket, ''), coalesce(e.size, 0) from entries e join buckets b on b.name = e.bucket
+		where b.series = ? and coalesce(e.size, 0) > 0
+		and e.hash in (select hash from entries where bucket in (select name from buckets where series = ?) group by hash having count(*) > 1)
+		order by e.hash, b.taken, e.bucket, e.path`, cmd.Series, cmd.Series)
+	if err != nil {
+		slog.Error("error querying snapshot copies", "series", cmd.Series, "error", err)
+		return err
+	}
+	groups := [][]*duplicates.Entry{}
+	for rows.Next() {
+		entry := &duplicates.Entry{}
+		if err := rows.Scan(&entry.Hash, &entry.Path, &entry.Bucket, &entry.Size); err != nil {
+			rows.Close()
+			slog.Error("error reading snapshot copy", "error", err)
+			return err
+		}
+		if n := len(groups); n > 0 && groups[n-1][0].Hash == entry.Hash {
+			groups[n-1] = append(groups[n-1], entry)
+		} else {
+			groups = append(groups, []*duplicates.Entry{entry})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		slog.Error("error reading snapshot copies", "error", err)
+		return err
+	}
+	rows.Close()
+
+	j := journal.New(db)
+	summary := &Summary{Run: j.Run(), DryRun: cmd.DryRun}
+	if cmd.DryRun {
+		summary.Run = ""
+	}
+	for _, group := range groups {
+		summary.Groups++
+		cmd.group(j, group, algorithm, summary)
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if cmd.DryRun {
+		cmd.Output().Printf("would link %d copies (%d bytes) in %d groups, %d already linked, %d skipped\n", summary.Linked, summary.Bytes, summary.Groups, summary.Already, summary.Skipped)
+	} else {
+		cmd.Output().Printf("linked %d copies (%d bytes) in %d groups, %d already linked, %d skipped, %d failed (run %s)\n", summary.Linked, summary.Bytes, summary.Groups, summary.Already, summary.Skipped, summary.Failed, summary.Run)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// algorithm returns the hash algorithm of the snapshots in the series, once
+// it has checked that all of them record when they were taken.
+func (cmd *Backups) algorithm(db *sql.DB) (string, error) {
+	rows, err := db.Query("select name, coalesce(taken, '') from buckets where series = ? order by name", cmd.Series)
+	if err != nil {
+		slog.Error("error querying snapshots", "series", cmd.Series, "error", err)
+		return "", err
+	}
+	names := []string{}
+	undated := []string{}
+	for rows.Next() {
+		var name, taken string
+		if err := rows.Scan(&name, &taken); err != nil {
+			rows.Close()
+			slog.Error("error reading snapshot", "error", err)
+			return "", err
+		}
+		names = append(names, name)
+		if taken == "" {
+			undated = append(undated, name)
+		}
+	}
+	rows.Close()
+	if len(names) == 0 {
+		err := fmt.Errorf("no snapshots in series %s", cmd.Series)
+		slog.Error("error consolidating snapshots", "error", err)
+		return "", err
+	}
+	if len(undated) > 0 {
+		// without it the oldest snapshot, holding the keepers, is unknown
+		err := fmt.Errorf("snapshots %s do not record when they were taken, set it with bucket --snapshot=bucket=%s@time", strings.Join(undated, ", "), cmd.Series)
+		slog.Error("error consolidating snapshots", "error", err)
+		return "", err
+	}
+	if err := buckets.Compatible(db, names...); err != nil {
+		return "", err
+	}
+	metadata, err := buckets.Load(db, names[0])
+	if err != nil {
+		return "", err
+	}
+	if metadata == nil || metadata.Algorithm == "" {
+		return index.DefaultAlgorithm, nil
+	}
+	return metadata.Algorithm, nil
+}
+
+// group links the copies of the same contents to the first one, once it has
+// been verified to still hold the indexed contents.
+func (cmd *Backups) group(j *journal.Journal, group []*duplicates.Entry, algorithm string, summary *Summary) {
+	keeper := group[0]
+	keeperInfo, err := index.Verify(keeper.Path, algorithm, keeper.Hash, keeper.Size)
+	if err != nil {
+		slog.Warn("keeper does not have the indexed contents anymore, skipping group", "path", keeper.Path, "error", err)
+		summary.Skipped += len(group) - 1
+		return
+	}
+	for _, entry := range group[1:] {
+		info, err := os.Lstat(entry.Path)
+		if err != nil {
+			slog.Warn("copy cannot be read, skipping", "path", entry.Path, "error", err)
+			summary.Skipped++
+			continue
+		}
+		if os.SameFile(keeperInfo, info) {
+			summary.Already++
+			continue
+		}
+		if !cmd.IgnoreOwner && (info.Mode().Perm() != keeperInfo.Mode().Perm() || !sameOwner(info, keeperInfo)) {
+			slog.Warn("copy has a different owner or permissions than its keeper, skipping", "path", entry.Path, "keeper", keeper.Path)
+			summary.Skipped++
+			continue
+		}
+		if !cmd.IgnoreModified && !info.ModTime().Equal(keeperInfo.ModTime()) {
+			slog.Warn("copy has a different modification time than its keeper, skipping", "path", entry.Path, "keeper", keeper.Path)
+			summary.Skipped++
+			continue
+		}
+		if _, err := index.Verify(entry.Path, algorithm, entry.Hash, entry.Size); err != nil {
+			slog.Warn("copy does not have the indexed contents anymore, skipping", "path", entry.Path, "error", err)
+			summary.Skipped++
+			continue
+		}
+		if cmd.DryRun {
+			cmd.Would("hardlink", entry.Path, keeper.Path, entry.Size)
+			summary.Linked++
+			summary.Bytes += entry.Size
+			continue
+		}
+		if err := link(keeper.Path, entry.Path); err != nil {
+			err = &failures.ActionError{Action: action, Path: entry.Path, Err: err}
+			slog.Error("error replacing copy with hard link", "path", entry.Path, "keeper", keeper.Path, "error", err)
+			summary.Failed++
+			continue
+		}
+		if err := j.Add(&journal.Record{Action: action, Path: entry.Path, Target: keeper.Path, Hash: entry.Hash, Bucket: entry.Bucket, Size: entry.Size}); err != nil {
+			summary.Failed++
+			continue
+		}
+		slog.Info("copy replaced with hard link", "path", entry.Path, "keeper", keeper.Path)
+		summary.Linked++
+		summary.Bytes += entry.Size
+	}
+}
+
+// link atomically replaces the file at path with a hard link to the keeper.
+func link(keeper string, path string) error {
+	// create the link next to the copy, then rename it over the copy
+	temporary := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.dedup-%d", filepath.Base(path), os.Getpid()))
+	if err := os.Link(keeper, temporary); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary, path); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package consolidate
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/internal/testutil"
+	"github.com/dihedron/dedup/journal"
+)
+
+func TestGroupModified(t *testing.T) {
+	const contents = "same contents"
+	tests := []struct {
+		name string
+		// modified is whether the copy has another modification time than
+		// its keeper.
+		modified bool
+		ignore   bool
+		linked   bool
+	}{
+		{name: "same time", linked: true},
+		{name: "different time", modified: true},
+		{name: "different time, ignored", modified: true, ignore: true, linked: true},
+	}
+	h, err := index.NewHasher(index.DefaultAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write([]byte(contents))
+	hash := hex.EncodeToString(h.Sum(nil))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			dir := t.TempDir()
+			group := []*duplicates.Entry{}
+			taken := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+			for _, name := range []string{"2024-06-01", "2024-06-02"} {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, taken, taken); err != nil {
+					t.Fatal(err)
+				}
+				group = append(group, &duplicates.Entry{Hash: hash, Path: path, Bucket: name, Size: int64(len(contents))})
+				if test.modified {
+					taken = taken.Add(time.Hour)
+				}
+			}
+
+			cmd := &Backups{IgnoreModified: test.ignore}
+			cmd.Color = "never"
+			summary := &Summary{}
+			cmd.group(journal.New(db), group, index.DefaultAlgorithm, summary)
+
+			keeper, err := os.Stat(group[0].Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := os.Stat(group[1].Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(keeper, other) != test.linked {
+				t.Errorf("expected copy linked %t, got %+v", test.linked, summary)
+			}
+			if skipped := !test.linked; (summary.Skipped == 1) != skipped || summary.Failed != 0 {
+				t.Errorf("expected copy skipped %t, got %+v", skipped, summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package consolidate
+
+import (
+	"io/fs"
+)
+
+// sameOwner returns whether the two files have the same owner and group;
+// ownership is not available on this platform.
+func sameOwner(a fs.FileInfo, b fs.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package consolidate
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// sameOwner returns whether the two files have the same owner and group,
+// which hard links cannot tell apart.
+func sameOwner(a fs.FileInfo, b fs.FileInfo) bool {
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	sb, ok := b.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	return sa.Uid == sb.Uid && sa.Gid == sb.Gid
+}
@@ -0,0 +1,293 @@
+package cp
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/dihedron/dedup/bloom"
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+)
+
+// Copy is the command that copies files consulting the index first: if the
+// same content is already present on the destination side, the file is linked
+// to the existing copy (or skipped) rather than copied, otherwise it is copied
+// and the new file is added to the index.
+type Copy struct {
+	base.Command
+	base.Database
+	// Bucket is the bucket new files are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index copied files into." optional:"true" default:"default"`
+	// Root is the directory where existing copies of the content are looked for.
+	Root string `short:"r" long:"root" description:"The directory tree on the destination side where existing copies are looked for (defaults to the destination directory)." optional:"true"`
+	// Existing is what to do when the content is already on the destination side.
+	Existing string `short:"e" long:"existing" description:"What to do when identical content already exists on the destination side." optional:"true" choice:"hardlink" choice:"reflink" choice:"skip" default:"hardlink"`
+	// Hash is the hash algorithm the index was built with.
+	Hash string `long:"hash" description:"The hash algorithm the index was built with." optional:"true" default:"sha256"`
+	// filter tells the contents certainly not in the index, which need no
+	// lookup.
+	filter *bloom.Filter
+	// Arguments are the source and destination paths.
+	Arguments struct {
+		Source      string `positional-arg-name:"source" description:"The file or directory to copy."`
+		Destination string `positional-arg-name:"destination" description:"The destination file or directory."`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Summary contains the outcome of a copy.
+type Summary struct {
+	Copied  int   `json:"copied"`
+	Linked  int   `json:"linked"`
+	Skipped int   `json:"skipped"`
+	Failed  int   `json:"failed"`
+	Saved   int64 `json:"saved"`
+}
+
+// Execute is the real implementation of the Copy command.
+func (cmd *Copy) Execute(args []string) error {
+	cmd.Init()
+	source, destination := cmd.Arguments.Source, cmd.Arguments.Destination
+	slog.Debug("running cp command", "source", source, "destination", destination, "database", cmd.Database)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if cmd.filter, err = bloom.Load(db); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		slog.Error("error reading source", "path", source, "error", err)
+		return err
+	}
+	// copying a file into an existing directory keeps its name
+	if !info.IsDir() {
+		if target, err := os.Stat(destination); err == nil && target.IsDir() {
+			destination = filepath.Join(destination, filepath.Base(source))
+		}
+	}
+	root := cmd.Root
+	if root == "" {
+		root = destination
+		if !info.IsDir() {
+			root = filepath.Dir(destination)
+		}
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		slog.Error("error resolving root", "path", root, "error", err)
+		return err
+	}
+
+	// new files are indexed like the destination tree would be, and must be
+	// hashed like the rest of their bucket
+	if target, err := filepath.Abs(destination); err == nil {
+		if err := buckets.Register(db, cmd.Bucket, cmd.Hash, []string{target}, nil); err != nil {
+			return err
+		}
+	}
+
+	summary := &Summary{}
+	err = filepath.WalkDir(source, func(path string, object fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Error("error visiting source", "path", path, "error", err)
+			summary.Failed++
+			return nil
+		}
+		relative, _ := filepath.Rel(source, path)
+		target := filepath.Join(destination, relative)
+		if object.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				slog.Error("error creating directory", "path", target, "error", err)
+				return err
+			}
+			return nil
+		}
+		if !object.Type().IsRegular() {
+			slog.Warn("skipping non regular file", "path", path, "type", object.Type().String())
+			summary.Skipped++
+			return nil
+		}
+		if err := cmd.copy(db, path, target, root, summary); err != nil {
+			slog.Error("error copying file", "source", path, "target", target, "error", err)
+			summary.Failed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := bloom.Save(db, cmd.filter); err != nil {
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("copied %d files, linked %d, skipped %d, %d failed (%d bytes saved)\n", summary.Copied, summary.Linked, summary.Skipped, summary.Failed, summary.Saved)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// copy copies a single file, unless its content already exists under root.
+func (cmd *Copy) copy(db *sql.DB, source string, target string, root string, summary *Summary) error {
+	hash, size, err := cmd.digest(source)
+	if err != nil {
+		return err
+	}
+	existing := ""
+	if cmd.filter.Test(hash) {
+		if existing, err = lookup(db, cmd.Hash, hash, size, root); err != nil {
+			return err
+		}
+	}
+	// the content is in the index from now on, whatever the outcome
+	cmd.filter.Add(hash)
+	if existing != "" {
+		slog.Debug("content already exists on destination side", "source", source, "existing", existing)
+		switch cmd.Existing {
+		case "skip":
+			summary.Skipped++
+			summary.Saved += size
+			return nil
+		case "hardlink":
+			err = os.Link(existing, target)
+		case "reflink":
+			err = reflink(existing, target)
+		}
+		if err == nil {
+			summary.Linked++
+			summary.Saved += size
+			return cmd.store(db, target, hash)
+		}
+		// e.g. across devices, fall back to copying
+		slog.Warn("error linking to existing copy, copying instead", "existing", existing, "target", target, "error", err)
+	}
+	if err := copyFile(source, target); err != nil {
+		return err
+	}
+	summary.Copied++
+	return cmd.store(db, target, hash)
+}
+
+// store adds the given file to the index, checking that it has the contents
+// of the source, which may have changed while it was being copied.
+func (cmd *Copy) store(db *sql.DB, path string, hash string) error {
+	stored, err := index.Store(db, cmd.Bucket, cmd.Hash, path)
+	if err != nil {
+		return err
+	}
+	if stored != hash {
+		return fmt.Errorf("%s does not have the contents of its source (hash %s, expected %s)", path, stored, hash)
+	}
+	return nil
+}
+
+// digest computes the hash and size of the given file.
+func (cmd *Copy) digest(path string) (string, int64, error) {
+	h, err := index.NewHasher(cmd.Hash)
+	if err != nil {
+		return "", 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// lookup returns the path of an indexed file under root having the given hash
+// (computed with the given algorithm) and size, and still having those
+// contents on disk, or an empty string.
+func lookup(db *sql.DB, algorithm string, hash string, size int64, root string) (string, error) {
+	rows, err := db.Query("select path from entries where hash = ? and size = ? and coalesce((select algorithm from buckets where name = entries.bucket), ?) = ?", hash, size, algorithm, algorithm)
+	if err != nil {
+		slog.Error("error querying existing copies", "hash", hash, "error", err)
+		return "", err
+	}
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return "", err
+		}
+		// indexed paths may be relative to the current directory
+		if duplicates.Under(path, root) {
+			paths = append(paths, path)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	// files edited in place since they were indexed must not be linked to
+	for _, path := range paths {
+		if _, err := index.Verify(path, algorithm, hash, size); errors.Is(err, fs.ErrNotExist) {
+			slog.Debug("existing copy removed since indexing, ignoring", "path", path)
+			continue
+		} else if err != nil {
+			slog.Warn("existing copy changed since indexing, ignoring", "path", path, "error", err)
+			continue
+		}
+		return path, nil
+	}
+	return "", nil
+}
+
+// copyFile copies the contents, permissions and modification time of the
+// source file to the target, which must not exist.
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+// ErrNotSupported is returned when reflinks are not available.
+var ErrNotSupported = errors.New("reflinks are not supported on this platform")
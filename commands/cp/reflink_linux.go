@@ -0,0 +1,33 @@
+//go:build linux
+
+package cp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates the target as a copy-on-write clone of the source (FICLONE),
+// sharing its extents on filesystems that support it (e.g. btrfs, XFS).
+func reflink(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package cp
+
+// reflink always fails, since FICLONE is Linux-specific.
+func reflink(source string, target string) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,165 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/version"
+	"github.com/jessevdk/go-flags"
+)
+
+// Docs is the command that generates the reference documentation of the
+// commands and their options from the same metadata the command line help is
+// built from, so that the two never diverge.
+type Docs struct {
+	// Man writes the man page.
+	Man DocsMan `command:"man" description:"Write the man page (troff) of all the commands."`
+	// Markdown writes the Markdown reference.
+	Markdown DocsMarkdown `command:"markdown" alias:"md" description:"Write the Markdown reference of all the commands."`
+}
+
+// docsOutput contains the options common to the docs subcommands.
+type docsOutput struct {
+	base.Command
+	// Output is the file the documentation is written to.
+	Output string `short:"o" long:"output" description:"The file to write the documentation to (- for standard output)." optional:"true" default:"-"`
+}
+
+// write opens the output and calls the given function to fill it.
+func (cmd *docsOutput) write(generate func(io.Writer, *flags.Parser) error) error {
+	var w io.Writer = os.Stdout
+	if cmd.Output != "-" {
+		f, err := os.Create(cmd.Output)
+		if err != nil {
+			slog.Error("error creating documentation file", "path", cmd.Output, "error", err)
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := generate(w, newDocsParser()); err != nil {
+		slog.Error("error writing documentation", "path", cmd.Output, "error", err)
+		return err
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// newDocsParser returns a parser of all the commands, set up like the one of
+// the application.
+func newDocsParser() *flags.Parser {
+	parser := flags.NewParser(&Commands{}, flags.Default)
+	parser.Name = "dedup"
+	parser.ShortDescription = version.Description
+	if parser.ShortDescription == "" {
+		parser.ShortDescription = "Detect duplicate files on disk."
+	}
+	parser.LongDescription = fmt.Sprintf("Options can also be given through the environment, as %s_<OPTION> or %s_<COMMAND>_<OPTION> (e.g. %s_DATABASE, %s_INDEX_BUCKET); values of repeatable options are separated by commas. %s_<OPTION> applies to every command, so the options of the commands that change files on disk, besides the logging and database ones, can only be given as %s_<COMMAND>_<OPTION>.", EnvironmentPrefix, EnvironmentPrefix, EnvironmentPrefix, EnvironmentPrefix, EnvironmentPrefix, EnvironmentPrefix)
+	Environment(parser)
+	return parser
+}
+
+// DocsMan is the command that writes the man page.
+type DocsMan struct {
+	docsOutput
+}
+
+// Execute is the real implementation of the DocsMan command.
+func (cmd *DocsMan) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running docs man command", "output", cmd.Output)
+	return cmd.write(func(w io.Writer, parser *flags.Parser) error {
+		parser.WriteManPage(w)
+		return nil
+	})
+}
+
+// DocsMarkdown is the command that writes the Markdown reference.
+type DocsMarkdown struct {
+	docsOutput
+}
+
+// Execute is the real implementation of the DocsMarkdown command.
+func (cmd *DocsMarkdown) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running docs markdown command", "output", cmd.Output)
+	return cmd.write(func(w io.Writer, parser *flags.Parser) error {
+		fmt.Fprintf(w, "# %s\n\n%s\n\n%s\n", parser.Name, parser.ShortDescription, strings.ReplaceAll(parser.LongDescription, "<", `\<`))
+		for _, command := range parser.Commands() {
+			markdown(w, command, parser.Name)
+		}
+		return nil
+	})
+}
+
+// markdown writes the reference of the given command and of its subcommands.
+func markdown(w io.Writer, command *flags.Command, parent string) {
+	name := parent + " " + command.Name
+	fmt.Fprintf(w, "\n## %s\n\n%s\n", name, command.ShortDescription)
+	if command.LongDescription != "" {
+		fmt.Fprintf(w, "\n%s\n", command.LongDescription)
+	}
+	if len(command.Aliases) > 0 {
+		fmt.Fprintf(w, "\nAliases: `%s`.\n", strings.Join(command.Aliases, "`, `"))
+	}
+	if args := command.Args(); len(args) > 0 {
+		fmt.Fprintf(w, "\n| Argument | Description |\n| --- | --- |\n")
+		for _, arg := range args {
+			fmt.Fprintf(w, "| `%s` | %s |\n", arg.Name, cell(arg.Description))
+		}
+	}
+	options := []*flags.Option{}
+	var collect func(group *flags.Group)
+	collect = func(group *flags.Group) {
+		for _, option := range group.Options() {
+			if !option.Hidden {
+				options = append(options, option)
+			}
+		}
+		for _, child := range group.Groups() {
+			collect(child)
+		}
+	}
+	collect(command.Group)
+	if len(options) > 0 {
+		fmt.Fprintf(w, "\n| Option | Environment | Default | Description |\n| --- | --- | --- | --- |\n")
+		for _, option := range options {
+			description := option.Description
+			if len(option.Choices) > 0 {
+				description += " One of: " + strings.Join(option.Choices, ", ") + "."
+			}
+			if option.Required {
+				description += " Required."
+			}
+			environment := ""
+			if option.EnvDefaultKey != "" {
+				// the generic variable if the option has one, not the one
+				// found in this environment
+				variable := EnvironmentPrefix
+				if !global(command, option) {
+					for _, name := range strings.Fields(name)[1:] {
+						variable += "_" + key(name)
+					}
+				}
+				environment = "`" + variable + "_" + key(option.LongName) + "`"
+			}
+			def := ""
+			if len(option.Default) > 0 {
+				def = "`" + strings.Join(option.Default, ",") + "`"
+			}
+			fmt.Fprintf(w, "| `%s` | %s | %s | %s |\n", option, environment, def, cell(description))
+		}
+	}
+	for _, child := range command.Commands() {
+		markdown(w, child, name)
+	}
+}
+
+// cell escapes the text for use in a Markdown table cell.
+func cell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(text)
+}
@@ -0,0 +1,76 @@
+package doctor
+
+// check is a consistency check of the index database.
+type check struct {
+	// name identifies the check.
+	name string
+	// description tells what the check looks for.
+	description string
+	// count is the query counting the anomalies.
+	count string
+	// fix is the statement removing the anomalies, if they can be fixed
+	// automatically.
+	fix string
+	// advice tells how to fix the anomalies by hand otherwise.
+	advice string
+}
+
+// checks are the consistency checks run, after the SQLite integrity check.
+var checks = []check{
+	{
+		name:        "unregistered-buckets",
+		description: "entries in buckets that are not registered",
+		count:       "select count(*) from entries where bucket is not null and bucket not in (select name from buckets)",
+		fix:         "insert or ignore into buckets(name, created) select distinct bucket, strftime('%Y-%m-%dT%H:%M:%SZ', 'now') from entries where bucket is not null and bucket not in (select name from buckets)",
+	},
+	{
+		name:        "entries-without-bucket",
+		description: "entries not in any bucket",
+		count:       "select count(*) from entries where bucket is null or bucket = ''",
+		advice:      "index their paths again with --bucket, or delete them with the query command",
+	},
+	{
+		name:        "stale-contents",
+		description: "paths indexed with more than one content",
+		count:       "select count(*) from entries e where exists (select 1 from entries o where o.path = e.path and o.rowid != e.rowid and (coalesce(o.indexed, '') > coalesce(e.indexed, '') or (coalesce(o.indexed, '') = coalesce(e.indexed, '') and o.rowid > e.rowid)))",
+		// only the content indexed last is kept
+		fix: "delete from entries where rowid in (select e.rowid from entries e where exists (select 1 from entries o where o.path = e.path and o.rowid != e.rowid and (coalesce(o.indexed, '') > coalesce(e.indexed, '') or (coalesce(o.indexed, '') = coalesce(e.indexed, '') and o.rowid > e.rowid))))",
+	},
+	{
+		name:        "mixed-algorithms",
+		description: "entries whose hash was not computed with the algorithm of their bucket",
+		count: `select count(*) from entries e join buckets b on b.name = e.bucket where b.algorithm is not null and b.algorithm != '' and length(e.hash) !=
+			case b.algorithm when 'md5' then 32 when 'sha1' then 40 when 'sha256' then 64 when 'blake3' then 64 when 'sha512' then 128 when 'quickxor' then 40 else length(e.hash) end`,
+		advice: "index the bucket again from scratch with the --hash it was registered with",
+	},
+	{
+		name:        "orphaned-acceptances",
+		description: "accepted groups that do not exist",
+		count:       "select count(*) from accepted where group_id not in (select id from duplicate_groups)",
+		fix:         "delete from accepted where group_id not in (select id from duplicate_groups)",
+	},
+	{
+		name:        "orphaned-tags",
+		description: "tags on entries or groups that do not exist",
+		count:       "select count(*) from tags where (kind = 'entry' and target not in (select path from entries)) or (kind = 'group' and cast(target as integer) not in (select id from duplicate_groups))",
+		fix:         "delete from tags where (kind = 'entry' and target not in (select path from entries)) or (kind = 'group' and cast(target as integer) not in (select id from duplicate_groups))",
+	},
+	{
+		name:        "orphaned-decisions",
+		description: "pending plan decisions on entries that do not exist",
+		count:       "select count(*) from decisions d where d.applied is null and not exists (select 1 from entries e where e.path = d.path and e.hash = d.hash)",
+		fix:         "delete from decisions where applied is null and not exists (select 1 from entries e where e.path = decisions.path and e.hash = decisions.hash)",
+	},
+	{
+		name:        "orphaned-fingerprints",
+		description: "video and photo fingerprints of contents no longer indexed",
+		count:       "select (select count(*) from videos where hash not in (select hash from entries)) + (select count(*) from photos where hash not in (select hash from entries))",
+		fix:         "delete from videos where hash not in (select hash from entries); delete from photos where hash not in (select hash from entries)",
+	},
+	{
+		name:        "orphaned-checkpoints",
+		description: "checkpoints of interrupted runs on buckets that do not exist",
+		count:       "select count(*) from checkpoints where bucket not in (select name from buckets)",
+		fix:         "delete from checkpoints where bucket not in (select name from buckets)",
+	},
+}
@@ -0,0 +1,154 @@
+package doctor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/locale"
+)
+
+// Doctor is the command that checks the health of the index database: it runs
+// the SQLite integrity check, then looks for rows that are inconsistent with
+// the rest of the index (e.g. left behind by interrupted runs or by older
+// versions), fixing those that can be fixed safely on request.
+type Doctor struct {
+	base.Command
+	base.Database
+	// Fix applies the automatic fixes.
+	Fix bool `long:"fix" description:"Fix the anomalies that can be fixed automatically." optional:"true"`
+}
+
+// Finding is the outcome of a check.
+type Finding struct {
+	Check       string   `json:"check"`
+	Description string   `json:"description"`
+	Count       int64    `json:"count"`
+	Details     []string `json:"details,omitempty"`
+	Fixable     bool     `json:"fixable"`
+	Fixed       bool     `json:"fixed,omitempty"`
+	Advice      string   `json:"advice,omitempty"`
+}
+
+// Execute is the real implementation of the Doctor command.
+func (cmd *Doctor) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running doctor command", "database", cmd.Database, "fix", cmd.Fix)
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	findings := []*Finding{}
+	integrity, err := cmd.integrity(db)
+	if err != nil {
+		return err
+	}
+	findings = append(findings, integrity)
+	// the other checks are meaningless on a corrupted database
+	if integrity.Count == 0 {
+		for _, c := range checks {
+			f := &Finding{Check: c.name, Description: c.description, Fixable: c.fix != "", Advice: c.advice}
+			if err := db.QueryRow(c.count).Scan(&f.Count); err != nil {
+				slog.Error("error running check", "check", c.name, "error", err)
+				return err
+			}
+			if f.Count > 0 && f.Fixable && cmd.Fix {
+				if err := fix(db, c); err != nil {
+					return err
+				}
+				f.Fixed = true
+			}
+			findings = append(findings, f)
+		}
+	}
+
+	problems := 0
+	out := cmd.Output()
+	for _, f := range findings {
+		if f.Count > 0 && !f.Fixed {
+			problems++
+		}
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(f)
+			if err != nil {
+				slog.Error("error marshalling finding to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+			continue
+		}
+		switch {
+		case f.Count == 0:
+			out.Printf("%s   %s\n", out.Paint(base.Green, "ok"), f.Check)
+		case f.Fixed:
+			out.Printf("%s %s: %d %s\n", out.Paint(base.Cyan, "fixed"), f.Check, f.Count, locale.Translate(f.Description))
+		default:
+			out.Printf("%s %s: %d %s\n", out.Paint(base.Red, "fail"), f.Check, f.Count, locale.Translate(f.Description))
+			for _, detail := range f.Details {
+				out.Printf("       %s\n", out.Paint(base.Faint, detail))
+			}
+			if f.Fixable {
+				out.Printf("       run doctor --fix to fix them\n")
+			} else if f.Advice != "" {
+				out.Printf("       %s\n", locale.Translate(f.Advice))
+			}
+		}
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(problems))
+}
+
+// integrity runs the SQLite integrity check.
+func (cmd *Doctor) integrity(db *sql.DB) (*Finding, error) {
+	f := &Finding{Check: "integrity", Description: "problems reported by the SQLite integrity check", Advice: "restore the database from a backup (see replicate), or recover it with the sqlite3 .recover command"}
+	rows, err := db.Query("pragma integrity_check")
+	if err != nil {
+		slog.Error("error running integrity check", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			slog.Error("error reading integrity check", "error", err)
+			return nil, err
+		}
+		if line != "ok" {
+			f.Count++
+			f.Details = append(f.Details, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading integrity check", "error", err)
+		return nil, err
+	}
+	return f, nil
+}
+
+// fix applies the fix of the check in a transaction.
+func fix(db *sql.DB, c check) error {
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("error opening database transaction", "error", err)
+		return err
+	}
+	for _, statement := range strings.Split(c.fix, "; ") {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			slog.Error("error fixing anomalies", "check", c.name, "error", err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error committing fixes", "check", c.name, "error", err)
+		return err
+	}
+	slog.Info("anomalies fixed", "check", c.name)
+	return nil
+}
@@ -0,0 +1,176 @@
+// Package drive indexes the files in cloud drives (Google Drive and OneDrive)
+// from the hashes their providers report, without downloading the contents
+// of any file, so that cloud drives can be compared with local files. Files
+// are indexed by a gdrive:// or onedrive:// path; since they are not on disk,
+// use report --offline to list duplicates.
+package drive
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/failures"
+	"golang.org/x/oauth2"
+)
+
+// Credentials are the OAuth 2.0 credentials to access a drive with: either an
+// access token, which expires after about an hour, or a refresh token and the
+// client it was issued to, from which access tokens are obtained as needed.
+type Credentials struct {
+	// Token is an OAuth 2.0 access token.
+	Token string `long:"token" description:"An OAuth 2.0 access token with read access to the drive (better set through the environment)." optional:"true"`
+	// ClientID is the ID of the OAuth 2.0 client the refresh token was issued to.
+	ClientID string `long:"client-id" description:"The ID of the OAuth 2.0 client the refresh token was issued to." optional:"true"`
+	// ClientSecret is the secret of the OAuth 2.0 client, if it has one.
+	ClientSecret string `long:"client-secret" description:"The secret of the OAuth 2.0 client, if it has one (better set through the environment)." optional:"true"`
+	// RefreshToken is an OAuth 2.0 refresh token.
+	RefreshToken string `long:"refresh-token" description:"An OAuth 2.0 refresh token, to obtain access tokens with (better set through the environment)." optional:"true"`
+}
+
+// client returns an HTTP client that authenticates its requests with the
+// credentials, obtaining access tokens from the given endpoint if needed.
+func (c *Credentials) client(ctx context.Context, endpoint oauth2.Endpoint, timeout time.Duration) (*http.Client, error) {
+	var client *http.Client
+	switch {
+	case c.RefreshToken != "":
+		if c.ClientID == "" {
+			return nil, errors.New("a refresh token requires the ID of the client it was issued to")
+		}
+		config := &oauth2.Config{ClientID: c.ClientID, ClientSecret: c.ClientSecret, Endpoint: endpoint}
+		client = config.Client(ctx, &oauth2.Token{RefreshToken: c.RefreshToken})
+	case c.Token != "":
+		client = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.Token}))
+	default:
+		return nil, errors.New("either an access token or a refresh token is required")
+	}
+	client.Timeout = timeout
+	return client, nil
+}
+
+// item is a file or folder in a drive.
+type item struct {
+	// ID identifies the item in the drive.
+	ID string
+	// Name is the name of the item in its folder.
+	Name string
+	// Folder is whether the item is a folder.
+	Folder bool
+	// Size is the size of the file.
+	Size int64
+	// Modified is when the file was last modified, if reported.
+	Modified time.Time
+	// Hashes are the hex-encoded hashes the provider reports for the file, by
+	// algorithm.
+	Hashes map[string]string
+}
+
+// lister lists the items in a folder of a drive.
+type lister interface {
+	list(ctx context.Context, folder string) ([]*item, error)
+}
+
+// Summary contains the outcome of a drive indexing run.
+type Summary struct {
+	Folders int   `json:"folders"`
+	Files   int   `json:"files"`
+	Bytes   int64 `json:"bytes"`
+	Skipped int   `json:"skipped"`
+	Failed  int   `json:"failed"`
+}
+
+// walk indexes the files under the given folder into the bucket, walking the
+// folders breadth first, by the hash of the given algorithm that the provider
+// reports; files with no such hash (e.g. Google Docs) are skipped. Files are
+// indexed under the given root path, followed by their path in the drive.
+func walk(ctx context.Context, db *sql.DB, l lister, folder string, root string, bucket string, algorithm string) (*Summary, error) {
+	stmt, err := db.Prepare("insert or replace into entries(hash, path, bucket, size, modified, indexed) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	type pending struct {
+		id   string
+		path string
+	}
+	summary := &Summary{}
+	now := time.Now().UTC().Format(time.RFC3339)
+	folders := []pending{{id: folder, path: strings.TrimSuffix(root, "/")}}
+	for len(folders) > 0 && ctx.Err() == nil {
+		current := folders[0]
+		folders = folders[1:]
+		items, err := l.list(ctx, current.id)
+		if err != nil {
+			slog.Error("error listing folder", "path", current.path, "error", &failures.WalkError{Path: current.path, Err: err})
+			summary.Failed++
+			continue
+		}
+		summary.Folders++
+		for _, i := range items {
+			// names may hold slashes, which would make paths ambiguous
+			path := current.path + "/" + strings.ReplaceAll(i.Name, "/", "%2F")
+			if i.Folder {
+				folders = append(folders, pending{id: i.ID, path: path})
+				continue
+			}
+			hash := i.Hashes[algorithm]
+			if hash == "" {
+				slog.Debug("no hash reported for file, skipping", "path", path, "algorithm", algorithm)
+				summary.Skipped++
+				continue
+			}
+			var modified any
+			if !i.Modified.IsZero() {
+				modified = i.Modified.UTC().Format(time.RFC3339)
+			}
+			if _, err := stmt.Exec(hash, path, bucket, i.Size, modified, now); err != nil {
+				slog.Error("error executing database insert statement", "path", path, "error", err)
+				summary.Failed++
+				continue
+			}
+			slog.Debug("file indexed", "path", path, "hash", hash, "size", i.Size)
+			summary.Files++
+			summary.Bytes += i.Size
+		}
+	}
+	if ctx.Err() != nil {
+		slog.Warn("indexing interrupted")
+	}
+	return summary, nil
+}
+
+// get requests the given URL and returns the body of the response, which must
+// be successful.
+func get(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		// the body holds the reason, e.g. an expired token or a missing scope
+		const max = 512
+		if len(body) > max {
+			body = body[:max]
+		}
+		return nil, fmt.Errorf("unexpected status %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
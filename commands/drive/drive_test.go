@@ -0,0 +1,197 @@
+package drive
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dihedron/dedup/internal/testutil"
+)
+
+// entries returns the hash of each entry in the bucket, by path.
+func entries(t *testing.T, db *sql.DB, bucket string) map[string]string {
+	t.Helper()
+	rows, err := db.Query("select path, hash from entries where bucket = ?", bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	found := map[string]string{}
+	for rows.Next() {
+		var path, hash string
+		if err := rows.Scan(&path, &hash); err != nil {
+			t.Fatal(err)
+		}
+		found[path] = hash
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestGoogleDrive(t *testing.T) {
+	// the folders in the drive, by ID, each listed in pages
+	folders := map[string][]string{
+		"root": {
+			`{"files": [{"id": "f1", "name": "a.txt", "mimeType": "text/plain", "size": "3", "modifiedTime": "2024-06-01T10:00:00Z", "md5Checksum": "900150983cd24fb0d6963f7d28e17f72"}], "nextPageToken": "p2"}`,
+			`{"files": [{"id": "d1", "name": "docs/old", "mimeType": "application/vnd.google-apps.folder"}, {"id": "g1", "name": "notes", "mimeType": "application/vnd.google-apps.document"}]}`,
+		},
+		"d1": {
+			`{"files": [{"id": "f2", "name": "b.txt", "mimeType": "text/plain", "size": "3", "md5Checksum": "900150983CD24FB0D6963F7D28E17F72"}]}`,
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"error": "unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		query := r.URL.Query()
+		folder, _, _ := strings.Cut(strings.TrimPrefix(query.Get("q"), "'"), "'")
+		page := 0
+		if query.Get("pageToken") == "p2" {
+			page = 1
+		}
+		w.Write([]byte(folders[folder][page]))
+	}))
+	defer server.Close()
+
+	db, database := testutil.DatabasePath(t)
+	cmd := &GoogleDrive{Folder: "root", Bucket: "gdrive", Hash: "md5", api: server.URL}
+	cmd.Token = "token"
+	cmd.Database.Database = database
+	cmd.LogStream, cmd.LogFormat = "none", "text"
+	cmd.Color = "never"
+	cmd.AutomationFriendly = true
+	if err := cmd.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	found := entries(t, db, "gdrive")
+	expected := map[string]string{
+		"gdrive://root/a.txt":            "900150983cd24fb0d6963f7d28e17f72",
+		"gdrive://root/docs%2Fold/b.txt": "900150983cd24fb0d6963f7d28e17f72",
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, found)
+	}
+	for path, hash := range expected {
+		if found[path] != hash {
+			t.Errorf("expected %s indexed with %s, got %q", path, hash, found[path])
+		}
+	}
+}
+
+func TestOneDrive(t *testing.T) {
+	// "abc" has QuickXorHash YRDDGAAAAAAAAAAAAwAAAAAAAAA=, i.e. 6110c318...
+	const quickxor = "6110c31800000000000000000300000000000000"
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"error": "unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		var body any
+		switch {
+		case r.URL.Path == "/me/drive/root:/Documents:":
+			body = map[string]any{"id": "docs", "folder": map[string]any{"childCount": 2}}
+		case r.URL.Path == "/me/drive/items/docs/children" && r.URL.Query().Get("page") == "":
+			body = map[string]any{
+				"value": []any{
+					map[string]any{"id": "f1", "name": "a.txt", "size": 3, "lastModifiedDateTime": "2024-06-01T10:00:00Z",
+						"file": map[string]any{"hashes": map[string]any{"quickXorHash": "YRDDGAAAAAAAAAAAAwAAAAAAAAA=", "sha1Hash": "A9993E364706816ABA3E25717850C26C9CD0D89D"}}},
+				},
+				"@odata.nextLink": server.URL + "/me/drive/items/docs/children?page=2",
+			}
+		case r.URL.Path == "/me/drive/items/docs/children":
+			body = map[string]any{
+				"value": []any{
+					map[string]any{"id": "sub", "name": "sub", "folder": map[string]any{"childCount": 1}},
+					map[string]any{"id": "n1", "name": "notebook", "package": map[string]any{"type": "oneNote"}},
+				},
+			}
+		case r.URL.Path == "/me/drive/items/sub/children":
+			body = map[string]any{
+				"value": []any{
+					map[string]any{"id": "f2", "name": "b.txt", "size": 3, "file": map[string]any{"hashes": map[string]any{"quickXorHash": "YRDDGAAAAAAAAAAAAwAAAAAAAAA="}}},
+					map[string]any{"id": "f3", "name": "c.txt", "size": 3, "file": map[string]any{"hashes": map[string]any{}}},
+				},
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		hash     string
+		expected map[string]string
+	}{
+		{
+			name: "quickxor",
+			hash: "quickxor",
+			expected: map[string]string{
+				"onedrive://me/Documents/a.txt":     quickxor,
+				"onedrive://me/Documents/sub/b.txt": quickxor,
+			},
+		},
+		{
+			name: "sha1",
+			hash: "sha1",
+			expected: map[string]string{
+				"onedrive://me/Documents/a.txt": "a9993e364706816aba3e25717850c26c9cd0d89d",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, database := testutil.DatabasePath(t)
+			cmd := &OneDrive{Folder: "/Documents", Bucket: "onedrive", Hash: test.hash, api: server.URL}
+			cmd.Token = "token"
+			cmd.Database.Database = database
+			cmd.LogStream, cmd.LogFormat = "none", "text"
+			cmd.Color = "never"
+			cmd.AutomationFriendly = true
+			if err := cmd.Execute(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			found := entries(t, db, "onedrive")
+			if len(found) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, found)
+			}
+			for path, hash := range test.expected {
+				if found[path] != hash {
+					t.Errorf("expected %s indexed with %s, got %q", path, hash, found[path])
+				}
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials Credentials
+		ok          bool
+	}{
+		{name: "none"},
+		{name: "access token", credentials: Credentials{Token: "t"}, ok: true},
+		{name: "refresh token", credentials: Credentials{RefreshToken: "r", ClientID: "c"}, ok: true},
+		{name: "refresh token without client", credentials: Credentials{RefreshToken: "r"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.credentials.client(t.Context(), google, 0)
+			if (err == nil) != test.ok {
+				t.Errorf("expected success %t, got %v", test.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
+	"golang.org/x/oauth2"
+)
+
+// google is the OAuth 2.0 endpoint of Google accounts.
+var google = oauth2.Endpoint{
+	AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+	TokenURL: "https://oauth2.googleapis.com/token",
+}
+
+// folderType is the MIME type of the folders in Google Drive.
+const folderType = "application/vnd.google-apps.folder"
+
+// GoogleDrive is the command that indexes the files in Google Drive by the
+// MD5, SHA-1 or SHA-256 checksums Drive reports for them. Google Docs, Sheets
+// and the other files Drive stores in its own formats have no checksum, and
+// are skipped.
+type GoogleDrive struct {
+	base.Command
+	base.Database
+	Credentials
+	// Folder is the ID of the folder to index.
+	Folder string `long:"folder" description:"The ID of the folder to index, as in its URL (the whole drive if not given)." optional:"true" default:"root"`
+	// Bucket is the bucket files are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index files into." optional:"true" default:"gdrive"`
+	// Hash is the hash algorithm of the checksums to index files by.
+	Hash string `long:"hash" description:"The hash algorithm of the checksums to index files by (md5, sha1 or sha256), which should match the one of the buckets to compare with." optional:"true" default:"md5" choice:"md5" choice:"sha1" choice:"sha256"`
+	// Timeout is how long each request may take.
+	Timeout time.Duration `long:"timeout" description:"How long listing a folder may take (0 for no limit)." optional:"true" default:"0s"`
+
+	// api is the base URL of the Drive API, replaced in tests.
+	api string
+}
+
+// Execute is the real implementation of the GoogleDrive command.
+func (cmd *GoogleDrive) Execute(args []string) error {
+	cmd.Init()
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	slog.Debug("running gdrive command", "folder", cmd.Folder, "database", cmd.Database, "bucket", cmd.Bucket, "hash", cmd.Hash)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := cmd.client(ctx, google, cmd.Timeout)
+	if err != nil {
+		slog.Error("invalid credentials", "error", err)
+		return err
+	}
+	l := &googleLister{http: client, api: cmd.api}
+	if l.api == "" {
+		l.api = "https://www.googleapis.com"
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	root := "gdrive://" + cmd.Folder
+	if err := buckets.Register(db, cmd.Bucket, cmd.Hash, []string{root}, map[string]any{"gdrive": true}); err != nil {
+		return err
+	}
+	summary, err := walk(ctx, db, l, cmd.Folder, root, cmd.Bucket, cmd.Hash)
+	if err != nil {
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d files (%d bytes) in %d folders, %d skipped, %d failed\n", summary.Files, summary.Bytes, summary.Folders, summary.Skipped, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// googleLister lists the folders in Google Drive through the Drive API.
+type googleLister struct {
+	http *http.Client
+	api  string
+}
+
+// files is the body of a files.list response.
+type files struct {
+	NextPageToken string `json:"nextPageToken"`
+	Files         []struct {
+		ID             string `json:"id"`
+		Name           string `json:"name"`
+		MimeType       string `json:"mimeType"`
+		Size           string `json:"size"`
+		ModifiedTime   string `json:"modifiedTime"`
+		MD5Checksum    string `json:"md5Checksum"`
+		SHA1Checksum   string `json:"sha1Checksum"`
+		SHA256Checksum string `json:"sha256Checksum"`
+	} `json:"files"`
+}
+
+// list returns the files and folders in the given folder, across all pages.
+func (l *googleLister) list(ctx context.Context, folder string) ([]*item, error) {
+	query := url.Values{}
+	query.Set("q", fmt.Sprintf("'%s' in parents and trashed = false", strings.ReplaceAll(folder, "'", `\'`)))
+	query.Set("fields", "nextPageToken,files(id,name,mimeType,size,modifiedTime,md5Checksum,sha1Checksum,sha256Checksum)")
+	query.Set("pageSize", "1000")
+	query.Set("supportsAllDrives", "true")
+	query.Set("includeItemsFromAllDrives", "true")
+	items := []*item{}
+	for {
+		body, err := get(ctx, l.http, l.api+"/drive/v3/files?"+query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		var page files
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("invalid files list: %w", err)
+		}
+		for _, f := range page.Files {
+			i := &item{ID: f.ID, Name: f.Name, Folder: f.MimeType == folderType, Hashes: map[string]string{}}
+			if f.Size != "" {
+				i.Size, _ = strconv.ParseInt(f.Size, 10, 64)
+			}
+			if f.ModifiedTime != "" {
+				i.Modified, _ = time.Parse(time.RFC3339, f.ModifiedTime)
+			}
+			for algorithm, hash := range map[string]string{"md5": f.MD5Checksum, "sha1": f.SHA1Checksum, "sha256": f.SHA256Checksum} {
+				if hash != "" {
+					i.Hashes[algorithm] = strings.ToLower(hash)
+				}
+			}
+			items = append(items, i)
+		}
+		if page.NextPageToken == "" {
+			return items, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
@@ -0,0 +1,207 @@
+package drive
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
+	"golang.org/x/oauth2"
+)
+
+// OneDrive is the command that indexes the files in OneDrive (or in a
+// SharePoint document library) by the QuickXorHash, SHA-1 or SHA-256 hashes
+// Microsoft Graph reports for them. OneDrive for Business and SharePoint only
+// report QuickXorHash, which the index command can compute for local files.
+type OneDrive struct {
+	base.Command
+	base.Database
+	Credentials
+	// Tenant is the Microsoft Entra tenant the credentials are issued by.
+	Tenant string `long:"tenant" description:"The Microsoft Entra tenant to obtain access tokens from, for refresh tokens (common for personal and work accounts)." optional:"true" default:"common"`
+	// Drive is the ID of the drive to index.
+	Drive string `long:"drive" description:"The ID of the drive to index (the drive of the signed in user if not given)." optional:"true"`
+	// Folder is the path of the folder to index in the drive.
+	Folder string `long:"folder" description:"The path of the folder to index in the drive, e.g. /Documents (the whole drive if not given)." optional:"true"`
+	// Bucket is the bucket files are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index files into." optional:"true" default:"onedrive"`
+	// Hash is the hash algorithm of the hashes to index files by.
+	Hash string `long:"hash" description:"The hash algorithm of the hashes to index files by (quickxor, sha1 or sha256; only quickxor is reported by OneDrive for Business), which should match the one of the buckets to compare with." optional:"true" default:"quickxor" choice:"quickxor" choice:"sha1" choice:"sha256"`
+	// Timeout is how long each request may take.
+	Timeout time.Duration `long:"timeout" description:"How long listing a folder may take (0 for no limit)." optional:"true" default:"0s"`
+
+	// api is the base URL of Microsoft Graph, replaced in tests.
+	api string
+}
+
+// Execute is the real implementation of the OneDrive command.
+func (cmd *OneDrive) Execute(args []string) error {
+	cmd.Init()
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	slog.Debug("running onedrive command", "drive", cmd.Drive, "folder", cmd.Folder, "database", cmd.Database, "bucket", cmd.Bucket, "hash", cmd.Hash)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	microsoft := oauth2.Endpoint{
+		AuthURL:  "https://login.microsoftonline.com/" + url.PathEscape(cmd.Tenant) + "/oauth2/v2.0/authorize",
+		TokenURL: "https://login.microsoftonline.com/" + url.PathEscape(cmd.Tenant) + "/oauth2/v2.0/token",
+	}
+	client, err := cmd.client(ctx, microsoft, cmd.Timeout)
+	if err != nil {
+		slog.Error("invalid credentials", "error", err)
+		return err
+	}
+	api := cmd.api
+	if api == "" {
+		api = "https://graph.microsoft.com/v1.0"
+	}
+	l := &graphLister{http: client, drive: api + "/me/drive"}
+	if cmd.Drive != "" {
+		l.drive = api + "/drives/" + url.PathEscape(cmd.Drive)
+	}
+	folder, err := l.resolve(ctx, cmd.Folder)
+	if err != nil {
+		slog.Error("error resolving folder", "folder", cmd.Folder, "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	drive := cmd.Drive
+	if drive == "" {
+		drive = "me"
+	}
+	root := "onedrive://" + drive
+	if folder := strings.Trim(cmd.Folder, "/"); folder != "" {
+		root += "/" + folder
+	}
+	if err := buckets.Register(db, cmd.Bucket, cmd.Hash, []string{root}, map[string]any{"onedrive": true}); err != nil {
+		return err
+	}
+	summary, err := walk(ctx, db, l, folder, root, cmd.Bucket, cmd.Hash)
+	if err != nil {
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d files (%d bytes) in %d folders, %d skipped, %d failed\n", summary.Files, summary.Bytes, summary.Folders, summary.Skipped, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// graphLister lists the folders in a drive through Microsoft Graph.
+type graphLister struct {
+	http *http.Client
+	// drive is the URL of the drive.
+	drive string
+}
+
+// driveItem is a driveItem resource.
+type driveItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	Folder               *struct{} `json:"folder"`
+	File                 *struct {
+		Hashes struct {
+			QuickXorHash string `json:"quickXorHash"`
+			SHA1Hash     string `json:"sha1Hash"`
+			SHA256Hash   string `json:"sha256Hash"`
+		} `json:"hashes"`
+	} `json:"file"`
+}
+
+// resolve returns the ID of the folder at the given path in the drive.
+func (l *graphLister) resolve(ctx context.Context, path string) (string, error) {
+	target := l.drive + "/root"
+	if path = strings.Trim(path, "/"); path != "" {
+		segments := strings.Split(path, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		target += ":/" + strings.Join(segments, "/") + ":"
+	}
+	body, err := get(ctx, l.http, target+"?$select=id,folder")
+	if err != nil {
+		return "", err
+	}
+	var folder driveItem
+	if err := json.Unmarshal(body, &folder); err != nil {
+		return "", fmt.Errorf("invalid drive item: %w", err)
+	}
+	if folder.Folder == nil {
+		return "", fmt.Errorf("%s is not a folder", path)
+	}
+	return folder.ID, nil
+}
+
+// list returns the files and folders in the given folder, across all pages.
+func (l *graphLister) list(ctx context.Context, folder string) ([]*item, error) {
+	next := l.drive + "/items/" + url.PathEscape(folder) + "/children?$select=id,name,size,lastModifiedDateTime,folder,file&$top=1000"
+	items := []*item{}
+	for next != "" {
+		body, err := get(ctx, l.http, next)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Value    []driveItem `json:"value"`
+			NextLink string      `json:"@odata.nextLink"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("invalid children list: %w", err)
+		}
+		for _, child := range page.Value {
+			if child.Folder == nil && child.File == nil {
+				// e.g. OneNote notebooks, which are packages of items
+				slog.Debug("neither a file nor a folder, skipping", "name", child.Name)
+				continue
+			}
+			i := &item{ID: child.ID, Name: child.Name, Folder: child.Folder != nil, Size: child.Size, Modified: child.LastModifiedDateTime, Hashes: map[string]string{}}
+			if child.File != nil {
+				hashes := child.File.Hashes
+				// QuickXorHash is reported in base64, the others in upper case hex
+				if sum, err := base64.StdEncoding.DecodeString(hashes.QuickXorHash); err == nil && len(sum) > 0 {
+					i.Hashes["quickxor"] = hex.EncodeToString(sum)
+				}
+				if hashes.SHA1Hash != "" {
+					i.Hashes["sha1"] = strings.ToLower(hashes.SHA1Hash)
+				}
+				if hashes.SHA256Hash != "" {
+					i.Hashes["sha256"] = strings.ToLower(hashes.SHA256Hash)
+				}
+			}
+			items = append(items, i)
+		}
+		next = page.NextLink
+	}
+	return items, nil
+}
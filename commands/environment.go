@@ -0,0 +1,94 @@
+package command
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/jessevdk/go-flags"
+)
+
+// EnvironmentPrefix is the prefix of the environment variables providing the
+// default values of the options.
+const EnvironmentPrefix = "DEDUP"
+
+// shared are the options common to all commands (logging, output and the
+// database), which are safe to give once for every command.
+var shared = func() map[string]bool {
+	names := map[string]bool{}
+	for _, t := range []reflect.Type{reflect.TypeOf(base.Command{}), reflect.TypeOf(base.Database{})} {
+		for i := 0; i < t.NumField(); i++ {
+			if name := t.Field(i).Tag.Get("long"); name != "" {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}()
+
+// Environment binds every option without an explicit environment variable to
+// DEDUP_<OPTION> (e.g. DEDUP_DATABASE for --database), or to the per-command
+// DEDUP_<COMMAND>_<OPTION> (e.g. DEDUP_INDEX_BUCKET) when that is set, so that
+// options can be given through the environment in containers and cron jobs;
+// values of repeatable options are separated by commas. The options of the
+// commands that change files on disk (e.g. clean, move) are only bound to the
+// per-command variables, except for the shared ones like --database, so that a
+// DEDUP_BUCKET meant for index never selects what clean deletes.
+func Environment(parser *flags.Parser) {
+	for _, command := range parser.Commands() {
+		bind(command, []string{EnvironmentPrefix})
+	}
+}
+
+// bind binds the options of the given command and of its subcommands.
+func bind(command *flags.Command, prefix []string) {
+	prefix = append(append([]string{}, prefix...), key(command.Name))
+	var groups func(group *flags.Group)
+	groups = func(group *flags.Group) {
+		for _, option := range group.Options() {
+			if option.EnvDefaultKey != "" || option.LongName == "" {
+				continue
+			}
+			name := key(option.LongName)
+			specific := strings.Join(append(prefix, name), "_")
+			option.EnvDefaultKey = specific
+			if os.Getenv(specific) == "" && global(command, option) {
+				option.EnvDefaultKey = EnvironmentPrefix + "_" + name
+			}
+			if option.Field().Type.Kind() == reflect.Slice {
+				option.EnvDefaultDelim = ","
+			}
+		}
+		for _, child := range group.Groups() {
+			groups(child)
+		}
+	}
+	groups(command.Group)
+	for _, child := range command.Commands() {
+		bind(child, prefix)
+	}
+}
+
+// global returns whether the option of the given command can be given through
+// the DEDUP_<OPTION> variable, which applies to every command.
+func global(command *flags.Command, option *flags.Option) bool {
+	return shared[option.LongName] || !destructive(command)
+}
+
+// destructive returns whether the given command changes files on disk, which
+// all such commands tell by embedding base.Preview.
+func destructive(command *flags.Command) bool {
+	preview := reflect.TypeOf(base.Preview{}).Field(0)
+	for _, option := range command.Options() {
+		if option.Field().Name == preview.Name && option.Field().Tag == preview.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the environment variable name fragment for the given name.
+func key(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
@@ -0,0 +1,50 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		// command is the path of the command, option its long name.
+		command, option string
+		// set are the variables set in the environment.
+		set      []string
+		expected string
+	}{
+		{name: "generic", command: "index", option: "bucket", expected: "DEDUP_BUCKET"},
+		{name: "per command", command: "index", option: "bucket", set: []string{"DEDUP_INDEX_BUCKET"}, expected: "DEDUP_INDEX_BUCKET"},
+		{name: "destructive", command: "clean", option: "bucket", set: []string{"DEDUP_BUCKET"}, expected: "DEDUP_CLEAN_BUCKET"},
+		{name: "destructive, dry run", command: "clean", option: "dry-run", expected: "DEDUP_CLEAN_DRY_RUN"},
+		{name: "destructive, shared", command: "clean", option: "database", expected: "DEDUP_DATABASE"},
+		{name: "destructive, shared per command", command: "clean", option: "database", set: []string{"DEDUP_CLEAN_DATABASE"}, expected: "DEDUP_CLEAN_DATABASE"},
+		{name: "destructive subcommand", command: "plan apply", option: "to", expected: "DEDUP_PLAN_APPLY_TO"},
+		{name: "subcommand", command: "plan import", option: "database", expected: "DEDUP_DATABASE"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, variable := range test.set {
+				t.Setenv(variable, "value")
+			}
+			parser := flags.NewParser(&Commands{}, flags.Default)
+			Environment(parser)
+			command := parser.Command
+			for _, name := range strings.Fields(test.command) {
+				if command = command.Find(name); command == nil {
+					t.Fatalf("no command %s", test.command)
+				}
+			}
+			option := command.FindOptionByLongName(test.option)
+			if option == nil {
+				t.Fatalf("no option --%s in %s", test.option, test.command)
+			}
+			if option.EnvDefaultKey != test.expected {
+				t.Errorf("expected --%s bound to %s, got %s", test.option, test.expected, option.EnvDefaultKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Export is the command that dumps the index entries to a file, in a format
+// suitable for analysis with external tools (e.g. DuckDB, Spark, pandas).
+type Export struct {
+	base.Command
+	base.Database
+	// Format is the output format.
+	Format string `short:"f" long:"format" description:"The output format." optional:"true" choice:"ndjson" choice:"csv" choice:"parquet" default:"ndjson"`
+	// Output is the file to write to.
+	Output string `short:"o" long:"output" description:"The file to write the entries to (- for standard output)." optional:"true" default:"-"`
+	// Bucket restricts the export to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to export (all buckets if not specified)." optional:"true"`
+}
+
+// Entry is an exported index entry; optional values are nil when they were
+// not recorded at indexing time.
+type Entry struct {
+	Hash      string     `json:"hash"`
+	Path      string     `json:"path"`
+	Bucket    string     `json:"bucket"`
+	Size      int64      `json:"size"`
+	Allocated *int64     `json:"allocated,omitempty"`
+	Modified  *time.Time `json:"modified,omitempty"`
+	Indexed   *time.Time `json:"indexed,omitempty"`
+	UID       *int64     `json:"uid,omitempty"`
+	GID       *int64     `json:"gid,omitempty"`
+	Mode      *int64     `json:"mode,omitempty"`
+	Entropy   *float64   `json:"entropy,omitempty"`
+	Magic     *string    `json:"magic,omitempty"`
+	Known     *string    `json:"known,omitempty"`
+}
+
+// writer writes entries to the output in a given format.
+type writer interface {
+	// Write writes an entry.
+	Write(entry *Entry) error
+	// Close writes any buffered data and the trailer of the format, if any.
+	Close() error
+}
+
+// newWriter creates a writer for the given format.
+func newWriter(format string, output io.Writer) writer {
+	switch format {
+	case "csv":
+		return newCSVWriter(output)
+	case "parquet":
+		return newParquetWriter(output)
+	default:
+		return &ndjsonWriter{encoder: json.NewEncoder(output)}
+	}
+}
+
+// Execute is the real implementation of the Export command.
+func (cmd *Export) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running export command", "database", cmd.Database, "format", cmd.Format, "output", cmd.Output, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var output io.Writer = cmd.Command.Output()
+	if cmd.Output != "-" {
+		f, err := os.Create(cmd.Output)
+		if err != nil {
+			slog.Error("error creating output file", "path", cmd.Output, "error", err)
+			return err
+		}
+		defer f.Close()
+		output = f
+	}
+
+	query := "select hash, path, coalesce(bucket, ''), coalesce(size, 0), allocated, modified, indexed, uid, gid, mode, entropy, magic, known from entries"
+	params := []any{}
+	if cmd.Bucket != "" {
+		query += " where bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	query += " order by bucket, path"
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		slog.Error("error querying entries", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	w := newWriter(cmd.Format, output)
+	var count int64
+	for rows.Next() {
+		entry, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := w.Write(entry); err != nil {
+			slog.Error("error writing entry", "path", entry.Path, "error", err)
+			return err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading entries", "error", err)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		slog.Error("error closing output", "error", err)
+		return err
+	}
+	slog.Info("entries exported", "count", count, "format", cmd.Format)
+	slog.Debug("command done")
+	return nil
+}
+
+// scan reads an entry from the current row.
+func scan(rows *sql.Rows) (*Entry, error) {
+	entry := &Entry{}
+	var allocated, uid, gid, mode sql.NullInt64
+	var modified, indexed, magic, known sql.NullString
+	var entropy sql.NullFloat64
+	if err := rows.Scan(&entry.Hash, &entry.Path, &entry.Bucket, &entry.Size, &allocated, &modified, &indexed, &uid, &gid, &mode, &entropy, &magic, &known); err != nil {
+		slog.Error("error reading entry", "error", err)
+		return nil, err
+	}
+	entry.Allocated = nullInt(allocated)
+	entry.UID = nullInt(uid)
+	entry.GID = nullInt(gid)
+	entry.Mode = nullInt(mode)
+	entry.Modified = nullTime(modified)
+	entry.Indexed = nullTime(indexed)
+	entry.Magic = nullString(magic)
+	entry.Known = nullString(known)
+	if entropy.Valid {
+		entry.Entropy = &entropy.Float64
+	}
+	return entry, nil
+}
+
+func nullInt(value sql.NullInt64) *int64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Int64
+}
+
+func nullString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+func nullTime(value sql.NullString) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value.String)
+	if err != nil {
+		slog.Warn("invalid timestamp in index", "value", value.String, "error", err)
+		return nil
+	}
+	return &t
+}
+
+// ndjsonWriter writes entries as JSON objects, one per line.
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(entry *Entry) error {
+	return w.encoder.Encode(entry)
+}
+
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
+
+// format returns the textual representation of an optional value, or an
+// empty string if it is nil.
+func format[T any](value *T) string {
+	if value == nil {
+		return ""
+	}
+	switch v := any(*value).(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
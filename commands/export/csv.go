@@ -0,0 +1,55 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// csvWriter writes entries as CSV records, with a header line.
+type csvWriter struct {
+	writer *csv.Writer
+	header bool
+}
+
+func newCSVWriter(output io.Writer) *csvWriter {
+	return &csvWriter{writer: csv.NewWriter(output)}
+}
+
+// writeHeader writes the header line, once.
+func (w *csvWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	return w.writer.Write([]string{"hash", "path", "bucket", "size", "allocated", "modified", "indexed", "uid", "gid", "mode", "entropy", "magic", "known"})
+}
+
+func (w *csvWriter) Write(entry *Entry) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.writer.Write([]string{
+		entry.Hash,
+		entry.Path,
+		entry.Bucket,
+		strconv.FormatInt(entry.Size, 10),
+		format(entry.Allocated),
+		format(entry.Modified),
+		format(entry.Indexed),
+		format(entry.UID),
+		format(entry.GID),
+		format(entry.Mode),
+		format(entry.Entropy),
+		format(entry.Magic),
+		format(entry.Known),
+	})
+}
+
+func (w *csvWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
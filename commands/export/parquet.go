@@ -0,0 +1,86 @@
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// row is an entry as stored in the Parquet file; optional columns are null
+// when the value is the zero value.
+type row struct {
+	Hash      string    `parquet:"hash,dict"`
+	Path      string    `parquet:"path"`
+	Bucket    string    `parquet:"bucket,dict"`
+	Size      int64     `parquet:"size"`
+	Allocated *int64    `parquet:"allocated,optional"`
+	Modified  time.Time `parquet:"modified,optional,timestamp(millisecond)"`
+	Indexed   time.Time `parquet:"indexed,optional,timestamp(millisecond)"`
+	UID       *int64    `parquet:"uid,optional"`
+	GID       *int64    `parquet:"gid,optional"`
+	Mode      *int64    `parquet:"mode,optional"`
+	Entropy   *float64  `parquet:"entropy,optional"`
+	Magic     *string   `parquet:"magic,optional"`
+	Known     *string   `parquet:"known,optional,dict"`
+}
+
+// rowBatch is the number of rows handed to the Parquet library at once.
+const rowBatch = 1024
+
+// parquetWriter writes entries as a Parquet file, with typed columns for
+// sizes and timestamps.
+type parquetWriter struct {
+	writer *parquet.GenericWriter[row]
+	buffer []row
+}
+
+func newParquetWriter(output io.Writer) *parquetWriter {
+	return &parquetWriter{
+		writer: parquet.NewGenericWriter[row](output, parquet.Compression(&parquet.Zstd)),
+		buffer: make([]row, 0, rowBatch),
+	}
+}
+
+func (w *parquetWriter) Write(entry *Entry) error {
+	r := row{
+		Hash:      entry.Hash,
+		Path:      entry.Path,
+		Bucket:    entry.Bucket,
+		Size:      entry.Size,
+		Allocated: entry.Allocated,
+		UID:       entry.UID,
+		GID:       entry.GID,
+		Mode:      entry.Mode,
+		Entropy:   entry.Entropy,
+		Magic:     entry.Magic,
+		Known:     entry.Known,
+	}
+	if entry.Modified != nil {
+		r.Modified = *entry.Modified
+	}
+	if entry.Indexed != nil {
+		r.Indexed = *entry.Indexed
+	}
+	w.buffer = append(w.buffer, r)
+	if len(w.buffer) < rowBatch {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *parquetWriter) flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	_, err := w.writer.Write(w.buffer)
+	w.buffer = w.buffer[:0]
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.writer.Close()
+}
@@ -0,0 +1,298 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/failures"
+)
+
+// Image is the command that indexes the files inside the layers of container
+// images, read from tarballs (as written by docker save, podman save or in OCI
+// layout) or exported by the local Docker daemon, so that assets duplicated
+// across images, or between images and the host, can be found. Files are
+// indexed as <image>#<layer>/<path>; since they are not files on disk, use
+// report --offline to list duplicates.
+type Image struct {
+	base.Command
+	base.Database
+	// Paths are the image tarballs to scan.
+	Paths []string `short:"p" long:"path" description:"The image tarball(s) to index, as written by docker save or in OCI layout." optional:"true"`
+	// Images are the images to export from the Docker daemon.
+	Images []string `short:"i" long:"image" description:"The image(s) to export from the Docker daemon and index, e.g. alpine:3.20." optional:"true"`
+	// Host is the address of the Docker daemon.
+	Host string `long:"docker-host" description:"The address of the Docker daemon." optional:"true" default:"unix:///var/run/docker.sock"`
+	// Bucket is the bucket files are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index files into." optional:"true" default:"images"`
+	// Hash is the hash algorithm to compute.
+	Hash string `long:"hash" description:"The hash algorithm to compute, which should match the one of the buckets to compare with." optional:"true" default:"sha256"`
+}
+
+// Summary contains the outcome of an image indexing run.
+type Summary struct {
+	Images  int   `json:"images"`
+	Layers  int   `json:"layers"`
+	Shared  int   `json:"shared"`
+	Files   int   `json:"files"`
+	Bytes   int64 `json:"bytes"`
+	Skipped int   `json:"skipped"`
+	Failed  int   `json:"failed"`
+}
+
+// Execute is the real implementation of the Image command.
+func (cmd *Image) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running image command", "paths", cmd.Paths, "images", cmd.Images, "database", cmd.Database, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	if len(cmd.Paths) == 0 && len(cmd.Images) == 0 {
+		err := errors.New("no image tarball or image name given")
+		slog.Error("nothing to index", "error", err)
+		return err
+	}
+	if _, err := index.NewHasher(cmd.Hash); err != nil {
+		slog.Error("invalid hash algorithm", "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	roots := []string{}
+	for _, p := range cmd.Paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		roots = append(roots, p)
+	}
+	for _, name := range cmd.Images {
+		roots = append(roots, "docker://"+name)
+	}
+	if err := buckets.Register(db, cmd.Bucket, cmd.Hash, roots, map[string]any{"image": true}); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("error opening database transaction", "error", err)
+		return err
+	}
+	stmt, err := tx.Prepare("insert or replace into entries(hash, path, bucket, size, modified, indexed) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	s := &scanner{stmt: stmt, bucket: cmd.Bucket, algorithm: cmd.Hash, indexed: time.Now().UTC().Format(time.RFC3339), layers: map[string]bool{}, summary: &Summary{}}
+	for i, p := range cmd.Paths {
+		f, err := os.Open(p)
+		if err != nil {
+			slog.Error("error opening image tarball", "path", p, "error", err)
+			s.summary.Failed++
+			continue
+		}
+		err = s.image(roots[i], f)
+		f.Close()
+		if err != nil {
+			slog.Error("error reading image tarball", "path", p, "error", err)
+			s.summary.Failed++
+		}
+	}
+	for _, name := range cmd.Images {
+		if err := cmd.export(name, func(r io.Reader) error { return s.image("docker://"+name, r) }); err != nil {
+			slog.Error("error reading image from Docker daemon", "image", name, "error", err)
+			s.summary.Failed++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error committing database insert transaction", "error", err)
+		return err
+	}
+
+	summary := s.summary
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d files (%d bytes) in %d layers of %d images, %d shared layers skipped, %d entries skipped, %d failed\n", summary.Files, summary.Bytes, summary.Layers, summary.Images, summary.Shared, summary.Skipped, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// export streams the tarball of the given image from the Docker daemon to the
+// given function.
+func (cmd *Image) export(name string, read func(io.Reader) error) error {
+	host, err := url.Parse(cmd.Host)
+	if err != nil {
+		return fmt.Errorf("invalid Docker host %q: %w", cmd.Host, err)
+	}
+	transport := &http.Transport{}
+	endpoint := "http://docker"
+	switch host.Scheme {
+	case "unix":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", host.Path)
+		}
+	case "tcp", "http":
+		endpoint = "http://" + host.Host
+	default:
+		return fmt.Errorf("unsupported Docker host %q", cmd.Host)
+	}
+	client := &http.Client{Transport: transport}
+	response, err := client.Get(endpoint + "/images/" + url.PathEscape(name) + "/get")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("exporting image %s: %s: %s", name, response.Status, bytes.TrimSpace(message))
+	}
+	return read(response.Body)
+}
+
+// scanner indexes the files in the layers of images.
+type scanner struct {
+	stmt      *sql.Stmt
+	bucket    string
+	algorithm string
+	indexed   string
+	// layers are the layers already indexed in this run, which are shared
+	// by several images and would otherwise only report themselves.
+	layers  map[string]bool
+	summary *Summary
+}
+
+// image indexes the layers in an image tarball; layers are recognised by
+// their contents, so that both docker save (<id>/layer.tar) and OCI layout
+// (blobs/sha256/<digest>) tarballs can be read as a stream.
+func (s *scanner) image(name string, r io.Reader) error {
+	s.summary.Images++
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || header.Size == 0 {
+			continue
+		}
+		layer := strings.TrimSuffix(path.Clean(header.Name), "/layer.tar")
+		if path.Ext(header.Name) == ".json" || header.Name == "oci-layout" {
+			continue
+		}
+		contents, ok, err := open(archive)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if s.layers[layer] {
+			slog.Debug("skipping layer already indexed", "image", name, "layer", layer)
+			s.summary.Shared++
+			continue
+		}
+		s.layers[layer] = true
+		if err := s.layer(name+"#"+layer, contents); err != nil {
+			slog.Error("error reading layer", "image", name, "layer", layer, "error", err)
+			s.summary.Failed++
+			continue
+		}
+		s.summary.Layers++
+	}
+}
+
+// open returns a tar reader over the given entry if it is a layer, that is an
+// (optionally gzipped) tarball.
+func open(r io.Reader) (*tar.Reader, bool, error) {
+	buffered := bufio.NewReaderSize(r, 1024)
+	magic, err := buffered.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	var contents io.Reader = buffered
+	if len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		z, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, false, nil
+		}
+		contents = z
+	} else if len(magic) < 263 || string(magic[257:262]) != "ustar" {
+		// manifests, configurations and layers compressed otherwise (e.g.
+		// zstd) are not read
+		return nil, false, nil
+	}
+	return tar.NewReader(contents), true, nil
+}
+
+// layer indexes the regular files in a layer; whiteouts, which mark files
+// deleted from lower layers, are skipped.
+func (s *scanner) layer(prefix string, archive *tar.Reader) error {
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean("/" + header.Name)
+		if strings.HasPrefix(path.Base(name), ".wh.") {
+			s.summary.Skipped++
+			continue
+		}
+		h, err := index.NewHasher(s.algorithm)
+		if err != nil {
+			return err
+		}
+		size, err := io.Copy(h, archive)
+		if err != nil {
+			return err
+		}
+		p := prefix + name
+		if _, err := s.stmt.Exec(hex.EncodeToString(h.Sum(nil)), p, s.bucket, size, header.ModTime.UTC().Format(time.RFC3339), s.indexed); err != nil {
+			slog.Error("error executing database insert statement", "path", p, "error", err)
+			return err
+		}
+		s.summary.Files++
+		s.summary.Bytes += size
+	}
+}
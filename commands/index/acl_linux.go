@@ -0,0 +1,88 @@
+//go:build linux
+
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// posixACLAccess is the name of the extended attribute holding the
+	// POSIX access ACL of a file.
+	posixACLAccess = "system.posix_acl_access"
+	// posixACLVersion is the only supported version of the xattr encoding.
+	posixACLVersion = 2
+)
+
+// POSIX ACL entry tags, as per <linux/posix_acl.h>.
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+// aclOf returns the POSIX access ACL of the given file in short text form
+// (e.g. "user::rw-,user:1000:r--,group::r--,mask::r--,other::r--"), or nil
+// if the file has no extended ACL.
+func aclOf(path string) (any, error) {
+	buffer := make([]byte, 1024)
+	n, err := unix.Getxattr(path, posixACLAccess, buffer)
+	if err != nil {
+		if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	buffer = buffer[:n]
+	if len(buffer) < 4 || binary.LittleEndian.Uint32(buffer) != posixACLVersion {
+		return nil, fmt.Errorf("unsupported ACL encoding")
+	}
+	entries := []string{}
+	for data := buffer[4:]; len(data) >= 8; data = data[8:] {
+		tag := binary.LittleEndian.Uint16(data[0:])
+		perm := binary.LittleEndian.Uint16(data[2:])
+		id := binary.LittleEndian.Uint32(data[4:])
+		var qualifier string
+		switch tag {
+		case aclUserObj:
+			qualifier = "user:"
+		case aclUser:
+			qualifier = fmt.Sprintf("user:%d", id)
+		case aclGroupObj:
+			qualifier = "group:"
+		case aclGroup:
+			qualifier = fmt.Sprintf("group:%d", id)
+		case aclMask:
+			qualifier = "mask:"
+		case aclOther:
+			qualifier = "other:"
+		default:
+			return nil, fmt.Errorf("unsupported ACL tag %#x", tag)
+		}
+		entries = append(entries, qualifier+":"+permissions(perm))
+	}
+	return strings.Join(entries, ","), nil
+}
+
+// permissions returns the rwx representation of the given ACL permission bits.
+func permissions(perm uint16) string {
+	result := []byte("---")
+	if perm&0x04 != 0 {
+		result[0] = 'r'
+	}
+	if perm&0x02 != 0 {
+		result[1] = 'w'
+	}
+	if perm&0x01 != 0 {
+		result[2] = 'x'
+	}
+	return string(result)
+}
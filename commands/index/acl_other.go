@@ -0,0 +1,8 @@
+//go:build !linux
+
+package index
+
+// aclOf returns nil, since ACLs are only captured on Linux.
+func aclOf(path string) (any, error) {
+	return nil, nil
+}
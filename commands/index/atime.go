@@ -0,0 +1,25 @@
+package index
+
+import (
+	"log/slog"
+	"os"
+)
+
+// file is a file opened for reading by open; closing it restores its access
+// time, if reading it could not be kept from updating it.
+type file struct {
+	*os.File
+	// restore, if set, restores the access time of the file.
+	restore func() error
+}
+
+// Close closes the file, then restores its access time if needed.
+func (f *file) Close() error {
+	err := f.File.Close()
+	if f.restore != nil {
+		if err := f.restore(); err != nil {
+			slog.Debug("error restoring file access time", "path", f.Name(), "error", err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+//go:build darwin
+
+package index
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// open opens the file for reading; there is no way to keep reading from
+// updating the access time on this platform, so it is restored once the file
+// is closed, as far as the permissions on the file allow it.
+func open(path string) (*file, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, restore: func() error {
+		return unix.UtimesNano(path, []unix.Timespec{stat.Atim, stat.Mtim})
+	}}, nil
+}
+
+// timesOf returns the access and status change times of the given file.
+func timesOf(info fs.FileInfo) (any, any) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		accessed := time.Unix(stat.Atimespec.Unix()).UTC().Format(time.RFC3339Nano)
+		changed := time.Unix(stat.Ctimespec.Unix()).UTC().Format(time.RFC3339Nano)
+		return accessed, changed
+	}
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package index
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// open opens the file for reading without updating its access time, so that
+// indexing does not defeat cleanup policies based on it; O_NOATIME is only
+// allowed to the owner of the file (or with CAP_FOWNER), so other files are
+// opened normally, and their access time is restored once closed, as far as
+// the permissions on the file allow it.
+func open(path string) (*file, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOATIME, 0)
+	if err == nil {
+		return &file{File: f}, nil
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return nil, err
+	}
+	return restoring(path)
+}
+
+// restoring opens the file for reading, and restores its access time once
+// closed.
+func restoring(path string) (*file, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, restore: func() error {
+		// the modification time is left alone, lest a concurrent write be
+		// hidden
+		return unix.UtimesNano(path, []unix.Timespec{stat.Atim, {Nsec: unix.UTIME_OMIT}})
+	}}, nil
+}
+
+// timesOf returns the access and status change times of the given file.
+func timesOf(info fs.FileInfo) (any, any) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		accessed := time.Unix(stat.Atim.Unix()).UTC().Format(time.RFC3339Nano)
+		changed := time.Unix(stat.Ctim.Unix()).UTC().Format(time.RFC3339Nano)
+		return accessed, changed
+	}
+	return nil, nil
+}
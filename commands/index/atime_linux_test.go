@@ -0,0 +1,62 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAccessTimePreserved(t *testing.T) {
+	const contents = "contents"
+	sum := sha256.Sum256([]byte(contents))
+	tests := []struct {
+		name string
+		read func(path string) error
+	}{
+		{name: "restored", read: func(path string) error {
+			f, err := restoring(path)
+			if err != nil {
+				return err
+			}
+			if _, err := io.ReadAll(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}},
+		{name: "verified", read: func(path string) error {
+			_, err := Verify(path, "sha256", hex.EncodeToString(sum[:]), int64(len(contents)))
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// an access time older than the modification time is updated on
+			// read even with relatime
+			accessed, modified := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC)
+			if err := os.Chtimes(path, accessed, modified); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.read(path); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stat := info.Sys().(*syscall.Stat_t)
+			if actual := time.Unix(stat.Atim.Unix()); !actual.Equal(accessed) || !info.ModTime().Equal(modified) {
+				t.Errorf("expected accessed %s and modified %s, got %s and %s", accessed, modified, actual, info.ModTime())
+			}
+		})
+	}
+}
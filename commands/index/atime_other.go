@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+
+package index
+
+import (
+	"io/fs"
+	"os"
+)
+
+// open opens the file for reading; on this platform the access time cannot
+// be preserved while reading.
+func open(path string) (*file, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f}, nil
+}
+
+// timesOf returns nil, since the access and status change times are not
+// available on this platform.
+func timesOf(info fs.FileInfo) (any, any) {
+	return nil, nil
+}
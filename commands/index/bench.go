@@ -0,0 +1,225 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
+)
+
+// Bench is the command that measures how fast files are digested on this
+// machine: it generates a synthetic tree of files, then digests it with each
+// combination of hash algorithm and number of workers, and prints the
+// throughput of each, to help choosing the settings of the index command.
+type Bench struct {
+	base.Command
+	// Directory is where the synthetic tree is generated.
+	Directory string `short:"D" long:"directory" description:"The directory where the synthetic tree is generated, e.g. on the disk to be indexed (defaults to a temporary directory)." optional:"true"`
+	// Files is the number of files in the synthetic tree.
+	Files int `short:"n" long:"files" description:"The number of files to generate." optional:"true" default:"1000"`
+	// MinSize is the size of the smallest files.
+	MinSize int64 `long:"min-size" description:"The size of the smallest files, in bytes." optional:"true" default:"1024"`
+	// MaxSize is the size of the largest files.
+	MaxSize int64 `long:"max-size" description:"The size of the largest files, in bytes; sizes are distributed logarithmically, so most files are small as in real trees." optional:"true" default:"4194304"`
+	// Hash is the comma-separated list of algorithms to compare.
+	Hash string `long:"hash" description:"The comma-separated hash algorithms to compare." optional:"true" default:"md5,sha1,sha256,sha512,blake3"`
+	// Workers is the comma-separated list of numbers of workers to compare.
+	Workers string `long:"workers" description:"The comma-separated numbers of workers to compare." optional:"true" default:"1,2,4,8"`
+	// Keep leaves the synthetic tree on disk.
+	Keep bool `long:"keep" description:"Do not remove the synthetic tree when done." optional:"true"`
+}
+
+// Result is the outcome of digesting the synthetic tree with an algorithm and
+// a number of workers.
+type Result struct {
+	Algorithm string        `json:"algorithm"`
+	Workers   int           `json:"workers"`
+	Files     int           `json:"files"`
+	Bytes     int64         `json:"bytes"`
+	Elapsed   time.Duration `json:"elapsed"`
+	// Throughput is in bytes per second.
+	Throughput float64 `json:"throughput"`
+	// Rate is in files per second.
+	Rate float64 `json:"rate"`
+}
+
+// Execute is the real implementation of the Bench command.
+func (cmd *Bench) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running bench command", "directory", cmd.Directory, "files", cmd.Files, "hash", cmd.Hash, "workers", cmd.Workers)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	algorithms, err := parseAlgorithms(cmd.Hash)
+	if err != nil {
+		slog.Error("invalid hash algorithms", "hash", cmd.Hash, "error", err)
+		return err
+	}
+	workers := []int{}
+	for _, value := range strings.Split(cmd.Workers, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			err = fmt.Errorf("invalid number of workers %q", value)
+			slog.Error("invalid number of workers", "workers", cmd.Workers, "error", err)
+			return err
+		}
+		workers = append(workers, n)
+	}
+	if cmd.Files <= 0 || cmd.MinSize <= 0 || cmd.MaxSize < cmd.MinSize {
+		err := fmt.Errorf("invalid tree: %d files of %d to %d bytes", cmd.Files, cmd.MinSize, cmd.MaxSize)
+		slog.Error("invalid synthetic tree", "error", err)
+		return err
+	}
+
+	root, err := os.MkdirTemp(cmd.Directory, "dedup-bench-")
+	if err != nil {
+		slog.Error("error creating synthetic tree directory", "error", err)
+		return err
+	}
+	if !cmd.Keep {
+		defer os.RemoveAll(root)
+	}
+	paths, bytes, err := cmd.generate(root)
+	if err != nil {
+		return err
+	}
+	slog.Info("synthetic tree generated", "path", root, "files", len(paths), "bytes", bytes)
+
+	// read everything once, so that all runs find the files in the page cache
+	// and only the first one is not penalised
+	for _, path := range paths {
+		if f, err := os.Open(path); err == nil {
+			io.Copy(io.Discard, f)
+			f.Close()
+		}
+	}
+
+	results := []*Result{}
+	for _, algorithm := range algorithms {
+		for _, n := range workers {
+			result, err := run(paths, algorithm, n)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+	}
+
+	if cmd.AutomationFriendly {
+		for _, result := range results {
+			data, err := json.Marshal(result)
+			if err != nil {
+				slog.Error("error marshalling result to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		}
+	} else {
+		cmd.Output().Printf("%d files, %d bytes in %s\n\n", len(paths), bytes, root)
+		w := tabwriter.NewWriter(cmd.Output(), 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "algorithm\tworkers\telapsed\tMB/s\tfiles/s\t")
+		for _, result := range results {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%.1f\t%.0f\t\n", result.Algorithm, result.Workers, result.Elapsed.Round(time.Millisecond), result.Throughput/1e6, result.Rate)
+		}
+		w.Flush()
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// generate writes the synthetic tree under root, spreading files across
+// subdirectories; sizes follow a log-uniform distribution and contents are
+// pseudo-random, so that they cannot be compressed by the filesystem.
+func (cmd *Bench) generate(root string) ([]string, int64, error) {
+	random := rand.New(rand.NewSource(1))
+	low, high := math.Log(float64(cmd.MinSize)), math.Log(float64(cmd.MaxSize))
+	paths := make([]string, 0, cmd.Files)
+	var total int64
+	buffer := make([]byte, 64*1024)
+	for i := 0; i < cmd.Files; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("%03d", i/100))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			slog.Error("error creating directory", "path", dir, "error", err)
+			return nil, 0, err
+		}
+		path := filepath.Join(dir, fmt.Sprintf("file-%06d", i))
+		size := int64(math.Exp(low + random.Float64()*(high-low)))
+		f, err := os.Create(path)
+		if err != nil {
+			slog.Error("error creating file", "path", path, "error", err)
+			return nil, 0, err
+		}
+		for written := int64(0); written < size; {
+			n := min(int64(len(buffer)), size-written)
+			random.Read(buffer[:n])
+			if _, err := f.Write(buffer[:n]); err != nil {
+				f.Close()
+				slog.Error("error writing file", "path", path, "error", err)
+				return nil, 0, err
+			}
+			written += n
+		}
+		if err := f.Close(); err != nil {
+			slog.Error("error closing file", "path", path, "error", err)
+			return nil, 0, err
+		}
+		paths = append(paths, path)
+		total += size
+	}
+	return paths, total, nil
+}
+
+// run digests all the given files with the given algorithm and number of
+// workers, the same way the index command does.
+func run(paths []string, algorithm string, workers int) (*Result, error) {
+	mp, err := newPool(workers)
+	if err != nil {
+		slog.Error("error creating workers pool", "workers", workers, "error", err)
+		return nil, err
+	}
+	defer mp.ReleaseTimeout(5 * time.Second)
+	digester := &digester{algorithms: []string{algorithm}}
+	summary := &Summary{}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, path := range paths {
+		path := path
+		wg.Add(1)
+		_ = mp.Submit(func() {
+			defer wg.Done()
+			if d, err := digester.digest(path); err != nil {
+				summary.failed(&failures.HashError{Path: path, Err: err})
+			} else {
+				summary.indexed(d.size)
+			}
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if summary.Failed > 0 {
+		err := fmt.Errorf("%d files could not be digested", summary.Failed)
+		slog.Error("error digesting synthetic tree", "error", err)
+		return nil, err
+	}
+	return &Result{
+		Algorithm:  algorithm,
+		Workers:    workers,
+		Files:      int(summary.Files),
+		Bytes:      summary.Bytes,
+		Elapsed:    elapsed,
+		Throughput: float64(summary.Bytes) / elapsed.Seconds(),
+		Rate:       float64(summary.Files) / elapsed.Seconds(),
+	}, nil
+}
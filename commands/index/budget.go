@@ -0,0 +1,43 @@
+package index
+
+import (
+	"log/slog"
+)
+
+// reservedFiles is the number of file descriptors kept out of the budget for
+// the database, the log file and the runtime.
+const reservedFiles = 32
+
+// budget limits the number of files that the digesters keep open at the same
+// time; workers block until a slot is available instead of failing with "too
+// many open files".
+type budget chan struct{}
+
+// newBudget creates a budget for the given number of open files; if size is
+// not positive, the budget is derived from the process limits, which are
+// raised to the maximum allowed first.
+func newBudget(size int) budget {
+	if size <= 0 {
+		limit, err := raiseOpenFilesLimit()
+		if err != nil {
+			slog.Warn("error raising open files limit", "error", err)
+		}
+		slog.Debug("open files limit", "limit", limit)
+		size = int(limit) - reservedFiles
+		if size < 1 {
+			size = 1
+		}
+	}
+	slog.Debug("open files budget", "size", size)
+	return make(budget, size)
+}
+
+// acquire takes a slot from the budget, blocking until one is available.
+func (b budget) acquire() {
+	b <- struct{}{}
+}
+
+// release gives a slot back to the budget.
+func (b budget) release() {
+	<-b
+}
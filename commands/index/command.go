@@ -1,49 +1,242 @@
 package index
 
 import (
-	"crypto/sha256"
-	"database/sql"
-	"encoding/hex"
-	"io"
+	"fmt"
 	"io/fs"
 	"log/slog"
-	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/dihedron/dedup/bloom"
+	"github.com/dihedron/dedup/buckets"
 	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/mattn/go-sqlite3"
-	"github.com/panjf2000/ants/v2"
 )
 
 // Index is the command that scans and indexes all cxontents in one or mode directories
 // on disk, in order to check if there are duplicate files on disk, and where they are.
 type Index struct {
 	base.Command
+	base.Database
 	// Paths is the array of directory paths to scan and index.
 	Paths []string `short:"p" long:"path" description:"The directory path(s) to index." required:"true"`
-	// Database is the path to the database to open/create on disk.
-	Database string `short:"d" long:"database" description:"Path to the database." required:"true" default:"./dedup.db"`
 	// Bucket is a label that is given to all entries indexed during this run.
 	Bucket string `short:"b" long:"bucket" description:"The bucket to use for indexing the given paths." optional:"true" default:"default"`
+	// MacOSMetadata is the way macOS metadata (.DS_Store, __MACOSX, ._*) is handled:
+	// it can be skipped, indexed like any other file, or AppleDouble files can be
+	// indexed and paired with their data files.
+	MacOSMetadata string `long:"macos-metadata" description:"How to handle macOS metadata files and folders." optional:"true" choice:"skip" choice:"index" choice:"pair" default:"skip"`
+	// PhotosLibrary is the way macOS Photos libraries are handled: only the
+	// originals can be indexed, skipping the derivatives Photos generates from
+	// them, or the whole package like any other directory.
+	PhotosLibrary string `long:"photos-library" description:"How to handle macOS Photos libraries (.photoslibrary): index only the originals, or everything including thumbnails and renders." optional:"true" choice:"originals" choice:"all" default:"originals"`
+	// ExcludeProfile is the comma-separated list of built-in exclusion
+	// profiles whose directories and files are not indexed.
+	ExcludeProfile string `long:"exclude-profile" description:"The comma-separated built-in exclusion profiles (system, browser-caches, node_modules, build-artifacts, or none)." optional:"true" default:"system"`
+	// Ownership enables recording the owner, group and mode of each entry.
+	Ownership bool `long:"ownership" description:"Record owner, group and mode of indexed files." optional:"true"`
+	// ACLs enables recording the POSIX access ACLs of each entry (Linux only).
+	ACLs bool `long:"acls" description:"Record the access control lists of indexed files." optional:"true"`
+	// Workers is the number of files digested at the same time.
+	Workers int `long:"workers" description:"The number of files digested at the same time (0 for no limit other than the open files)." optional:"true" default:"0"`
+	// PathBuffer is the number of files found by the walker that can wait to
+	// be digested.
+	PathBuffer int `long:"path-buffer" description:"The number of files found while walking that can be queued for digesting." optional:"true" default:"1024"`
+	// EntryBuffer is the number of digested files that can wait to be stored.
+	EntryBuffer int `long:"entry-buffer" description:"The number of digested files that can be queued for storing in the database." optional:"true" default:"1024"`
+	// CommitInterval is the interval at which stored entries are committed.
+	CommitInterval time.Duration `long:"commit-interval" description:"Commit the stored entries to the database at this interval (0 to commit each entry on its own)." optional:"true" default:"1s"`
+	// CheckpointInterval is the interval at which the write-ahead log is checkpointed.
+	CheckpointInterval time.Duration `long:"checkpoint-interval" description:"Checkpoint and truncate the database write-ahead log at this interval during long runs (0 to only do it at the end)." optional:"true" default:"5m"`
+	// MetricsInterval is the interval at which the pipeline metrics are logged.
+	MetricsInterval time.Duration `long:"metrics-interval" description:"Log queue depths and per-stage throughput at debug level at this interval (0 to disable)." optional:"true" default:"10s"`
+	// MaxMemory is the memory budget of the run.
+	MaxMemory int64 `long:"max-memory" description:"The memory budget of the run, in bytes: beyond it, queued files and resumed paths are kept on disk and the Bloom filter is not used (0 for no limit)." optional:"true" default:"0"`
+	// MaxProcs is the maximum number of CPUs executing the run at the same time.
+	MaxProcs int `long:"max-procs" description:"The maximum number of CPUs executing the run at the same time (GOMAXPROCS, 0 for all)." optional:"true" default:"0"`
+	// CPUs are the CPUs the run is pinned to.
+	CPUs string `long:"cpus" description:"Pin the run to these CPUs, e.g. 0-3,6 (Linux only)." optional:"true"`
+	// Background lowers the CPU and I/O priority of the run.
+	Background bool `long:"background" description:"Lower the CPU and I/O priority of the run, so that it yields to other workloads." optional:"true"`
+	// MaxOpenFiles is the maximum number of files that can be open at the same time.
+	MaxOpenFiles int `long:"max-open-files" description:"The maximum number of files open at the same time (0 to derive it from the process limits)." optional:"true" default:"0"`
+	// Retries is the number of times reading a file is retried on transient errors.
+	Retries int `long:"retries" description:"The number of times reading a file is retried on transient I/O errors." optional:"true" default:"3"`
+	// RetryDelay is the delay before the first retry, doubled at each attempt.
+	RetryDelay time.Duration `long:"retry-delay" description:"The delay before retrying a file, doubled at each subsequent attempt." optional:"true" default:"100ms"`
+	// MinAge is the minimum time since the last modification for a file to be indexed.
+	MinAge time.Duration `long:"min-age" description:"Skip files modified more recently than this (e.g. 30s)." optional:"true" default:"0s"`
+	// StableInterval is the interval over which a file must not change to be indexed.
+	StableInterval time.Duration `long:"stable-interval" description:"Skip files whose size or modification time change within this interval (e.g. 1s)." optional:"true" default:"0s"`
+	// Order is the order in which files are digested: as they are found while
+	// walking the filesystem, by size or randomly.
+	Order string `long:"order" description:"The order in which files are digested." optional:"true" choice:"walk" choice:"largest-first" choice:"smallest-first" choice:"random" default:"walk"`
+	// MaxFiles is the maximum number of files to index in this run.
+	MaxFiles int64 `long:"max-files" description:"Stop after indexing this many files, recording a checkpoint (0 for no limit)." optional:"true" default:"0"`
+	// MaxBytes is the maximum number of bytes to read in this run.
+	MaxBytes int64 `long:"max-bytes" description:"Stop after reading this many bytes, recording a checkpoint (0 for no limit)." optional:"true" default:"0"`
+	// Resume skips the files already indexed in the bucket by a previous run.
+	Resume bool `long:"resume" description:"Resume a previous run, skipping the files already indexed in the bucket." optional:"true"`
+	// Prune removes the entries of files that no longer exist under the
+	// indexed paths.
+	Prune bool `long:"prune" description:"Remove from the bucket the files under the indexed paths that no longer exist (complete runs only)." optional:"true"`
+	// KeepRuns is the number of most recent runs kept in the history of the
+	// bucket; entries of missing files not seen by any of them are expired.
+	KeepRuns int `long:"keep-runs" description:"Keep only this many most recent runs of the bucket, expiring the entries of missing files none of them has seen (0 for no limit)." optional:"true" default:"0"`
+	// KeepDays is the number of days runs are kept in the history of the
+	// bucket; entries of missing files not seen in as many days are expired.
+	KeepDays int `long:"keep-days" description:"Keep the runs of the bucket for this many days, expiring the entries of missing files not seen in as long (0 for no limit)." optional:"true" default:"0"`
+	// DryRun only prints the files that would be pruned or expired.
+	DryRun bool `short:"n" long:"dry-run" description:"With --prune, --keep-runs or --keep-days, only print the files that would be removed from the bucket." optional:"true"`
+	// Hash is the comma-separated list of hash algorithms to compute for each
+	// file; the first one is used to detect duplicates.
+	Hash string `long:"hash" description:"The comma-separated hash algorithms to compute (md5, sha1, sha256, sha512, blake3, quickxor, or the name of a hasher plugin); the first is used to detect duplicates." optional:"true" default:"sha256"`
+	// Filters are the filter plugins selecting the files to index.
+	Filters []string `long:"filter" description:"Only index the files kept by the filter plugin with this name, i.e. dedup-filter-<name> in the plugins directory (repeatable)." optional:"true"`
+	// HashSets are the sets of known hashes (e.g. NSRL, blocklists) that entries
+	// are matched against, each in the form label=path.
+	HashSets []string `long:"hash-set" description:"A set of known hashes to tag matching entries with, as label=path (repeatable)." optional:"true"`
+	// Entropy enables computing the Shannon entropy of each file while hashing.
+	Entropy bool `long:"entropy" description:"Compute the Shannon entropy of indexed files, to spot compressed or encrypted content." optional:"true"`
+	// Magic is the number of leading bytes of each file to store, hex-encoded.
+	Magic int `long:"magic" description:"Store this many leading bytes (up to 64) of indexed files, to identify their real type (0 to disable)." optional:"true" default:"0"`
+	// Emit is the file where indexed entries are streamed as NDJSON.
+	Emit string `long:"emit" description:"Also stream every stored entry as NDJSON to this file (- for standard output)." optional:"true"`
+	// OnStore is a statement run on each stored entry, in the same transaction.
+	OnStore string `long:"on-store" description:"An SQL statement to run for each stored entry in the same transaction, with :hash, :path, :bucket, :size, :allocated, :modified, :accessed, :changed and :change (inserted, updated or unchanged) placeholders; if it fails, the entry is not stored." optional:"true"`
+	// ReportLive announces duplicates as soon as they are indexed.
+	ReportLive bool `long:"report-live" description:"Announce each new or changed file whose content is already in the index as soon as it is stored." optional:"true"`
+	// Queue is the URL of the Redis server where the files are queued for the
+	// hash workers of other hosts, instead of being digested by this process.
+	Queue string `long:"queue" description:"Queue the files on this Redis server, e.g. redis://host:6379/0, to be digested by hash-worker processes on hosts sharing the same storage at the same paths." optional:"true"`
+	// QueueName is the prefix of the Redis keys of the queue.
+	QueueName string `long:"queue-name" description:"The prefix of the Redis keys of the queue, the same as that of the hash workers." optional:"true" default:"dedup"`
+	// QueueTimeout is the time a queued file can wait to be digested.
+	QueueTimeout time.Duration `long:"queue-timeout" description:"Give up on a queued file no worker digested within this time (0 to wait forever)." optional:"true" default:"1h"`
+	// PProf is the address where runtime profiles are served during the run.
+	PProf string `long:"pprof" description:"Serve runtime profiles (net/http/pprof) on this address during the run, e.g. localhost:6060." optional:"true"`
 
 	Up   bool `long:"up" description:"Migrate the database up." optional:"true"`
 	Down bool `long:"down" description:"Migrate the database up." optional:"true"`
 }
 
+// settings returns the options affecting the contents of the index, to be
+// recorded in the bucket metadata.
+func (cmd *Index) settings() map[string]any {
+	return map[string]any{
+		"hash":           cmd.Hash,
+		"macos-metadata": cmd.MacOSMetadata,
+		"photos-library": cmd.PhotosLibrary,
+		"exclude":        cmd.ExcludeProfile,
+		"ownership":      cmd.Ownership,
+		"acls":           cmd.ACLs,
+		"entropy":        cmd.Entropy,
+		"magic":          cmd.Magic,
+		"filters":        cmd.Filters,
+	}
+}
+
+// schedule applies the CPU and priority settings of the run.
+func (cmd *Index) schedule() error {
+	if cmd.MaxProcs > 0 {
+		previous := runtime.GOMAXPROCS(cmd.MaxProcs)
+		slog.Debug("CPUs limited", "max-procs", cmd.MaxProcs, "previous", previous)
+	}
+	if cmd.CPUs != "" {
+		cpus, err := parseCPUs(cmd.CPUs)
+		if err != nil {
+			slog.Error("invalid CPUs", "cpus", cmd.CPUs, "error", err)
+			return err
+		}
+		if err := setAffinity(cpus); err != nil {
+			slog.Error("error pinning run to CPUs", "cpus", cmd.CPUs, "error", err)
+			return err
+		}
+		slog.Debug("run pinned to CPUs", "cpus", cpus)
+	}
+	if cmd.Background {
+		if err := lowerPriority(); err != nil {
+			// not worth failing the run for
+			slog.Warn("error lowering process priority", "error", err)
+		} else {
+			slog.Debug("process priority lowered")
+		}
+	}
+	return nil
+}
+
 // Execute is the real implementation of the Version command.
 func (cmd *Index) Execute(args []string) error {
 	cmd.Init()
 	slog.Debug("running index command", "paths", cmd.Paths, "database", cmd.Database)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+	if cmd.PProf != "" {
+		stop, err := base.ServeProfiles(cmd.PProf)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	algorithms, err := parseAlgorithms(cmd.Hash)
+	if err != nil {
+		slog.Error("invalid hash algorithms", "hash", cmd.Hash, "error", err)
+		return err
+	}
+
+	if cmd.Magic < 0 || cmd.Magic > MaxMagicLength {
+		err := fmt.Errorf("invalid magic length %d, must be between 0 and %d", cmd.Magic, MaxMagicLength)
+		slog.Error("invalid magic length", "magic", cmd.Magic, "error", err)
+		return err
+	}
+
+	excluded, err := newExclusions(cmd.ExcludeProfile, cmd.Paths)
+	if err != nil {
+		slog.Error("invalid exclusion profiles", "profiles", cmd.ExcludeProfile, "error", err)
+		return err
+	}
+
+	if err := cmd.schedule(); err != nil {
+		return err
+	}
+
+	digester := &digester{
+		algorithms: algorithms,
+		entropy:    cmd.Entropy,
+		magic:      cmd.Magic,
+		retries:    cmd.Retries,
+		delay:      cmd.RetryDelay,
+	}
+
+	known, err := loadHashSets(cmd.HashSets)
+	if err != nil {
+		return err
+	}
+
+	selected, err := loadFilters(cmd.Filters)
+	if err != nil {
+		return err
+	}
+
+	// the files are digested by remote workers if a queue is given
+	var remote *queue
+	if cmd.Queue != "" {
+		if remote, err = newQueue(cmd.Queue, cmd.QueueName, cmd.QueueTimeout, digester); err != nil {
+			return err
+		}
+		defer remote.close()
+	}
 
 	// open the SQLite3 database
-	db, err := sql.Open("sqlite3", cmd.Database+"?_journal=WAL&_timeout=5000&_fk=true")
+	db, err := cmd.Open()
 	if err != nil {
-		slog.Error("error opening SQLite database", "path", cmd.Database, "error", err)
 		return err
 	}
 	defer db.Close()
@@ -73,59 +266,251 @@ func (cmd *Index) Execute(args []string) error {
 		}
 	}
 
+	// check the entry hook before anything is recorded
+	hook, err := newHook(db, cmd.OnStore)
+	if err != nil {
+		return err
+	}
+
+	// record the bucket settings, refusing to mix incomparable hashes
+	roots := make([]string, 0, len(cmd.Paths))
+	for _, path := range cmd.Paths {
+		if root, err := filepath.Abs(path); err == nil {
+			path = root
+		}
+		roots = append(roots, path)
+	}
+	if err := buckets.Register(db, cmd.Bucket, algorithms[0], roots, cmd.settings()); err != nil {
+		return err
+	}
+
+	// the filter of the hashes already in the index tells likely duplicates
+	// apart as soon as files are stored, without looking them up
+	limitMemory(cmd.MaxMemory)
+	filter, err := bloom.Load(db)
+	if err != nil {
+		return err
+	}
+	if cmd.MaxMemory > 0 && filter.Size() > cmd.MaxMemory/4 {
+		slog.Info("Bloom filter exceeds the memory budget, not flagging likely duplicates", "bytes", filter.Size())
+		filter = nil
+	}
+
+	// when resuming, skip the files indexed in the previous run(s)
+	var done *indexed
+	if checkpoint, err := hasCheckpoint(db, cmd.Bucket); err != nil {
+		return err
+	} else if checkpoint && !cmd.Resume {
+		slog.Warn("previous run did not complete, use --resume to continue it", "bucket", cmd.Bucket)
+	}
+	if cmd.Resume {
+		if done, err = newIndexed(db, cmd.Bucket, cmd.MaxMemory/4); err != nil {
+			return err
+		}
+		defer done.close()
+		slog.Debug("resuming run", "bucket", cmd.Bucket)
+	}
+	current, err := startRun(db, cmd.Bucket)
+	if err != nil {
+		return err
+	}
+	budget := &limits{maxFiles: cmd.MaxFiles, maxBytes: cmd.MaxBytes}
+	exhausted := false
+
+	// limit the number of open files, raising the process limits if needed
+	files := newBudget(cmd.MaxOpenFiles)
+
+	summary := &Summary{}
+
+	emitter, err := newEmitter(cmd.Emit)
+	if err != nil {
+		return err
+	}
+	defer emitter.close()
+
 	// create the workers' pool
 	var wg sync.WaitGroup
-	mp, _ := ants.NewMultiPool(10, -1, ants.RoundRobin)
+	workers := cmd.Workers
+	if remote != nil && workers <= 0 {
+		workers = DefaultQueueDepth
+	}
+	mp, err := newPool(workers)
+	if err != nil {
+		slog.Error("error creating workers pool", "workers", workers, "error", err)
+		return err
+	}
 	defer mp.ReleaseTimeout(5 * time.Second)
 
+	// the walker queues the files to digest, the digest workers queue the
+	// entries to store, and a single writer stores them
+	if cmd.PathBuffer < 0 || cmd.EntryBuffer < 0 {
+		err := fmt.Errorf("invalid buffer sizes %d and %d", cmd.PathBuffer, cmd.EntryBuffer)
+		slog.Error("invalid buffer sizes", "error", err)
+		return err
+	}
+	paths := make(chan *job, cmd.PathBuffer)
+	entries := make(chan *pending, cmd.EntryBuffer)
+	stats := &metrics{}
+	stop := stats.start(cmd.MetricsInterval, paths, entries, mp)
+
+	// process digests a file and queues the corresponding entry
+	process := func(j *job) {
+		defer wg.Done()
+		path := j.path
+		if cmd.MinAge > 0 || cmd.StableInterval > 0 {
+			if settled, err := isSettled(path, cmd.MinAge, cmd.StableInterval); err != nil {
+				slog.Error("error checking if file is being written", "path", path, "error", err)
+				summary.failed(&failures.HashError{Path: path, Err: err})
+				return
+			} else if !settled {
+				slog.Info("skipping file being written", "path", path)
+				summary.skipped()
+				return
+			}
+		}
+		var d *digest
+		var err error
+		if remote != nil {
+			d, err = remote.digest(path, summary)
+		} else {
+			files.acquire()
+			d, err = digester.digestWithRetries(path, summary)
+			files.release()
+		}
+		if err != nil {
+			summary.failed(&failures.HashError{Path: path, Err: err})
+			return
+		}
+		slog.Debug("file processed", "path", path, "hash", d.hash)
+		atomic.AddInt64(&stats.digested, 1)
+		match := known.match(d.hashes)
+		if match != nil {
+			slog.Info("file matches known hash set", "path", path, "sets", match)
+			summary.matched()
+		}
+		entries <- &pending{job: j, digest: d, known: match}
+	}
+
+	// the writer stores the digested files in the background
+	w := &writer{db: db, bucket: cmd.Bucket, interval: cmd.CommitInterval, checkpoint: cmd.CheckpointInterval, summary: summary, emitter: emitter, stats: stats, hook: hook, filter: filter}
+	if cmd.ReportLive {
+		w.live = &reporter{out: cmd.Output(), automationFriendly: cmd.AutomationFriendly}
+	}
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		w.run(entries)
+	}()
+
+	// the dispatcher hands the queued files over to the digest workers
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		for j := range paths {
+			j := j
+			wg.Add(1)
+			_ = mp.Submit(func() { process(j) })
+		}
+	}()
+
+	// files are either submitted as they are found, or queued and submitted
+	// once the whole tree has been visited, according to the requested order
+	queue := &spool{limit: cmd.MaxMemory / 2}
+	defer queue.close()
+	schedule := func(j *job) error {
+		if cmd.Order != "walk" {
+			return queue.add(j)
+		}
+		if !budget.allow(j.size) {
+			exhausted = true
+			return nil
+		}
+		atomic.AddInt64(&stats.walked, 1)
+		paths <- j
+		return nil
+	}
+
 	// now visit the filesystem
 	visit := func(path string, object fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Error("error visiting path", "path", path, "error", err)
+			summary.failed(&failures.WalkError{Path: path, Err: err})
+			return nil
+		}
 		if object.Type().IsDir() {
 			slog.Debug("visit directory", "path", path)
+			if cmd.MacOSMetadata == "skip" && isMacOSXFolder(object.Name()) {
+				slog.Debug("skipping macOS metadata folder", "path", path)
+				return filepath.SkipDir
+			}
+			if cmd.PhotosLibrary == "originals" && isPhotosLibraryInternal(path) {
+				slog.Debug("skipping Photos library derivatives", "path", path)
+				return filepath.SkipDir
+			}
+			if excluded.dir(path) {
+				slog.Debug("skipping excluded directory", "path", path)
+				return filepath.SkipDir
+			}
 		} else if object.Type().IsRegular() {
 			slog.Debug("visit regular file", "path", path)
-			wg.Add(1)
-			_ = mp.Submit(func() {
-				defer wg.Done()
-				f, err := os.Open(path)
-				if err != nil {
-					slog.Error("error opening file", "path", path, "error", err)
-					return
-				}
-				defer f.Close()
-
-				var size int64
-				h := sha256.New()
-				if size, err = io.Copy(h, f); err != nil {
-					slog.Error("error reading file", "path", path, "error", err)
-					return
+			if excluded.file(path) {
+				slog.Debug("skipping excluded file", "path", path)
+				return nil
+			}
+			if cmd.PhotosLibrary == "originals" && isPhotosLibraryInternal(path) {
+				slog.Debug("skipping Photos library internal file", "path", path)
+				return nil
+			}
+			if done.contains(path) {
+				slog.Debug("skipping file indexed in previous run", "path", path)
+				return nil
+			}
+			if keep, err := selected.keep(path, object); err != nil {
+				slog.Error("error filtering file", "path", path, "error", err)
+				summary.failed(&failures.WalkError{Path: path, Err: err})
+				return nil
+			} else if !keep {
+				slog.Debug("skipping file not kept by filters", "path", path)
+				return nil
+			}
+			j := &job{path: path}
+			if cmd.MacOSMetadata != "index" {
+				if isDesktopServicesStore(object.Name()) {
+					slog.Debug("skipping macOS Finder metadata file", "path", path)
+					return nil
 				}
-
-				hash := hex.EncodeToString(h.Sum(nil))
-				slog.Debug("file processed", "path", path, "hash", hash)
-				//db.View
-
-				tx, err := db.Begin()
-				if err != nil {
-					slog.Error("error opening database transaction", "error", err)
-					return
+				if isAppleDouble(object.Name()) {
+					if cmd.MacOSMetadata == "skip" {
+						slog.Debug("skipping macOS AppleDouble file", "path", path)
+						return nil
+					}
+					j.forkOf = dataFileOf(path)
+					slog.Debug("pairing macOS AppleDouble file", "path", path, "data file", j.forkOf)
 				}
-				stmt, err := tx.Prepare("insert into entries(hash, path, bucket, size) values(?, ?, ?, ?)")
+			}
+			if cmd.Ownership || cmd.Order != "walk" || cmd.MaxBytes > 0 {
+				info, err := object.Info()
 				if err != nil {
-					slog.Error("error preparing database insert statement", "error", err)
-					return
+					slog.Error("error reading file info", "path", path, "error", err)
+					return nil
 				}
-				defer stmt.Close()
-				_, err = stmt.Exec(hash, path, cmd.Bucket, size)
-				if err != nil {
-					slog.Error("error executing database insert statement", "error", err)
-					return
+				j.size = info.Size()
+				if cmd.Ownership {
+					j.uid, j.gid, j.mode = ownershipOf(info)
 				}
-				if err = tx.Commit(); err != nil {
-					slog.Error("error committing database insert transaction", "error", err)
-					return
+			}
+			if cmd.ACLs {
+				if j.acl, err = aclOf(path); err != nil {
+					slog.Warn("error reading file ACL", "path", path, "error", err)
 				}
-			})
+			}
+			if err := schedule(j); err != nil {
+				return err
+			}
+			if exhausted {
+				slog.Info("run budget exhausted", "files", budget.files, "bytes", budget.bytes)
+				return fs.SkipAll
+			}
 		} else {
 			slog.Warn("visit object", "path", path, "type", object.Type().String())
 		}
@@ -133,12 +518,75 @@ func (cmd *Index) Execute(args []string) error {
 	}
 
 	for _, path := range cmd.Paths {
+		if exhausted {
+			break
+		}
 		slog.Debug("visiting directory", "path", path)
-		if err := filepath.WalkDir(path, visit); err != nil {
+		if err := walk(path, visit); err != nil {
 			slog.Error("error visiting directory", "path", path, "error", err)
 		}
 	}
-	slog.Debug("filepath.WalkDir() returned", "error", err)
-	// slog.Debug("command done")
-	return nil
+
+	if queue.len() > 0 {
+		if err := queue.drain(cmd.Order, func(j *job) bool {
+			if !budget.allow(j.size) {
+				slog.Info("run budget exhausted", "files", budget.files, "bytes", budget.bytes)
+				exhausted = true
+				return false
+			}
+			atomic.AddInt64(&stats.walked, 1)
+			paths <- j
+			return true
+		}); err != nil {
+			slog.Error("error reading queued files", "error", err)
+		}
+	}
+
+	// wait for the digesters and then the writer to be done before reporting
+	close(paths)
+	<-dispatched
+	wg.Wait()
+	close(entries)
+	<-written
+	stop()
+	if filter != nil {
+		if err := bloom.Save(db, filter); err != nil {
+			return err
+		}
+	}
+	if exhausted {
+		if err := saveCheckpoint(db, cmd.Bucket, budget); err != nil {
+			return err
+		}
+		summary.Incomplete = true
+	} else if err := clearCheckpoint(db, cmd.Bucket); err != nil {
+		return err
+	}
+	if cmd.Prune {
+		if exhausted || cmd.Resume {
+			slog.Warn("not pruning the bucket after an incomplete or resumed run", "bucket", cmd.Bucket)
+		} else if err := current.prune(db, cmd.Paths, cmd.DryRun, cmd.Output()); err != nil {
+			return err
+		}
+	}
+	if cmd.KeepRuns > 0 || cmd.KeepDays > 0 {
+		if exhausted || cmd.Resume {
+			slog.Warn("not expiring entries after an incomplete or resumed run", "bucket", cmd.Bucket)
+		} else if err := current.expire(db, cmd.Paths, cmd.KeepRuns, cmd.KeepDays, cmd.DryRun, cmd.Output()); err != nil {
+			return err
+		}
+	}
+	if err := current.finish(db, summary); err != nil {
+		return err
+	}
+	if (cmd.KeepRuns > 0 || cmd.KeepDays > 0) && !cmd.DryRun && !exhausted && !cmd.Resume {
+		if err := current.retain(db, cmd.KeepRuns, cmd.KeepDays); err != nil {
+			return err
+		}
+	}
+	if err := summary.Print(cmd.Output(), cmd.AutomationFriendly); err != nil {
+		return err
+	}
+	slog.Debug("command done")
+	return failures.Partial(summary.Failed)
 }
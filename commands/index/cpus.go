@@ -0,0 +1,45 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrAffinityNotSupported is returned when CPU affinity cannot be set on the
+// current platform.
+var ErrAffinityNotSupported = errors.New("CPU affinity is not supported on this platform")
+
+// niceness is the scheduling priority the process is lowered to when asked to
+// run in the background.
+const niceness = 10
+
+// parseCPUs parses a list of CPUs such as "0-3,6,8-9".
+func parseCPUs(spec string) ([]int, error) {
+	cpus := []int{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, found := strings.Cut(part, "-")
+		low, err := strconv.Atoi(first)
+		if err != nil || low < 0 {
+			return nil, fmt.Errorf("invalid CPU %q in %q", first, spec)
+		}
+		high := low
+		if found {
+			if high, err = strconv.Atoi(last); err != nil || high < low {
+				return nil, fmt.Errorf("invalid CPU range %q in %q", part, spec)
+			}
+		}
+		for cpu := low; cpu <= high; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("no CPUs in %q", spec)
+	}
+	return cpus, nil
+}
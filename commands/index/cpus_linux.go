@@ -0,0 +1,32 @@
+package index
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// I/O scheduling classes and targets, see ioprio_set(2).
+const (
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioLowest     = 7
+	ioprioWhoProcess = 1
+)
+
+// setAffinity restricts the process, and thus all the digest workers, to the
+// given CPUs.
+func setAffinity(cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return unix.SchedSetaffinity(0, &set)
+}
+
+// lowerIOPriority moves the process to the lowest best-effort I/O priority,
+// so that its reads yield to those of other processes.
+func lowerIOPriority() error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassBE<<ioprioClassShift|ioprioLowest); errno != 0 {
+		return errno
+	}
+	return nil
+}
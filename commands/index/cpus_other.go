@@ -0,0 +1,13 @@
+//go:build !linux
+
+package index
+
+// setAffinity returns an error, since CPU affinity is only set on Linux.
+func setAffinity(cpus []int) error {
+	return ErrAffinityNotSupported
+}
+
+// lowerIOPriority does nothing, since I/O priorities are only set on Linux.
+func lowerIOPriority() error {
+	return nil
+}
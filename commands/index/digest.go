@@ -0,0 +1,151 @@
+package index
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"time"
+)
+
+// digester holds the settings used to read and hash files.
+type digester struct {
+	// algorithms are the hash algorithms to compute; the first is the primary.
+	algorithms []string
+	// entropy enables computing the Shannon entropy of the file contents.
+	entropy bool
+	// magic is the number of leading bytes of the file to store (0 to disable).
+	magic int
+	// retries is the number of times reading a file is retried on transient errors.
+	retries int
+	// delay is the delay before the first retry, doubled at each attempt.
+	delay time.Duration
+}
+
+// digest is the outcome of reading and hashing a file.
+type digest struct {
+	// hash is the hex-encoded hash of the file contents, computed with the
+	// primary algorithm.
+	hash string
+	// hashes maps each of the requested algorithms to the hex-encoded hash
+	// of the file contents.
+	hashes map[string]string
+	// size is the apparent size of the file.
+	size int64
+	// allocated is the number of bytes allocated on disk, if available.
+	allocated any
+	// entropy is the Shannon entropy of the file contents, if requested.
+	entropy any
+	// magic is the hex-encoded first bytes of the file, if requested.
+	magic any
+	// modified is the modification time of the file, if available.
+	modified any
+	// accessed and changed are the access and status change times of the
+	// file before it was read, if available.
+	accessed any
+	changed  any
+}
+
+// digest reads the file at the given path and computes its hashes with all
+// the configured algorithms in a single pass.
+func (dg *digester) digest(path string) (*digest, error) {
+	f, err := open(path)
+	if err != nil {
+		slog.Error("error opening file", "path", path, "error", err)
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &digest{
+		hashes: map[string]string{},
+	}
+	// the access time is taken before reading, in case it cannot be preserved
+	if info, err := f.Stat(); err == nil {
+		d.accessed, d.changed = timesOf(info)
+	}
+	hs := make([]hash.Hash, len(dg.algorithms))
+	ws := make([]io.Writer, 0, len(dg.algorithms)+2)
+	for i, algorithm := range dg.algorithms {
+		constructor, err := hasherOf(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hs[i] = constructor()
+		ws = append(ws, hs[i])
+	}
+	var histogram *histogram
+	if dg.entropy {
+		histogram = newHistogram()
+		ws = append(ws, histogram)
+	}
+	var prefix *prefix
+	if dg.magic > 0 {
+		prefix = newPrefix(dg.magic)
+		ws = append(ws, prefix)
+	}
+	if d.size, err = io.Copy(io.MultiWriter(ws...), f); err != nil {
+		slog.Error("error reading file", "path", path, "error", err)
+		return nil, err
+	}
+	for i, algorithm := range dg.algorithms {
+		d.hashes[algorithm] = hex.EncodeToString(hs[i].Sum(nil))
+		// hashes computed by plugins can fail when summing
+		if h, ok := hs[i].(interface{ Err() error }); ok && h.Err() != nil {
+			slog.Error("error hashing file", "path", path, "algorithm", algorithm, "error", h.Err())
+			return nil, h.Err()
+		}
+	}
+	d.hash = d.hashes[dg.algorithms[0]]
+	if histogram != nil {
+		d.entropy = histogram.Entropy()
+	}
+	if prefix != nil {
+		d.magic = hex.EncodeToString(prefix.data)
+	}
+
+	// record the allocated size too, so sparse files are not
+	// accounted for more space than they actually take up
+	if info, err := f.Stat(); err != nil {
+		slog.Warn("error reading file info", "path", path, "error", err)
+	} else {
+		d.modified = info.ModTime().UTC().Format(time.RFC3339Nano)
+		if d.allocated = allocatedSizeOf(info); d.allocated != nil && d.allocated.(int64) < d.size {
+			slog.Debug("sparse file detected", "path", path, "size", d.size, "allocated", d.allocated)
+		}
+	}
+	return d, nil
+}
+
+// isTransient returns whether the given error may go away if the operation is
+// retried, as is often the case with network filesystems.
+func isTransient(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, fs.ErrInvalid)
+}
+
+// digestWithRetries digests the given file, retrying on transient errors and
+// doubling the delay between attempts; the number of retries is added to the
+// run summary.
+func (dg *digester) digestWithRetries(path string, summary *Summary) (*digest, error) {
+	delay := dg.delay
+	for attempt := 0; ; attempt++ {
+		d, err := dg.digest(path)
+		if err == nil || attempt >= dg.retries || !isTransient(err) {
+			return d, err
+		}
+		summary.retried()
+		slog.Warn("retrying file after transient error", "path", path, "attempt", attempt+1, "delay", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// column returns the hash computed with the given algorithm as a value for the
+// corresponding database column, or nil if it was not computed.
+func (d *digest) column(algorithm string) any {
+	if hash, ok := d.hashes[algorithm]; ok {
+		return hash
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// record is an indexed entry as streamed to the emit target, one JSON object
+// per line.
+type record struct {
+	Hash      string            `json:"hash"`
+	Path      string            `json:"path"`
+	Bucket    string            `json:"bucket"`
+	Size      int64             `json:"size"`
+	Allocated any               `json:"allocated,omitempty"`
+	ForkOf    any               `json:"fork_of,omitempty"`
+	UID       any               `json:"uid,omitempty"`
+	GID       any               `json:"gid,omitempty"`
+	Mode      any               `json:"mode,omitempty"`
+	ACL       any               `json:"acl,omitempty"`
+	Hashes    map[string]string `json:"hashes,omitempty"`
+	Known     any               `json:"known,omitempty"`
+	Entropy   any               `json:"entropy,omitempty"`
+	Magic     any               `json:"magic,omitempty"`
+	Modified  any               `json:"modified,omitempty"`
+	Accessed  any               `json:"accessed,omitempty"`
+	Changed   any               `json:"changed,omitempty"`
+}
+
+// emitter streams the indexed entries as NDJSON; a nil emitter discards them.
+type emitter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// newEmitter returns an emitter writing to the given file, or to standard
+// output if the path is "-"; it returns nil if the path is empty.
+func newEmitter(path string) (*emitter, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return &emitter{encoder: json.NewEncoder(os.Stdout)}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		slog.Error("error creating emit file", "path", path, "error", err)
+		return nil, err
+	}
+	return &emitter{encoder: json.NewEncoder(f), closer: f}, nil
+}
+
+// emit writes the given record; it is safe for concurrent use.
+func (e *emitter) emit(r *record) {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := e.encoder.Encode(r); err != nil {
+		slog.Error("error emitting entry", "path", r.Path, "error", err)
+	}
+}
+
+// close closes the emit file, if any.
+func (e *emitter) close() error {
+	if e == nil || e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
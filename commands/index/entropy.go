@@ -0,0 +1,42 @@
+package index
+
+import (
+	"math"
+)
+
+// histogram counts the occurrences of each byte value written to it, so that
+// the Shannon entropy of a stream can be computed alongside its hashes.
+type histogram struct {
+	counts [256]int64
+	total  int64
+}
+
+// newHistogram creates a new, empty histogram.
+func newHistogram() *histogram {
+	return &histogram{}
+}
+
+// Write implements io.Writer.
+func (h *histogram) Write(p []byte) (int, error) {
+	for _, b := range p {
+		h.counts[b]++
+	}
+	h.total += int64(len(p))
+	return len(p), nil
+}
+
+// Entropy returns the Shannon entropy of the data written so far, in bits per
+// byte: values close to 8 indicate compressed or encrypted content.
+func (h *histogram) Entropy() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	entropy := 0.0
+	for _, count := range h.counts {
+		if count > 0 {
+			p := float64(count) / float64(h.total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
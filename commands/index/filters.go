@@ -0,0 +1,44 @@
+package index
+
+import (
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/dihedron/dedup/plugins"
+)
+
+// filters are the filter plugins selecting the files to index.
+type filters []plugins.Filter
+
+// loadFilters starts the filter plugins with the given names.
+func loadFilters(names []string) (filters, error) {
+	result := filters{}
+	for _, name := range names {
+		filter, err := plugins.OpenFilter(name)
+		if err != nil {
+			slog.Error("error loading filter plugin", "name", name, "error", err)
+			return nil, err
+		}
+		result = append(result, filter)
+	}
+	return result, nil
+}
+
+// keep returns whether all the filters keep the file at the given path.
+func (f filters) keep(path string, object fs.DirEntry) (bool, error) {
+	if len(f) == 0 {
+		return true, nil
+	}
+	file := plugins.File{Path: path}
+	if info, err := object.Info(); err == nil {
+		file.Size = info.Size()
+		file.Modified = info.ModTime().UTC().Format(time.RFC3339)
+	}
+	for _, filter := range f {
+		if keep, err := filter.Keep(file); err != nil || !keep {
+			return false, err
+		}
+	}
+	return true, nil
+}
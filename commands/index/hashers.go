@@ -0,0 +1,127 @@
+package index
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dihedron/dedup/plugins"
+	"github.com/zeebo/blake3"
+)
+
+// hashers maps the names of the supported hash algorithms, which are also the
+// names of the columns where the corresponding digests are stored, to their
+// constructors.
+var hashers = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake3": func() hash.Hash { return blake3.New() },
+	// quickxor is the hash OneDrive and SharePoint report for their files
+	"quickxor": newQuickXor,
+}
+
+// DefaultAlgorithm is the hash algorithm used to detect duplicates unless told
+// otherwise, and assumed for the buckets that do not record theirs.
+const DefaultAlgorithm = "sha256"
+
+// algorithms is the ordered list of the supported hash algorithms.
+var algorithms = []string{"md5", "sha1", "sha256", "sha512", "blake3", "quickxor"}
+
+// extensions maps the names of the hash algorithms provided by hasher plugins
+// to their constructors, once started.
+var extensions sync.Map
+
+// hasherOf returns the constructor of the given hash algorithm, which is either
+// built in or provided by the hasher plugin with the same name.
+func hasherOf(name string) (func() hash.Hash, error) {
+	if constructor, ok := hashers[name]; ok {
+		return constructor, nil
+	}
+	if constructor, ok := extensions.Load(name); ok {
+		return constructor.(func() hash.Hash), nil
+	}
+	constructor, err := plugins.OpenHasher(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported hash algorithm %q (valid values: %s, or the name of a hasher plugin): %w", name, strings.Join(algorithms, ", "), err)
+	}
+	extensions.Store(name, constructor)
+	return constructor, nil
+}
+
+// parseAlgorithms parses a comma-separated list of hash algorithms; the first
+// one is the primary algorithm, used to detect duplicates.
+func parseAlgorithms(spec string) ([]string, error) {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, err := hasherOf(name); err != nil {
+			return nil, err
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no hash algorithm specified")
+	}
+	return result, nil
+}
+
+// NewHasher returns a new hash for the given algorithm.
+func NewHasher(algorithm string) (hash.Hash, error) {
+	constructor, err := hasherOf(strings.ToLower(algorithm))
+	if err != nil {
+		return nil, err
+	}
+	return constructor(), nil
+}
+
+// Verify hashes the file at the given path again with the given algorithm and
+// returns its info, or an error unless it is still a regular file, and not a
+// link to one, with the indexed size and hash: files must be verified this
+// way before any action that destroys them or relies on their contents. Like
+// indexing, verifying leaves the access time of the file alone.
+func Verify(path string, algorithm string, hash string, size int64) (fs.FileInfo, error) {
+	h, err := NewHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err != nil {
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("file is not a regular file of %d bytes anymore", size)
+	}
+	f, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() != size {
+		return nil, fmt.Errorf("file is not a regular file of %d bytes anymore", size)
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != hash {
+		return nil, fmt.Errorf("file has changed (hash %s, expected %s)", actual, hash)
+	}
+	return info, nil
+}
@@ -0,0 +1,86 @@
+package index
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// hashSets maps known hashes (e.g. from the NSRL known-good sets or from
+// custom blocklists), lowercased and hex-encoded, to the labels of the sets
+// they belong to.
+type hashSets map[string][]string
+
+// loadHashSets loads the hash sets in the given specifications, each in the
+// form label=path; files contain one hash per line, optionally followed by
+// other comma- or whitespace-separated fields (as in NSRL-like CSV files);
+// lines that do not start with a hex hash (e.g. headers, comments) are ignored.
+func loadHashSets(specs []string) (hashSets, error) {
+	sets := hashSets{}
+	for _, spec := range specs {
+		label, path, ok := strings.Cut(spec, "=")
+		if !ok || label == "" || path == "" {
+			return nil, fmt.Errorf("invalid hash set %q, expected label=path", spec)
+		}
+		if err := sets.load(label, path); err != nil {
+			slog.Error("error loading hash set", "label", label, "path", path, "error", err)
+			return nil, err
+		}
+	}
+	return sets, nil
+}
+
+// load adds the hashes in the file at the given path to the set with the given label.
+func (s hashSets) load(label string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		field, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ",")
+		if fields := strings.Fields(field); len(fields) > 0 {
+			field = fields[0]
+		}
+		field = strings.ToLower(strings.Trim(field, `"'`))
+		if len(field) < 32 {
+			continue
+		}
+		if _, err := hex.DecodeString(field); err != nil {
+			continue
+		}
+		s[field] = append(s[field], label)
+		count++
+	}
+	slog.Debug("hash set loaded", "label", label, "path", path, "hashes", count)
+	return scanner.Err()
+}
+
+// match returns the comma-separated, sorted labels of the hash sets that any
+// of the given hashes belongs to, or nil if none.
+func (s hashSets) match(hashes map[string]string) any {
+	if len(s) == 0 {
+		return nil
+	}
+	labels := map[string]bool{}
+	for _, hash := range hashes {
+		for _, label := range s[hash] {
+			labels[label] = true
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(labels))
+	for label := range labels {
+		result = append(result, label)
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
+}
@@ -0,0 +1,74 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// hookParameters are the named placeholders (as :name, @name or $name) that
+// the statement run on each stored entry can refer to.
+var hookParameters = []string{"hash", "path", "bucket", "size", "allocated", "modified", "accessed", "changed", "change"}
+
+// placeholder matches the named placeholders in a statement.
+var placeholder = regexp.MustCompile(`[:@$]([A-Za-z_][A-Za-z0-9_]*)`)
+
+// hook is a user statement run on each stored entry, in the same transaction,
+// e.g. to maintain derived tables alongside the standard schema.
+type hook struct {
+	query string
+	// names are the placeholders the statement refers to, since the driver
+	// requires exactly as many arguments as parameters.
+	names []string
+}
+
+// newHook checks the given statement against the database, and returns nil if
+// there is none.
+func newHook(db *sql.DB, query string) (*hook, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	h := &hook{query: query}
+	for _, match := range placeholder.FindAllStringSubmatch(query, -1) {
+		name := match[1]
+		if !slices.Contains(hookParameters, name) {
+			err := fmt.Errorf("unknown placeholder %s in entry hook (available: %s)", match[0], strings.Join(hookParameters, ", "))
+			slog.Error("invalid entry hook", "error", err)
+			return nil, err
+		}
+		if !slices.Contains(h.names, name) {
+			h.names = append(h.names, name)
+		}
+	}
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		slog.Error("invalid entry hook", "query", query, "error", err)
+		return nil, err
+	}
+	stmt.Close()
+	return h, nil
+}
+
+// args returns the values of the placeholders for the given stored entry.
+func (h *hook) args(p *pending, bucket string, c change) []any {
+	j, d := p.job, p.digest
+	values := map[string]any{
+		"hash":      d.hash,
+		"path":      j.path,
+		"bucket":    bucket,
+		"size":      d.size,
+		"allocated": d.allocated,
+		"modified":  d.modified,
+		"accessed":  d.accessed,
+		"changed":   d.changed,
+		"change":    [...]string{inserted: "inserted", updated: "updated", unchanged: "unchanged"}[c],
+	}
+	args := make([]any, 0, len(h.names))
+	for _, name := range h.names {
+		args = append(args, sql.Named(name, values[name]))
+	}
+	return args
+}
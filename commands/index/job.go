@@ -0,0 +1,35 @@
+package index
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// job is a file to be digested and stored, along with the metadata collected
+// while walking the filesystem.
+type job struct {
+	// path is the path of the file.
+	path string
+	// size is the size of the file at the time it was found; it is only
+	// collected when needed to order the jobs.
+	size int64
+	// forkOf is the path of the data file of an AppleDouble file.
+	forkOf any
+	// uid, gid and mode are the ownership and permissions of the file.
+	uid, gid, mode any
+	// acl is the access control list of the file.
+	acl any
+}
+
+// sortJobs arranges the given jobs in the given order; a multi-pool processes
+// jobs concurrently, so the order is only approximately respected.
+func sortJobs(jobs []*job, order string) {
+	switch order {
+	case "largest-first":
+		sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].size > jobs[j].size })
+	case "smallest-first":
+		sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].size < jobs[j].size })
+	case "random":
+		rand.Shuffle(len(jobs), func(i, j int) { jobs[i], jobs[j] = jobs[j], jobs[i] })
+	}
+}
@@ -0,0 +1,80 @@
+package index
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// limits keeps track of the number of files and bytes scheduled for digesting
+// in a run, against the maximum budget (if any).
+type limits struct {
+	maxFiles int64
+	maxBytes int64
+	files    int64
+	bytes    int64
+}
+
+// allow returns whether a file of the given size fits in the remaining budget,
+// and if so accounts for it.
+func (l *limits) allow(size int64) bool {
+	if l.maxFiles > 0 && l.files+1 > l.maxFiles {
+		return false
+	}
+	if l.maxBytes > 0 && l.bytes+size > l.maxBytes {
+		return false
+	}
+	l.files++
+	l.bytes += size
+	return true
+}
+
+// saveCheckpoint records that the index run for the given bucket stopped
+// before completion, so it can be resumed later.
+func saveCheckpoint(db *sql.DB, bucket string, l *limits) error {
+	_, err := db.Exec("insert or replace into checkpoints(bucket, files, bytes, created) values(?, ?, ?, ?)", bucket, l.files, l.bytes, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		slog.Error("error saving checkpoint", "bucket", bucket, "error", err)
+	}
+	return err
+}
+
+// clearCheckpoint removes the checkpoint of the given bucket, if any.
+func clearCheckpoint(db *sql.DB, bucket string) error {
+	_, err := db.Exec("delete from checkpoints where bucket = ?", bucket)
+	if err != nil {
+		slog.Error("error clearing checkpoint", "bucket", bucket, "error", err)
+	}
+	return err
+}
+
+// hasCheckpoint returns whether a previous run for the given bucket stopped
+// before completion.
+func hasCheckpoint(db *sql.DB, bucket string) (bool, error) {
+	var count int
+	if err := db.QueryRow("select count(*) from checkpoints where bucket = ?", bucket).Scan(&count); err != nil {
+		slog.Error("error reading checkpoint", "bucket", bucket, "error", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// indexedPaths returns the set of paths already indexed in the given bucket.
+func indexedPaths(db *sql.DB, bucket string) (map[string]struct{}, error) {
+	rows, err := db.Query("select path from entries where bucket = ?", bucket)
+	if err != nil {
+		slog.Error("error querying indexed paths", "bucket", bucket, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	paths := map[string]struct{}{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			slog.Error("error reading indexed path", "error", err)
+			return nil, err
+		}
+		paths[path] = struct{}{}
+	}
+	return paths, rows.Err()
+}
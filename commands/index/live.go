@@ -0,0 +1,72 @@
+package index
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// sibling is an entry already in the index with the same content as a file
+// being indexed.
+type sibling struct {
+	Path   string `json:"path"`
+	Bucket string `json:"bucket"`
+}
+
+// finding is a duplicate announced as soon as it is stored.
+type finding struct {
+	Hash   string    `json:"hash"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	Copies []sibling `json:"copies"`
+}
+
+// reporter announces the duplicates found while indexing, either in human
+// readable form or as NDJSON; a nil reporter announces nothing.
+type reporter struct {
+	out                *base.Output
+	automationFriendly bool
+}
+
+// others returns the other entries in the index with the given hash,
+// including those stored earlier in the current transaction.
+func others(stmt *sql.Stmt, hash string, path string) ([]sibling, error) {
+	rows, err := stmt.Query(hash, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	copies := []sibling{}
+	for rows.Next() {
+		var c sibling
+		var bucket sql.NullString
+		if err := rows.Scan(&c.Path, &bucket); err != nil {
+			return nil, err
+		}
+		c.Bucket = bucket.String
+		copies = append(copies, c)
+	}
+	return copies, rows.Err()
+}
+
+// report announces the given duplicate.
+func (r *reporter) report(f *finding) {
+	if r == nil {
+		return
+	}
+	if r.automationFriendly {
+		data, err := json.Marshal(f)
+		if err != nil {
+			slog.Error("error marshalling duplicate to JSON", "path", f.Path, "error", err)
+			return
+		}
+		r.out.Println(string(data))
+		return
+	}
+	r.out.Printf("%s: %s (%d bytes, hash %s)\n", r.out.Paint(base.Yellow, "duplicate"), f.Path, f.Size, f.Hash)
+	for _, c := range f.Copies {
+		r.out.Printf("       = %s [%s]\n", c.Path, r.out.Paint(base.Faint, c.Bucket))
+	}
+}
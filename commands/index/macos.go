@@ -0,0 +1,74 @@
+package index
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DesktopServicesStore is the name of the file where the macOS Finder
+	// stores the custom attributes of a folder.
+	DesktopServicesStore = ".DS_Store"
+	// MacOSXFolder is the name of the folder where archivers on macOS store
+	// the resource forks of the archived files.
+	MacOSXFolder = "__MACOSX"
+	// AppleDoublePrefix is the prefix of AppleDouble files, which hold the
+	// resource fork and extended attributes of the file with the same name.
+	AppleDoublePrefix = "._"
+	// PhotosLibraryExtension is the extension of the package where the macOS
+	// Photos app keeps its library.
+	PhotosLibraryExtension = ".photoslibrary"
+)
+
+// photosOriginals are the folders of a Photos library holding the originals
+// imported by the user (originals since Photos 5, Masters before); all the
+// others hold the database and derivatives (thumbnails, renders, proxies).
+var photosOriginals = map[string]bool{
+	"originals": true,
+	"Masters":   true,
+}
+
+// isDesktopServicesStore returns whether the given file name is a Finder
+// .DS_Store file.
+func isDesktopServicesStore(name string) bool {
+	return name == DesktopServicesStore
+}
+
+// isMacOSXFolder returns whether the given directory name is a __MACOSX
+// folder as created by archivers on macOS.
+func isMacOSXFolder(name string) bool {
+	return name == MacOSXFolder
+}
+
+// isAppleDouble returns whether the given file name is that of an AppleDouble
+// (._*) resource fork sidecar.
+func isAppleDouble(name string) bool {
+	return strings.HasPrefix(name, AppleDoublePrefix) && len(name) > len(AppleDoublePrefix)
+}
+
+// isPhotosLibraryInternal returns whether the given path is directly inside a
+// Photos library package and is not one of its folders of originals, that is
+// whether it is part of the library database or of its derivative caches.
+func isPhotosLibraryInternal(path string) bool {
+	return strings.HasSuffix(filepath.Base(filepath.Dir(path)), PhotosLibraryExtension) && !photosOriginals[filepath.Base(path)]
+}
+
+// dataFileOf returns the path of the data file that the given AppleDouble file
+// refers to; AppleDouble files in a __MACOSX folder refer to the data file at
+// the same relative path in the folder containing __MACOSX.
+func dataFileOf(path string) string {
+	dir, name := filepath.Split(path)
+	name = strings.TrimPrefix(name, AppleDoublePrefix)
+	components := strings.Split(filepath.Clean(dir), string(filepath.Separator))
+	for i := len(components) - 1; i >= 0; i-- {
+		if isMacOSXFolder(components[i]) {
+			components = append(components[:i], components[i+1:]...)
+			break
+		}
+	}
+	dir = strings.Join(components, string(filepath.Separator))
+	if dir == "" && filepath.IsAbs(path) {
+		dir = string(filepath.Separator)
+	}
+	return filepath.Join(dir, name)
+}
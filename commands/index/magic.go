@@ -0,0 +1,30 @@
+package index
+
+// MaxMagicLength is the maximum number of leading bytes stored per entry.
+const MaxMagicLength = 64
+
+// prefix captures the first bytes written to it, so that the magic signature
+// of a file can be recorded alongside its hashes without reading it twice.
+type prefix struct {
+	data  []byte
+	limit int
+}
+
+// newPrefix creates a prefix capturing up to limit bytes.
+func newPrefix(limit int) *prefix {
+	return &prefix{
+		data:  make([]byte, 0, limit),
+		limit: limit,
+	}
+}
+
+// Write implements io.Writer.
+func (p *prefix) Write(b []byte) (int, error) {
+	if missing := p.limit - len(p.data); missing > 0 {
+		if missing > len(b) {
+			missing = len(b)
+		}
+		p.data = append(p.data, b[:missing]...)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,219 @@
+package index
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+	"runtime/debug"
+)
+
+// jobOverhead is the approximate memory taken by a queued job, besides its path.
+const jobOverhead = 160
+
+// pathOverhead is the approximate memory taken by a path in a set, besides
+// the path itself.
+const pathOverhead = 64
+
+// limitMemory sets the soft memory limit of the runtime, so that the garbage
+// collector works harder as the limit approaches; the in-memory structures of
+// the run share the limit: the queue of files to digest can take up half of
+// it, the paths indexed by previous runs and the Bloom filter a quarter each.
+func limitMemory(limit int64) {
+	if limit > 0 {
+		debug.SetMemoryLimit(limit)
+		slog.Debug("memory limit set", "bytes", limit)
+	}
+}
+
+// spool holds the files to digest when they must be sorted first; once they
+// take up more than the given amount of memory, they are moved to a temporary
+// database on disk, which sorts them when they are read back.
+type spool struct {
+	limit  int64
+	size   int64
+	jobs   []*job
+	file   string
+	db     *sql.DB
+	insert *sql.Stmt
+	count  int
+}
+
+// add queues a job.
+func (s *spool) add(j *job) error {
+	if s.db != nil {
+		return s.write(j)
+	}
+	s.jobs = append(s.jobs, j)
+	s.size += int64(len(j.path)) + jobOverhead
+	if s.limit <= 0 || s.size <= s.limit {
+		return nil
+	}
+	slog.Info("files to digest exceed the memory budget, spilling to disk", "files", len(s.jobs), "bytes", s.size)
+	if err := s.open(); err != nil {
+		return err
+	}
+	for _, j := range s.jobs {
+		if err := s.write(j); err != nil {
+			return err
+		}
+	}
+	s.jobs, s.size = nil, 0
+	return nil
+}
+
+// open creates the temporary database.
+func (s *spool) open() error {
+	f, err := os.CreateTemp("", "dedup-spool-*.db")
+	if err != nil {
+		slog.Error("error creating spool file", "error", err)
+		return err
+	}
+	f.Close()
+	s.file = f.Name()
+	if s.db, err = sql.Open("sqlite3", s.file+"?_journal=OFF&_sync=OFF"); err != nil {
+		slog.Error("error opening spool database", "path", s.file, "error", err)
+		return err
+	}
+	// a single connection keeps the insert statement and queries in sync
+	s.db.SetMaxOpenConns(1)
+	if _, err := s.db.Exec("create table jobs(path text, size int, fork_of text, uid int, gid int, mode int, acl text)"); err != nil {
+		slog.Error("error creating spool table", "path", s.file, "error", err)
+		return err
+	}
+	if _, err := s.db.Exec("begin"); err != nil {
+		slog.Error("error opening spool transaction", "error", err)
+		return err
+	}
+	if s.insert, err = s.db.Prepare("insert into jobs values(?, ?, ?, ?, ?, ?, ?)"); err != nil {
+		slog.Error("error preparing spool insert", "error", err)
+		return err
+	}
+	return nil
+}
+
+// write stores a job in the temporary database.
+func (s *spool) write(j *job) error {
+	if _, err := s.insert.Exec(j.path, j.size, j.forkOf, j.uid, j.gid, j.mode, j.acl); err != nil {
+		slog.Error("error spilling file to disk", "path", j.path, "error", err)
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// len returns the number of queued jobs.
+func (s *spool) len() int {
+	return len(s.jobs) + s.count
+}
+
+// drain calls the given function on each job in the given order, until it
+// returns false.
+func (s *spool) drain(order string, fn func(*job) bool) error {
+	if s.db == nil {
+		sortJobs(s.jobs, order)
+		for _, j := range s.jobs {
+			if !fn(j) {
+				break
+			}
+		}
+		return nil
+	}
+	s.insert.Close()
+	if _, err := s.db.Exec("commit"); err != nil {
+		slog.Error("error committing spool transaction", "error", err)
+		return err
+	}
+	query := "select path, size, fork_of, uid, gid, mode, acl from jobs"
+	switch order {
+	case "largest-first":
+		query += " order by size desc"
+	case "smallest-first":
+		query += " order by size asc"
+	case "random":
+		query += " order by random()"
+	}
+	rows, err := s.db.Query(query)
+	if err != nil {
+		slog.Error("error reading spooled files", "error", err)
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		j := &job{}
+		if err := rows.Scan(&j.path, &j.size, &j.forkOf, &j.uid, &j.gid, &j.mode, &j.acl); err != nil {
+			slog.Error("error reading spooled file", "error", err)
+			return err
+		}
+		if !fn(j) {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+// close removes the temporary database, if any.
+func (s *spool) close() {
+	if s.db != nil {
+		s.db.Close()
+		os.Remove(s.file)
+	}
+}
+
+// indexed tells whether files were already indexed in the bucket by previous
+// runs; the paths are kept in memory unless they would take up more than the
+// given amount of it, in which case the database is queried for each file.
+type indexed struct {
+	bucket string
+	paths  map[string]struct{}
+	lookup *sql.Stmt
+}
+
+// newIndexed loads the paths indexed in the bucket, within the memory limit.
+func newIndexed(db *sql.DB, bucket string, limit int64) (*indexed, error) {
+	if limit > 0 {
+		var size sql.NullInt64
+		if err := db.QueryRow("select sum(length(path)) + count(*) * ? from entries where bucket = ?", pathOverhead, bucket).Scan(&size); err != nil {
+			slog.Error("error estimating indexed paths size", "bucket", bucket, "error", err)
+			return nil, err
+		}
+		if size.Int64 > limit {
+			slog.Info("indexed paths exceed the memory budget, looking them up on disk", "bytes", size.Int64)
+			stmt, err := db.Prepare("select count(*) from entries where bucket = ? and path = ?")
+			if err != nil {
+				slog.Error("error preparing indexed path lookup", "error", err)
+				return nil, err
+			}
+			return &indexed{bucket: bucket, lookup: stmt}, nil
+		}
+	}
+	paths, err := indexedPaths(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("indexed paths loaded", "bucket", bucket, "paths", len(paths))
+	return &indexed{bucket: bucket, paths: paths}, nil
+}
+
+// contains returns whether the given path was indexed in the bucket.
+func (i *indexed) contains(path string) bool {
+	if i == nil {
+		return false
+	}
+	if i.lookup == nil {
+		_, ok := i.paths[path]
+		return ok
+	}
+	var count int
+	if err := i.lookup.QueryRow(i.bucket, path).Scan(&count); err != nil {
+		slog.Warn("error looking up indexed path", "path", path, "error", err)
+		return false
+	}
+	return count > 0
+}
+
+// close releases the lookup statement, if any.
+func (i *indexed) close() {
+	if i != nil && i.lookup != nil {
+		i.lookup.Close()
+	}
+}
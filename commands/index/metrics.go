@@ -0,0 +1,60 @@
+package index
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// pending is a digested file waiting to be stored.
+type pending struct {
+	job    *job
+	digest *digest
+	known  any
+}
+
+// metrics counts the files going through each stage of the indexing pipeline
+// (walking, digesting, storing), so that stalls caused by slow disks or slow
+// database writes can be told apart.
+type metrics struct {
+	walked   int64
+	digested int64
+	stored   int64
+}
+
+// start logs the queue depths and the throughput of each stage at debug level
+// every interval, until the returned function is called; nothing is logged if
+// the interval is not positive.
+func (m *metrics) start(interval time.Duration, paths chan *job, entries chan *pending, workers pool) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var walked, digested, stored int64
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				w, d, s := atomic.LoadInt64(&m.walked), atomic.LoadInt64(&m.digested), atomic.LoadInt64(&m.stored)
+				slog.Debug("pipeline metrics",
+					"paths queued", len(paths), "paths capacity", cap(paths),
+					"digests running", workers.Running(), "digests waiting", workers.Waiting(),
+					"entries queued", len(entries), "entries capacity", cap(entries),
+					"walked/s", float64(w-walked)/interval.Seconds(),
+					"digested/s", float64(d-digested)/interval.Seconds(),
+					"stored/s", float64(s-stored)/interval.Seconds())
+				walked, digested, stored = w, d, s
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
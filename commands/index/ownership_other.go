@@ -0,0 +1,13 @@
+//go:build !unix
+
+package index
+
+import (
+	"io/fs"
+)
+
+// ownershipOf returns the permission bits of the given file; owner and group
+// are not available on this platform.
+func ownershipOf(info fs.FileInfo) (uid any, gid any, mode any) {
+	return nil, nil, int64(info.Mode().Perm())
+}
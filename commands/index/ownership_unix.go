@@ -0,0 +1,17 @@
+//go:build unix
+
+package index
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// ownershipOf returns the numeric owner and group identifiers and the Unix
+// mode bits of the given file.
+func ownershipOf(info fs.FileInfo) (uid any, gid any, mode any) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Uid), int64(stat.Gid), int64(stat.Mode)
+	}
+	return nil, nil, int64(info.Mode().Perm())
+}
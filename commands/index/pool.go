@@ -0,0 +1,25 @@
+package index
+
+import (
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+)
+
+// pool runs the digest workers.
+type pool interface {
+	Submit(task func()) error
+	Running() int
+	Waiting() int
+	ReleaseTimeout(timeout time.Duration) error
+}
+
+// newPool returns a pool running at most the given number of tasks at the
+// same time; if workers is not positive, tasks are only limited by the open
+// files budget.
+func newPool(workers int) (pool, error) {
+	if workers <= 0 {
+		return ants.NewMultiPool(10, -1, ants.RoundRobin)
+	}
+	return ants.NewPool(workers)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package index
+
+// lowerPriority does nothing, since priorities are only lowered on Unix.
+func lowerPriority() error {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package index
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// lowerPriority lowers the CPU and, where supported, the I/O scheduling
+// priority of the process.
+func lowerPriority() error {
+	if err := unix.Setpriority(unix.PRIO_PROCESS, 0, niceness); err != nil {
+		return err
+	}
+	return lowerIOPriority()
+}
@@ -0,0 +1,136 @@
+package index
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// profile is a curated set of patterns of directories and files that are
+// noise for deduplication purposes. Patterns are slash-separated and matched
+// against the trailing components of a path, each component as per path.Match;
+// patterns starting with a slash must match the whole path.
+type profile struct {
+	dirs  []string
+	files []string
+}
+
+// profiles are the built-in exclusion profiles.
+var profiles = map[string]profile{
+	"system": {
+		dirs: []string{
+			"/proc", "/sys", "/dev", "/run", "/var/run", "/var/lock",
+			"/System/Volumes/VM", "/private/var/vm",
+			"lost+found", "$RECYCLE.BIN", "$Recycle.Bin", "System Volume Information",
+			".Trash", ".Trashes", ".Trash-*", ".Spotlight-V100", ".fseventsd",
+			".DocumentRevisions-V100", ".TemporaryItems",
+		},
+		files: []string{
+			"pagefile.sys", "hiberfil.sys", "swapfile.sys", "Thumbs.db", "desktop.ini",
+		},
+	},
+	"browser-caches": {
+		dirs: []string{
+			".cache/mozilla", ".cache/google-chrome", ".cache/chromium", ".cache/BraveSoftware", ".cache/microsoft-edge",
+			"Library/Caches/Google", "Library/Caches/Firefox", "Library/Caches/com.apple.Safari", "Library/Caches/Mozilla",
+			"User Data/*/Cache", "User Data/*/Code Cache", "User Data/*/GPUCache", "User Data/*/Service Worker/CacheStorage",
+			"google-chrome/*/Cache", "google-chrome/*/Code Cache", "google-chrome/*/GPUCache",
+			"chromium/*/Cache", "chromium/*/Code Cache", "chromium/*/GPUCache",
+			"Profiles/*/cache2", "Profiles/*/startupCache",
+		},
+	},
+	"node_modules": {
+		dirs: []string{
+			"node_modules", "bower_components", "jspm_packages", ".npm", ".pnpm-store", ".yarn/cache",
+		},
+	},
+	"build-artifacts": {
+		dirs: []string{
+			"__pycache__", ".pytest_cache", ".mypy_cache", ".ruff_cache", ".tox",
+			".gradle", ".next", ".nuxt", ".parcel-cache", ".terraform",
+			"target", "build", "CMakeFiles",
+		},
+		files: []string{
+			"*.pyc", "*.pyo", "*.o", "*.obj", "*.class", "*.a", "*.lib",
+		},
+	},
+}
+
+// exclusions are the patterns of the selected profiles, along with the roots
+// being indexed, which are never excluded.
+type exclusions struct {
+	dirs  []string
+	files []string
+	roots map[string]bool
+}
+
+// newExclusions returns the exclusions of the comma-separated list of profiles;
+// "none" selects no profile.
+func newExclusions(names string, roots []string) (*exclusions, error) {
+	e := &exclusions{roots: map[string]bool{}}
+	for _, root := range roots {
+		e.roots[filepath.Clean(root)] = true
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		p, ok := profiles[name]
+		if !ok {
+			available := make([]string, 0, len(profiles))
+			for name := range profiles {
+				available = append(available, name)
+			}
+			sort.Strings(available)
+			return nil, fmt.Errorf("unknown exclusion profile %q, must be one of %s or none", name, strings.Join(available, ", "))
+		}
+		e.dirs = append(e.dirs, p.dirs...)
+		e.files = append(e.files, p.files...)
+	}
+	return e, nil
+}
+
+// dir returns whether the given directory is excluded.
+func (e *exclusions) dir(name string) bool {
+	return !e.roots[filepath.Clean(name)] && matchAny(e.dirs, name)
+}
+
+// file returns whether the given file is excluded.
+func (e *exclusions) file(name string) bool {
+	return !e.roots[filepath.Clean(name)] && matchAny(e.files, name)
+}
+
+// matchAny returns whether the path matches any of the patterns.
+func matchAny(patterns []string, name string) bool {
+	components := strings.Split(filepath.ToSlash(filepath.Clean(name)), "/")
+	for _, pattern := range patterns {
+		anchored := strings.HasPrefix(pattern, "/")
+		parts := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+		tail := components
+		if anchored {
+			// the first component of an absolute path is empty
+			if len(components) != len(parts)+1 || components[0] != "" {
+				continue
+			}
+			tail = components[1:]
+		} else if len(components) < len(parts) {
+			continue
+		} else {
+			tail = components[len(components)-len(parts):]
+		}
+		matched := true
+		for i, part := range parts {
+			if ok, err := path.Match(part, tail[i]); err != nil || !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package index
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestResponseDigest(t *testing.T) {
+	tests := []struct {
+		name   string
+		hashes map[string]string
+		ok     bool
+	}{
+		{name: "primary hash", hashes: map[string]string{"sha256": "h", "md5": "m"}, ok: true},
+		{name: "other hash only", hashes: map[string]string{"md5": "m"}},
+		{name: "no hashes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &response{ID: "1", Worker: "w", Hashes: test.hashes, Size: 1}
+			d, err := r.digest("sha256")
+			if (err == nil) != test.ok {
+				t.Fatalf("expected success %t, got %v", test.ok, err)
+			}
+			if test.ok && d.hash != "h" {
+				t.Errorf("expected hash h, got %q", d.hash)
+			}
+		})
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	server := miniredis.RunT(t)
+	q, err := newQueue("redis://"+server.Addr(), "dedup", 50*time.Millisecond, &digester{algorithms: []string{"sha256"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.close()
+
+	// no worker is running, so the request times out
+	if _, err := q.digest(filepath.Join(t.TempDir(), "file"), &Summary{}); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if jobs, _ := server.List(q.jobs); len(jobs) != 0 {
+		t.Errorf("expected the request removed from the queue, got %v", jobs)
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.waiting) != 0 {
+		t.Errorf("expected no request waiting, got %d", len(q.waiting))
+	}
+}
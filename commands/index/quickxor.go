@@ -0,0 +1,104 @@
+package index
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+const (
+	// quickXorWidth is the size in bits of a QuickXorHash.
+	quickXorWidth = 160
+	// quickXorShift is how many bits each byte is shifted from the previous.
+	quickXorShift = 11
+)
+
+// quickXor is the QuickXorHash that OneDrive and SharePoint report for every
+// file: each byte is XORed into a 160-bit circular buffer, 11 bits further
+// than the previous one, and the length of the data is XORed into the last
+// 64 bits of the result. It lets local files be compared with the files in a
+// drive without downloading them.
+type quickXor struct {
+	data   [3]uint64
+	length uint64
+	shift  int
+}
+
+// newQuickXor returns a new QuickXorHash.
+func newQuickXor() hash.Hash {
+	return &quickXor{}
+}
+
+// Write XORs the bytes into the buffer.
+func (q *quickXor) Write(p []byte) (int, error) {
+	cell, offset := q.shift/64, q.shift%64
+	for i := 0; i < len(p) && i < quickXorWidth; i++ {
+		last := cell == len(q.data)-1
+		bits := 64
+		if last {
+			bits = quickXorWidth % 64
+		}
+		// the bytes that are a multiple of the width apart land in the same
+		// place, and are XORed together first
+		var b byte
+		for j := i; j < len(p); j += quickXorWidth {
+			b ^= p[j]
+		}
+		if offset <= bits-8 {
+			q.data[cell] ^= uint64(b) << offset
+		} else {
+			next := cell + 1
+			if last {
+				next = 0
+			}
+			q.data[cell] ^= uint64(b) << offset
+			q.data[next] ^= uint64(b) >> (bits - offset)
+		}
+		offset += quickXorShift
+		for offset >= bits {
+			if last {
+				cell = 0
+			} else {
+				cell++
+			}
+			offset -= bits
+			last = cell == len(q.data)-1
+			bits = 64
+			if last {
+				bits = quickXorWidth % 64
+			}
+		}
+	}
+	q.shift = (q.shift + quickXorShift*(len(p)%quickXorWidth)) % quickXorWidth
+	q.length += uint64(len(p))
+	return len(p), nil
+}
+
+// Sum appends the hash to b.
+func (q *quickXor) Sum(b []byte) []byte {
+	var sum [quickXorWidth / 8]byte
+	var cell [8]byte
+	for i, value := range q.data {
+		binary.LittleEndian.PutUint64(cell[:], value)
+		copy(sum[i*8:], cell[:])
+	}
+	binary.LittleEndian.PutUint64(cell[:], q.length)
+	for i := range cell {
+		sum[len(sum)-8+i] ^= cell[i]
+	}
+	return append(b, sum[:]...)
+}
+
+// Reset resets the hash to its initial state.
+func (q *quickXor) Reset() {
+	*q = quickXor{}
+}
+
+// Size returns the number of bytes of the hash.
+func (q *quickXor) Size() int {
+	return quickXorWidth / 8
+}
+
+// BlockSize returns the number of bytes the hash is best written in.
+func (q *quickXor) BlockSize() int {
+	return 64
+}
@@ -0,0 +1,58 @@
+package index
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// naiveQuickXor computes the QuickXorHash bit by bit, as specified: byte i is
+// XORed at bit 11*i of a 160-bit circular buffer, and the length into the
+// last 64 bits.
+func naiveQuickXor(data []byte) []byte {
+	var bits [quickXorWidth]bool
+	for i, b := range data {
+		offset := i * quickXorShift % quickXorWidth
+		for k := 0; k < 8; k++ {
+			if b&(1<<k) != 0 {
+				bits[(offset+k)%quickXorWidth] = !bits[(offset+k)%quickXorWidth]
+			}
+		}
+	}
+	sum := make([]byte, quickXorWidth/8)
+	for p, set := range bits {
+		if set {
+			sum[p/8] |= 1 << (p % 8)
+		}
+	}
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(data)))
+	for i := range length {
+		sum[len(sum)-8+i] ^= length[i]
+	}
+	return sum
+}
+
+func TestQuickXor(t *testing.T) {
+	if sum := base64.StdEncoding.EncodeToString(newQuickXor().Sum(nil)); sum != "AAAAAAAAAAAAAAAAAAAAAAAAAAA=" {
+		t.Errorf("expected the hash of no data to be all zeroes, got %s", sum)
+	}
+	random := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, 7, 19, 20, 21, 159, 160, 161, 1000, 4096, 100003} {
+		data := make([]byte, size)
+		random.Read(data)
+		expected := naiveQuickXor(data)
+		// the hash must not depend on how the data is split across writes
+		for _, chunk := range []int{1, 3, 64, 160, 333, size} {
+			h := newQuickXor()
+			for i := 0; i < size; i += chunk {
+				h.Write(data[i:min(i+chunk, size)])
+			}
+			if sum := h.Sum(nil); !bytes.Equal(sum, expected) {
+				t.Errorf("size %d in chunks of %d: expected %x, got %x", size, chunk, expected, sum)
+			}
+		}
+	}
+}
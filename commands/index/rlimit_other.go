@@ -0,0 +1,12 @@
+//go:build !unix
+
+package index
+
+// defaultOpenFilesLimit is the assumed limit on open files on platforms that
+// do not expose RLIMIT_NOFILE.
+const defaultOpenFilesLimit = 512
+
+// raiseOpenFilesLimit returns the assumed open files limit.
+func raiseOpenFilesLimit() (uint64, error) {
+	return defaultOpenFilesLimit, nil
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package index
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// raiseOpenFilesLimit raises the soft limit on the number of open file
+// descriptors (RLIMIT_NOFILE) to the hard limit, and returns the resulting
+// soft limit.
+func raiseOpenFilesLimit() (uint64, error) {
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	if limit.Cur < limit.Max {
+		raised := limit
+		raised.Cur = limit.Max
+		if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &raised); err != nil {
+			// some platforms (e.g. macOS) refuse an unlimited soft limit
+			return uint64(limit.Cur), err
+		}
+		limit = raised
+	}
+	return uint64(limit.Cur), nil
+}
@@ -0,0 +1,265 @@
+package index
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/duplicates"
+)
+
+// Delta is what changed in the bucket since the previous run.
+type Delta struct {
+	// Previous is the time the previous run on the bucket finished, if any.
+	Previous string `json:"previous,omitempty"`
+	// New is the number of files that were not in the index.
+	New int64 `json:"new"`
+	// Changed is the number of files whose content changed.
+	Changed int64 `json:"changed"`
+	// Removed is the number of files pruned from the index because they no
+	// longer exist.
+	Removed int64 `json:"removed"`
+	// Groups is the number of duplicate groups introduced by the run.
+	Groups int64 `json:"new_groups"`
+	// Expired is the number of entries removed because none of the runs
+	// kept in the history has seen them.
+	Expired int64 `json:"expired,omitempty"`
+}
+
+// session is an index run, recorded so that the next run on the same bucket can
+// tell what changed.
+type session struct {
+	bucket  string
+	started time.Time
+	delta   *Delta
+	// last is the identifier of the last duplicate group assigned when the run
+	// started.
+	last int64
+}
+
+// startRun looks up the previous run on the bucket and assigns identifiers to
+// the duplicate groups already in the index, so that the groups introduced by
+// this run can be told apart at the end.
+func startRun(db *sql.DB, bucket string) (*session, error) {
+	r := &session{
+		bucket:  bucket,
+		started: time.Now().UTC().Truncate(time.Second),
+		delta:   &Delta{},
+	}
+	var previous sql.NullString
+	if err := db.QueryRow("select max(finished) from runs where bucket = ?", bucket).Scan(&previous); err != nil {
+		slog.Error("error reading previous run", "bucket", bucket, "error", err)
+		return nil, err
+	}
+	r.delta.Previous = previous.String
+	if err := assign(db); err != nil {
+		return nil, err
+	}
+	var last sql.NullInt64
+	if err := db.QueryRow("select max(id) from duplicate_groups").Scan(&last); err != nil {
+		slog.Error("error reading duplicate group identifiers", "error", err)
+		return nil, err
+	}
+	r.last = last.Int64
+	return r, nil
+}
+
+// assign assigns identifiers to the duplicate groups seen for the first time.
+func assign(db *sql.DB) error {
+	if _, err := db.Exec("insert or ignore into duplicate_groups(hash, created) select hash, ? from entries group by hash having count(*) > 1", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		slog.Error("error assigning duplicate group identifiers", "error", err)
+		return err
+	}
+	return nil
+}
+
+// groups assigns identifiers to the duplicate groups seen for the first time
+// and returns how many of those assigned since the run started include a file
+// the run indexed: groups that other commands introduced in the meantime, in
+// other buckets, are not counted.
+func (r *session) groups(db *sql.DB) (int64, error) {
+	if err := assign(db); err != nil {
+		return 0, err
+	}
+	var groups int64
+	err := db.QueryRow("select count(*) from duplicate_groups g where g.id > ? and exists (select 1 from entries e where e.hash = g.hash and e.bucket = ? and e.indexed >= ?)", r.last, r.bucket, r.started.Format(time.RFC3339)).Scan(&groups)
+	if err != nil {
+		slog.Error("error counting new duplicate groups", "bucket", r.bucket, "error", err)
+		return 0, err
+	}
+	return groups, nil
+}
+
+// prune removes from the bucket the entries under the given roots whose files
+// no longer exist; since the run was complete, all the others were refreshed.
+// In a dry run, the entries are only printed.
+func (r *session) prune(db *sql.DB, roots []string, dryRun bool, out *base.Output) error {
+	rows, err := db.Query("select path from entries where bucket = ?", r.bucket)
+	if err != nil {
+		slog.Error("error querying bucket entries", "bucket", r.bucket, "error", err)
+		return err
+	}
+	stale := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			slog.Error("error reading entry path", "error", err)
+			return err
+		}
+		if !under(path, roots) {
+			continue
+		}
+		if _, err := os.Lstat(duplicates.Absolute(path)); errors.Is(err, fs.ErrNotExist) {
+			stale = append(stale, path)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("error querying bucket entries", "bucket", r.bucket, "error", err)
+		return err
+	}
+	for _, path := range stale {
+		if dryRun {
+			out.Printf("%s %s\n", out.Paint(base.Yellow, "would prune"), path)
+			continue
+		}
+		result, err := db.Exec("delete from entries where bucket = ? and path = ?", r.bucket, path)
+		if err != nil {
+			slog.Error("error pruning entry", "path", path, "error", err)
+			return err
+		}
+		removed, _ := result.RowsAffected()
+		r.delta.Removed += removed
+		slog.Debug("entry pruned", "path", path)
+	}
+	return nil
+}
+
+// cutoff returns the time before which runs and entries of the bucket are not
+// retained, or an empty string if all are: the start of the oldest of the last
+// keepRuns runs (this one included), or keepDays days ago, whichever is later.
+func (r *session) cutoff(db *sql.DB, keepRuns int, keepDays int) (string, error) {
+	cutoff := ""
+	if keepRuns == 1 {
+		cutoff = r.started.Format(time.RFC3339)
+	} else if keepRuns > 1 {
+		var started sql.NullString
+		err := db.QueryRow("select started from runs where bucket = ? order by started desc limit 1 offset ?", r.bucket, keepRuns-2).Scan(&started)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("error reading retained runs", "bucket", r.bucket, "error", err)
+			return "", err
+		}
+		cutoff = started.String
+	}
+	if keepDays > 0 {
+		if days := r.started.AddDate(0, 0, -keepDays).Format(time.RFC3339); days > cutoff {
+			cutoff = days
+		}
+	}
+	return cutoff, nil
+}
+
+// expire removes from the bucket the entries under the given roots that were
+// last indexed before the retention cutoff, that is that none of the retained
+// runs has seen, and whose files no longer exist: files skipped or failed by
+// the retained runs, or left out of them, are still there and are kept, and so
+// are the entries under roots this run did not walk. Paths are resolved like
+// the indexer stored them, relative to the current directory. In a dry run,
+// the entries are only printed.
+func (r *session) expire(db *sql.DB, roots []string, keepRuns int, keepDays int, dryRun bool, out *base.Output) error {
+	cutoff, err := r.cutoff(db, keepRuns, keepDays)
+	if err != nil || cutoff == "" {
+		return err
+	}
+	rows, err := db.Query("select path from entries where bucket = ? and indexed < ? order by path", r.bucket, cutoff)
+	if err != nil {
+		slog.Error("error querying expired entries", "bucket", r.bucket, "error", err)
+		return err
+	}
+	expired := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			slog.Error("error reading entry path", "error", err)
+			return err
+		}
+		if !under(path, roots) {
+			continue
+		}
+		if _, err := os.Lstat(duplicates.Absolute(path)); errors.Is(err, fs.ErrNotExist) {
+			expired = append(expired, path)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("error querying expired entries", "bucket", r.bucket, "error", err)
+		return err
+	}
+	for _, path := range expired {
+		if dryRun {
+			out.Printf("%s %s\n", out.Paint(base.Yellow, "would expire"), path)
+			continue
+		}
+		result, err := db.Exec("delete from entries where bucket = ? and path = ? and indexed < ?", r.bucket, path, cutoff)
+		if err != nil {
+			slog.Error("error expiring entry", "path", path, "cutoff", cutoff, "error", err)
+			return err
+		}
+		removed, _ := result.RowsAffected()
+		r.delta.Expired += removed
+	}
+	slog.Debug("entries expired", "bucket", r.bucket, "cutoff", cutoff, "count", r.delta.Expired)
+	return nil
+}
+
+// retain removes from the history of the bucket the runs started before the
+// retention cutoff; the run just recorded is always kept.
+func (r *session) retain(db *sql.DB, keepRuns int, keepDays int) error {
+	// the run just recorded is now in the history, and counts among the kept
+	if keepRuns > 0 {
+		keepRuns++
+	}
+	cutoff, err := r.cutoff(db, keepRuns, keepDays)
+	if err != nil || cutoff == "" {
+		return err
+	}
+	if _, err := db.Exec("delete from runs where bucket = ? and started < ? and id != (select max(id) from runs where bucket = ?)", r.bucket, cutoff, r.bucket); err != nil {
+		slog.Error("error removing old runs", "bucket", r.bucket, "cutoff", cutoff, "error", err)
+		return err
+	}
+	return nil
+}
+
+// finish computes the delta of the run and records it.
+func (r *session) finish(db *sql.DB, summary *Summary) error {
+	groups, err := r.groups(db)
+	if err != nil {
+		return err
+	}
+	r.delta.New, r.delta.Changed, r.delta.Groups = summary.Inserted, summary.Updated, groups
+	_, err = db.Exec("insert into runs(bucket, started, finished, files, bytes, inserted, updated, unchanged, removed, groups, incomplete) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		r.bucket, r.started.Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339), summary.Files, summary.Bytes, summary.Inserted, summary.Updated, summary.Unchanged, r.delta.Removed, groups, summary.Incomplete)
+	if err != nil {
+		slog.Error("error recording run", "bucket", r.bucket, "error", err)
+		return err
+	}
+	summary.Delta = r.delta
+	return nil
+}
+
+// under returns whether the indexed path is one of the roots or is inside one
+// of them, whether either is relative or absolute.
+func under(path string, roots []string) bool {
+	for _, root := range roots {
+		if duplicates.Under(path, root) {
+			return true
+		}
+	}
+	return false
+}
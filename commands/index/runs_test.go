@@ -0,0 +1,239 @@
+package index
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/internal/testutil"
+)
+
+// day returns the time of the given day of June 2024.
+func day(n int) time.Time {
+	return time.Date(2024, 6, n, 0, 0, 0, 0, time.UTC)
+}
+
+// history records runs on bucket "b" started on days 1, 4 and 7, and one on
+// bucket "c" started on day 9.
+func history(t *testing.T, db *sql.DB) {
+	t.Helper()
+	for _, run := range []struct {
+		bucket string
+		day    int
+	}{{"b", 1}, {"b", 4}, {"b", 7}, {"c", 9}} {
+		started := day(run.day).Format(time.RFC3339)
+		if _, err := db.Exec("insert into runs(bucket, started, finished) values(?, ?, ?)", run.bucket, started, started); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tests := []struct {
+		name     string
+		keepRuns int
+		keepDays int
+		dryRun   bool
+		// roots are the roots of the run, relative to the current directory;
+		// $ stands for the current directory, and is the default.
+		roots []string
+		// expired are the entries of bucket "b" expected to be removed.
+		expired []string
+	}{
+		{name: "keep all"},
+		{name: "keep this run", keepRuns: 1, expired: []string{"gone-2", "gone-5", "gone-8"}},
+		{name: "keep 2 runs", keepRuns: 2, expired: []string{"gone-2", "gone-5"}},
+		{name: "keep 3 runs", keepRuns: 3, expired: []string{"gone-2"}},
+		{name: "keep more runs than recorded", keepRuns: 10},
+		{name: "keep 4 days", keepDays: 4, expired: []string{"gone-2", "gone-5"}},
+		{name: "keep 3 runs or 4 days", keepRuns: 3, keepDays: 4, expired: []string{"gone-2", "gone-5"}},
+		{name: "keep 1 run or 20 days", keepRuns: 1, keepDays: 20, expired: []string{"gone-2", "gone-5", "gone-8"}},
+		{name: "dry run", keepRuns: 1, dryRun: true},
+		{name: "relative root", keepRuns: 1, roots: []string{"."}, expired: []string{"gone-2", "gone-5", "gone-8"}},
+		{name: "root of a single entry", keepRuns: 1, roots: []string{"$/gone-5"}, expired: []string{"gone-5"}},
+		{name: "root sharing a prefix", keepRuns: 1, roots: []string{"gone"}},
+		{name: "other root", keepRuns: 1, roots: []string{"$/other"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			history(t, db)
+			dir := t.TempDir()
+			t.Chdir(dir)
+			// files still there are never expired, however old their entries
+			if err := os.WriteFile("here-2", nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// entries are stored relative or absolute, like the roots they
+			// were found under
+			for _, e := range []struct {
+				path   string
+				bucket string
+				day    int
+			}{{"gone-2", "b", 2}, {"$/gone-5", "b", 5}, {"gone-8", "b", 8}, {"here-2", "b", 2}, {"other-2", "c", 2}} {
+				path := e.path
+				if path[0] == '$' {
+					path = filepath.Join(dir, path[1:])
+				}
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size, indexed) values(?, ?, ?, 0, ?)", filepath.Base(path), path, e.bucket, day(e.day).Format(time.RFC3339)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			roots := []string{dir}
+			if test.roots != nil {
+				roots = nil
+				for _, root := range test.roots {
+					if root[0] == '$' {
+						root = dir + root[1:]
+					}
+					roots = append(roots, root)
+				}
+			}
+
+			r := &session{bucket: "b", started: day(10), delta: &Delta{}}
+			if err := r.expire(db, roots, test.keepRuns, test.keepDays, test.dryRun, base.NewOutput("never")); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query("select hash from entries order by hash")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var kept []string
+			for rows.Next() {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					t.Fatal(err)
+				}
+				kept = append(kept, name)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			var expected []string
+			for _, name := range []string{"gone-2", "gone-5", "gone-8", "here-2", "other-2"} {
+				if !slices.Contains(test.expired, name) {
+					expected = append(expected, name)
+				}
+			}
+			if !slices.Equal(kept, expected) {
+				t.Errorf("expected entries %v, got %v", expected, kept)
+			}
+			if r.delta.Expired != int64(len(test.expired)) {
+				t.Errorf("expected %d expired, got %d", len(test.expired), r.delta.Expired)
+			}
+		})
+	}
+}
+
+func TestRetain(t *testing.T) {
+	tests := []struct {
+		name     string
+		keepRuns int
+		keepDays int
+		// kept are the days the runs kept in bucket "b" started on.
+		kept []int
+	}{
+		{name: "keep all", kept: []int{1, 4, 7, 10}},
+		{name: "keep this run", keepRuns: 1, kept: []int{10}},
+		{name: "keep 2 runs", keepRuns: 2, kept: []int{7, 10}},
+		{name: "keep more runs than recorded", keepRuns: 10, kept: []int{1, 4, 7, 10}},
+		{name: "keep 4 days", keepDays: 4, kept: []int{7, 10}},
+		{name: "keep 1 day", keepDays: 1, kept: []int{10}},
+		{name: "keep 3 runs or 4 days", keepRuns: 3, keepDays: 4, kept: []int{7, 10}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			history(t, db)
+			r := &session{bucket: "b", started: day(10), delta: &Delta{}}
+			if err := r.finish(db, &Summary{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.retain(db, test.keepRuns, test.keepDays); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := db.Query("select bucket, started from runs order by started")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var kept []int
+			others := 0
+			for rows.Next() {
+				var (
+					bucket  string
+					started string
+				)
+				if err := rows.Scan(&bucket, &started); err != nil {
+					t.Fatal(err)
+				}
+				when, err := time.Parse(time.RFC3339, started)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if bucket != "b" {
+					others++
+					continue
+				}
+				kept = append(kept, when.Day())
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(kept, test.kept) {
+				t.Errorf("expected runs started on days %v, got %v", test.kept, kept)
+			}
+			if others != 1 {
+				t.Errorf("expected the run on the other bucket to be kept, got %d", others)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	db := testutil.Database(t)
+	insert := func(hash, path, bucket, indexed string) {
+		t.Helper()
+		if _, err := db.Exec("insert into entries(hash, path, bucket, size, indexed) values(?, ?, ?, 0, ?)", hash, path, bucket, indexed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := day(1).Format(time.RFC3339)
+	// a group already in the index when the run starts
+	insert("old", "/b/old-1", "b", before)
+	insert("old", "/b/old-2", "b", before)
+	r, err := startRun(db, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := r.started.Format(time.RFC3339)
+	// the run re-indexes the old group and adds a copy to a file in another
+	// bucket, while another command adds a group of its own
+	insert("old", "/b/old-3", "b", now)
+	insert("new", "/c/new-1", "c", before)
+	insert("new", "/b/new-2", "b", now)
+	insert("other", "/c/other-1", "c", now)
+	insert("other", "/c/other-2", "c", now)
+
+	summary := &Summary{}
+	if err := r.finish(db, summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Delta.Groups != 1 {
+		t.Errorf("expected 1 new group, got %d", summary.Delta.Groups)
+	}
+	var assigned int
+	if err := db.QueryRow("select count(*) from duplicate_groups").Scan(&assigned); err != nil {
+		t.Fatal(err)
+	}
+	if assigned != 3 {
+		t.Errorf("expected 3 groups with an identifier, got %d", assigned)
+	}
+}
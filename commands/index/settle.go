@@ -0,0 +1,34 @@
+package index
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// isSettled returns whether the file at the given path looks like it is not
+// being written anymore, i.e. it was last modified at least minAge ago and
+// neither its size nor its modification time change within the given interval;
+// zero durations disable the corresponding check.
+func isSettled(path string, minAge time.Duration, interval time.Duration) (bool, error) {
+	before, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if minAge > 0 && time.Since(before.ModTime()) < minAge {
+		slog.Debug("file modified too recently", "path", path, "modified", before.ModTime())
+		return false, nil
+	}
+	if interval > 0 {
+		time.Sleep(interval)
+		after, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+			slog.Debug("file changed while checking", "path", path, "size before", before.Size(), "size after", after.Size())
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package index
+
+import (
+	"io/fs"
+)
+
+// allocatedSizeOf returns nil, since the allocated size is not available on
+// this platform.
+func allocatedSizeOf(info fs.FileInfo) any {
+	return nil
+}
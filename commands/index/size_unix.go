@@ -0,0 +1,17 @@
+//go:build unix
+
+package index
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// allocatedSizeOf returns the number of bytes actually allocated on disk for
+// the given file, which is less than its apparent size for sparse files.
+func allocatedSizeOf(info fs.FileInfo) any {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Blocks) * 512
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package index
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
+)
+
+// Summary contains the statistics of an index run; its counters are updated
+// concurrently by the digest workers.
+type Summary struct {
+	// Files is the number of files successfully indexed.
+	Files int64 `json:"files"`
+	// Bytes is the total size of the files successfully indexed.
+	Bytes int64 `json:"bytes"`
+	// Inserted is the number of indexed files that were not in the index.
+	Inserted int64 `json:"inserted"`
+	// Updated is the number of indexed files whose content, size or bucket
+	// changed since they were last indexed.
+	Updated int64 `json:"updated"`
+	// Unchanged is the number of indexed files that were already in the index
+	// as they are now.
+	Unchanged int64 `json:"unchanged"`
+	// Failed is the number of files that could not be indexed.
+	Failed int64 `json:"failed"`
+	// Failures are the failures by category.
+	Failures failures.Tally `json:"failures"`
+	// Skipped is the number of files skipped because they were still being
+	// written, or because the same content is indexed at the same path in
+	// another bucket.
+	Skipped int64 `json:"skipped"`
+	// Retries is the number of times reading a file was retried.
+	Retries int64 `json:"retries"`
+	// Matches is the number of files matching a known hash set.
+	Matches int64 `json:"matches"`
+	// Likely is the number of new or changed files whose content is probably
+	// already in the index at another path, or was seen earlier in the run.
+	Likely int64 `json:"likely_duplicates"`
+	// Delta is what changed in the bucket since the previous run.
+	Delta *Delta `json:"delta,omitempty"`
+	// Incomplete is set when the run stopped because its budget was exhausted.
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// indexed records a successfully indexed file of the given size.
+func (s *Summary) indexed(size int64) {
+	atomic.AddInt64(&s.Files, 1)
+	atomic.AddInt64(&s.Bytes, size)
+}
+
+// changed records what storing an indexed file changed in the index.
+func (s *Summary) changed(c change) {
+	switch c {
+	case inserted:
+		atomic.AddInt64(&s.Inserted, 1)
+	case updated:
+		atomic.AddInt64(&s.Updated, 1)
+	case unchanged:
+		atomic.AddInt64(&s.Unchanged, 1)
+	}
+}
+
+// failed records a file that could not be indexed, and why.
+func (s *Summary) failed(err error) {
+	atomic.AddInt64(&s.Failed, 1)
+	s.Failures.Add(err)
+}
+
+// skipped records a file that was skipped.
+func (s *Summary) skipped() {
+	atomic.AddInt64(&s.Skipped, 1)
+}
+
+// retried records a retry.
+func (s *Summary) retried() {
+	atomic.AddInt64(&s.Retries, 1)
+}
+
+// matched records a file matching a known hash set.
+func (s *Summary) matched() {
+	atomic.AddInt64(&s.Matches, 1)
+}
+
+// likely records a file that is probably a duplicate.
+func (s *Summary) likely() {
+	atomic.AddInt64(&s.Likely, 1)
+}
+
+// Print writes the summary to the given output, either in human readable or
+// in automation friendly JSON format.
+func (s *Summary) Print(out *base.Output, automationFriendly bool) error {
+	if automationFriendly {
+		data, err := json.Marshal(s)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		out.Println(string(data))
+	} else {
+		out.Printf("indexed %d files (%d bytes), %d failed, %d skipped, %d retries\n", s.Files, s.Bytes, s.Failed, s.Skipped, s.Retries)
+		out.Printf("%d new, %d changed, %d unchanged\n", s.Inserted, s.Updated, s.Unchanged)
+		if s.Failed > 0 {
+			out.Printf("%s: %s\n", out.Paint(base.Red, "failures"), s.Failures.String())
+		}
+		if s.Delta != nil {
+			since := "first run on bucket"
+			if s.Delta.Previous != "" {
+				since = "since run of " + s.Delta.Previous
+			}
+			out.Printf("%s: %d new, %d changed, %d removed files, %d new duplicate groups\n", since, s.Delta.New, s.Delta.Changed, s.Delta.Removed, s.Delta.Groups)
+			if s.Delta.Expired > 0 {
+				out.Printf("%d files not seen by the retained runs expired\n", s.Delta.Expired)
+			}
+		}
+		if s.Likely > 0 {
+			out.Printf("%d files are likely duplicates: run the report command for details\n", s.Likely)
+		}
+		if s.Matches > 0 {
+			out.Printf("%d files match known hash sets: run the known command for details\n", s.Matches)
+		}
+		if s.Incomplete {
+			out.Printf("%s: use --resume to continue\n", out.Paint(base.Yellow, "run budget exhausted"))
+		}
+	}
+	return nil
+}
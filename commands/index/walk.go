@@ -0,0 +1,95 @@
+package index
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// readDirBatch is the number of directory entries read at a time.
+const readDirBatch = 1024
+
+// walk visits the tree rooted at root like filepath.WalkDir, but reads the
+// directories in batches instead of all at once and without sorting them, so
+// that huge directories do not need to be held in memory; the files of a
+// directory are visited as they are read, its subdirectories once it has been
+// read entirely and closed, so that only one directory is open at a time. As
+// with filepath.WalkDir, no lstat is performed beyond the root: the type of
+// each entry comes from the directory itself.
+func walk(root string, fn fs.WalkDirFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, filepath.SkipDir) || errors.Is(err, filepath.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walkDir visits the given directory entry and, if it is a directory, its
+// contents.
+func walkDir(path string, entry fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, entry, nil); err != nil || !entry.IsDir() {
+		if errors.Is(err, filepath.SkipDir) && entry.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	subdirs, err := readDir(path, entry, fn)
+	if err != nil {
+		return err
+	}
+	for _, subdir := range subdirs {
+		if err := walkDir(filepath.Join(path, subdir.Name()), subdir, fn); err != nil {
+			if errors.Is(err, filepath.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readDir reads the given directory in batches, visiting its files and
+// returning its subdirectories.
+func readDir(path string, entry fs.DirEntry, fn fs.WalkDirFunc) ([]fs.DirEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		// give the function a chance to skip the directory
+		if err := fn(path, entry, err); err != nil && !errors.Is(err, filepath.SkipDir) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	defer f.Close()
+	subdirs := []fs.DirEntry{}
+	for {
+		entries, err := f.ReadDir(readDirBatch)
+		for _, child := range entries {
+			if child.IsDir() {
+				subdirs = append(subdirs, child)
+				continue
+			}
+			if err := fn(filepath.Join(path, child.Name()), child, nil); err != nil {
+				if errors.Is(err, filepath.SkipDir) {
+					// skip the rest of the directory, as filepath.WalkDir does
+					return nil, nil
+				}
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			return subdirs, nil
+		} else if err != nil {
+			if err := fn(path, entry, err); err != nil && !errors.Is(err, filepath.SkipDir) {
+				return nil, err
+			}
+			return subdirs, nil
+		}
+	}
+}
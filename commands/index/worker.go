@@ -0,0 +1,163 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/redis/go-redis/v9"
+)
+
+// Worker is the command that digests the files queued by index runs started
+// with --queue on other hosts: it must run on hosts mounting the indexed
+// storage at the same paths as the host running the index command, which is
+// the only one storing the outcomes in the database.
+type Worker struct {
+	base.Command
+	// Queue is the URL of the Redis server the files are queued on.
+	Queue string `long:"queue" description:"The URL of the Redis server the files to digest are queued on, e.g. redis://host:6379/0." required:"true"`
+	// QueueName is the prefix of the Redis keys of the queue.
+	QueueName string `long:"queue-name" description:"The prefix of the Redis keys of the queue, the same as that of the index runs." optional:"true" default:"dedup"`
+	// Workers is the number of files digested at the same time.
+	Workers int `long:"workers" description:"The number of files digested at the same time (0 for the number of CPUs)." optional:"true" default:"0"`
+	// Idle is the time after which the worker exits if no file is queued.
+	Idle time.Duration `long:"idle" description:"Exit when no file has been queued for this long (0 to wait until interrupted)." optional:"true" default:"0s"`
+}
+
+// WorkerSummary contains the statistics of a worker.
+type WorkerSummary struct {
+	// Files is the number of files digested.
+	Files int64 `json:"files"`
+	// Bytes is the total size of the files digested.
+	Bytes int64 `json:"bytes"`
+	// Failed is the number of files that could not be digested.
+	Failed int64 `json:"failed"`
+}
+
+// Execute is the real implementation of the Worker command.
+func (cmd *Worker) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running worker command", "queue", cmd.QueueName, "workers", cmd.Workers)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := connect(ctx, cmd.Queue)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	workers := cmd.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%s:%d", host, os.Getpid())
+	jobs := jobsOf(cmd.QueueName)
+	summary := &WorkerSummary{}
+	var last atomic.Int64
+	last.Store(time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				values, err := client.BLPop(ctx, time.Second, jobs).Result()
+				if errors.Is(err, redis.Nil) {
+					if cmd.Idle > 0 && time.Since(time.Unix(0, last.Load())) > cmd.Idle {
+						slog.Debug("no file queued, exiting", "idle", cmd.Idle)
+						stop()
+					}
+					continue
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					slog.Error("error receiving from queue", "list", jobs, "error", err)
+					time.Sleep(time.Second)
+					continue
+				}
+				last.Store(time.Now().UnixNano())
+				r := &request{}
+				if err := json.Unmarshal([]byte(values[1]), r); err != nil {
+					slog.Error("invalid request from queue", "list", jobs, "error", err)
+					continue
+				}
+				// a file being digested is not interrupted by a stop request
+				cmd.serve(context.WithoutCancel(ctx), client, name, r, summary)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("digested %d files (%d bytes), %d failed\n", summary.Files, summary.Bytes, summary.Failed)
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// serve digests the file of the given request and pushes the outcome onto the
+// reply list of the run that queued it.
+func (cmd *Worker) serve(ctx context.Context, client *redis.Client, name string, r *request, summary *WorkerSummary) {
+	var (
+		d       *digest
+		err     error
+		retries = &Summary{}
+	)
+	for _, algorithm := range r.Algorithms {
+		if _, err = hasherOf(algorithm); err != nil {
+			break
+		}
+	}
+	if len(r.Algorithms) == 0 {
+		err = errors.New("no hash algorithm requested")
+	}
+	if err == nil {
+		dg := &digester{algorithms: r.Algorithms, entropy: r.Entropy, magic: r.Magic, retries: r.Retries, delay: r.Delay}
+		d, err = dg.digestWithRetries(r.Path, retries)
+	}
+	if err != nil {
+		slog.Error("error digesting file", "path", r.Path, "error", err)
+		atomic.AddInt64(&summary.Failed, 1)
+	} else {
+		slog.Debug("file digested", "path", r.Path, "hash", d.hashes[r.Algorithms[0]])
+		atomic.AddInt64(&summary.Files, 1)
+		atomic.AddInt64(&summary.Bytes, d.size)
+	}
+	data, err := json.Marshal(respond(r.ID, name, d, retries.Retries, err))
+	if err != nil {
+		slog.Error("error marshalling response to JSON", "path", r.Path, "error", err)
+		return
+	}
+	// the reply list expires if the run that queued the file is gone
+	pipe := client.TxPipeline()
+	pipe.RPush(ctx, r.Reply, data)
+	pipe.Expire(ctx, r.Reply, time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("error replying to queue", "list", r.Reply, "path", r.Path, "error", err)
+	}
+}
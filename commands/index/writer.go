@@ -0,0 +1,373 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/dihedron/dedup/bloom"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+)
+
+const (
+	// lookup is the statement retrieving what is already known about a path
+	// in the bucket being indexed (or in no bucket at all).
+	lookup = "select hash, size, bucket from entries where path = ? and (bucket is null or bucket = ?)"
+	// remove is the statement dropping the entries of a path in the bucket
+	// being indexed whose content has changed since it was last indexed.
+	remove = "delete from entries where path = ? and hash <> ? and (bucket is null or bucket = ?)"
+	// siblings is the statement retrieving the other entries with the same
+	// content as a file being indexed.
+	siblings = "select path, bucket from entries where hash = ? and path <> ? order by path"
+	// upsert is the statement storing an entry, or refreshing it if the same
+	// content was already indexed at the same path in the same bucket; entries
+	// of other buckets are never taken over, and are left untouched.
+	upsert = `insert into entries(hash, path, bucket, size, allocated, fork_of, uid, gid, mode, acl, md5, sha1, sha256, sha512, blake3, quickxor, known, entropy, magic, modified, accessed, changed, indexed) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	on conflict(hash, path) do update set bucket = excluded.bucket, size = excluded.size, allocated = excluded.allocated, fork_of = excluded.fork_of, uid = excluded.uid, gid = excluded.gid, mode = excluded.mode, acl = excluded.acl,
+	md5 = excluded.md5, sha1 = excluded.sha1, sha256 = excluded.sha256, sha512 = excluded.sha512, blake3 = excluded.blake3, quickxor = excluded.quickxor, known = excluded.known, entropy = excluded.entropy, magic = excluded.magic, modified = excluded.modified, accessed = excluded.accessed, changed = excluded.changed, indexed = excluded.indexed
+	where entries.bucket is null or entries.bucket = excluded.bucket`
+)
+
+// change tells what storing an entry did to the index.
+type change int
+
+const (
+	// inserted means that the path was not in the index.
+	inserted change = iota
+	// updated means that the path was in the index with a different content,
+	// size or bucket.
+	updated
+	// unchanged means that the path was already in the index as it is now.
+	unchanged
+)
+
+// writer stores the digested files in the database in the background, so that
+// digesting never waits for the disk to sync: entries are inserted in a
+// transaction that is committed at regular intervals, and the write-ahead log
+// is checkpointed periodically so that it does not grow without bounds on long
+// runs. Entries are only accounted for (and emitted) once committed.
+type writer struct {
+	db         *sql.DB
+	bucket     string
+	interval   time.Duration
+	checkpoint time.Duration
+	summary    *Summary
+	emitter    *emitter
+	stats      *metrics
+	live       *reporter
+	hook       *hook
+	// filter holds the hashes in the index, to tell likely duplicates and
+	// skip looking up the copies of unique contents; it may be nil.
+	filter *bloom.Filter
+
+	tx           *sql.Tx
+	stmts        []*sql.Stmt
+	batch        []*stored
+	committed    time.Time
+	checkpointed time.Time
+}
+
+// stored is an entry stored in the current transaction, along with what
+// storing it changed.
+type stored struct {
+	*pending
+	change change
+}
+
+// run stores the entries received until the channel is closed, then commits
+// the last batch.
+func (w *writer) run(entries <-chan *pending) {
+	w.committed, w.checkpointed = time.Now(), time.Now()
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case p, ok := <-entries:
+			if !ok {
+				w.commit()
+				w.wal()
+				return
+			}
+			w.store(p)
+			if w.interval > 0 {
+				continue
+			}
+		case <-tick:
+		}
+		w.commit()
+		if w.checkpoint > 0 && time.Since(w.checkpointed) >= w.checkpoint {
+			w.wal()
+		}
+	}
+}
+
+// store inserts an entry in the current transaction, opening one if needed.
+func (w *writer) store(p *pending) {
+	if w.tx == nil {
+		tx, err := w.db.Begin()
+		if err != nil {
+			slog.Error("error opening database transaction", "error", err)
+			w.summary.failed(&failures.StoreError{Path: p.job.path, Err: err})
+			return
+		}
+		stmts := []*sql.Stmt{}
+		queries := []string{lookup, remove, siblings, upsert}
+		if w.hook != nil {
+			queries = append(queries, w.hook.query)
+		}
+		for _, query := range queries {
+			stmt, err := tx.Prepare(query)
+			if err != nil {
+				slog.Error("error preparing database statement", "query", query, "error", err)
+				for _, stmt := range stmts {
+					stmt.Close()
+				}
+				tx.Rollback()
+				w.summary.failed(&failures.StoreError{Path: p.job.path, Err: err})
+				return
+			}
+			stmts = append(stmts, stmt)
+		}
+		w.tx, w.stmts = tx, stmts
+	}
+	j, d := p.job, p.digest
+	c, stale, err := w.compare(p)
+	if err != nil {
+		slog.Error("error looking up path in database", "path", j.path, "error", err)
+		w.summary.failed(&failures.StoreError{Path: j.path, Err: err})
+		return
+	}
+	// the stale entries are removed, the entry is stored and the hook is run
+	// under a savepoint, so that either all of them take effect or none does
+	if _, err := w.tx.Exec("savepoint entry"); err != nil {
+		slog.Error("error opening database savepoint", "path", j.path, "error", err)
+		w.summary.failed(&failures.StoreError{Path: j.path, Err: err})
+		return
+	}
+	written, err := w.write(p, c, stale)
+	if err != nil || !written {
+		if err := w.rollback(); err != nil {
+			slog.Error("error rolling back to database savepoint", "path", j.path, "error", err)
+		}
+	} else if _, err = w.tx.Exec("release entry"); err != nil {
+		slog.Error("error releasing database savepoint", "path", j.path, "error", err)
+	}
+	if err != nil {
+		w.summary.failed(&failures.StoreError{Path: j.path, Err: err})
+		return
+	}
+	if !written {
+		// the same content is indexed at the same path in another bucket, and
+		// the entries of the path in this bucket are left as they were
+		slog.Warn("path already indexed in another bucket, skipping", "path", j.path, "bucket", w.bucket)
+		w.summary.skipped()
+		return
+	}
+	// the filter holds the hashes of the previous runs too, so only contents
+	// new to the path are checked against it, lest a file match its own entry
+	fresh, likely := c == inserted || stale, true
+	if w.filter != nil {
+		likely = w.filter.Test(d.hash)
+	}
+	if w.live != nil && c != unchanged && likely {
+		if copies, err := others(w.stmts[2], d.hash, j.path); err != nil {
+			slog.Error("error looking up duplicates in database", "path", j.path, "error", err)
+		} else if len(copies) > 0 {
+			w.live.report(&finding{Hash: d.hash, Path: j.path, Size: d.size, Copies: copies})
+		}
+	}
+	if w.filter != nil {
+		if fresh && likely {
+			slog.Info("likely duplicate", "path", j.path, "hash", d.hash)
+			w.summary.likely()
+		}
+		w.filter.Add(d.hash)
+	}
+	w.batch = append(w.batch, &stored{pending: p, change: c})
+}
+
+// write removes the stale entries of the path, stores the entry and runs the
+// hook on it; it returns false if the entry was not stored because the same
+// content is indexed at the same path in another bucket.
+func (w *writer) write(p *pending, c change, stale bool) (bool, error) {
+	j, d := p.job, p.digest
+	if stale {
+		if _, err := w.stmts[1].Exec(j.path, d.hash, w.bucket); err != nil {
+			slog.Error("error removing stale entries from database", "path", j.path, "error", err)
+			return false, err
+		}
+	}
+	result, err := w.stmts[3].Exec(d.hash, j.path, w.bucket, d.size, d.allocated, j.forkOf, j.uid, j.gid, j.mode, j.acl, d.column("md5"), d.column("sha1"), d.column("sha256"), d.column("sha512"), d.column("blake3"), d.column("quickxor"), p.known, d.entropy, d.magic, d.modified, d.accessed, d.changed, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		// a failed statement does not abort the transaction
+		slog.Error("error executing database upsert statement", "path", j.path, "error", err)
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return false, nil
+	}
+	if w.hook != nil {
+		if _, err := w.stmts[4].Exec(w.hook.args(p, w.bucket, c)...); err != nil {
+			slog.Error("error executing entry hook", "path", j.path, "error", err)
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// rollback undoes what was written since the savepoint of the current entry,
+// and releases it.
+func (w *writer) rollback() error {
+	if _, err := w.tx.Exec("rollback to entry"); err != nil {
+		return err
+	}
+	_, err := w.tx.Exec("release entry")
+	return err
+}
+
+// compare tells how the entry differs from what is already in the index for
+// the same path, and whether there are entries for the path with a content
+// other than the current one, which must be removed.
+func (w *writer) compare(p *pending) (change, bool, error) {
+	rows, err := w.stmts[0].Query(p.job.path, w.bucket)
+	if err != nil {
+		return inserted, false, err
+	}
+	defer rows.Close()
+	c, stale := inserted, false
+	for rows.Next() {
+		var (
+			hash   string
+			size   sql.NullInt64
+			bucket sql.NullString
+		)
+		if err := rows.Scan(&hash, &size, &bucket); err != nil {
+			return inserted, false, err
+		}
+		if hash != p.digest.hash {
+			stale = true
+			continue
+		}
+		if size.Int64 == p.digest.size && bucket.String == w.bucket {
+			c = unchanged
+		} else {
+			c = updated
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return inserted, false, err
+	}
+	if stale {
+		c = updated
+	}
+	return c, stale, nil
+}
+
+// commit commits the current transaction, if any.
+func (w *writer) commit() {
+	if w.tx == nil {
+		return
+	}
+	for _, stmt := range w.stmts {
+		stmt.Close()
+	}
+	err := w.tx.Commit()
+	w.tx, w.stmts = nil, nil
+	batch := w.batch
+	w.batch = nil
+	w.committed = time.Now()
+	if err != nil {
+		slog.Error("error committing database insert transaction", "entries", len(batch), "error", err)
+		for _, p := range batch {
+			w.summary.failed(&failures.StoreError{Path: p.job.path, Err: err})
+		}
+		return
+	}
+	slog.Debug("entries committed", "entries", len(batch))
+	for _, p := range batch {
+		j, d := p.job, p.digest
+		atomic.AddInt64(&w.stats.stored, 1)
+		w.summary.indexed(d.size)
+		w.summary.changed(p.change)
+		w.emitter.emit(&record{
+			Hash:      d.hash,
+			Path:      j.path,
+			Bucket:    w.bucket,
+			Size:      d.size,
+			Allocated: d.allocated,
+			ForkOf:    j.forkOf,
+			UID:       j.uid,
+			GID:       j.gid,
+			Mode:      j.mode,
+			ACL:       j.acl,
+			Hashes:    d.hashes,
+			Known:     p.known,
+			Entropy:   d.entropy,
+			Magic:     d.magic,
+			Modified:  d.modified,
+			Accessed:  d.accessed,
+			Changed:   d.changed,
+		})
+	}
+}
+
+// wal checkpoints the write-ahead log into the database and truncates it.
+func (w *writer) wal() {
+	w.checkpointed = time.Now()
+	if _, err := w.db.Exec("pragma wal_checkpoint(TRUNCATE)"); err != nil {
+		slog.Warn("error checkpointing write-ahead log", "error", err)
+		return
+	}
+	slog.Debug("write-ahead log checkpointed")
+}
+
+// Store digests the file at the given path with the given algorithm and stores
+// it into the bucket as an index run would, replacing the entry of the path in
+// the bucket if its content changed; it returns the hash of the file. Paths
+// are stored as given, like the paths found walking the indexed roots.
+func Store(db *sql.DB, bucket string, algorithm string, path string) (string, error) {
+	algorithms, err := parseAlgorithms(algorithm)
+	if err != nil {
+		return "", err
+	}
+	d, err := (&digester{algorithms: algorithms}).digest(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec(remove, path, d.hash, bucket); err != nil {
+		slog.Error("error removing stale entries from database", "path", path, "error", err)
+		return "", err
+	}
+	result, err := db.Exec(upsert, d.hash, path, bucket, d.size, d.allocated, nil, nil, nil, nil, nil, d.column("md5"), d.column("sha1"), d.column("sha256"), d.column("sha512"), d.column("blake3"), d.column("quickxor"), nil, d.entropy, d.magic, d.modified, d.accessed, d.changed, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		slog.Error("error executing database upsert statement", "path", path, "error", err)
+		return "", err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		err := fmt.Errorf("%s is already indexed in another bucket", path)
+		slog.Error("error storing entry", "path", path, "bucket", bucket, "error", err)
+		return "", err
+	}
+	return d.hash, nil
+}
+
+// Discard deletes the file of the given entry and removes the entry from the
+// index.
+func Discard(db *sql.DB, entry *duplicates.Entry) error {
+	if err := os.Remove(entry.Path); err != nil {
+		return err
+	}
+	if _, err := db.Exec("delete from entries where path = ? and hash = ?", entry.Path, entry.Hash); err != nil {
+		slog.Error("error removing deleted entry", "path", entry.Path, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package index
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/dihedron/dedup/bloom"
+	"github.com/dihedron/dedup/internal/testutil"
+)
+
+func TestWriterChanges(t *testing.T) {
+	// entry is a row of the entries table, or a file to store.
+	type entry struct {
+		hash   string
+		path   string
+		bucket any
+		size   int64
+	}
+	tests := []struct {
+		name string
+		// before are the entries in the index before the file is stored.
+		before []entry
+		// file is stored in bucket "b".
+		file entry
+		// inserted, updated, unchanged and skipped are the expected counts.
+		inserted, updated, unchanged, skipped int64
+		// after are the expected entries of the path once stored.
+		after []entry
+	}{
+		{
+			name:     "new path",
+			file:     entry{"h1", "/a", "b", 10},
+			inserted: 1,
+			after:    []entry{{"h1", "/a", "b", 10}},
+		},
+		{
+			name:      "same content",
+			before:    []entry{{"h1", "/a", "b", 10}},
+			file:      entry{"h1", "/a", "b", 10},
+			unchanged: 1,
+			after:     []entry{{"h1", "/a", "b", 10}},
+		},
+		{
+			name:    "different size",
+			before:  []entry{{"h1", "/a", "b", 10}},
+			file:    entry{"h1", "/a", "b", 11},
+			updated: 1,
+			after:   []entry{{"h1", "/a", "b", 11}},
+		},
+		{
+			name:    "different content",
+			before:  []entry{{"h1", "/a", "b", 10}},
+			file:    entry{"h2", "/a", "b", 10},
+			updated: 1,
+			after:   []entry{{"h2", "/a", "b", 10}},
+		},
+		{
+			name:    "no bucket",
+			before:  []entry{{"h1", "/a", nil, 10}},
+			file:    entry{"h1", "/a", "b", 10},
+			updated: 1,
+			after:   []entry{{"h1", "/a", "b", 10}},
+		},
+		{
+			name:     "other path",
+			before:   []entry{{"h1", "/b", "b", 10}},
+			file:     entry{"h1", "/a", "b", 10},
+			inserted: 1,
+			after:    []entry{{"h1", "/a", "b", 10}},
+		},
+		{
+			name:    "same content in other bucket",
+			before:  []entry{{"h1", "/a", "c", 10}},
+			file:    entry{"h1", "/a", "b", 10},
+			skipped: 1,
+			after:   []entry{{"h1", "/a", "c", 10}},
+		},
+		{
+			name:     "different content in other bucket",
+			before:   []entry{{"h1", "/a", "c", 10}},
+			file:     entry{"h2", "/a", "b", 10},
+			inserted: 1,
+			after:    []entry{{"h1", "/a", "c", 10}, {"h2", "/a", "b", 10}},
+		},
+		{
+			name:    "changed to content in other bucket",
+			before:  []entry{{"h1", "/a", "b", 10}, {"h2", "/a", "c", 10}},
+			file:    entry{"h2", "/a", "b", 10},
+			skipped: 1,
+			after:   []entry{{"h1", "/a", "b", 10}, {"h2", "/a", "c", 10}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			for _, e := range test.before {
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size) values(?, ?, ?, ?)", e.hash, e.path, e.bucket, e.size); err != nil {
+					t.Fatal(err)
+				}
+			}
+			summary := &Summary{}
+			w := &writer{db: db, bucket: "b", summary: summary, stats: &metrics{}}
+			entries := make(chan *pending, 1)
+			entries <- &pending{job: &job{path: test.file.path}, digest: &digest{hash: test.file.hash, size: test.file.size}}
+			close(entries)
+			w.run(entries)
+
+			if summary.Failed != 0 {
+				t.Fatalf("unexpected failures: %s", summary.Failures.String())
+			}
+			if summary.Inserted != test.inserted || summary.Updated != test.updated || summary.Unchanged != test.unchanged || summary.Skipped != test.skipped {
+				t.Errorf("expected %d new, %d changed, %d unchanged, %d skipped, got %d, %d, %d, %d",
+					test.inserted, test.updated, test.unchanged, test.skipped, summary.Inserted, summary.Updated, summary.Unchanged, summary.Skipped)
+			}
+			if files := summary.Inserted + summary.Updated + summary.Unchanged; summary.Files != files {
+				t.Errorf("expected %d files indexed, got %d", files, summary.Files)
+			}
+
+			rows, err := db.Query("select hash, path, bucket, size from entries where path = ? order by hash", test.file.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var after []entry
+			for rows.Next() {
+				var (
+					e      entry
+					bucket sql.NullString
+				)
+				if err := rows.Scan(&e.hash, &e.path, &bucket, &e.size); err != nil {
+					t.Fatal(err)
+				}
+				if bucket.Valid {
+					e.bucket = bucket.String
+				}
+				after = append(after, e)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != len(test.after) {
+				t.Fatalf("expected entries %v, got %v", test.after, after)
+			}
+			for i := range after {
+				if after[i] != test.after[i] {
+					t.Errorf("expected entry %v, got %v", test.after[i], after[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriterLikely(t *testing.T) {
+	tests := []struct {
+		name string
+		// hash and size are those of file /a, stored in bucket "b" where
+		// /a is indexed with hash h1 and size 10, and /c with hash h2.
+		hash   string
+		size   int64
+		likely int64
+	}{
+		{name: "unchanged", hash: "h1", size: 10},
+		{name: "size changed", hash: "h1", size: 11},
+		{name: "changed to unique content", hash: "h3", size: 10},
+		{name: "changed to indexed content", hash: "h2", size: 10, likely: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			for _, e := range [][]any{{"h1", "/a"}, {"h2", "/c"}} {
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size) values(?, ?, 'b', 10)", e...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			filter, err := bloom.Load(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary := &Summary{}
+			w := &writer{db: db, bucket: "b", summary: summary, stats: &metrics{}, filter: filter}
+			entries := make(chan *pending, 2)
+			entries <- &pending{job: &job{path: "/a"}, digest: &digest{hash: test.hash, size: test.size}}
+			// a new path with the same contents is seen earlier in the run
+			entries <- &pending{job: &job{path: "/d"}, digest: &digest{hash: test.hash, size: test.size}}
+			close(entries)
+			w.run(entries)
+
+			if summary.Likely != test.likely+1 {
+				t.Errorf("expected %d likely duplicates, got %d", test.likely+1, summary.Likely)
+			}
+		})
+	}
+}
+
+func TestWriterHook(t *testing.T) {
+	db := testutil.Database(t)
+	if _, err := db.Exec("create table derived(path text check(path <> '/bad'))"); err != nil {
+		t.Fatal(err)
+	}
+	// the path that the hook fails on was indexed with another content
+	if _, err := db.Exec("insert into entries(hash, path, bucket, size) values('h0', '/bad', 'b', 10)"); err != nil {
+		t.Fatal(err)
+	}
+	h, err := newHook(db, "insert into derived(path) values(:path)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := &Summary{}
+	w := &writer{db: db, bucket: "b", summary: summary, stats: &metrics{}, hook: h}
+	entries := make(chan *pending, 2)
+	entries <- &pending{job: &job{path: "/bad"}, digest: &digest{hash: "h1", size: 10}}
+	entries <- &pending{job: &job{path: "/good"}, digest: &digest{hash: "h1", size: 10}}
+	close(entries)
+	w.run(entries)
+
+	if summary.Failed != 1 || summary.Inserted != 1 || summary.Updated != 0 {
+		t.Errorf("expected 1 failed and 1 new, got %d, %d and %d changed", summary.Failed, summary.Inserted, summary.Updated)
+	}
+	// the entry is dropped along with the hook, and the stale one restored
+	for path, hash := range map[string]string{"/bad": "h0", "/good": "h1"} {
+		var stored string
+		if err := db.QueryRow("select hash from entries where path = ?", path).Scan(&stored); err != nil || stored != hash {
+			t.Errorf("expected %s stored with hash %s, got %q, %v", path, hash, stored, err)
+		}
+	}
+	var derived int
+	if err := db.QueryRow("select count(*) from derived").Scan(&derived); err != nil {
+		t.Fatal(err)
+	}
+	if derived != 1 {
+		t.Errorf("expected 1 derived row, got %d", derived)
+	}
+}
@@ -0,0 +1,144 @@
+package iso
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/failures"
+)
+
+// ISO is the command that indexes the files inside ISO9660 images (e.g.
+// archived installer or backup discs), reading them in-process and without
+// mounting them, so that content that also exists loose on disk can be found.
+// Files are indexed as <image>#<path>; since they are not files on disk, use
+// report --offline to list duplicates.
+type ISO struct {
+	base.Command
+	base.Database
+	// Paths are the images to scan.
+	Paths []string `short:"p" long:"path" description:"The ISO9660 image(s) to index." required:"true"`
+	// Bucket is the bucket files are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index files into." optional:"true" default:"iso"`
+	// Hash is the hash algorithm to compute.
+	Hash string `long:"hash" description:"The hash algorithm to compute, which should match the one of the buckets to compare with." optional:"true" default:"sha256"`
+}
+
+// Summary contains the outcome of an image indexing run.
+type Summary struct {
+	Images int   `json:"images"`
+	Files  int   `json:"files"`
+	Bytes  int64 `json:"bytes"`
+	Failed int   `json:"failed"`
+}
+
+// Execute is the real implementation of the ISO command.
+func (cmd *ISO) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running iso command", "paths", cmd.Paths, "database", cmd.Database, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	if _, err := index.NewHasher(cmd.Hash); err != nil {
+		slog.Error("invalid hash algorithm", "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	roots := make([]string, 0, len(cmd.Paths))
+	for _, p := range cmd.Paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		roots = append(roots, p)
+	}
+	if err := buckets.Register(db, cmd.Bucket, cmd.Hash, roots, map[string]any{"iso": true}); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("error opening database transaction", "error", err)
+		return err
+	}
+	stmt, err := tx.Prepare("insert or replace into entries(hash, path, bucket, size, modified, indexed) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	summary := &Summary{}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, root := range roots {
+		f, err := os.Open(root)
+		if err != nil {
+			slog.Error("error opening image", "path", root, "error", err)
+			summary.Failed++
+			continue
+		}
+		v, err := openVolume(f)
+		if err != nil {
+			f.Close()
+			slog.Error("error reading image", "path", root, "error", err)
+			summary.Failed++
+			continue
+		}
+		summary.Images++
+		err = v.walk(func(file *file) error {
+			p := root + "#" + file.Path
+			h, err := index.NewHasher(cmd.Hash)
+			if err != nil {
+				return err
+			}
+			size, err := io.Copy(h, file.Reader(f))
+			if err != nil || size != file.Size {
+				slog.Error("error reading file in image", "path", p, "error", &failures.HashError{Path: p, Err: err})
+				summary.Failed++
+				return nil
+			}
+			if _, err := stmt.Exec(hex.EncodeToString(h.Sum(nil)), p, cmd.Bucket, size, file.Modified.UTC().Format(time.RFC3339), now); err != nil {
+				slog.Error("error executing database insert statement", "path", p, "error", err)
+				return err
+			}
+			summary.Files++
+			summary.Bytes += size
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			slog.Error("error walking image", "path", root, "error", &failures.WalkError{Path: root, Err: err})
+			summary.Failed++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error committing database insert transaction", "error", err)
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d files (%d bytes) in %d images, %d failed\n", summary.Files, summary.Bytes, summary.Images, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
@@ -0,0 +1,185 @@
+package iso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// sector is the size of the logical sectors of ISO9660 images.
+const sector = 2048
+
+// ErrNotISO9660 is returned when an image has no ISO9660 volume descriptor.
+var ErrNotISO9660 = errors.New("not an ISO9660 image")
+
+// extent is a contiguous area of the image holding (part of) a file.
+type extent struct {
+	offset int64
+	size   int64
+}
+
+// file is a regular file in an ISO9660 image.
+type file struct {
+	// Path is the absolute path of the file in the image.
+	Path string
+	// Extents are the areas of the image holding the file contents, in order;
+	// files larger than 4 GiB are split across several extents.
+	Extents []extent
+	// Size is the total size of the file.
+	Size int64
+	// Modified is the recording time of the file.
+	Modified time.Time
+}
+
+// Reader returns a reader over the contents of the file.
+func (f *file) Reader(image io.ReaderAt) io.Reader {
+	readers := make([]io.Reader, 0, len(f.Extents))
+	for _, e := range f.Extents {
+		readers = append(readers, io.NewSectionReader(image, e.offset, e.size))
+	}
+	return io.MultiReader(readers...)
+}
+
+// volume is the directory tree of an ISO9660 image, as described by the
+// Joliet supplementary descriptor if there is one (for its long Unicode
+// names), by the primary descriptor otherwise.
+type volume struct {
+	image  io.ReaderAt
+	root   []byte
+	joliet bool
+}
+
+// openVolume reads the volume descriptors of the image.
+func openVolume(image io.ReaderAt) (*volume, error) {
+	v := &volume{image: image}
+	descriptor := make([]byte, sector)
+	for i := int64(16); ; i++ {
+		if _, err := image.ReadAt(descriptor, i*sector); err != nil {
+			if errors.Is(err, io.EOF) && v.root != nil {
+				return v, nil
+			}
+			return nil, ErrNotISO9660
+		}
+		if string(descriptor[1:6]) != "CD001" {
+			if v.root == nil {
+				return nil, ErrNotISO9660
+			}
+			return v, nil
+		}
+		switch descriptor[0] {
+		case 1:
+			if v.root == nil {
+				v.root = append([]byte(nil), descriptor[156:190]...)
+			}
+		case 2:
+			escape := descriptor[88:91]
+			if bytes.Equal(escape, []byte("%/@")) || bytes.Equal(escape, []byte("%/C")) || bytes.Equal(escape, []byte("%/E")) {
+				v.root = append([]byte(nil), descriptor[156:190]...)
+				v.joliet = true
+			}
+		case 255:
+			if v.root == nil {
+				return nil, ErrNotISO9660
+			}
+			return v, nil
+		}
+	}
+}
+
+// walk calls the given function on each regular file in the volume.
+func (v *volume) walk(visit func(*file) error) error {
+	return v.directory("/", v.root, map[uint32]bool{}, visit)
+}
+
+// directory walks the directory described by the given record.
+func (v *volume) directory(dir string, record []byte, visited map[uint32]bool, visit func(*file) error) error {
+	lba := binary.LittleEndian.Uint32(record[2:6])
+	size := int64(binary.LittleEndian.Uint32(record[10:14]))
+	// loops in corrupted or crafted images would never end
+	if visited[lba] {
+		return fmt.Errorf("directory %s loops back", dir)
+	}
+	visited[lba] = true
+	data := make([]byte, size)
+	if _, err := v.image.ReadAt(data, int64(lba)*sector); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading directory %s: %w", dir, err)
+	}
+	var pending *file
+	for offset := 0; offset < len(data); {
+		length := int(data[offset])
+		if length == 0 {
+			// records do not cross sector boundaries: the rest is padding
+			offset = (offset/sector + 1) * sector
+			continue
+		}
+		if length < 34 || offset+length > len(data) {
+			return fmt.Errorf("invalid record in directory %s", dir)
+		}
+		entry := data[offset : offset+length]
+		offset += length
+		nameLength := int(entry[32])
+		if 33+nameLength > len(entry) {
+			return fmt.Errorf("invalid record in directory %s", dir)
+		}
+		raw := entry[33 : 33+nameLength]
+		if nameLength == 1 && (raw[0] == 0 || raw[0] == 1) {
+			continue
+		}
+		name := v.name(raw)
+		flags := entry[25]
+		if flags&0x02 != 0 {
+			if err := v.directory(path.Join(dir, name), entry, visited, visit); err != nil {
+				return err
+			}
+			continue
+		}
+		e := extent{offset: int64(binary.LittleEndian.Uint32(entry[2:6])) * sector, size: int64(binary.LittleEndian.Uint32(entry[10:14]))}
+		if pending == nil {
+			pending = &file{Path: path.Join(dir, name), Modified: recorded(entry[18:25])}
+		}
+		pending.Extents = append(pending.Extents, e)
+		pending.Size += e.size
+		// the multi-extent flag is set on all the records of a file but the last
+		if flags&0x80 != 0 {
+			continue
+		}
+		if err := visit(pending); err != nil {
+			return err
+		}
+		pending = nil
+	}
+	return nil
+}
+
+// name decodes a file identifier, dropping the version number.
+func (v *volume) name(raw []byte) string {
+	var name string
+	if v.joliet {
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(raw[2*i:])
+		}
+		name = string(utf16.Decode(units))
+	} else {
+		name = string(raw)
+	}
+	if i := strings.LastIndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	if !v.joliet {
+		name = strings.TrimSuffix(name, ".")
+	}
+	return name
+}
+
+// recorded decodes the recording date and time of a directory record.
+func recorded(b []byte) time.Time {
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
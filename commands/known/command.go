@@ -0,0 +1,85 @@
+package known
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Known is the command that reports the entries whose hash matched one of the
+// known hash sets (e.g. NSRL known-good files, custom blocklists) at indexing
+// time.
+type Known struct {
+	base.Command
+	base.Database
+	// Set restricts the report to the entries matching the given hash set.
+	Set string `short:"s" long:"set" description:"The label of the hash set to report matches for (all sets if not specified)." optional:"true"`
+	// Bucket restricts the report to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to report matches for (all buckets if not specified)." optional:"true"`
+}
+
+// Match is an entry matching one or more known hash sets.
+type Match struct {
+	Path   string `json:"path"`
+	Bucket string `json:"bucket,omitempty"`
+	Hash   string `json:"hash"`
+	Size   int64  `json:"size"`
+	Sets   string `json:"sets"`
+}
+
+// Execute is the real implementation of the Known command.
+func (cmd *Known) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running known command", "database", cmd.Database, "set", cmd.Set, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query := "select path, coalesce(bucket, ''), hash, coalesce(size, 0), known from entries where known is not null"
+	params := []any{}
+	if cmd.Set != "" {
+		query += " and (',' || known || ',') like ?"
+		params = append(params, "%,"+cmd.Set+",%")
+	}
+	if cmd.Bucket != "" {
+		query += " and bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	query += " order by known, path"
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		slog.Error("error querying known entries", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		match := &Match{}
+		if err := rows.Scan(&match.Path, &match.Bucket, &match.Hash, &match.Size, &match.Sets); err != nil {
+			slog.Error("error reading known entry", "error", err)
+			return err
+		}
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(match)
+			if err != nil {
+				slog.Error("error marshalling match to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else {
+			cmd.Output().Printf("%s\t%s\t%d\t%s\n", match.Sets, match.Hash, match.Size, match.Path)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading known entries", "error", err)
+		return err
+	}
+	slog.Debug("command done")
+	return nil
+}
@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/messages"
+)
+
+// Algorithm is the hash algorithm recorded for mail buckets: hashes are of the
+// canonical form of messages, and cannot be compared with those of files.
+const Algorithm = "mail-sha256"
+
+// Mail is the command that indexes the individual messages in mbox files and
+// Maildir folders, hashing their canonical form, so that the same message
+// stored in different archives is reported as a duplicate. Messages in mbox
+// files are indexed as <path>#<n>, where n is their position in the file;
+// since they are not files on disk, use report --offline to list duplicates.
+type Mail struct {
+	base.Command
+	base.Database
+	// Paths are the mbox files and directories to scan.
+	Paths []string `short:"p" long:"path" description:"The mbox file(s) or directory(es) containing mbox files and Maildir folders to index." required:"true"`
+	// Bucket is the bucket messages are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index messages into." optional:"true" default:"mail"`
+}
+
+// Summary contains the outcome of a mail indexing run.
+type Summary struct {
+	Mailboxes int   `json:"mailboxes"`
+	Messages  int   `json:"messages"`
+	Bytes     int64 `json:"bytes"`
+	Skipped   int   `json:"skipped"`
+	Failed    int   `json:"failed"`
+}
+
+// Execute is the real implementation of the Mail command.
+func (cmd *Mail) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running mail command", "paths", cmd.Paths, "database", cmd.Database, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	roots := make([]string, 0, len(cmd.Paths))
+	for _, path := range cmd.Paths {
+		if root, err := filepath.Abs(path); err == nil {
+			path = root
+		}
+		roots = append(roots, path)
+	}
+	if err := buckets.Register(db, cmd.Bucket, Algorithm, roots, map[string]any{"mail": true}); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("error opening database transaction", "error", err)
+		return err
+	}
+	stmt, err := tx.Prepare("insert or replace into entries(hash, path, bucket, size, modified, indexed) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	summary := &Summary{}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, object fs.DirEntry, err error) error {
+			if err != nil {
+				slog.Error("error visiting path", "path", path, "error", err)
+				summary.Failed++
+				return nil
+			}
+			if !object.Type().IsRegular() {
+				return nil
+			}
+			info, err := object.Info()
+			if err != nil {
+				slog.Error("error reading file info", "path", path, "error", err)
+				summary.Failed++
+				return nil
+			}
+			modified := info.ModTime().UTC().Format(time.RFC3339)
+			switch {
+			case messages.IsMaildirMessage(path):
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					slog.Error("error reading message", "path", path, "error", err)
+					summary.Failed++
+					return nil
+				}
+				return store(stmt, cmd.Bucket, path, raw, modified, now, summary)
+			case messages.IsMbox(path):
+				f, err := os.Open(path)
+				if err != nil {
+					slog.Error("error opening mbox", "path", path, "error", err)
+					summary.Failed++
+					return nil
+				}
+				defer f.Close()
+				summary.Mailboxes++
+				err = messages.Mbox(f, func(n int, raw []byte) error {
+					return store(stmt, cmd.Bucket, fmt.Sprintf("%s#%d", path, n), raw, modified, now, summary)
+				})
+				if err != nil {
+					slog.Error("error reading mbox", "path", path, "error", err)
+					summary.Failed++
+				}
+				return nil
+			default:
+				slog.Debug("skipping file not containing mail", "path", path)
+				summary.Skipped++
+				return nil
+			}
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error committing database insert transaction", "error", err)
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d messages (%d bytes), %d mbox files read, %d files skipped, %d failed\n", summary.Messages, summary.Bytes, summary.Mailboxes, summary.Skipped, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// store hashes a message and adds it to the index; messages that cannot be
+// parsed are counted as failed, and do not stop the run.
+func store(stmt *sql.Stmt, bucket string, path string, raw []byte, modified string, indexed string, summary *Summary) error {
+	hash, err := messages.Hash(raw)
+	if err != nil {
+		slog.Warn("error parsing message", "path", path, "error", err)
+		summary.Failed++
+		return nil
+	}
+	if _, err := stmt.Exec(hash, path, bucket, len(raw), modified, indexed); err != nil {
+		slog.Error("error executing database insert statement", "path", path, "error", err)
+		return err
+	}
+	summary.Messages++
+	summary.Bytes += int64(len(raw))
+	return nil
+}
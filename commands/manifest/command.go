@@ -0,0 +1,66 @@
+package manifest
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/index"
+)
+
+// Manifest is the command that creates, compares and imports portable index
+// manifests, for comparing indexes across sites without network access:
+// create a manifest on one machine, carry it to another, and compare it with
+// the local index there.
+type Manifest struct {
+	// Create writes a manifest of the index.
+	Create Create `command:"create" description:"Write a manifest of the indexed files."`
+	// Compare compares a manifest with the index.
+	Compare Compare `command:"compare" alias:"diff" description:"Compare a manifest with the indexed files."`
+	// Import adds the files in a manifest to the index.
+	Import Import `command:"import" description:"Add the files in a manifest to the index, as a separate bucket."`
+	// Keygen generates a key pair to sign manifests with.
+	Keygen Keygen `command:"keygen" description:"Generate an Ed25519 key pair to sign and verify manifests."`
+	// Sign signs a file with a private key, e.g. the checksums of a release.
+	Sign Sign `command:"sign" description:"Sign a file, e.g. the checksums of a release, with a private key generated by keygen."`
+}
+
+// algorithmOf returns the hash algorithm of the given bucket or, if no bucket
+// is given, the one common to all buckets; buckets with no recorded algorithm
+// are assumed to use the default one.
+func algorithmOf(db *sql.DB, bucket string) (string, error) {
+	if bucket != "" {
+		metadata, err := buckets.Load(db, bucket)
+		if err != nil {
+			return "", err
+		}
+		if metadata == nil || metadata.Algorithm == "" {
+			return index.DefaultAlgorithm, nil
+		}
+		return metadata.Algorithm, nil
+	}
+	if err := buckets.Compatible(db); err != nil {
+		return "", err
+	}
+	var algorithm sql.NullString
+	if err := db.QueryRow("select max(algorithm) from buckets where name in (select distinct bucket from entries)").Scan(&algorithm); err != nil {
+		slog.Error("error reading bucket algorithm", "error", err)
+		return "", err
+	}
+	if !algorithm.Valid {
+		return index.DefaultAlgorithm, nil
+	}
+	return algorithm.String, nil
+}
+
+// checkAlgorithm returns an error if the manifest and the index were hashed
+// with different algorithms.
+func checkAlgorithm(manifest string, local string) error {
+	if manifest != local {
+		err := fmt.Errorf("%w: manifest was hashed with %s, index with %s", buckets.ErrIncompatible, manifest, local)
+		slog.Error("hash algorithms do not match", "error", err)
+		return err
+	}
+	return nil
+}
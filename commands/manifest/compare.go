@@ -0,0 +1,164 @@
+package manifest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"path"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Compare is the command that compares a manifest with the index: it lists
+// the contents only present in the manifest (missing here) and the contents
+// only present in the index (missing there), and optionally those present on
+// both sides.
+type Compare struct {
+	base.Command
+	base.Database
+	Source
+	// Bucket restricts the comparison to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to compare with (all buckets if not specified)." optional:"true"`
+	// Common also lists the indexed files whose contents are in the manifest.
+	Common bool `long:"common" description:"Also list the indexed files whose contents are in the manifest." optional:"true"`
+}
+
+// Difference is a file whose contents are only on one side.
+type Difference struct {
+	// Side is "manifest" if the contents are only in the manifest, "index" if
+	// they are only in the index, "both" if they are on both sides.
+	Side string `json:"side"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	// Path is empty for contents only in a contents only manifest.
+	Path string `json:"path,omitempty"`
+}
+
+// Comparison contains the totals of a comparison.
+type Comparison struct {
+	Manifest     int   `json:"manifest"`
+	Index        int   `json:"index"`
+	Common       int   `json:"common"`
+	OnlyManifest int   `json:"only_manifest"`
+	OnlyIndex    int   `json:"only_index"`
+	MissingBytes int64 `json:"missing_bytes"`
+}
+
+// Execute is the real implementation of the Compare command.
+func (cmd *Compare) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running manifest compare command", "database", cmd.Database, "manifest", cmd.Arguments.Manifest, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	m, err := cmd.load()
+	if err != nil {
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	algorithm, err := algorithmOf(db, cmd.Bucket)
+	if err != nil {
+		return err
+	}
+	if err := checkAlgorithm(m.Algorithm, algorithm); err != nil {
+		return err
+	}
+
+	print := func(d *Difference) error {
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(d)
+			if err != nil {
+				slog.Error("error marshalling difference to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else {
+			sign := map[string]string{"manifest": "-", "index": "+", "both": "="}[d.Side]
+			if d.Path == "" {
+				cmd.Output().Printf("%s %s\t%d\n", sign, d.Hash, d.Size)
+			} else {
+				cmd.Output().Printf("%s %s\t%d\t%s\n", sign, d.Hash, d.Size, d.Path)
+			}
+		}
+		return nil
+	}
+
+	comparison := &Comparison{}
+	remote := map[string]bool{}
+	for _, root := range m.Roots {
+		for _, entry := range root.Entries {
+			comparison.Manifest++
+			remote[entry.Hash] = true
+		}
+	}
+
+	query := "select hash, path, coalesce(size, 0) from entries"
+	params := []any{}
+	if cmd.Bucket != "" {
+		query += " where bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" order by path", params...)
+	if err != nil {
+		slog.Error("error querying entries", "error", err)
+		return err
+	}
+	defer rows.Close()
+	local := map[string]bool{}
+	for rows.Next() {
+		d := &Difference{Side: "index"}
+		if err := rows.Scan(&d.Hash, &d.Path, &d.Size); err != nil {
+			slog.Error("error reading entry", "error", err)
+			return err
+		}
+		comparison.Index++
+		local[d.Hash] = true
+		if !remote[d.Hash] {
+			comparison.OnlyIndex++
+			if err := print(d); err != nil {
+				return err
+			}
+		} else if cmd.Common {
+			d.Side = "both"
+			if err := print(d); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading entries", "error", err)
+		return err
+	}
+	for _, root := range m.Roots {
+		for _, entry := range root.Entries {
+			if local[entry.Hash] {
+				comparison.Common++
+				continue
+			}
+			comparison.OnlyManifest++
+			comparison.MissingBytes += entry.Size
+			if err := print(&Difference{Side: "manifest", Hash: entry.Hash, Size: entry.Size, Path: path.Join(root.Path, entry.Path)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(comparison)
+		if err != nil {
+			slog.Error("error marshalling comparison to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("%d files in manifest, %d in index: %d with contents on both sides, %d only in manifest (%d bytes), %d only in index\n",
+			comparison.Manifest, comparison.Index, comparison.Common, comparison.OnlyManifest, comparison.MissingBytes, comparison.OnlyIndex)
+	}
+	slog.Debug("command done")
+	return nil
+}
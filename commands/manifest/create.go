@@ -0,0 +1,205 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/manifest"
+)
+
+// Create is the command that writes a manifest of the indexed files, with
+// their paths relative to the roots they were indexed from, or of their
+// contents only, without any path.
+type Create struct {
+	base.Command
+	base.Database
+	// Output is the file to write the manifest to.
+	Output string `short:"o" long:"output" description:"The file to write the manifest to (- for standard output)." optional:"true" default:"-"`
+	// Bucket restricts the manifest to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to list (all buckets if not specified)." optional:"true"`
+	// Roots are the directories paths are made relative to.
+	Roots []string `short:"r" long:"root" description:"A directory the paths are made relative to (repeatable; defaults to the roots the bucket was indexed from)." optional:"true"`
+	// ContentsOnly lists each content once, by hash and size, without paths.
+	ContentsOnly bool `long:"contents-only" description:"List each content once by hash and size, without roots or paths, to compare sites without disclosing file names." optional:"true"`
+	// Key is the private key to sign the manifest with.
+	Key string `short:"k" long:"key" description:"The private key file to sign the manifest with (see manifest keygen)." optional:"true"`
+}
+
+// Execute is the real implementation of the Create command.
+func (cmd *Create) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running manifest create command", "database", cmd.Database, "bucket", cmd.Bucket, "output", cmd.Output)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	var key ed25519.PrivateKey
+	if cmd.Key != "" {
+		var err error
+		if key, err = manifest.LoadPrivateKey(cmd.Key); err != nil {
+			slog.Error("error loading private key", "path", cmd.Key, "error", err)
+			return err
+		}
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	algorithm, err := algorithmOf(db, cmd.Bucket)
+	if err != nil {
+		return err
+	}
+	if cmd.ContentsOnly {
+		return cmd.contents(db, algorithm, key)
+	}
+
+	roots := cmd.Roots
+	if len(roots) == 0 {
+		query := "select name from buckets where name in (select distinct bucket from entries)"
+		params := []any{}
+		if cmd.Bucket != "" {
+			query = "select name from buckets where name = ?"
+			params = append(params, cmd.Bucket)
+		}
+		rows, err := db.Query(query, params...)
+		if err != nil {
+			slog.Error("error querying buckets", "error", err)
+			return err
+		}
+		names := []string{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				slog.Error("error reading bucket", "error", err)
+				return err
+			}
+			names = append(names, name)
+		}
+		rows.Close()
+		for _, name := range names {
+			metadata, err := buckets.Load(db, name)
+			if err != nil {
+				return err
+			}
+			if metadata != nil {
+				roots = append(roots, metadata.Roots...)
+			}
+		}
+	}
+	for i, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			roots[i] = abs
+		}
+	}
+	// the most specific root comes first, and files under no root are
+	// listed under the filesystem root
+	sort.Slice(roots, func(i, j int) bool { return len(roots[i]) > len(roots[j]) })
+	roots = append(roots, string(filepath.Separator))
+
+	query := "select hash, path, coalesce(size, 0) from entries"
+	params := []any{}
+	if cmd.Bucket != "" {
+		query += " where bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" order by path", params...)
+	if err != nil {
+		slog.Error("error querying entries", "error", err)
+		return err
+	}
+	defer rows.Close()
+	m := &manifest.Manifest{Algorithm: algorithm, Created: time.Now()}
+	byRoot := map[string]*manifest.Root{}
+	for rows.Next() {
+		entry := &manifest.Entry{}
+		var path string
+		if err := rows.Scan(&entry.Hash, &path, &entry.Size); err != nil {
+			slog.Error("error reading entry", "error", err)
+			return err
+		}
+		for _, root := range roots {
+			relative, err := filepath.Rel(root, path)
+			if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+				continue
+			}
+			entry.Path = filepath.ToSlash(relative)
+			r, ok := byRoot[root]
+			if !ok {
+				r = &manifest.Root{Path: root}
+				byRoot[root] = r
+				m.Roots = append(m.Roots, r)
+			}
+			r.Entries = append(r.Entries, entry)
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading entries", "error", err)
+		return err
+	}
+
+	return cmd.write(m, key)
+}
+
+// contents writes a manifest listing each content in the index once, by hash
+// and size.
+func (cmd *Create) contents(db *sql.DB, algorithm string, key ed25519.PrivateKey) error {
+	query := "select hash, max(coalesce(size, 0)) from entries"
+	params := []any{}
+	if cmd.Bucket != "" {
+		query += " where bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" group by hash order by hash", params...)
+	if err != nil {
+		slog.Error("error querying contents", "error", err)
+		return err
+	}
+	defer rows.Close()
+	root := &manifest.Root{}
+	for rows.Next() {
+		entry := &manifest.Entry{}
+		if err := rows.Scan(&entry.Hash, &entry.Size); err != nil {
+			slog.Error("error reading content", "error", err)
+			return err
+		}
+		root.Entries = append(root.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading contents", "error", err)
+		return err
+	}
+	return cmd.write(&manifest.Manifest{Algorithm: algorithm, Created: time.Now(), ContentsOnly: true, Roots: []*manifest.Root{root}}, key)
+}
+
+// write writes the manifest to the output, signing it if a key is given.
+func (cmd *Create) write(m *manifest.Manifest, key ed25519.PrivateKey) error {
+	var output io.Writer = cmd.Command.Output()
+	if cmd.Output != "-" {
+		f, err := os.Create(cmd.Output)
+		if err != nil {
+			slog.Error("error creating manifest file", "path", cmd.Output, "error", err)
+			return err
+		}
+		defer f.Close()
+		output = f
+	}
+	if err := m.Write(output, key); err != nil {
+		slog.Error("error writing manifest", "error", err)
+		return err
+	}
+	slog.Debug("command done")
+	return nil
+}
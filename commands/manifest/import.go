@@ -0,0 +1,95 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Import is the command that adds the files listed in a manifest to the index,
+// in a bucket of their own, so that they can be used as reference in reports
+// (e.g. with --against and --offline) even though they are not on this machine.
+type Import struct {
+	base.Command
+	base.Database
+	Source
+	// Bucket is the bucket the files are added to.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to add the files to (e.g. the name of the site the manifest comes from)." required:"true"`
+}
+
+// Execute is the real implementation of the Import command.
+func (cmd *Import) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running manifest import command", "database", cmd.Database, "manifest", cmd.Arguments.Manifest, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	m, err := cmd.load()
+	if err != nil {
+		return err
+	}
+	if m.ContentsOnly {
+		err := fmt.Errorf("manifest %s lists contents only, without paths: it can be compared with, not imported", cmd.Arguments.Manifest)
+		slog.Error("error importing manifest", "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	roots := []string{}
+	for _, root := range m.Roots {
+		roots = append(roots, root.Path)
+	}
+	if err := buckets.Register(db, cmd.Bucket, m.Algorithm, roots, map[string]any{"manifest": cmd.Arguments.Manifest, "signed": m.Signed}); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("error opening database transaction", "error", err)
+		return err
+	}
+	stmt, err := tx.Prepare("insert or replace into entries(hash, path, bucket, size) values(?, ?, ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	var count int
+	for _, root := range m.Roots {
+		for _, entry := range root.Entries {
+			if _, err := stmt.Exec(entry.Hash, path.Join(root.Path, entry.Path), cmd.Bucket, entry.Size); err != nil {
+				slog.Error("error executing database insert statement", "error", err)
+				tx.Rollback()
+				return err
+			}
+			count++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error committing database insert transaction", "error", err)
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(map[string]any{"imported": count, "bucket": cmd.Bucket, "signed": m.Signed})
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("imported %d files into bucket %s\n", count, cmd.Bucket)
+	}
+	slog.Debug("command done")
+	return nil
+}
@@ -0,0 +1,31 @@
+package manifest
+
+import (
+	"log/slog"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/manifest"
+)
+
+// Keygen is the command that generates a key pair to sign manifests with; the
+// private key stays with whoever creates manifests, the public key (.pub) is
+// given to whoever compares them.
+type Keygen struct {
+	base.Command
+	// Output is the path of the private key file.
+	Output string `short:"o" long:"output" description:"The private key file to create; the public key is written next to it, with a .pub extension." required:"true"`
+}
+
+// Execute is the real implementation of the Keygen command.
+func (cmd *Keygen) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running manifest keygen command", "output", cmd.Output)
+
+	if err := manifest.GenerateKey(cmd.Output); err != nil {
+		slog.Error("error generating key pair", "path", cmd.Output, "error", err)
+		return err
+	}
+	cmd.Command.Output().Printf("private key written to %s, public key to %s.pub\n", cmd.Output, cmd.Output)
+	slog.Debug("command done")
+	return nil
+}
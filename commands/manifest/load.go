@@ -0,0 +1,46 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"log/slog"
+	"os"
+
+	"github.com/dihedron/dedup/manifest"
+)
+
+// Source is the manifest to read, along with the key to verify it with.
+type Source struct {
+	// Key is the public key the manifest must be signed with.
+	Key string `short:"k" long:"key" description:"The public key file the manifest must be signed with (signatures are not checked if not specified)." optional:"true"`
+	// Arguments hold the path of the manifest.
+	Arguments struct {
+		Manifest string `positional-arg-name:"manifest" description:"The manifest file."`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// load reads and verifies the manifest.
+func (s *Source) load() (*manifest.Manifest, error) {
+	var key ed25519.PublicKey
+	if s.Key != "" {
+		var err error
+		if key, err = manifest.LoadPublicKey(s.Key); err != nil {
+			slog.Error("error loading public key", "path", s.Key, "error", err)
+			return nil, err
+		}
+	}
+	f, err := os.Open(s.Arguments.Manifest)
+	if err != nil {
+		slog.Error("error opening manifest", "path", s.Arguments.Manifest, "error", err)
+		return nil, err
+	}
+	defer f.Close()
+	m, err := manifest.Read(f, key)
+	if err != nil {
+		slog.Error("error reading manifest", "path", s.Arguments.Manifest, "error", err)
+		return nil, err
+	}
+	if !m.Signed {
+		slog.Warn("manifest is not signed", "path", s.Arguments.Manifest)
+	}
+	return m, nil
+}
@@ -0,0 +1,55 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"log/slog"
+	"os"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/manifest"
+)
+
+// Sign is the command that signs a file with a private key generated by
+// keygen, writing a detached base64 signature next to it; it is used to sign
+// the checksums published with each release, which self-update verifies.
+type Sign struct {
+	base.Command
+	// Key is the private key to sign with.
+	Key string `short:"k" long:"key" description:"The private key file to sign with." required:"true"`
+	// Output is the path of the signature file.
+	Output string `short:"o" long:"output" description:"The signature file to create (defaults to the signed file with a .sig extension)." optional:"true"`
+	// Arguments hold the file to sign.
+	Arguments struct {
+		File string `positional-arg-name:"file" description:"The file to sign."`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute is the real implementation of the Sign command.
+func (cmd *Sign) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running manifest sign command", "file", cmd.Arguments.File, "output", cmd.Output)
+
+	key, err := manifest.LoadPrivateKey(cmd.Key)
+	if err != nil {
+		slog.Error("error loading private key", "path", cmd.Key, "error", err)
+		return err
+	}
+	data, err := os.ReadFile(cmd.Arguments.File)
+	if err != nil {
+		slog.Error("error reading file to sign", "path", cmd.Arguments.File, "error", err)
+		return err
+	}
+	output := cmd.Output
+	if output == "" {
+		output = cmd.Arguments.File + ".sig"
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	if err := os.WriteFile(output, []byte(signature+"\n"), 0644); err != nil {
+		slog.Error("error writing signature", "path", output, "error", err)
+		return err
+	}
+	cmd.Command.Output().Printf("signature of %s written to %s\n", cmd.Arguments.File, output)
+	slog.Debug("command done")
+	return nil
+}
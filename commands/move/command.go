@@ -0,0 +1,263 @@
+package move
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/privileges"
+)
+
+// Move is the command that relocates the duplicate copies of each content
+// (all but the one to keep) into a holding area, where they can be reviewed
+// before being deleted.
+type Move struct {
+	base.Command
+	base.Database
+	base.Preview
+	// Prefer is the ordered list of path prefixes the keeper is preferably chosen from.
+	Prefer []string `short:"P" long:"prefer" description:"Prefer keeping the copy under this path prefix; repeat in order of priority (defaults to keeping the oldest copy)." optional:"true"`
+	// PreferOwners is the ordered list of users whose copy is preferably kept.
+	PreferOwners []string `long:"prefer-owner" description:"Prefer keeping the copy owned by this user, by name or identifier, among those equally preferred by path; repeat in order of priority (needs index --ownership)." optional:"true"`
+	// Bucket restricts the move to the duplicates in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose duplicates should be moved (all buckets if not specified)." optional:"true"`
+	// Within restricts the move to the duplicates under the given directory.
+	Within string `short:"W" long:"within" description:"Only act on duplicates under this directory." optional:"true"`
+	// Against restricts the move to the files having a copy in the given bucket.
+	Against string `short:"g" long:"against" description:"Only act on files having a copy in this bucket, which is kept untouched." optional:"true"`
+	// Groups restricts the move to the duplicate groups with the given identifiers.
+	Groups []int64 `short:"G" long:"group" description:"Only act on the duplicate group with this identifier (repeatable)." optional:"true"`
+	// Tagged restricts the move to the duplicate groups having any of the given tags.
+	Tagged []string `long:"tagged" description:"Only act on the duplicate groups having this tag, on the group or any of its files (repeatable)." optional:"true"`
+	// Untagged excludes from the move the duplicate groups having any of the given tags.
+	Untagged []string `long:"untagged" description:"Skip the duplicate groups having this tag, on the group or any of its files (repeatable)." optional:"true"`
+	// Target is the directory where duplicates are moved to.
+	Target string `short:"t" long:"to" description:"The directory to move duplicates into." required:"true"`
+	// Template is the layout of the moved files under the target directory.
+	Template string `short:"T" long:"template" description:"The path of moved files under the target directory, with {path}, {dir}, {name}, {base}, {ext}, {hash} and {bucket} placeholders (defaults to the original path)." optional:"true"`
+	// Sidecars is how sidecar files (e.g. XMP, THM) of the duplicates are
+	// handled: like any other file, moved along with their media file, or
+	// left in place.
+	Sidecars string `long:"sidecars" description:"How to handle the sidecar files (XMP, THM, SRT, AAE) of duplicates: as plain files, moved along with them, or kept in place." optional:"true" choice:"ignore" choice:"move" choice:"keep" default:"ignore"`
+}
+
+// Summary contains the outcome of a move.
+type Summary struct {
+	Groups   int   `json:"groups"`
+	Moved    int   `json:"moved"`
+	Sidecars int   `json:"sidecars,omitempty"`
+	Forks    int   `json:"forks,omitempty"`
+	Denied   int   `json:"denied,omitempty"`
+	Failed   int   `json:"failed"`
+	Bytes    int64 `json:"bytes"`
+	DryRun   bool  `json:"dry_run,omitempty"`
+}
+
+// Execute is the real implementation of the Move command.
+func (cmd *Move) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running move command", "database", cmd.Database, "target", cmd.Target, "template", cmd.Template)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	owners, err := duplicates.LookupOwners(cmd.PreferOwners)
+	if err != nil {
+		slog.Error("error looking up preferred owners", "owners", cmd.PreferOwners, "error", err)
+		return err
+	}
+	// collect the groups first, since moving updates the entries being read
+	var groups []*duplicates.Group
+	if err := duplicates.Scan(db, &duplicates.Options{Bucket: cmd.Bucket, Prefer: cmd.Prefer, Owners: owners, Within: cmd.Within, Against: cmd.Against, Groups: cmd.Groups, Tagged: cmd.Tagged, Untagged: cmd.Untagged, Sidecars: cmd.Sidecars != "ignore"}, func(group *duplicates.Group) error {
+		groups = append(groups, group)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// check all the moves up-front, so that those that would fail for lack of
+	// privileges are reported before anything is changed
+	denied := map[string]error{}
+	for _, group := range groups {
+		for _, entry := range group.Duplicates {
+			target := Destination(cmd.Target, cmd.Template, entry)
+			if err := Preflight(entry.Path, target); err != nil {
+				denied[entry.Path] = err
+				if !cmd.DryRun {
+					slog.Warn("duplicate cannot be moved, skipping", "path", entry.Path, "target", target, "error", err)
+				}
+			}
+		}
+	}
+
+	summary := &Summary{DryRun: cmd.DryRun}
+	for _, group := range groups {
+		summary.Groups++
+		for _, entry := range group.Duplicates {
+			target := Destination(cmd.Target, cmd.Template, entry)
+			if err, ok := denied[entry.Path]; ok {
+				if cmd.DryRun {
+					cmd.WouldFail("move", entry.Path, target, entry.Size, err)
+				}
+				summary.Denied++
+				continue
+			}
+			if cmd.DryRun {
+				cmd.Would("move", entry.Path, target, entry.Size)
+				summary.Moved++
+				summary.Bytes += entry.Size
+				for _, fork := range entry.Forks {
+					cmd.Would("move", fork, filepath.Join(filepath.Dir(target), duplicates.ForkName(target)), 0)
+					summary.Forks++
+				}
+				if cmd.Sidecars == "move" {
+					for _, sidecar := range entry.Sidecars {
+						cmd.Would("move", sidecar, filepath.Join(filepath.Dir(target), duplicates.SidecarName(sidecar, entry.Path, target)), 0)
+						summary.Sidecars++
+					}
+				}
+				continue
+			}
+			// the duplicate may be the keeper itself under another path (e.g.
+			// a hard link or a bind mount), which moving would take away
+			if err := duplicates.SameFile(entry.Path, group.Keeper.Path); errors.Is(err, duplicates.ErrSameFile) {
+				slog.Warn("duplicate is its keeper under another path, skipping", "path", entry.Path, "keeper", group.Keeper.Path)
+				continue
+			} else if err != nil {
+				slog.Error("error comparing duplicate with its keeper", "path", entry.Path, "keeper", group.Keeper.Path, "error", err)
+				summary.Failed++
+				continue
+			}
+			if err := Relocate(db, entry, target); err != nil {
+				slog.Error("error moving duplicate", "path", entry.Path, "target", target, "error", err)
+				summary.Failed++
+				continue
+			}
+			slog.Info("duplicate moved", "path", entry.Path, "target", target, "keeper", group.Keeper.Path)
+			summary.Moved++
+			summary.Bytes += entry.Size
+			// resource forks paired at indexing time always follow their file
+			for _, fork := range entry.Forks {
+				destination := filepath.Join(filepath.Dir(target), duplicates.ForkName(target))
+				if err := Relocate(db, &duplicates.Entry{Path: fork}, destination); err != nil {
+					slog.Error("error moving resource fork", "path", fork, "target", destination, "error", err)
+					summary.Failed++
+					continue
+				}
+				slog.Info("resource fork moved", "path", fork, "target", destination)
+				summary.Forks++
+			}
+			if cmd.Sidecars != "move" {
+				continue
+			}
+			for _, sidecar := range entry.Sidecars {
+				destination := filepath.Join(filepath.Dir(target), duplicates.SidecarName(sidecar, entry.Path, target))
+				if err := Relocate(db, &duplicates.Entry{Path: sidecar}, destination); err != nil {
+					slog.Error("error moving sidecar", "path", sidecar, "target", destination, "error", err)
+					summary.Failed++
+					continue
+				}
+				slog.Info("sidecar moved", "path", sidecar, "target", destination)
+				summary.Sidecars++
+			}
+		}
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if cmd.DryRun {
+		cmd.Output().Printf("would move %d duplicates (%d bytes), %d sidecars and %d resource forks from %d groups, %d denied\n", summary.Moved, summary.Bytes, summary.Sidecars, summary.Forks, summary.Groups, summary.Denied)
+	} else {
+		cmd.Output().Printf("moved %d duplicates (%d bytes), %d sidecars and %d resource forks from %d groups, %d denied, %d failed\n", summary.Moved, summary.Bytes, summary.Sidecars, summary.Forks, summary.Groups, summary.Denied, summary.Failed)
+	}
+	slog.Debug("command done")
+	if cmd.DryRun {
+		return nil
+	}
+	return failures.Partial(int64(summary.Failed + summary.Denied))
+}
+
+// Preflight returns an error wrapping privileges.ErrDenied if the current user
+// is not allowed to move the file at path to the target path.
+func Preflight(path string, target string) error {
+	if err := privileges.CanReplace(path); err != nil {
+		return err
+	}
+	return privileges.CanCreate(target)
+}
+
+// Relocate moves the file of the given entry to the target path and removes it
+// from the index, since the holding area is not part of the indexed trees.
+func Relocate(db *sql.DB, entry *duplicates.Entry, target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("target %s already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := rename(entry.Path, target); err != nil {
+		return err
+	}
+	if _, err := db.Exec("delete from entries where path = ? and (hash = ? or ? = '')", entry.Path, entry.Hash, entry.Hash); err != nil {
+		slog.Error("error removing moved entry", "path", entry.Path, "error", err)
+		return err
+	}
+	return nil
+}
+
+// rename moves a file, falling back to copying and removing it when the
+// target is on a different filesystem.
+func rename(source string, target string) error {
+	err := os.Rename(source, target)
+	if err == nil || !crossDevice(err) {
+		return err
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(target)
+		return err
+	}
+	if err := privileges.Preserve(source, info, target); err != nil {
+		os.Remove(target)
+		return err
+	}
+	if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+		slog.Warn("error preserving modification time", "path", target, "error", err)
+	}
+	return os.Remove(source)
+}
@@ -0,0 +1,71 @@
+package move
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dihedron/dedup/internal/testutil"
+)
+
+func TestMoveSameFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// paths are indexed with the same contents: a and b are copies, l is
+		// a hard link to a; $ stands for the current directory.
+		paths []string
+		// moved are the files expected to be moved.
+		moved []string
+	}{
+		{name: "copy", paths: []string{"a", "b"}, moved: []string{"b"}},
+		{name: "relative and absolute", paths: []string{"a", "$/a"}},
+		{name: "hard link", paths: []string{"a", "l"}},
+		{name: "relative, absolute and hard link with a copy", paths: []string{"a", "$/a", "l", "b"}, moved: []string{"b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, database := testutil.DatabasePath(t)
+			dir := t.TempDir()
+			t.Chdir(dir)
+			for _, name := range []string{"a", "b"} {
+				if err := os.WriteFile(name, []byte("contents"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Link("a", "l"); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range test.paths {
+				if path[0] == '$' {
+					path = dir + path[1:]
+				}
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size) values('h', ?, 'b', 8)", path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			target := t.TempDir()
+			cmd := &Move{Target: target, Sidecars: "ignore"}
+			cmd.Database.Database = database
+			cmd.LogStream, cmd.LogFormat = "none", "text"
+			cmd.Color = "never"
+			if err := cmd.Execute(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{"a", "b", "l"} {
+				_, err := os.Stat(name)
+				moved := false
+				for _, m := range test.moved {
+					moved = moved || m == name
+				}
+				if moved != os.IsNotExist(err) {
+					t.Errorf("expected %s moved %t, got %v", name, moved, err)
+				}
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, "a")); err != nil || string(data) != "contents" {
+				t.Errorf("keeper lost: %q, %v", data, err)
+			}
+		})
+	}
+}
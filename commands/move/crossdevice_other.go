@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package move
+
+// crossDevice returns whether the rename failed because the source and the
+// target are on different filesystems, which cannot be told on this platform.
+func crossDevice(err error) bool {
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package move
+
+import (
+	"errors"
+	"syscall"
+)
+
+// crossDevice returns whether the rename failed because the source and the
+// target are on different filesystems.
+func crossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package move
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE, returned by Windows when the
+// file cannot be moved to a different volume.
+const errorNotSameDevice = syscall.Errno(17)
+
+// crossDevice returns whether the rename failed because the source and the
+// target are on different volumes.
+func crossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
@@ -0,0 +1,47 @@
+package move
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/dihedron/dedup/duplicates"
+)
+
+// Destination returns the path where the given duplicate is moved to: if no
+// template is given, the full original path is recreated under the target
+// directory, otherwise the template placeholders are expanded:
+//   - {path}: the original path, relative to the filesystem root
+//   - {dir}: the original directory, relative to the filesystem root
+//   - {name}: the file name
+//   - {base}: the file name without extension
+//   - {ext}: the file extension, including the dot
+//   - {hash}: the hash of the contents
+//   - {bucket}: the bucket of the entry
+func Destination(target string, template string, entry *duplicates.Entry) string {
+	path := relative(entry.Path)
+	if template == "" {
+		return filepath.Join(target, path)
+	}
+	name := filepath.Base(entry.Path)
+	ext := filepath.Ext(name)
+	replacer := strings.NewReplacer(
+		"{path}", path,
+		"{dir}", filepath.Dir(path),
+		"{name}", name,
+		"{base}", strings.TrimSuffix(name, ext),
+		"{ext}", ext,
+		"{hash}", entry.Hash,
+		"{bucket}", entry.Bucket,
+	)
+	return filepath.Join(target, filepath.FromSlash(replacer.Replace(template)))
+}
+
+// relative returns the given path relative to the root of its filesystem
+// (or volume, on Windows).
+func relative(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	path = strings.TrimPrefix(path, filepath.VolumeName(path))
+	return strings.TrimLeft(path, `/\`)
+}
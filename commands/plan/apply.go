@@ -0,0 +1,236 @@
+package plan
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/commands/move"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/journal"
+)
+
+// action is the name of the apply action in the journal.
+const action = "apply"
+
+// Apply is the command that carries out the imported decisions: in each group,
+// the copies marked to be deleted are removed (or moved into a holding area),
+// provided that at least one copy marked to be kept is still on disk with the
+// indexed contents and that they have not changed since they were indexed.
+// Only the decisions carried out are marked as applied, so that the others are
+// retried the next time.
+type Apply struct {
+	base.Command
+	base.Database
+	base.Preview
+	// Target is the holding area the copies are moved to instead of being
+	// deleted.
+	Target string `short:"t" long:"to" description:"Move the copies marked to be deleted into this directory instead of deleting them." optional:"true"`
+}
+
+// ApplySummary contains the outcome of applying the decisions.
+type ApplySummary struct {
+	Groups  int    `json:"groups"`
+	Deleted int    `json:"deleted"`
+	Moved   int    `json:"moved"`
+	Bytes   int64  `json:"bytes"`
+	Skipped int    `json:"skipped"`
+	Failed  int    `json:"failed"`
+	Run     string `json:"run,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// Execute is the real implementation of the Apply command.
+func (cmd *Apply) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running plan apply command", "database", cmd.Database, "target", cmd.Target)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	decisions, err := pending(db)
+	if err != nil {
+		return err
+	}
+
+	j := journal.New(db)
+	summary := &ApplySummary{Run: j.Run(), DryRun: cmd.DryRun}
+	if cmd.DryRun {
+		summary.Run = ""
+	}
+	for start := 0; start < len(decisions); {
+		end := start
+		for end < len(decisions) && decisions[end].Hash == decisions[start].Hash {
+			end++
+		}
+		summary.Groups++
+		cmd.group(db, j, decisions[start:end], summary)
+		start = end
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if cmd.DryRun {
+		cmd.Output().Printf("would delete %d and move %d copies (%d bytes) from %d groups, %d skipped\n", summary.Deleted, summary.Moved, summary.Bytes, summary.Groups, summary.Skipped)
+	} else {
+		cmd.Output().Printf("deleted %d and moved %d copies (%d bytes) from %d groups, %d skipped, %d failed (run %s)\n", summary.Deleted, summary.Moved, summary.Bytes, summary.Groups, summary.Skipped, summary.Failed, summary.Run)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// group applies the decisions on the copies of the same contents.
+func (cmd *Apply) group(db *sql.DB, j *journal.Journal, decisions []*Decision, summary *ApplySummary) {
+	var keepers []*duplicates.Entry
+	for _, d := range decisions {
+		if d.Decision != keep {
+			continue
+		}
+		if entry, err := verify(db, d); err != nil {
+			slog.Warn("copy to keep cannot be relied upon", "path", d.Path, "error", err)
+		} else {
+			keepers = append(keepers, entry)
+		}
+	}
+	if len(keepers) == 0 {
+		slog.Warn("no copy to keep is still there, skipping group", "hash", decisions[0].Hash)
+		for _, d := range decisions {
+			if d.Decision == remove {
+				summary.Skipped++
+			}
+		}
+		return
+	}
+	keeper := keepers[0]
+	now := time.Now().UTC().Format(time.RFC3339)
+	complete := true
+	for _, d := range decisions {
+		if d.Decision != remove {
+			continue
+		}
+		entry, err := verify(db, d)
+		if err != nil {
+			slog.Warn("copy to delete changed since it was reviewed, skipping", "path", d.Path, "error", err)
+			summary.Skipped++
+			complete = false
+			continue
+		}
+		// the copy may be one to keep under another path, which deleting or
+		// moving it would destroy
+		if err := distinct(entry, keepers); err != nil {
+			slog.Warn("copy to delete cannot be told apart from a copy to keep, skipping", "path", d.Path, "error", err)
+			summary.Skipped++
+			complete = false
+			continue
+		}
+		record := &journal.Record{Path: entry.Path, Target: keeper.Path, Hash: entry.Hash, Bucket: entry.Bucket, Size: entry.Size, Group: d.Group}
+		if cmd.DryRun {
+			if cmd.Target != "" {
+				cmd.Would("move", entry.Path, move.Destination(cmd.Target, "", entry), entry.Size)
+				summary.Moved++
+			} else {
+				cmd.Would("delete", entry.Path, "", entry.Size)
+				summary.Deleted++
+			}
+			summary.Bytes += entry.Size
+			continue
+		}
+		if cmd.Target != "" {
+			record.Action, record.Target = "move", move.Destination(cmd.Target, "", entry)
+			err = move.Relocate(db, entry, record.Target)
+		} else {
+			record.Action = "delete"
+			err = index.Discard(db, entry)
+		}
+		if err != nil {
+			err = &failures.ActionError{Action: record.Action, Path: entry.Path, Err: err}
+			slog.Error("error applying decision", "path", entry.Path, "decision", d.Decision, "error", err)
+			summary.Failed++
+			complete = false
+			continue
+		}
+		if err := j.Add(record); err != nil {
+			summary.Failed++
+			complete = false
+			continue
+		}
+		if _, err := db.Exec("update decisions set applied = ? where path = ?", now, d.Path); err != nil {
+			slog.Error("error marking decision as applied", "path", d.Path, "error", err)
+		}
+		slog.Info("decision applied", "path", entry.Path, "action", record.Action, "keeper", keeper.Path)
+		if cmd.Target != "" {
+			summary.Moved++
+		} else {
+			summary.Deleted++
+		}
+		summary.Bytes += entry.Size
+	}
+	// the copies to keep are done with once there is nothing left to delete
+	if cmd.DryRun || !complete {
+		return
+	}
+	if _, err := db.Exec("update decisions set applied = ? where hash = ? and decision = ? and applied is null", now, keeper.Hash, keep); err != nil {
+		slog.Error("error marking decisions as applied", "hash", keeper.Hash, "error", err)
+	}
+}
+
+// distinct returns an error unless the entry is a file other than all the
+// copies to keep.
+func distinct(entry *duplicates.Entry, keepers []*duplicates.Entry) error {
+	for _, keeper := range keepers {
+		if err := duplicates.SameFile(entry.Path, keeper.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify returns the index entry of the file the decision is about, if the
+// file is still on disk with the contents it was indexed with, which is
+// hashed again with the algorithm of its bucket.
+func verify(db *sql.DB, d *Decision) (*duplicates.Entry, error) {
+	entry := &duplicates.Entry{Path: d.Path, Hash: d.Hash}
+	var bucket sql.NullString
+	if err := db.QueryRow("select bucket, coalesce(size, 0) from entries where path = ? and hash = ?", d.Path, d.Hash).Scan(&bucket, &entry.Size); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = errors.New("file is not in the index with the reviewed contents")
+		}
+		return nil, err
+	}
+	entry.Bucket = bucket.String
+	info, err := os.Lstat(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("file is not a regular file anymore")
+	}
+	algorithm := index.DefaultAlgorithm
+	if metadata, err := buckets.Load(db, entry.Bucket); err != nil {
+		return nil, err
+	} else if metadata != nil && metadata.Algorithm != "" {
+		algorithm = metadata.Algorithm
+	}
+	if _, err := index.Verify(d.Path, algorithm, entry.Hash, entry.Size); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
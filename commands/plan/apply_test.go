@@ -0,0 +1,241 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/dihedron/dedup/commands/move"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/internal/testutil"
+	"github.com/dihedron/dedup/journal"
+)
+
+func TestApply(t *testing.T) {
+	const contents = "same contents"
+	tests := []struct {
+		name string
+		// decisions are taken on files a, b and c, indexed with the same
+		// contents.
+		decisions map[string]string
+		// aliases are decisions taken on other paths to file a: l is a hard
+		// link to it, any other path is appended to the directory as is.
+		aliases map[string]string
+		// removed and changed are the files removed or modified after the
+		// decisions were taken.
+		removed, changed []string
+		target           bool
+		dryRun           bool
+		// deleted, moved and skipped are the expected counts.
+		deleted, moved, skipped int
+		// gone are the files expected to be deleted or moved.
+		gone []string
+		// applied are the decisions expected to be marked as applied.
+		applied []string
+	}{
+		{
+			name:      "delete copies",
+			decisions: map[string]string{"a": keep, "b": remove, "c": remove},
+			deleted:   2,
+			gone:      []string{"b", "c"},
+			applied:   []string{"a", "b", "c"},
+		},
+		{
+			name:      "move copies",
+			decisions: map[string]string{"a": keep, "b": remove, "c": remove},
+			target:    true,
+			moved:     2,
+			gone:      []string{"b", "c"},
+			applied:   []string{"a", "b", "c"},
+		},
+		{
+			name:      "dry run",
+			decisions: map[string]string{"a": keep, "b": remove, "c": remove},
+			dryRun:    true,
+			deleted:   2,
+		},
+		{
+			name:      "copy to keep removed",
+			decisions: map[string]string{"a": keep, "b": remove, "c": remove},
+			removed:   []string{"a"},
+			skipped:   2,
+			gone:      []string{"a"},
+		},
+		{
+			name:      "copy to keep changed",
+			decisions: map[string]string{"a": keep, "b": remove, "c": remove},
+			changed:   []string{"a"},
+			skipped:   2,
+		},
+		{
+			name:      "other copy to keep",
+			decisions: map[string]string{"a": keep, "b": keep, "c": remove},
+			removed:   []string{"a"},
+			deleted:   1,
+			gone:      []string{"a", "c"},
+			applied:   []string{"a", "b", "c"},
+		},
+		{
+			name:      "copy to delete changed",
+			decisions: map[string]string{"a": keep, "b": remove, "c": remove},
+			changed:   []string{"c"},
+			deleted:   1,
+			skipped:   1,
+			gone:      []string{"b"},
+			applied:   []string{"b"},
+		},
+		{
+			name:      "copy to delete removed",
+			decisions: map[string]string{"a": keep, "b": remove, "c": remove},
+			removed:   []string{"c"},
+			deleted:   1,
+			skipped:   1,
+			gone:      []string{"b", "c"},
+			applied:   []string{"b"},
+		},
+		{
+			name:      "hard link to copy to keep",
+			decisions: map[string]string{"a": keep, "b": remove},
+			aliases:   map[string]string{"l": remove},
+			deleted:   1,
+			skipped:   1,
+			gone:      []string{"b"},
+			applied:   []string{"b"},
+		},
+		{
+			name:      "copy to keep under another path",
+			decisions: map[string]string{"a": keep},
+			aliases:   map[string]string{"./a": remove},
+			skipped:   1,
+		},
+		{
+			name:      "copy to keep under another path, moved",
+			decisions: map[string]string{"a": keep},
+			aliases:   map[string]string{"./a": remove},
+			target:    true,
+			skipped:   1,
+		},
+		{
+			name:      "no copy to keep",
+			decisions: map[string]string{"b": remove, "c": remove},
+			skipped:   2,
+		},
+	}
+	sum := sha256.Sum256([]byte(contents))
+	hash := hex.EncodeToString(sum[:])
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			dir := t.TempDir()
+			for _, name := range []string{"a", "b", "c"} {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size) values(?, ?, 'b', ?)", hash, path, len(contents)); err != nil {
+					t.Fatal(err)
+				}
+				if decision, ok := test.decisions[name]; ok {
+					if _, err := db.Exec("insert into decisions(path, hash, group_id, decision, imported) values(?, ?, 1, ?, '2024-06-01T00:00:00Z')", path, hash, decision); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "l")); err != nil {
+				t.Fatal(err)
+			}
+			for alias, decision := range test.aliases {
+				path := dir + string(filepath.Separator) + alias
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size) values(?, ?, 'b', ?)", hash, path, len(contents)); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.Exec("insert into decisions(path, hash, group_id, decision, imported) values(?, ?, 1, ?, '2024-06-01T00:00:00Z')", path, hash, decision); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range test.removed {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range test.changed {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("other contents"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := &Apply{}
+			cmd.DryRun = test.dryRun
+			cmd.Color = "never"
+			target := t.TempDir()
+			if test.target {
+				cmd.Target = target
+			}
+			decisions, err := pending(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary := &ApplySummary{}
+			cmd.group(db, journal.New(db), decisions, summary)
+
+			if summary.Deleted != test.deleted || summary.Moved != test.moved || summary.Skipped != test.skipped || summary.Failed != 0 {
+				t.Errorf("expected %d deleted, %d moved, %d skipped, 0 failed, got %d, %d, %d, %d",
+					test.deleted, test.moved, test.skipped, summary.Deleted, summary.Moved, summary.Skipped, summary.Failed)
+			}
+			if bytes := int64((test.deleted + test.moved) * len(contents)); summary.Bytes != bytes {
+				t.Errorf("expected %d bytes, got %d", bytes, summary.Bytes)
+			}
+			for _, name := range []string{"a", "b", "c"} {
+				path := filepath.Join(dir, name)
+				_, err := os.Lstat(path)
+				if gone := slices.Contains(test.gone, name); gone != os.IsNotExist(err) {
+					t.Errorf("expected %s gone %t, got error %v", name, gone, err)
+				}
+				if test.target && slices.Contains(test.gone, name) && !slices.Contains(test.removed, name) {
+					if _, err := os.Lstat(move.Destination(target, "", &duplicates.Entry{Path: path})); err != nil {
+						t.Errorf("expected %s moved: %v", name, err)
+					}
+				}
+			}
+
+			// other paths to a copy to keep are never deleted or moved
+			if data, err := os.ReadFile(filepath.Join(dir, "l")); err != nil || (string(data) != contents && !slices.Contains(test.changed, "a")) {
+				t.Errorf("expected hard link to a left in place, got %q, %v", data, err)
+			}
+
+			rows, err := db.Query("select path from decisions where applied is not null order by path")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var applied []string
+			for rows.Next() {
+				var path string
+				if err := rows.Scan(&path); err != nil {
+					t.Fatal(err)
+				}
+				applied = append(applied, filepath.Base(path))
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(applied, test.applied) {
+				t.Errorf("expected decisions %v applied, got %v", test.applied, applied)
+			}
+			// the copies deleted are dropped from the index, and journaled
+			var entries, journaled int
+			if err := db.QueryRow("select count(*) from entries").Scan(&entries); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.QueryRow("select count(*) from journal").Scan(&journaled); err != nil {
+				t.Fatal(err)
+			}
+			if done := test.deleted + test.moved; !test.dryRun && (entries != 3+len(test.aliases)-done || journaled != done) {
+				t.Errorf("expected %d entries and %d journaled, got %d and %d", 3+len(test.aliases)-done, done, entries, journaled)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan is the command that turns a report reviewed and edited by hand (e.g. in
+// a spreadsheet) into an action plan, and applies it: the copies marked to be
+// deleted are removed, as long as a copy marked to be kept is still there.
+type Plan struct {
+	// Import loads the decisions in an edited report.
+	Import Import `command:"import" description:"Load the keep/delete decisions from an edited CSV or JSON report."`
+	// Show lists the decisions still to be applied.
+	Show Show `command:"show" alias:"ls" description:"List the decisions still to be applied."`
+	// Apply carries out the decisions.
+	Apply Apply `command:"apply" description:"Delete (or move into a holding area) the copies marked to be deleted."`
+}
+
+const (
+	// keep is the decision to keep a copy.
+	keep = "keep"
+	// remove is the decision to delete a copy.
+	remove = "delete"
+)
+
+// parseDecision returns the decision corresponding to the given cell of an
+// edited report, or an empty string if no decision was taken.
+func parseDecision(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return "", nil
+	case "keep", "k", "yes", "y", "x":
+		return keep, nil
+	case "delete", "del", "d", "remove", "rm", "no", "n":
+		return remove, nil
+	}
+	return "", fmt.Errorf("invalid decision %q, must be keep or delete", value)
+}
@@ -0,0 +1,239 @@
+package plan
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Import is the command that loads the decisions taken on the files of an
+// edited report: in CSV reports, the decision column (or, if empty, the keep
+// column) of each row; in JSON reports, the keeper of each group is kept and
+// its duplicates are deleted.
+type Import struct {
+	base.Command
+	base.Database
+	// Input is the edited report.
+	Input string `short:"i" long:"input" description:"The edited report, in CSV or JSON format (by extension)." required:"true"`
+	// Replace discards the decisions not yet applied before importing.
+	Replace bool `long:"replace" description:"Discard the decisions not yet applied before importing the new ones." optional:"true"`
+}
+
+// decision is a decision taken on a file.
+type decision struct {
+	path     string
+	hash     string
+	group    int64
+	decision string
+}
+
+// ImportSummary contains the outcome of an import.
+type ImportSummary struct {
+	Keep    int `json:"keep"`
+	Delete  int `json:"delete"`
+	Skipped int `json:"skipped"`
+}
+
+// Execute is the real implementation of the Import command.
+func (cmd *Import) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running plan import command", "database", cmd.Database, "input", cmd.Input)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	f, err := os.Open(cmd.Input)
+	if err != nil {
+		slog.Error("error opening report", "path", cmd.Input, "error", err)
+		return err
+	}
+	defer f.Close()
+	var decisions []*decision
+	switch strings.ToLower(filepath.Ext(cmd.Input)) {
+	case ".csv":
+		decisions, err = readCSV(f)
+	case ".json", ".ndjson", ".jsonl":
+		decisions, err = readJSON(f)
+	default:
+		err = fmt.Errorf("unsupported report format %q, must be .csv or .json", filepath.Ext(cmd.Input))
+	}
+	if err != nil {
+		slog.Error("error reading report", "path", cmd.Input, "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	summary, err := cmd.store(db, decisions)
+	if err != nil {
+		return err
+	}
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("imported %d copies to keep and %d to delete, %d without decision\n", summary.Keep, summary.Delete, summary.Skipped)
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// store records the decisions, looking up the hash of the files whose hash is
+// not in the report.
+func (cmd *Import) store(db *sql.DB, decisions []*decision) (*ImportSummary, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("error opening database transaction", "error", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+	if cmd.Replace {
+		if _, err := tx.Exec("delete from decisions where applied is null"); err != nil {
+			slog.Error("error discarding pending decisions", "error", err)
+			return nil, err
+		}
+	}
+	stmt, err := tx.Prepare("insert or replace into decisions(path, hash, group_id, decision, imported) values(?, ?, nullif(?, 0), ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		return nil, err
+	}
+	defer stmt.Close()
+	summary := &ImportSummary{}
+	imported := time.Now().UTC().Format(time.RFC3339)
+	for _, d := range decisions {
+		if d.decision == "" {
+			summary.Skipped++
+			continue
+		}
+		if d.hash == "" {
+			if err := tx.QueryRow("select hash from entries where path = ?", d.path).Scan(&d.hash); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					err = fmt.Errorf("file %s is not in the index", d.path)
+				}
+				slog.Error("error looking up file hash", "path", d.path, "error", err)
+				return nil, err
+			}
+		}
+		if _, err := stmt.Exec(d.path, d.hash, d.group, d.decision, imported); err != nil {
+			slog.Error("error executing database insert statement", "path", d.path, "error", err)
+			return nil, err
+		}
+		if d.decision == keep {
+			summary.Keep++
+		} else {
+			summary.Delete++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error committing database insert transaction", "error", err)
+		return nil, err
+	}
+	return summary, nil
+}
+
+// readCSV reads the decisions in a CSV report; only the path column and one of
+// the decision and keep columns are required.
+func readCSV(r io.Reader) ([]*decision, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["path"]; !ok {
+		return nil, errors.New("missing path column")
+	}
+	_, hasDecision := columns["decision"]
+	_, hasKeep := columns["keep"]
+	if !hasDecision && !hasKeep {
+		return nil, errors.New("missing decision or keep column")
+	}
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	decisions := []*decision{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		d := &decision{path: cell(record, "path"), hash: cell(record, "hash")}
+		if d.path == "" {
+			continue
+		}
+		if group := cell(record, "group"); group != "" {
+			if d.group, err = strconv.ParseInt(group, 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid group %q", line, group)
+			}
+		}
+		if d.decision, err = parseDecision(cell(record, "decision")); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if d.decision == "" && hasKeep {
+			// an unmarked copy in a group with a keeper is to be deleted
+			if d.decision, err = parseDecision(cell(record, "keep")); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			} else if d.decision == "" {
+				d.decision = remove
+			}
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, nil
+}
+
+// readJSON reads the decisions in a JSON report, one group per line.
+func readJSON(r io.Reader) ([]*decision, error) {
+	decisions := []*decision{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		group := struct {
+			ID         int64    `json:"id"`
+			Hash       string   `json:"hash"`
+			Keeper     string   `json:"keeper"`
+			Duplicates []string `json:"duplicates"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &group); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if group.Hash == "" || group.Keeper == "" {
+			// e.g. the summary line
+			continue
+		}
+		decisions = append(decisions, &decision{path: group.Keeper, hash: group.Hash, group: group.ID, decision: keep})
+		for _, path := range group.Duplicates {
+			decisions = append(decisions, &decision{path: path, hash: group.Hash, group: group.ID, decision: remove})
+		}
+	}
+	return decisions, scanner.Err()
+}
@@ -0,0 +1,80 @@
+package plan
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Show is the command that lists the decisions still to be applied.
+type Show struct {
+	base.Command
+	base.Database
+}
+
+// Decision is the representation of a decision in the listing.
+type Decision struct {
+	Path     string `json:"path"`
+	Hash     string `json:"hash"`
+	Group    int64  `json:"group,omitempty"`
+	Decision string `json:"decision"`
+	Imported string `json:"imported"`
+}
+
+// pending returns the decisions not yet applied, by hash.
+func pending(db *sql.DB) ([]*Decision, error) {
+	rows, err := db.Query("select path, hash, coalesce(group_id, 0), decision, imported from decisions where applied is null order by hash, decision desc, path")
+	if err != nil {
+		slog.Error("error querying decisions", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	decisions := []*Decision{}
+	for rows.Next() {
+		d := &Decision{}
+		if err := rows.Scan(&d.Path, &d.Hash, &d.Group, &d.Decision, &d.Imported); err != nil {
+			slog.Error("error reading decision", "error", err)
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
+
+// Execute is the real implementation of the Show command.
+func (cmd *Show) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running plan show command", "database", cmd.Database)
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	decisions, err := pending(db)
+	if err != nil {
+		return err
+	}
+	hash := ""
+	for _, d := range decisions {
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(d)
+			if err != nil {
+				slog.Error("error marshalling decision to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+			continue
+		}
+		if d.Hash != hash {
+			hash = d.Hash
+			cmd.Output().Printf("#%d %s\n", d.Group, d.Hash)
+		}
+		cmd.Output().Printf("  %-6s %s\n", d.Decision, d.Path)
+	}
+	slog.Debug("command done")
+	return nil
+}
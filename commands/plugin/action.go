@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/plugins"
+)
+
+// Action is the command that hands the groups of duplicates over to an action
+// plugin, e.g. to move them to a cloud archive or to open tickets about
+// them. Unless in a dry run, the keeper and the duplicates of each group are
+// hashed again beforehand, and groups whose files changed since indexing are
+// skipped; the plugin is responsible for what it does with the duplicates,
+// which is not journaled.
+type Action struct {
+	base.Command
+	base.Database
+	base.Preview
+	// Name is the name of the action plugin.
+	Name string `short:"N" long:"name" description:"The name of the action plugin, i.e. dedup-action-<name> in the plugins directory." required:"true"`
+	// Prefer is the ordered list of path prefixes the keeper is preferably chosen from.
+	Prefer []string `short:"P" long:"prefer" description:"Prefer keeping the copy under this path prefix; repeat in order of priority (defaults to keeping the oldest copy)." optional:"true"`
+	// Bucket restricts the action to the duplicates in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose duplicates should be acted upon (all buckets if not specified)." optional:"true"`
+	// Within restricts the action to the duplicates under the given directory.
+	Within string `short:"W" long:"within" description:"Only act on duplicates under this directory." optional:"true"`
+	// Groups restricts the action to the duplicate groups with the given identifiers.
+	Groups []int64 `short:"G" long:"group" description:"Only act on the duplicate group with this identifier (repeatable)." optional:"true"`
+	// Tagged restricts the action to the duplicate groups having any of the given tags.
+	Tagged []string `long:"tagged" description:"Only act on the duplicate groups having this tag, on the group or any of its files (repeatable)." optional:"true"`
+	// Hash is the hash algorithm of the buckets indexed before it was recorded.
+	Hash string `long:"hash" description:"The hash algorithm the index was built with, for buckets that do not record it." optional:"true" default:"sha256"`
+}
+
+// ActionSummary contains the outcome of acting on duplicates with a plugin.
+type ActionSummary struct {
+	Groups  int   `json:"groups"`
+	Done    int   `json:"done"`
+	Changed int   `json:"changed,omitempty"`
+	Failed  int   `json:"failed"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Execute is the real implementation of the Action command.
+func (cmd *Action) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running plugins action command", "name", cmd.Name, "database", cmd.Database, "bucket", cmd.Bucket, "dry-run", cmd.DryRun)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	action, err := plugins.OpenAction(cmd.Name)
+	if err != nil {
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var groups []*duplicates.Group
+	if err := duplicates.Scan(db, &duplicates.Options{Bucket: cmd.Bucket, Prefer: cmd.Prefer, Within: cmd.Within, Groups: cmd.Groups, Tagged: cmd.Tagged}, func(group *duplicates.Group) error {
+		groups = append(groups, group)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	summary := &ActionSummary{}
+	for _, group := range groups {
+		summary.Groups++
+		if !cmd.DryRun {
+			if err := cmd.verify(db, group); err != nil {
+				slog.Warn("files changed since indexing, skipping group", "hash", group.Hash, "keeper", group.Keeper.Path, "error", err)
+				summary.Changed += len(group.Duplicates)
+				continue
+			}
+		}
+		g := plugins.Group{ID: group.ID, Hash: group.Hash, Size: group.Size, Keeper: fileOf(group.Keeper)}
+		for _, entry := range group.Duplicates {
+			g.Duplicates = append(g.Duplicates, fileOf(entry))
+		}
+		result, err := action.Apply(g, cmd.DryRun)
+		if err != nil {
+			slog.Error("error applying plugin action", "name", cmd.Name, "hash", group.Hash, "error", err)
+			summary.Failed += len(group.Duplicates)
+			continue
+		}
+		for _, path := range result.Done {
+			if cmd.DryRun {
+				cmd.Would(cmd.Name, path, group.Keeper.Path, group.Size)
+			} else {
+				slog.Info("duplicate acted upon", "name", cmd.Name, "path", path, "keeper", group.Keeper.Path)
+			}
+		}
+		for path, reason := range result.Failed {
+			if cmd.DryRun {
+				cmd.WouldFail(cmd.Name, path, group.Keeper.Path, group.Size, fmt.Errorf("%s", reason))
+			} else {
+				slog.Error("error acting on duplicate", "name", cmd.Name, "path", path, "error", reason)
+			}
+		}
+		summary.Done += len(result.Done)
+		summary.Failed += len(result.Failed)
+		summary.Bytes += result.Bytes
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if cmd.DryRun {
+		cmd.Output().Printf("would act on %d duplicates from %d groups with %s, reclaiming %d bytes, %d would fail\n", summary.Done, summary.Groups, cmd.Name, summary.Bytes, summary.Failed)
+	} else {
+		cmd.Output().Printf("acted on %d duplicates from %d groups with %s, reclaiming %d bytes, %d changed, %d failed\n", summary.Done, summary.Groups, cmd.Name, summary.Bytes, summary.Changed, summary.Failed)
+	}
+	slog.Debug("command done")
+	if cmd.DryRun {
+		return nil
+	}
+	return failures.Partial(int64(summary.Failed))
+}
+
+// verify hashes the keeper and the duplicates of the group again, and returns
+// an error unless they all still have the indexed contents.
+func (cmd *Action) verify(db *sql.DB, group *duplicates.Group) error {
+	for _, entry := range append([]*duplicates.Entry{group.Keeper}, group.Duplicates...) {
+		algorithm := cmd.Hash
+		metadata, err := buckets.Load(db, entry.Bucket)
+		if err != nil {
+			return err
+		}
+		if metadata != nil && metadata.Algorithm != "" {
+			algorithm = metadata.Algorithm
+		}
+		if _, err := index.Verify(entry.Path, algorithm, entry.Hash, entry.Size); err != nil {
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+	}
+	return nil
+}
+
+// fileOf returns the given entry as seen by plugins.
+func fileOf(entry *duplicates.Entry) plugins.File {
+	file := plugins.File{Path: entry.Path, Size: entry.Size, Hash: entry.Hash}
+	if !entry.Modified.IsZero() {
+		file.Modified = entry.Modified.UTC().Format(time.RFC3339)
+	}
+	return file
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/plugins"
+)
+
+// Plugins is the command that lists the plugins extending the tool, and runs
+// the source and action plugins; filter and hasher plugins are used by the
+// index command (see --filter and --hash).
+type Plugins struct {
+	// List lists the plugins in the plugins directory.
+	List List `command:"list" alias:"ls" description:"List the plugins found in the plugins directory."`
+	// Source indexes the files listed by a source plugin.
+	Source Source `command:"source" description:"Index the files listed, and hashed, by a source plugin."`
+	// Action acts on duplicates with an action plugin.
+	Action Action `command:"action" description:"Act on the duplicates with an action plugin."`
+}
+
+// List is the command that lists the plugins in the plugins directory.
+type List struct {
+	base.Command
+}
+
+// Execute is the real implementation of the List command.
+func (cmd *List) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running plugins list command", "directory", plugins.Directory())
+
+	infos, err := plugins.Discover()
+	if err != nil {
+		slog.Error("error discovering plugins", "directory", plugins.Directory(), "error", err)
+		return err
+	}
+	out := cmd.Output()
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(infos)
+		if err != nil {
+			slog.Error("error marshalling plugins to JSON", "error", err)
+			return err
+		}
+		out.Println(string(data))
+	} else {
+		for _, info := range infos {
+			out.Printf("%-7s %-20s %s\n", info.Kind, out.Paint(base.Bold, info.Name), out.Paint(base.Faint, info.Path))
+		}
+		if len(infos) == 0 {
+			out.Printf("no plugins in %s\n", plugins.Directory())
+		}
+	}
+	slog.Debug("command done")
+	return nil
+}
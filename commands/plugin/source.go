@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/plugins"
+)
+
+// Source is the command that indexes the files listed by a source plugin,
+// for storage the tool cannot walk by itself; the plugin hashes the files
+// with the algorithm of the bucket. Since the files are not on disk, use
+// report --offline to list duplicates.
+type Source struct {
+	base.Command
+	base.Database
+	// Name is the name of the source plugin.
+	Name string `short:"N" long:"name" description:"The name of the source plugin, i.e. dedup-source-<name> in the plugins directory." required:"true"`
+	// Root is where the plugin lists the files from.
+	Root string `short:"r" long:"root" description:"Where the plugin lists the files from, in a form the plugin understands." optional:"true"`
+	// Bucket is the bucket files are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index files into (defaults to the name of the plugin)." optional:"true"`
+	// Hash is the hash algorithm the plugin computes.
+	Hash string `long:"hash" description:"The hash algorithm the plugin computes, which should match the one of the buckets to compare with." optional:"true" default:"sha256"`
+}
+
+// SourceSummary contains the outcome of indexing the files of a source plugin.
+type SourceSummary struct {
+	Files  int   `json:"files"`
+	Bytes  int64 `json:"bytes"`
+	Failed int   `json:"failed"`
+}
+
+// Execute is the real implementation of the Source command.
+func (cmd *Source) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running plugins source command", "name", cmd.Name, "root", cmd.Root, "database", cmd.Database, "bucket", cmd.Bucket)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	if cmd.Bucket == "" {
+		cmd.Bucket = cmd.Name
+	}
+	source, err := plugins.OpenSource(cmd.Name)
+	if err != nil {
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := buckets.Register(db, cmd.Bucket, cmd.Hash, []string{cmd.Name + ":" + cmd.Root}, map[string]any{"source": cmd.Name}); err != nil {
+		return err
+	}
+	stmt, err := db.Prepare("insert or replace into entries(hash, path, bucket, size, modified, indexed) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		return err
+	}
+	defer stmt.Close()
+
+	summary := &SourceSummary{}
+	now := time.Now().UTC().Format(time.RFC3339)
+	err = source.List(cmd.Root, cmd.Hash, func(file plugins.File) error {
+		if file.Hash == "" {
+			slog.Error("file not hashed by plugin", "path", file.Path, "error", &failures.HashError{Path: file.Path, Err: fmt.Errorf("no %s hash", cmd.Hash)})
+			summary.Failed++
+			return nil
+		}
+		var modified any
+		if file.Modified != "" {
+			modified = file.Modified
+		}
+		if _, err := stmt.Exec(file.Hash, file.Path, cmd.Bucket, file.Size, modified, now); err != nil {
+			slog.Error("error executing database insert statement", "path", file.Path, "error", err)
+			summary.Failed++
+			return nil
+		}
+		slog.Debug("file indexed", "path", file.Path, "hash", file.Hash, "size", file.Size)
+		summary.Files++
+		summary.Bytes += file.Size
+		return nil
+	})
+	if err != nil {
+		slog.Error("error listing files with plugin", "name", cmd.Name, "root", cmd.Root, "error", err)
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d files (%d bytes) from %s, %d failed\n", summary.Files, summary.Bytes, cmd.Name, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
@@ -0,0 +1,54 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// alias is the pattern of valid schema names for attached databases.
+var alias = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// attachment is a database to be attached under the given schema name.
+type attachment struct {
+	path   string
+	schema string
+}
+
+// parseAttachment parses an attachment in the form "path AS schema"; if the
+// schema name is omitted, the base name of the file is used.
+func parseAttachment(spec string) (*attachment, error) {
+	a := &attachment{path: strings.TrimSpace(spec)}
+	if fields := strings.Fields(spec); len(fields) >= 3 && strings.EqualFold(fields[len(fields)-2], "as") {
+		a.schema = fields[len(fields)-1]
+		a.path = strings.TrimSpace(spec[:strings.LastIndex(strings.ToLower(spec), " as ")])
+	} else {
+		a.schema = strings.TrimSuffix(filepath.Base(a.path), filepath.Ext(a.path))
+	}
+	if a.path == "" || !alias.MatchString(a.schema) {
+		return nil, fmt.Errorf("invalid attachment %q, expected \"path AS name\"", spec)
+	}
+	return a, nil
+}
+
+// attach attaches the given databases to the connection, so that they can be
+// referred to as schema.table in queries.
+func attach(ctx context.Context, conn *sql.Conn, specs []string) error {
+	for _, spec := range specs {
+		a, err := parseAttachment(spec)
+		if err != nil {
+			slog.Error("invalid attachment", "attachment", spec, "error", err)
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("attach database ? as %s", a.schema), a.path); err != nil {
+			slog.Error("error attaching database", "path", a.path, "schema", a.schema, "error", err)
+			return err
+		}
+		slog.Debug("database attached", "path", a.path, "schema", a.schema)
+	}
+	return nil
+}
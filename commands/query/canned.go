@@ -0,0 +1,28 @@
+package query
+
+import (
+	"sort"
+)
+
+// canned are the built-in queries, which can be run by name instead of
+// writing the SQL by hand; they include derived columns such as the number of
+// copies of each content and the space wasted by duplicates.
+var canned = map[string]string{
+	"duplicates": `select hash, count(*) as copies, max(size) as size, (count(*) - 1) * max(size) as wasted, group_concat(path, ', ') as paths
+from entries group by hash having count(*) > 1 order by wasted desc`,
+	"entries": `select e.hash, e.path, e.bucket, e.size, (select count(*) from entries d where d.hash = e.hash) as copies
+from entries e order by e.path`,
+	"largest": `select hash, path, bucket, size from entries order by size desc`,
+	"buckets": `select bucket, count(*) as files, sum(size) as size, count(distinct hash) as contents, sum(size) - (select sum(size) from (select max(size) as size from entries i where i.bucket is e.bucket group by hash)) as wasted
+from entries e group by bucket order by bucket`,
+}
+
+// cannedNames returns the sorted names of the canned queries.
+func cannedNames() []string {
+	names := make([]string, 0, len(canned))
+	for name := range canned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,201 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Query is the command that runs arbitrary SQL queries against the index
+// database and streams the results to the console, one row at a time, so
+// that even huge result sets do not need to be held in memory.
+type Query struct {
+	base.Command
+	base.Database
+	// Format is the output format of the result set.
+	Format string `short:"f" long:"format" description:"The format of the results." optional:"true" choice:"table" choice:"ndjson" default:"table"`
+	// Limit is the maximum number of rows to return.
+	Limit int64 `short:"l" long:"limit" description:"The maximum number of rows to return (0 for no limit)." optional:"true" default:"0"`
+	// Offset is the number of rows to skip.
+	Offset int64 `short:"o" long:"offset" description:"The number of rows to skip." optional:"true" default:"0"`
+	// Humanize renders size columns in human readable form.
+	Humanize bool `short:"H" long:"humanize" description:"Render sizes in human readable form (KB, MB, GB...)." optional:"true"`
+	// Canned is the name of a built-in query to run instead of the given SQL.
+	Canned string `short:"c" long:"canned" description:"The name of a built-in query to run (buckets, duplicates, entries, largest)." optional:"true"`
+	// File is the path of an SQL script to run, or - to read it from standard input.
+	File string `short:"i" long:"file" description:"The SQL script to run, with statements separated by semicolons (- for standard input)." optional:"true"`
+	// Attach are other databases to attach, each in the form "path AS name".
+	Attach []string `short:"a" long:"attach" description:"Attach another database to query across databases, as \"path AS name\" (repeatable)." optional:"true"`
+	// Timeout is the maximum time the queries are allowed to run.
+	Timeout time.Duration `short:"t" long:"timeout" description:"The maximum time the queries may run (e.g. 30s, 0 for no timeout)." optional:"true" default:"0s"`
+	// MaxColumnWidth is the maximum width of table columns.
+	MaxColumnWidth int `short:"w" long:"max-col-width" description:"The maximum width of table columns, longer values are truncated (0 for no limit)." optional:"true" default:"0"`
+	// Null is the text shown for NULL values in table format.
+	Null string `long:"null" description:"The text shown for NULL values, to tell them apart from the \"NULL\" string." optional:"true" default:"∅"`
+	// NoPager disables paging the output on interactive terminals.
+	NoPager bool `long:"no-pager" description:"Do not page the output on interactive terminals." optional:"true"`
+}
+
+// Execute is the real implementation of the Query command.
+func (cmd *Query) Execute(args []string) error {
+	cmd.Init()
+	script, err := cmd.script(args)
+	if err != nil {
+		return err
+	}
+	statements := splitStatements(script)
+	slog.Debug("running query command", "database", cmd.Database, "statements", len(statements))
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+	if len(statements) == 0 {
+		err := fmt.Errorf("no query specified")
+		slog.Error("invalid query", "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// queries can be cancelled with Ctrl-C or by the timeout expiring
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	// use a single connection, so that statements in a script can rely on
+	// temporary tables and settings from the previous ones
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		slog.Error("error connecting to database", "error", err)
+		return err
+	}
+	defer conn.Close()
+
+	if err := attach(ctx, conn, cmd.Attach); err != nil {
+		return err
+	}
+
+	format := cmd.Format
+	if cmd.AutomationFriendly {
+		format = "ndjson"
+	}
+
+	var output io.Writer = cmd.Output()
+	if format == "table" && !cmd.NoPager && isTerminal(os.Stdout) {
+		pager, err := startPager(os.Stdout)
+		if err != nil {
+			slog.Warn("error starting pager", "error", err)
+		} else {
+			defer pager.Close()
+			output = pager
+		}
+	}
+
+	options := &tableOptions{
+		humanize: cmd.Humanize,
+		maxWidth: cmd.MaxColumnWidth,
+		null:     cmd.Null,
+	}
+	for i, query := range statements {
+		// apply limit and offset by wrapping the user query, so that they
+		// work with any statement returning rows
+		params := []any{}
+		if (cmd.Limit > 0 || cmd.Offset > 0) && returnsRows(query) {
+			limit := cmd.Limit
+			if limit <= 0 {
+				limit = -1
+			}
+			query = fmt.Sprintf("select * from (%s) limit ? offset ?", query)
+			params = append(params, limit, cmd.Offset)
+		}
+
+		rows, err := conn.QueryContext(ctx, query, params...)
+		if err != nil {
+			if ctx.Err() != nil {
+				cmd.Output().Warnf("query cancelled before returning any rows: %v\n", ctx.Err())
+				return ctx.Err()
+			}
+			slog.Error("error running query", "query", query, "error", err)
+			return err
+		}
+		columns, _ := rows.Columns()
+		if len(columns) == 0 {
+			// statements such as CREATE or INSERT have no results to render,
+			// but must be stepped through to be executed
+			for rows.Next() {
+			}
+			err := rows.Err()
+			rows.Close()
+			if err != nil {
+				slog.Error("error running statement", "query", query, "error", err)
+				return err
+			}
+			continue
+		}
+		if i > 0 && format == "table" {
+			fmt.Fprintln(output)
+		}
+		count, err := render(rows, newRenderer(format, output, options))
+		rows.Close()
+		if ctx.Err() != nil {
+			cmd.Output().Warnf("query cancelled after returning %d rows: %v\n", count, ctx.Err())
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		slog.Debug("query done", "query", query, "rows", count)
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// script returns the SQL to run, from the canned queries, the script file or
+// standard input, or the command line arguments.
+func (cmd *Query) script(args []string) (string, error) {
+	switch {
+	case cmd.Canned != "":
+		query, ok := canned[cmd.Canned]
+		if !ok {
+			err := fmt.Errorf("unknown canned query %q (valid values: %s)", cmd.Canned, strings.Join(cannedNames(), ", "))
+			slog.Error("invalid canned query", "name", cmd.Canned, "error", err)
+			return "", err
+		}
+		return query, nil
+	case cmd.File == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			slog.Error("error reading script from standard input", "error", err)
+			return "", err
+		}
+		return string(data), nil
+	case cmd.File != "":
+		data, err := os.ReadFile(cmd.File)
+		if err != nil {
+			slog.Error("error reading script file", "path", cmd.File, "error", err)
+			return "", err
+		}
+		return string(data), nil
+	case len(args) == 0 && !isTerminal(os.Stdin):
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			slog.Error("error reading script from standard input", "error", err)
+			return "", err
+		}
+		return string(data), nil
+	}
+	return strings.Join(args, " "), nil
+}
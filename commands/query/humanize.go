@@ -0,0 +1,36 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sizeColumns are the names (or name suffixes) of the columns holding sizes
+// in bytes, which are rendered in human readable form when requested.
+var sizeColumns = []string{"size", "allocated", "bytes", "wasted"}
+
+// isSizeColumn returns whether the column with the given name holds a size in bytes.
+func isSizeColumn(name string) bool {
+	name = strings.ToLower(name)
+	for _, column := range sizeColumns {
+		if name == column || strings.HasSuffix(name, "_"+column) {
+			return true
+		}
+	}
+	return false
+}
+
+// humanize returns the given number of bytes in human readable form (e.g. 1.5 MB).
+func humanize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit && bytes > -unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	suffix := -1
+	for value >= unit || value <= -unit {
+		value /= unit
+		suffix++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGTPE"[suffix])
+}
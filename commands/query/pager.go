@@ -0,0 +1,55 @@
+package query
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultPager is the pager used when the PAGER environment variable is not set.
+const defaultPager = "less -FRX"
+
+// isTerminal returns whether the given file is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// pager pipes the output through an external pager process.
+type pager struct {
+	cmd   *exec.Cmd
+	input io.WriteCloser
+}
+
+// startPager starts the pager in the PAGER environment variable, or less,
+// writing to the given output.
+func startPager(output *os.File) (*pager, error) {
+	command := os.Getenv("PAGER")
+	if command == "" {
+		command = defaultPager
+	}
+	fields := strings.Fields(command)
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = os.Stderr
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &pager{cmd: cmd, input: input}, nil
+}
+
+// Write implements io.Writer.
+func (p *pager) Write(data []byte) (int, error) {
+	return p.input.Write(data)
+}
+
+// Close closes the pager input and waits for the user to quit it.
+func (p *pager) Close() error {
+	p.input.Close()
+	return p.cmd.Wait()
+}
@@ -0,0 +1,98 @@
+package query
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+)
+
+// renderer writes a result set to the output, one row at a time.
+type renderer interface {
+	// Header writes the names of the columns.
+	Header(columns []string) error
+	// Row writes a row of values.
+	Row(values []any) error
+	// Flush writes any buffered data to the output.
+	Flush() error
+}
+
+// newRenderer creates a renderer for the given format; the table options only
+// apply to the table format, machine readable formats keep the raw values.
+func newRenderer(format string, output io.Writer, options *tableOptions) renderer {
+	switch format {
+	case "ndjson":
+		return &ndjsonRenderer{encoder: json.NewEncoder(output)}
+	default:
+		return newTableRenderer(output, options)
+	}
+}
+
+// render streams the given rows through the given renderer and returns the
+// number of rows written.
+func render(rows *sql.Rows, r renderer) (int64, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		slog.Error("error reading result columns", "error", err)
+		return 0, err
+	}
+	if err := r.Header(columns); err != nil {
+		slog.Error("error writing result header", "error", err)
+		return 0, err
+	}
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var count int64
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			slog.Error("error reading result row", "error", err)
+			return count, err
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if err := r.Row(values); err != nil {
+			slog.Error("error writing result row", "error", err)
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading result rows", "error", err)
+		// still show the rows read so far
+		r.Flush()
+		return count, err
+	}
+	return count, r.Flush()
+}
+
+// ndjsonRenderer renders each row as a JSON object on its own line.
+type ndjsonRenderer struct {
+	encoder *json.Encoder
+	columns []string
+}
+
+// Header implements renderer.
+func (n *ndjsonRenderer) Header(columns []string) error {
+	n.columns = columns
+	return nil
+}
+
+// Row implements renderer.
+func (n *ndjsonRenderer) Row(values []any) error {
+	object := make(map[string]any, len(values))
+	for i, value := range values {
+		object[n.columns[i]] = value
+	}
+	return n.encoder.Encode(object)
+}
+
+// Flush implements renderer.
+func (n *ndjsonRenderer) Flush() error {
+	return nil
+}
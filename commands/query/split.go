@@ -0,0 +1,75 @@
+package query
+
+import (
+	"strings"
+)
+
+// splitStatements splits an SQL script into its statements, separated by
+// semicolons outside of quoted strings, identifiers and comments; empty
+// statements are dropped. Statements containing semicolons of their own, such
+// as CREATE TRIGGER, are not supported.
+func splitStatements(script string) []string {
+	statements := []string{}
+	var current strings.Builder
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// copy the quoted text verbatim, doubled quotes included
+			end := i + 1
+			for end < len(script) {
+				if script[end] == c {
+					if end+1 < len(script) && script[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end >= len(script) {
+				end = len(script) - 1
+			}
+			current.WriteString(script[i : end+1])
+			i = end
+		case c == '-' && i+1 < len(script) && script[i+1] == '-':
+			// skip line comments
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			current.WriteByte('\n')
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			// skip block comments
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			current.WriteByte(' ')
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// returnsRows returns whether the given statement is a query whose results
+// can be paginated by wrapping it in an outer SELECT.
+func returnsRows(statement string) bool {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return false
+	}
+	keyword := strings.ToLower(fields[0])
+	return keyword == "select" || keyword == "with" || keyword == "values"
+}
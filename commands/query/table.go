@@ -0,0 +1,150 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// tableRows is the number of rows that are aligned together in table format:
+// the table is flushed every so many rows, so that rows are streamed rather
+// than buffered until the end of the result set.
+const tableRows = 1000
+
+// columnSeparator is the padding between table columns.
+const columnSeparator = "  "
+
+// timestampLayout is the layout used to render timestamps.
+const timestampLayout = "2006-01-02 15:04:05"
+
+// tableOptions are the settings of the table renderer.
+type tableOptions struct {
+	// humanize renders size columns in human readable form.
+	humanize bool
+	// maxWidth is the maximum width of a column, longer values are truncated
+	// (0 for no limit).
+	maxWidth int
+	// null is the text shown for NULL values.
+	null string
+}
+
+// cell is a rendered value.
+type cell struct {
+	text    string
+	numeric bool
+}
+
+// tableRenderer renders the results as an aligned table, with numeric values
+// right-aligned and the others left-aligned.
+type tableRenderer struct {
+	output  io.Writer
+	options *tableOptions
+	columns []string
+	rows    [][]cell
+}
+
+// newTableRenderer creates a table renderer writing to the given output.
+func newTableRenderer(output io.Writer, options *tableOptions) *tableRenderer {
+	if options == nil {
+		options = &tableOptions{null: "NULL"}
+	}
+	return &tableRenderer{
+		output:  output,
+		options: options,
+	}
+}
+
+// Header implements renderer.
+func (t *tableRenderer) Header(columns []string) error {
+	t.columns = columns
+	header := make([]cell, len(columns))
+	for i, column := range columns {
+		header[i] = cell{text: t.truncate(column)}
+	}
+	t.rows = append(t.rows, header)
+	return nil
+}
+
+// Row implements renderer.
+func (t *tableRenderer) Row(values []any) error {
+	cells := make([]cell, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			cells[i] = cell{text: t.options.null}
+		case int64:
+			if t.options.humanize && isSizeColumn(t.columns[i]) {
+				cells[i] = cell{text: humanize(v), numeric: true}
+			} else {
+				cells[i] = cell{text: fmt.Sprintf("%d", v), numeric: true}
+			}
+		case float64:
+			cells[i] = cell{text: fmt.Sprintf("%g", v), numeric: true}
+		case time.Time:
+			cells[i] = cell{text: v.Local().Format(timestampLayout)}
+		default:
+			cells[i] = cell{text: fmt.Sprintf("%v", v)}
+		}
+		cells[i].text = t.truncate(cells[i].text)
+	}
+	t.rows = append(t.rows, cells)
+	if len(t.rows) >= tableRows {
+		return t.Flush()
+	}
+	return nil
+}
+
+// Flush implements renderer.
+func (t *tableRenderer) Flush() error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	widths := make([]int, len(t.columns))
+	for _, row := range t.rows {
+		for i, c := range row {
+			if width := utf8.RuneCountInString(c.text); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+	var line strings.Builder
+	for _, row := range t.rows {
+		line.Reset()
+		for i, c := range row {
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c.text))
+			if i > 0 {
+				line.WriteString(columnSeparator)
+			}
+			if c.numeric {
+				line.WriteString(padding + c.text)
+			} else if i < len(row)-1 {
+				line.WriteString(c.text + padding)
+			} else {
+				line.WriteString(c.text)
+			}
+		}
+		line.WriteByte('\n')
+		if _, err := io.WriteString(t.output, line.String()); err != nil {
+			return err
+		}
+	}
+	t.rows = t.rows[:0]
+	return nil
+}
+
+// truncate shortens the given text to the maximum column width, if any,
+// marking truncated values with an ellipsis.
+func (t *tableRenderer) truncate(text string) string {
+	// values spanning multiple lines would break the table layout
+	text = strings.ReplaceAll(text, "\n", " ")
+	if t.options.maxWidth <= 0 || utf8.RuneCountInString(text) <= t.options.maxWidth {
+		return text
+	}
+	runes := []rune(text)
+	if t.options.maxWidth == 1 {
+		return "…"
+	}
+	return string(runes[:t.options.maxWidth-1]) + "…"
+}
@@ -0,0 +1,299 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/s3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/minio/minio-go/v7"
+)
+
+// Replicate is the command that continuously copies the index database to
+// another path (e.g. a different disk or a network share) or to an object
+// store, so that the index survives the failure of the disk it lives on and
+// can be queried elsewhere; each replica is a consistent snapshot taken with
+// the SQLite online backup API, and is only taken when the database has
+// changed.
+type Replicate struct {
+	base.Command
+	base.Database
+	s3.Store
+	// Target is the path, or the s3:// URL, of the replica.
+	Target string `short:"t" long:"to" description:"The path of the database replica, or its object as s3://bucket/key." required:"true"`
+	// Interval is the time between checks for changes to replicate.
+	Interval time.Duration `short:"i" long:"interval" description:"How often the database is checked for changes to replicate." optional:"true" default:"1m"`
+	// PProf is the address where runtime profiles are served.
+	PProf string `long:"pprof" description:"Serve runtime profiles (net/http/pprof) on this address, e.g. localhost:6060." optional:"true"`
+	// Health is the address where the health and readiness endpoints are served.
+	Health string `long:"health" description:"Serve /healthz and /readyz on this address, e.g. localhost:8080." optional:"true"`
+	// ShutdownTimeout is how long an in-flight replication may take to
+	// complete once the replicator is told to stop.
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" description:"How long to wait for an in-flight replication to complete when stopping." optional:"true" default:"30s"`
+	// Once replicates the database once and exits.
+	Once bool `long:"once" description:"Replicate the database once and exit." optional:"true"`
+}
+
+// Summary contains the outcome of a replication run.
+type Summary struct {
+	Replicas int `json:"replicas"`
+	Failed   int `json:"failed"`
+}
+
+// Execute is the real implementation of the Replicate command.
+func (cmd *Replicate) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running replicate command", "database", cmd.Database, "target", cmd.Target, "interval", cmd.Interval)
+
+	if cmd.Interval <= 0 {
+		err := fmt.Errorf("invalid interval %s", cmd.Interval)
+		slog.Error("invalid replication interval", "error", err)
+		return err
+	}
+
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+	if cmd.PProf != "" {
+		stop, err := base.ServeProfiles(cmd.PProf)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	replicate, err := cmd.replicator()
+	if err != nil {
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	h := &health{}
+	if cmd.Health != "" {
+		shutdown, err := h.serve(cmd.Health)
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+	}
+
+	// once told to stop, let the replication in flight (if any) complete, but
+	// not beyond the timeout: then it is abandoned
+	flight, abandon := context.WithCancel(context.WithoutCancel(ctx))
+	defer abandon()
+	var expired atomic.Bool
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+		}
+		h.ready.Store(false)
+		slog.Info("stopping replication", "timeout", cmd.ShutdownTimeout)
+		select {
+		case <-finished:
+		case <-time.After(cmd.ShutdownTimeout):
+			slog.Error("replication did not complete in time, abandoning it", "timeout", cmd.ShutdownTimeout)
+			expired.Store(true)
+			abandon()
+		}
+	}()
+
+	// the data version only changes when other connections modify the
+	// database, so the same connection must be used throughout
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		slog.Error("error opening database connection", "error", err)
+		return err
+	}
+	defer conn.Close()
+
+	summary := &Summary{}
+	last := int64(-1)
+	ticker := time.NewTicker(cmd.Interval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		var version int64
+		if err := conn.QueryRowContext(ctx, "pragma data_version").Scan(&version); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			slog.Error("error reading database version", "error", err)
+			return err
+		}
+		if version != last {
+			// a replication in flight is not interrupted by a stop request
+			if err := replicate(flight, conn); err != nil {
+				slog.Error("error replicating database", "target", cmd.Target, "error", err)
+				summary.Failed++
+				h.ready.Store(false)
+			} else {
+				slog.Info("database replicated", "target", cmd.Target, "version", version)
+				summary.Replicas++
+				last = version
+				h.ready.Store(ctx.Err() == nil)
+			}
+		}
+		if cmd.Once {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("replicated database %d times to %s, %d failed\n", summary.Replicas, cmd.Target, summary.Failed)
+	}
+	if expired.Load() {
+		return fmt.Errorf("replication did not complete within %s of the request to stop", cmd.ShutdownTimeout)
+	}
+	if cmd.Once && summary.Failed > 0 {
+		return errors.New("replication failed")
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// replicator returns the function replicating the database to the target,
+// either a path or an object in an object store.
+func (cmd *Replicate) replicator() (func(context.Context, *sql.Conn) error, error) {
+	if !s3.IsURL(cmd.Target) {
+		return func(ctx context.Context, conn *sql.Conn) error {
+			return backup(ctx, conn, cmd.Target)
+		}, nil
+	}
+	bucket, key, err := s3.ParseURL(cmd.Target)
+	if err == nil && (key == "" || strings.HasSuffix(key, "/")) {
+		err = fmt.Errorf("invalid replica %q, must be s3://bucket/key", cmd.Target)
+	}
+	if err != nil {
+		slog.Error("error parsing replica URL", "target", cmd.Target, "error", err)
+		return nil, err
+	}
+	client, err := cmd.Client()
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, conn *sql.Conn) error {
+		return upload(ctx, conn, client, bucket, key)
+	}, nil
+}
+
+// backup copies the database to a temporary file next to the target, then
+// renames it over the target, so that readers of the replica never see a
+// partial copy.
+func backup(ctx context.Context, conn *sql.Conn, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	temporary := fmt.Sprintf("%s.%d.tmp", target, os.Getpid())
+	defer os.Remove(temporary)
+	if err := snapshot(ctx, conn, temporary); err != nil {
+		return err
+	}
+	return os.Rename(temporary, target)
+}
+
+// upload copies the database to a local temporary file, puts it into the
+// object store under a temporary key and then copies it over the replica, so
+// that readers of the replica never see a partial upload.
+func upload(ctx context.Context, conn *sql.Conn, client *minio.Client, bucket string, key string) error {
+	f, err := os.CreateTemp("", "dedup-replica-*.db")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := snapshot(ctx, conn, f.Name()); err != nil {
+		return err
+	}
+	temporary := fmt.Sprintf("%s.%d.tmp", key, os.Getpid())
+	if _, err := client.FPutObject(ctx, bucket, temporary, f.Name(), minio.PutObjectOptions{ContentType: "application/vnd.sqlite3"}); err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.RemoveObject(context.WithoutCancel(ctx), bucket, temporary, minio.RemoveObjectOptions{}); err != nil {
+			slog.Warn("error removing temporary replica", "bucket", bucket, "key", temporary, "error", err)
+		}
+	}()
+	// unlike a plain copy, composing is not limited to 5 GiB objects
+	_, err = client.ComposeObject(ctx, minio.CopyDestOptions{Bucket: bucket, Object: key}, minio.CopySrcOptions{Bucket: bucket, Object: temporary})
+	return err
+}
+
+// snapshot copies the database to the given path with the SQLite online
+// backup API, a few pages at a time so that the copy can be abandoned when
+// the context is done.
+func snapshot(ctx context.Context, conn *sql.Conn, path string) error {
+	replica, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer replica.Close()
+	destination, err := replica.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	return destination.Raw(func(d any) error {
+		return conn.Raw(func(s any) error {
+			dc, ok := d.(*sqlite3.SQLiteConn)
+			sc, ok2 := s.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("database connections do not support backups")
+			}
+			b, err := dc.Backup("main", sc, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := b.Step(pages)
+				if err == nil && !done {
+					err = ctx.Err()
+				}
+				if err != nil {
+					b.Finish()
+					return err
+				}
+				if done {
+					return b.Finish()
+				}
+			}
+		})
+	})
+}
+
+// pages is the number of database pages copied at a time.
+const pages = 1024
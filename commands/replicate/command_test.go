@@ -0,0 +1,51 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dihedron/dedup/internal/testutil"
+)
+
+func TestBackup(t *testing.T) {
+	db := testutil.Database(t)
+	if _, err := db.Exec("insert into entries(hash, path, bucket, size) values('h', '/a', 'b', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	target := filepath.Join(t.TempDir(), "replicas", "dedup.db")
+
+	// an abandoned replication leaves the replica as it was
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := backup(ctx, conn, target); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the replication abandoned, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("expected no replica, got %v", err)
+	}
+
+	if err := backup(context.Background(), conn, target); err != nil {
+		t.Fatal(err)
+	}
+	replica, err := sql.Open("sqlite3", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	var path string
+	if err := replica.QueryRow("select path from entries").Scan(&path); err != nil || path != "/a" {
+		t.Errorf("expected the entry replicated, got %q, %v", path, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(target), "*.tmp")); len(matches) > 0 {
+		t.Errorf("expected no temporary files left, got %v", matches)
+	}
+}
@@ -0,0 +1,50 @@
+package replicate
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// health tells supervisors (e.g. systemd watchdogs or Kubernetes probes)
+// whether the replicator is alive and whether its replica is up to date.
+type health struct {
+	// ready is set once the database has been replicated, and cleared when
+	// replication fails or the replicator is shutting down.
+	ready atomic.Bool
+}
+
+// serve exposes /healthz, which answers as long as the process runs, and
+// /readyz, which answers successfully only when the replica is up to date,
+// on the given address; it returns a function shutting the server down.
+func (h *health) serve(address string) (func(), error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		slog.Error("error listening for health checks", "address", address, "error", err)
+		return nil, err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("error serving health checks", "address", address, "error", err)
+		}
+	}()
+	slog.Info("serving health checks", "address", listener.Addr().String())
+	return func() {
+		server.Close()
+	}, nil
+}
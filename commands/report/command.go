@@ -0,0 +1,314 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/duplicates"
+)
+
+// Report is the command that lists the groups of duplicate files in the
+// index, along with the copy that would be kept by the other commands.
+type Report struct {
+	base.Command
+	// Databases are the paths of the databases to report on; duplicates are
+	// computed across all of them without merging them.
+	Databases []string `short:"d" long:"database" description:"Path to the database; repeat to compute duplicates across several databases." required:"true" default:"./dedup.db"`
+	// Offline skips checking the files on disk.
+	Offline bool `long:"offline" description:"Do not check the files on disk (e.g. for indexes of other machines); sizes are taken from the index." optional:"true"`
+	// Prefer is the ordered list of path prefixes the keeper is preferably chosen from.
+	Prefer []string `short:"P" long:"prefer" description:"Prefer keeping the copy under this path prefix; repeat in order of priority (defaults to keeping the oldest copy)." optional:"true"`
+	// PreferOwners is the ordered list of users whose copy is preferably kept.
+	PreferOwners []string `long:"prefer-owner" description:"Prefer keeping the copy owned by this user, by name or identifier, among those equally preferred by path; repeat in order of priority (needs index --ownership)." optional:"true"`
+	// Bucket restricts the report to the duplicates in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose duplicates should be reported (all buckets if not specified)." optional:"true"`
+	// Within restricts the report to the duplicates under the given directory.
+	Within string `short:"W" long:"within" description:"Only report duplicates under this directory." optional:"true"`
+	// Against restricts the report to the files having a copy in the given bucket.
+	Against string `short:"g" long:"against" description:"Only report files having a copy in this bucket, which is never reported as duplicate." optional:"true"`
+	// Groups restricts the report to the duplicate groups with the given identifiers.
+	Groups []int64 `short:"G" long:"group" description:"Only report the duplicate group with this identifier (repeatable)." optional:"true"`
+	// Tagged restricts the report to the duplicate groups having any of the given tags.
+	Tagged []string `long:"tagged" description:"Only report the duplicate groups having this tag, on the group or any of its files (repeatable)." optional:"true"`
+	// Untagged excludes from the report the duplicate groups having any of the given tags.
+	Untagged []string `long:"untagged" description:"Skip the duplicate groups having this tag, on the group or any of its files (repeatable)." optional:"true"`
+	// Accepted includes the duplicate groups accepted as they are.
+	Accepted bool `long:"accepted" description:"Also report the duplicate groups that were accepted as they are." optional:"true"`
+	// Sidecars lists sidecar files with their media file.
+	Sidecars bool `long:"sidecars" description:"Attach sidecar files (XMP, THM, SRT, AAE) to their media file instead of reporting them on their own." optional:"true"`
+	// Format is the output format, unless automation friendly output is
+	// requested.
+	Format string `short:"f" long:"format" description:"The output format: csv lists one file per line, for triage in a spreadsheet." optional:"true" choice:"text" choice:"csv" default:"text"`
+	// MinCopies is the minimum number of copies in a reported group.
+	MinCopies int `long:"min-copies" description:"Only report the groups with at least this many copies." optional:"true" default:"2"`
+	// MinWasted is the minimum number of reclaimable bytes in a reported group.
+	MinWasted int64 `long:"min-wasted" description:"Only report the groups where at least this many bytes can be reclaimed." optional:"true" default:"0"`
+	// Extensions are the file extensions of the reported groups.
+	Extensions []string `short:"x" long:"extension" description:"Only report the groups of files with this extension, e.g. jpg (repeatable)." optional:"true"`
+	// MaxResults is the maximum number of reported groups.
+	MaxResults int `long:"max-results" description:"Stop after reporting this many groups (0 for no limit)." optional:"true" default:"0"`
+}
+
+// errEnough stops the scan once the maximum number of groups was reported.
+var errEnough = errors.New("enough groups reported")
+
+// Group is the representation of a group of duplicates in the report.
+type Group struct {
+	ID          int64    `json:"id"`
+	Hash        string   `json:"hash"`
+	Size        int64    `json:"size"`
+	Keeper      string   `json:"keeper"`
+	Duplicates  []string `json:"duplicates"`
+	References  []string `json:"references,omitempty"`
+	Archived    bool     `json:"archived"`
+	Reclaimable int64    `json:"reclaimable"`
+	// Volumes are the mount points of the compressing or deduplicating
+	// filesystems the duplicates are on, where the space actually freed may
+	// differ from the reclaimable bytes.
+	Volumes []string `json:"volumes,omitempty"`
+	// Sidecars maps the files having sidecars to their paths.
+	Sidecars map[string][]string `json:"sidecars,omitempty"`
+	// Forks maps the files having paired resource forks to their paths.
+	Forks map[string][]string `json:"forks,omitempty"`
+	// Owners maps the files to their ownership, when it was recorded.
+	Owners map[string]*duplicates.Ownership `json:"owners,omitempty"`
+}
+
+// Summary contains the totals of a report.
+type Summary struct {
+	Groups      int   `json:"groups"`
+	Duplicates  int   `json:"duplicates"`
+	Reclaimable int64 `json:"reclaimable"`
+	// Owners are the bytes taken up by the duplicates of each owner, when
+	// ownership was recorded, to tell who owns the reclaimable space.
+	Owners map[string]int64 `json:"owners,omitempty"`
+	// Volumes are the compressing or deduplicating filesystems found.
+	Volumes []*Volume `json:"volumes,omitempty"`
+}
+
+// Execute is the real implementation of the Report command.
+func (cmd *Report) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running report command", "databases", cmd.Databases, "bucket", cmd.Bucket, "within", cmd.Within, "against", cmd.Against)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := (&base.Database{Database: cmd.Databases[0]}).Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	federated := len(cmd.Databases) > 1
+	if federated {
+		if err := duplicates.Federate(db, cmd.Databases[0], cmd.Databases[1:]); err != nil {
+			return err
+		}
+	}
+
+	// files in other databases are told apart by the database they are in
+	location := func(entry *duplicates.Entry) string {
+		if federated {
+			return entry.Source + ":" + entry.Path
+		}
+		return entry.Path
+	}
+
+	extensions := map[string]bool{}
+	for _, extension := range cmd.Extensions {
+		extensions[strings.ToLower(strings.TrimPrefix(extension, "."))] = true
+	}
+	// selected returns whether the group passes the report filters
+	selected := func(group *duplicates.Group, reclaimable int64) bool {
+		if 1+len(group.References)+len(group.Duplicates) < cmd.MinCopies || reclaimable < cmd.MinWasted {
+			return false
+		}
+		if len(extensions) > 0 {
+			return extensions[strings.ToLower(strings.TrimPrefix(filepath.Ext(group.Keeper.Path), "."))]
+		}
+		return true
+	}
+
+	var table *csvReport
+	if cmd.Format == "csv" && !cmd.AutomationFriendly {
+		if table, err = newCSVReport(cmd.Output()); err != nil {
+			slog.Error("error writing CSV report", "error", err)
+			return err
+		}
+	}
+
+	// filesystems that compress or deduplicate data on their own are noted,
+	// since the reclaimable bytes are then only an estimate
+	vols := &volumes{}
+	on := func(group *duplicates.Group) []*Volume {
+		if cmd.Offline {
+			return nil
+		}
+		found := []*Volume{}
+		for _, entry := range group.Duplicates {
+			if volume := vols.of(entry.Path); volume != nil && !slices.Contains(found, volume) {
+				found = append(found, volume)
+			}
+		}
+		return found
+	}
+
+	owners, err := duplicates.LookupOwners(cmd.PreferOwners)
+	if err != nil {
+		slog.Error("error looking up preferred owners", "owners", cmd.PreferOwners, "error", err)
+		return err
+	}
+	summary := &Summary{}
+	options := &duplicates.Options{Bucket: cmd.Bucket, Prefer: cmd.Prefer, Owners: owners, Within: cmd.Within, Against: cmd.Against, Groups: cmd.Groups, Tagged: cmd.Tagged, Untagged: cmd.Untagged, Accepted: cmd.Accepted, Federated: federated, Offline: cmd.Offline, Sidecars: cmd.Sidecars}
+	if err := duplicates.Scan(db, options, func(group *duplicates.Group) error {
+		if cmd.MaxResults > 0 && summary.Groups >= cmd.MaxResults {
+			return errEnough
+		}
+		reclaimable := group.Reclaimable()
+		if !selected(group, reclaimable) {
+			return nil
+		}
+		summary.Groups++
+		summary.Duplicates += len(group.Duplicates)
+		summary.Reclaimable += reclaimable
+		for _, entry := range group.Duplicates {
+			if owner := entry.Owner(); owner != "" {
+				if summary.Owners == nil {
+					summary.Owners = map[string]int64{}
+				}
+				summary.Owners[owner] += entry.Size
+			}
+		}
+		volumes := on(group)
+		if table != nil {
+			if err := table.Write(group, location); err != nil {
+				slog.Error("error writing CSV report", "group", group.ID, "error", err)
+				return err
+			}
+			return nil
+		}
+		if cmd.AutomationFriendly {
+			g := &Group{
+				ID:          group.ID,
+				Hash:        group.Hash,
+				Size:        group.Size,
+				Keeper:      location(group.Keeper),
+				Archived:    group.Archived(),
+				Reclaimable: reclaimable,
+			}
+			for _, volume := range volumes {
+				g.Volumes = append(g.Volumes, volume.Mount)
+			}
+			for _, entry := range group.Duplicates {
+				g.Duplicates = append(g.Duplicates, location(entry))
+			}
+			for _, entry := range group.References {
+				g.References = append(g.References, location(entry))
+			}
+			for _, entry := range append(append([]*duplicates.Entry{group.Keeper}, group.References...), group.Duplicates...) {
+				if len(entry.Sidecars) > 0 {
+					if g.Sidecars == nil {
+						g.Sidecars = map[string][]string{}
+					}
+					g.Sidecars[location(entry)] = entry.Sidecars
+				}
+				if len(entry.Forks) > 0 {
+					if g.Forks == nil {
+						g.Forks = map[string][]string{}
+					}
+					g.Forks[location(entry)] = entry.Forks
+				}
+				if ownership := entry.Ownership(); ownership != nil {
+					if g.Owners == nil {
+						g.Owners = map[string]*duplicates.Ownership{}
+					}
+					g.Owners[location(entry)] = ownership
+				}
+			}
+			data, err := json.Marshal(g)
+			if err != nil {
+				slog.Error("error marshalling group to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+			return nil
+		}
+		out := cmd.Output()
+		out.Printf("%s %s (%d bytes, %d reclaimable)\n", out.Paint(base.Bold, fmt.Sprintf("#%d", group.ID)), group.Hash, group.Size, reclaimable)
+		// files are shown with their ownership, when it was recorded
+		describe := func(entry *duplicates.Entry) string {
+			if ownership := entry.Ownership(); ownership != nil {
+				return location(entry) + " " + out.Paint(base.Faint, "("+ownership.String()+")")
+			}
+			return location(entry)
+		}
+		sidecars := func(entry *duplicates.Entry) {
+			for _, sidecar := range entry.Sidecars {
+				out.Printf("       + %s\n", out.Paint(base.Faint, sidecar))
+			}
+			for _, fork := range entry.Forks {
+				out.Printf("       + %s (resource fork)\n", out.Paint(base.Faint, fork))
+			}
+		}
+		if group.Archived() {
+			out.Printf("  %s %s: %s\n", out.Paint(base.Cyan, "already in archive"), group.Keeper.Bucket, describe(group.Keeper))
+		} else {
+			out.Printf("  %s %s\n", out.Paint(base.Green, "keep"), describe(group.Keeper))
+		}
+		sidecars(group.Keeper)
+		for _, volume := range volumes {
+			out.Printf("  %s on %s\n", out.Paint(base.Yellow, "note"), volume)
+		}
+		for _, entry := range group.References {
+			out.Printf("  %s  %s\n", out.Paint(base.Cyan, "ref"), describe(entry))
+			sidecars(entry)
+		}
+		for _, entry := range group.Duplicates {
+			out.Printf("  %s  %s\n", out.Paint(base.Red, "dup"), describe(entry))
+			sidecars(entry)
+		}
+		return nil
+	}); err != nil && !errors.Is(err, errEnough) {
+		return err
+	}
+
+	summary.Volumes = vols.found
+	if table != nil {
+		for _, volume := range vols.found {
+			slog.Warn("duplicates on a compressing or deduplicating filesystem, logical savings may differ from physical savings", "volume", volume.String())
+		}
+		if err := table.Close(); err != nil {
+			slog.Error("error writing CSV report", "error", err)
+			return err
+		}
+	} else if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		out := cmd.Output()
+		out.Printf("%d duplicates in %d groups, %d bytes reclaimable\n", summary.Duplicates, summary.Groups, summary.Reclaimable)
+		owners := make([]string, 0, len(summary.Owners))
+		for owner := range summary.Owners {
+			owners = append(owners, owner)
+		}
+		slices.Sort(owners)
+		for _, owner := range owners {
+			out.Printf("  %s: %d bytes in duplicates\n", owner, summary.Owners[owner])
+		}
+		for _, volume := range summary.Volumes {
+			out.Printf("%s: some duplicates are on %s, which compresses or deduplicates data: logical savings may differ from physical savings\n", out.Paint(base.Yellow, "warning"), volume)
+		}
+	}
+	slog.Debug("command done")
+	return nil
+}
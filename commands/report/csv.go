@@ -0,0 +1,75 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/dihedron/dedup/duplicates"
+)
+
+// csvHeader is the header line of the CSV report; the decision column is left
+// empty, for reviewers to fill in and import back.
+var csvHeader = []string{"group", "hash", "role", "keep", "path", "bucket", "size", "modified", "owner", "mode", "decision"}
+
+// csvReport writes the duplicate groups as CSV records, one per file, so that
+// they can be triaged in a spreadsheet.
+type csvReport struct {
+	writer *csv.Writer
+}
+
+func newCSVReport(output io.Writer) (*csvReport, error) {
+	r := &csvReport{writer: csv.NewWriter(output)}
+	return r, r.writer.Write(csvHeader)
+}
+
+// Write writes the records of the files in the given group.
+func (r *csvReport) Write(group *duplicates.Group, location func(*duplicates.Entry) string) error {
+	row := func(role string, entry *duplicates.Entry) error {
+		keep := ""
+		if role == "keep" {
+			keep = "yes"
+		}
+		modified := ""
+		if !entry.Modified.IsZero() {
+			modified = entry.Modified.UTC().Format(time.RFC3339)
+		}
+		owner, mode := "", ""
+		if ownership := entry.Ownership(); ownership != nil && ownership.User != "" {
+			owner, mode = ownership.User+":"+ownership.Group, ownership.Mode
+		}
+		return r.writer.Write([]string{
+			strconv.FormatInt(group.ID, 10),
+			group.Hash,
+			role,
+			keep,
+			location(entry),
+			entry.Bucket,
+			strconv.FormatInt(entry.Size, 10),
+			modified,
+			owner,
+			mode,
+			"",
+		})
+	}
+	if err := row("keep", group.Keeper); err != nil {
+		return err
+	}
+	for _, entry := range group.References {
+		if err := row("ref", entry); err != nil {
+			return err
+		}
+	}
+	for _, entry := range group.Duplicates {
+		if err := row("dup", entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *csvReport) Close() error {
+	r.writer.Flush()
+	return r.writer.Error()
+}
@@ -0,0 +1,64 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Volume is a filesystem where the space freed by removing a file may differ
+// from its size as accounted by the report, because it compresses or
+// deduplicates data on its own.
+type Volume struct {
+	// Mount is the mount point of the filesystem.
+	Mount string `json:"mount"`
+	// Type is the type of the filesystem, e.g. btrfs or zfs.
+	Type string `json:"type"`
+	// Compression is the compression algorithm, if known.
+	Compression string `json:"compression,omitempty"`
+	// Dedup is the deduplication setting of ZFS datasets, if known.
+	Dedup string `json:"dedup,omitempty"`
+}
+
+// String returns a short description of the volume for the text report.
+func (v *Volume) String() string {
+	settings := []string{}
+	if v.Compression != "" {
+		settings = append(settings, "compression="+v.Compression)
+	}
+	if v.Dedup != "" {
+		settings = append(settings, "dedup="+v.Dedup)
+	}
+	if len(settings) == 0 {
+		return fmt.Sprintf("%s at %s", v.Type, v.Mount)
+	}
+	return fmt.Sprintf("%s at %s (%s)", v.Type, v.Mount, strings.Join(settings, ", "))
+}
+
+// volumes caches the volumes of the reported files by device, so that each
+// filesystem is probed once.
+type volumes struct {
+	cache map[uint64]*Volume
+	// found are the volumes found so far, in order of discovery.
+	found []*Volume
+}
+
+// of returns the volume the file at the given path is on, or nil if it is on
+// a filesystem where logical and physical sizes match.
+func (v *volumes) of(path string) *Volume {
+	dev, ok := device(path)
+	if !ok {
+		return nil
+	}
+	if volume, ok := v.cache[dev]; ok {
+		return volume
+	}
+	if v.cache == nil {
+		v.cache = map[uint64]*Volume{}
+	}
+	volume := probe(path)
+	v.cache[dev] = volume
+	if volume != nil {
+		v.found = append(v.found, volume)
+	}
+	return volume
+}
@@ -0,0 +1,155 @@
+//go:build linux
+
+package report
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystem magic numbers, as per <linux/magic.h> and OpenZFS.
+const (
+	btrfsMagic = 0x9123683e
+	zfsMagic   = 0x2fc12fc1
+)
+
+// device returns the device the file at the given path is on.
+func device(path string) (uint64, bool) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
+
+// probe returns the btrfs or ZFS volume the file at the given path is on, if
+// it compresses (or, for ZFS, deduplicates) data.
+func probe(path string) *Volume {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return nil
+	}
+	var kind string
+	switch uint32(fs.Type) {
+	case btrfsMagic:
+		kind = "btrfs"
+	case zfsMagic:
+		kind = "zfs"
+	default:
+		return nil
+	}
+	m := mountOf(path, kind)
+	if m == nil {
+		m = &mount{point: "?"}
+	}
+	volume := &Volume{Mount: m.point, Type: kind}
+	switch kind {
+	case "btrfs":
+		// compression is a mount option (files may also be compressed on
+		// their own with chattr +c, which is not detected)
+		for _, option := range strings.Split(m.options, ",") {
+			if key, value, ok := strings.Cut(option, "="); ok && (key == "compress" || key == "compress-force") {
+				volume.Compression = value
+			}
+		}
+		if volume.Compression == "" {
+			return nil
+		}
+	case "zfs":
+		// compression and dedup are properties of the dataset; if they cannot
+		// be read, the volume is reported anyway since compression is on by
+		// default
+		volume.Compression, volume.Dedup = properties(m.source)
+		if volume.Compression == "off" && volume.Dedup == "off" {
+			return nil
+		}
+	}
+	slog.Debug("files on compressing or deduplicating filesystem", "path", path, "volume", volume.String())
+	return volume
+}
+
+// mount is an entry of the mount table.
+type mount struct {
+	point   string
+	source  string
+	options string
+}
+
+// mountOf returns the mount of the given type the path is on, from the
+// mount table of the process.
+func mountOf(path string, kind string) *mount {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var best *mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// e.g. 36 35 98:0 / /mnt rw,noatime shared:1 - btrfs /dev/sda1 rw,compress=zstd:3
+		before, after, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, super := strings.Fields(before), strings.Fields(after)
+		if len(fields) < 6 || len(super) < 3 || super[0] != kind {
+			continue
+		}
+		point := unescape(fields[4])
+		if point != "/" && path != point && !strings.HasPrefix(path, point+"/") {
+			continue
+		}
+		if best == nil || len(point) > len(best.point) {
+			best = &mount{point: point, source: unescape(super[1]), options: fields[5] + "," + super[2]}
+		}
+	}
+	return best
+}
+
+// unescape decodes the octal escapes (e.g. \040 for spaces) of the mount table.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// properties returns the compression and dedup properties of the given ZFS
+// dataset, or empty strings if the zfs command is not available.
+func properties(dataset string) (string, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "zfs", "get", "-H", "-o", "value", "compression,dedup", dataset).Output()
+	if err != nil {
+		slog.Debug("cannot read ZFS dataset properties", "dataset", dataset, "error", err)
+		return "", ""
+	}
+	values := strings.Fields(string(output))
+	if len(values) != 2 {
+		return "", ""
+	}
+	return values[0], values[1]
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package report
+
+// device returns the device the file at the given path is on; on this
+// platform filesystems are not probed.
+func device(path string) (uint64, bool) {
+	return 0, false
+}
+
+// probe returns the compressing or deduplicating volume the file at the given
+// path is on; on this platform filesystems are not probed.
+func probe(path string) *Volume {
+	return nil
+}
@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/failures"
+	"github.com/minio/minio-go/v7"
+)
+
+// S3 is the command that indexes the objects in a bucket of an S3-compatible
+// object store (AWS S3, MinIO, Ceph...). Whenever the object metadata tells
+// the hash of the contents, i.e. the ETag of single-part objects is their
+// MD5 or the object has a full-object checksum, it is used instead of
+// downloading the object; only the others are downloaded and hashed. Objects
+// are indexed by s3:// URL; since they are not on disk, use report --offline
+// to list duplicates.
+type S3 struct {
+	base.Command
+	base.Database
+	Store
+	// URL is the bucket, and optional prefix, to index.
+	URL string `short:"u" long:"url" description:"The bucket and optional prefix of the objects to index, as s3://bucket/prefix." required:"true"`
+	// Versions indexes all the versions of the objects, not only the latest.
+	Versions bool `long:"versions" description:"Index all the versions of the objects in a versioned bucket, not only the latest." optional:"true"`
+	// NoDownload skips the objects whose hash is not in their metadata.
+	NoDownload bool `long:"no-download" description:"Skip the objects whose hash cannot be taken from their ETag or checksum, instead of downloading them." optional:"true"`
+	// Bucket is the bucket objects are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index objects into." optional:"true" default:"s3"`
+	// Hash is the hash algorithm to compute.
+	Hash string `long:"hash" description:"The hash algorithm to compute, which should match the one of the buckets to compare with; md5, sha1 and sha256 can often be taken from the object metadata." optional:"true" default:"sha256"`
+	// Workers is the number of objects examined at the same time.
+	Workers int `long:"workers" description:"The number of objects examined and downloaded at the same time." optional:"true" default:"4"`
+}
+
+// Summary contains the outcome of an S3 indexing run.
+type Summary struct {
+	// Objects is the number of objects indexed.
+	Objects int `json:"objects"`
+	// Bytes is the total size of the objects indexed.
+	Bytes int64 `json:"bytes"`
+	// Downloaded is the number of objects that had to be downloaded.
+	Downloaded int `json:"downloaded"`
+	// Skipped is the number of objects skipped with --no-download.
+	Skipped int `json:"skipped"`
+	// Failed is the number of objects that could not be indexed.
+	Failed int `json:"failed"`
+}
+
+// Execute is the real implementation of the S3 command.
+func (cmd *S3) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running s3 command", "url", cmd.URL, "endpoint", cmd.Endpoint, "database", cmd.Database, "bucket", cmd.Bucket, "workers", cmd.Workers)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	name, prefix, err := ParseURL(cmd.URL)
+	if err != nil {
+		slog.Error("error parsing URL", "url", cmd.URL, "error", err)
+		return err
+	}
+	cmd.Hash = strings.ToLower(cmd.Hash)
+	if _, err := index.NewHasher(cmd.Hash); err != nil {
+		slog.Error("invalid hash algorithm", "error", err)
+		return err
+	}
+	if cmd.Workers < 1 {
+		cmd.Workers = 1
+	}
+
+	client, err := cmd.Client()
+	if err != nil {
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	root := fmt.Sprintf("s3://%s/%s/%s", cmd.Endpoint, name, prefix)
+	if err := buckets.Register(db, cmd.Bucket, cmd.Hash, []string{root}, map[string]any{"s3": true, "versions": cmd.Versions}); err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(`insert or replace into entries(hash, path, bucket, size, modified, indexed, md5, sha1, sha256, etag, checksum, storage_class, version_id)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		return err
+	}
+	defer stmt.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary := &Summary{}
+	var lock sync.Mutex
+	objects := make(chan minio.ObjectInfo, cmd.Workers)
+	var wg sync.WaitGroup
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i := 0; i < cmd.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range objects {
+				path := pathOf(name, object, cmd.Versions)
+				d, err := cmd.digest(ctx, client, name, object)
+				lock.Lock()
+				switch {
+				case errors.Is(err, errNotInMetadata):
+					slog.Info("skipping object whose hash is not in its metadata", "path", path)
+					summary.Skipped++
+				case err != nil:
+					slog.Error("error hashing object", "path", path, "error", &failures.HashError{Path: path, Err: err})
+					summary.Failed++
+				default:
+					if _, err := stmt.Exec(d.hashes[cmd.Hash], path, cmd.Bucket, object.Size, object.LastModified.UTC().Format(time.RFC3339), now,
+						column(d.hashes, "md5"), column(d.hashes, "sha1"), column(d.hashes, "sha256"),
+						d.etag, d.checksum, d.storageClass, d.versionID); err != nil {
+						slog.Error("error executing database insert statement", "path", path, "error", err)
+						summary.Failed++
+						break
+					}
+					slog.Debug("object indexed", "path", path, "hash", d.hashes[cmd.Hash], "size", object.Size, "downloaded", d.downloaded)
+					summary.Objects++
+					summary.Bytes += object.Size
+					if d.downloaded {
+						summary.Downloaded++
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	options := minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithVersions: cmd.Versions}
+	for object := range client.ListObjects(ctx, name, options) {
+		if object.Err != nil {
+			if ctx.Err() == nil {
+				slog.Error("error listing objects", "url", cmd.URL, "error", &failures.WalkError{Path: cmd.URL, Err: object.Err})
+				lock.Lock()
+				summary.Failed++
+				lock.Unlock()
+			}
+			break
+		}
+		if object.IsDeleteMarker || (strings.HasSuffix(object.Key, "/") && object.Size == 0) {
+			// neither deleted objects nor folder placeholders have contents
+			continue
+		}
+		select {
+		case objects <- object:
+		case <-ctx.Done():
+		}
+	}
+	close(objects)
+	wg.Wait()
+	if ctx.Err() != nil {
+		slog.Warn("indexing interrupted")
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d objects (%d bytes), %d downloaded, %d skipped, %d failed\n", summary.Objects, summary.Bytes, summary.Downloaded, summary.Skipped, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// pathOf returns the path an object is indexed as; when all versions are
+// indexed, those other than the latest carry their version ID, so that the
+// path of the latest one does not change as new versions are added.
+func pathOf(bucket string, object minio.ObjectInfo, versions bool) string {
+	path := fmt.Sprintf("s3://%s/%s", bucket, object.Key)
+	if versions && !object.IsLatest && object.VersionID != "" {
+		path += "?versionId=" + url.QueryEscape(object.VersionID)
+	}
+	return path
+}
+
+// column returns the hash computed with the given algorithm as a value for the
+// corresponding database column, or nil if it is not known.
+func column(hashes map[string]string, algorithm string) any {
+	if hash, ok := hashes[algorithm]; ok {
+		return hash
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/minio/minio-go/v7"
+)
+
+// errNotInMetadata is returned when the hash of an object cannot be taken
+// from its metadata and downloading it is not allowed.
+var errNotInMetadata = errors.New("hash not in object metadata")
+
+// md5ETag matches the ETags that may be the MD5 of the contents: those of
+// multipart objects have a -N suffix with the number of parts.
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// digest is what is known about an object after examining it.
+type digest struct {
+	// hashes maps the algorithms to the hex-encoded hashes of the contents
+	// that are known, from the metadata or from downloading the object.
+	hashes map[string]string
+	// downloaded is whether the object had to be downloaded.
+	downloaded bool
+	// etag is the ETag of the object.
+	etag any
+	// checksum is the checksum stored with the object, as type:mode:value,
+	// e.g. sha256:composite:<base64>-3 for a multipart object.
+	checksum any
+	// storageClass is the storage class of the object.
+	storageClass any
+	// versionID is the version of the object, in versioned buckets.
+	versionID any
+}
+
+// digest returns the hashes of the given object, taking them from the ETag
+// and checksums of the object when possible and downloading it otherwise.
+func (cmd *S3) digest(ctx context.Context, client *minio.Client, bucket string, object minio.ObjectInfo) (*digest, error) {
+	stat, err := client.StatObject(ctx, bucket, object.Key, minio.StatObjectOptions{VersionID: object.VersionID, Checksum: true})
+	if err != nil {
+		return nil, err
+	}
+	d := &digest{
+		hashes:       hashesOf(stat),
+		etag:         nullable(strings.Trim(stat.ETag, `"`)),
+		checksum:     nullable(checksumOf(stat)),
+		storageClass: nullable(object.StorageClass),
+		versionID:    nullable(stat.VersionID),
+	}
+	if d.storageClass == nil {
+		d.storageClass = nullable(stat.StorageClass)
+	}
+	if d.checksum == nil && isMultipart(stat.ETag) {
+		d.checksum = nullable(cmd.composite(ctx, client, bucket, stat))
+	}
+	if _, ok := d.hashes[cmd.Hash]; ok {
+		return d, nil
+	}
+	if cmd.NoDownload {
+		return nil, errNotInMetadata
+	}
+
+	// the hash is not in the metadata, the object must be read
+	h, err := index.NewHasher(cmd.Hash)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := client.GetObject(ctx, bucket, object.Key, minio.GetObjectOptions{VersionID: object.VersionID})
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	size, err := io.Copy(h, reader)
+	if err != nil {
+		return nil, err
+	}
+	if size != stat.Size {
+		return nil, fmt.Errorf("read %d bytes of %d", size, stat.Size)
+	}
+	d.hashes[cmd.Hash] = hex.EncodeToString(h.Sum(nil))
+	d.downloaded = true
+	return d, nil
+}
+
+// hashesOf returns the hashes of the contents of an object that can be taken
+// from its metadata: the ETag of single-part objects is their MD5 unless they
+// are encrypted with KMS or customer keys, and SHA-1 and SHA-256 checksums
+// are those of the whole contents unless they are composite.
+func hashesOf(stat minio.ObjectInfo) map[string]string {
+	hashes := map[string]string{}
+	etag := strings.ToLower(strings.Trim(stat.ETag, `"`))
+	encryption := stat.Metadata.Get("X-Amz-Server-Side-Encryption")
+	encrypted := strings.HasPrefix(encryption, "aws:kms") || stat.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != ""
+	if md5ETag.MatchString(etag) && !encrypted {
+		hashes["md5"] = etag
+	}
+	for algorithm, value := range map[string]string{"sha1": stat.ChecksumSHA1, "sha256": stat.ChecksumSHA256} {
+		if value == "" || strings.Contains(value, "-") || strings.EqualFold(stat.ChecksumMode, "COMPOSITE") {
+			continue
+		}
+		if raw, err := base64.StdEncoding.DecodeString(value); err == nil {
+			hashes[algorithm] = hex.EncodeToString(raw)
+		}
+	}
+	return hashes
+}
+
+// checksumOf returns the checksum stored with an object as type:mode:value,
+// or an empty string if it has none.
+func checksumOf(stat minio.ObjectInfo) string {
+	mode := strings.ToLower(stat.ChecksumMode)
+	if mode == "" {
+		mode = "full_object"
+		if isMultipart(stat.ETag) {
+			mode = "composite"
+		}
+	}
+	for _, checksum := range []struct{ algorithm, value string }{
+		{"sha256", stat.ChecksumSHA256},
+		{"sha1", stat.ChecksumSHA1},
+		{"crc64nvme", stat.ChecksumCRC64NVME},
+		{"crc32c", stat.ChecksumCRC32C},
+		{"crc32", stat.ChecksumCRC32},
+	} {
+		if checksum.value != "" {
+			return checksum.algorithm + ":" + mode + ":" + checksum.value
+		}
+	}
+	return ""
+}
+
+// composite computes the composite checksum of a multipart object from the
+// checksums of its parts, for object stores reporting those but not the
+// checksum of the object; it returns an empty string if they are not
+// available.
+func (cmd *S3) composite(ctx context.Context, client *minio.Client, bucket string, stat minio.ObjectInfo) string {
+	attributes, err := client.GetObjectAttributes(ctx, bucket, stat.Key, minio.ObjectAttributesOptions{VersionID: stat.VersionID})
+	if err != nil {
+		// not all object stores implement it
+		slog.Debug("error reading object attributes", "key", stat.Key, "error", err)
+		return ""
+	}
+	if attributes.ObjectParts.IsTruncated || len(attributes.ObjectParts.Parts) == 0 {
+		return ""
+	}
+	first := attributes.ObjectParts.Parts[0]
+	var algorithm string
+	var t minio.ChecksumType
+	switch {
+	case first.ChecksumSHA256 != "":
+		algorithm, t = "sha256", minio.ChecksumSHA256
+	case first.ChecksumSHA1 != "":
+		algorithm, t = "sha1", minio.ChecksumSHA1
+	case first.ChecksumCRC32C != "":
+		algorithm, t = "crc32c", minio.ChecksumCRC32C
+	case first.ChecksumCRC32 != "":
+		algorithm, t = "crc32", minio.ChecksumCRC32
+	default:
+		return ""
+	}
+	parts := make([]minio.ObjectPart, 0, len(attributes.ObjectParts.Parts))
+	for _, part := range attributes.ObjectParts.Parts {
+		parts = append(parts, minio.ObjectPart{
+			PartNumber:     part.PartNumber,
+			Size:           int64(part.Size),
+			ChecksumCRC32:  part.ChecksumCRC32,
+			ChecksumCRC32C: part.ChecksumCRC32C,
+			ChecksumSHA1:   part.ChecksumSHA1,
+			ChecksumSHA256: part.ChecksumSHA256,
+		})
+	}
+	checksum, err := t.CompositeChecksum(parts)
+	if err != nil {
+		slog.Debug("error computing composite checksum", "key", stat.Key, "error", err)
+		return ""
+	}
+	return fmt.Sprintf("%s:composite:%s-%d", algorithm, checksum.Encoded(), len(parts))
+}
+
+// isMultipart returns whether the given ETag is that of a multipart object.
+func isMultipart(etag string) bool {
+	return strings.Contains(etag, "-")
+}
+
+// nullable returns the given value, or nil if it is empty.
+func nullable(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,59 @@
+package s3
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Store holds the options to connect to an S3-compatible object store, shared
+// by the commands that read from or write to one.
+type Store struct {
+	// Endpoint is the address of the object store.
+	Endpoint string `long:"endpoint" description:"The address of the object store." optional:"true" default:"s3.amazonaws.com"`
+	// Region is the region of the bucket.
+	Region string `long:"region" description:"The region of the bucket (detected if not given)." optional:"true"`
+	// AccessKey is the access key to authenticate with.
+	AccessKey string `long:"access-key" description:"The access key to authenticate with (defaults to the AWS environment, credentials file or instance role)." optional:"true"`
+	// SecretKey is the secret key to authenticate with.
+	SecretKey string `long:"secret-key" description:"The secret key to authenticate with (better set through DEDUP_<COMMAND>_SECRET_KEY)." optional:"true"`
+	// PlainHTTP connects to the object store without TLS.
+	PlainHTTP bool `long:"plain-http" description:"Connect to the object store over plain HTTP, e.g. to a local MinIO." optional:"true"`
+}
+
+// Client returns a client of the object store, authenticated with the given
+// keys or, if none, with the AWS environment, credentials file or instance
+// role.
+func (s *Store) Client() (*minio.Client, error) {
+	creds := credentials.NewChainCredentials([]credentials.Provider{&credentials.EnvAWS{}, &credentials.FileAWSCredentials{}, &credentials.IAM{}})
+	if s.AccessKey != "" {
+		creds = credentials.NewStaticV4(s.AccessKey, s.SecretKey, "")
+	}
+	client, err := minio.New(s.Endpoint, &minio.Options{Creds: creds, Secure: !s.PlainHTTP, Region: s.Region})
+	if err != nil {
+		slog.Error("error creating object store client", "endpoint", s.Endpoint, "error", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// IsURL returns whether the value is an s3:// URL rather than a path.
+func IsURL(value string) bool {
+	return strings.HasPrefix(value, "s3://")
+}
+
+// ParseURL returns the bucket and the prefix in the given s3:// URL.
+func ParseURL(value string) (string, string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 URL %q, must be s3://bucket/prefix", value)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
@@ -0,0 +1,156 @@
+package snapshots
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Snapshots is the command that compares the buckets of a series of snapshots
+// of the same tree taken at different times (see bucket --snapshot), e.g.
+// rsync-style dated backups: it reports the contents common to all snapshots
+// and those unique to each, and estimates how much space converting the
+// series into hardlinked snapshots would save.
+type Snapshots struct {
+	base.Command
+	base.Database
+	// Series is the series of snapshots to compare.
+	Series string `short:"s" long:"series" description:"The series of snapshots to compare." required:"true"`
+}
+
+// Snapshot contains the figures of a snapshot in the series.
+type Snapshot struct {
+	Bucket      string `json:"bucket"`
+	Files       int64  `json:"files"`
+	Bytes       int64  `json:"bytes"`
+	UniqueFiles int64  `json:"unique_files"`
+	UniqueBytes int64  `json:"unique_bytes"`
+}
+
+// Summary contains the figures of the whole series.
+type Summary struct {
+	Series         string `json:"series"`
+	Snapshots      int    `json:"snapshots"`
+	Contents       int64  `json:"contents"`
+	CommonContents int64  `json:"common_contents"`
+	CommonBytes    int64  `json:"common_bytes"`
+	LogicalBytes   int64  `json:"logical_bytes"`
+	LinkedBytes    int64  `json:"linked_bytes"`
+	SavedBytes     int64  `json:"saved_bytes"`
+}
+
+// Execute is the real implementation of the Snapshots command.
+func (cmd *Snapshots) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running snapshots command", "database", cmd.Database, "series", cmd.Series)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// list the snapshots oldest first, those with no time last
+	rows, err := db.Query("select name from buckets where series = ? order by taken is null, taken, name", cmd.Series)
+	if err != nil {
+		slog.Error("error querying snapshots", "series", cmd.Series, "error", err)
+		return err
+	}
+	snapshots := []*Snapshot{}
+	byBucket := map[string]*Snapshot{}
+	names := []string{}
+	for rows.Next() {
+		s := &Snapshot{}
+		if err := rows.Scan(&s.Bucket); err != nil {
+			rows.Close()
+			slog.Error("error reading snapshot", "error", err)
+			return err
+		}
+		snapshots = append(snapshots, s)
+		byBucket[s.Bucket] = s
+		names = append(names, s.Bucket)
+	}
+	rows.Close()
+	if len(snapshots) == 0 {
+		err := fmt.Errorf("no snapshots in series %s", cmd.Series)
+		slog.Error("error comparing snapshots", "error", err)
+		return err
+	}
+	if err := buckets.Compatible(db, names...); err != nil {
+		return err
+	}
+
+	summary := &Summary{Series: cmd.Series, Snapshots: len(snapshots)}
+	rows, err = db.Query("select e.bucket, e.hash, coalesce(e.size, 0), c.snapshots from entries e join (select hash, count(distinct bucket) as snapshots from entries where bucket in (select name from buckets where series = ?) group by hash) c on c.hash = e.hash where e.bucket in (select name from buckets where series = ?) order by e.hash", cmd.Series, cmd.Series)
+	if err != nil {
+		slog.Error("error querying snapshot contents", "series", cmd.Series, "error", err)
+		return err
+	}
+	defer rows.Close()
+	last := ""
+	for rows.Next() {
+		var bucket, hash string
+		var size, count int64
+		if err := rows.Scan(&bucket, &hash, &size, &count); err != nil {
+			slog.Error("error reading snapshot entry", "error", err)
+			return err
+		}
+		s := byBucket[bucket]
+		s.Files++
+		s.Bytes += size
+		summary.LogicalBytes += size
+		if count == 1 {
+			s.UniqueFiles++
+			s.UniqueBytes += size
+		}
+		if hash != last {
+			// with hardlinks, each content is stored once for the series
+			last = hash
+			summary.Contents++
+			summary.LinkedBytes += size
+			if count == int64(len(snapshots)) {
+				summary.CommonContents++
+				summary.CommonBytes += size
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading snapshot entries", "error", err)
+		return err
+	}
+	summary.SavedBytes = summary.LogicalBytes - summary.LinkedBytes
+
+	if cmd.AutomationFriendly {
+		for _, s := range snapshots {
+			data, err := json.Marshal(s)
+			if err != nil {
+				slog.Error("error marshalling snapshot to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		}
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		for _, s := range snapshots {
+			cmd.Output().Printf("%s\t%d files\t%d bytes\t%d unique files\t%d unique bytes\n", s.Bucket, s.Files, s.Bytes, s.UniqueFiles, s.UniqueBytes)
+		}
+		cmd.Output().Printf("%d contents (%d bytes) common to all %d snapshots, out of %d\n", summary.CommonContents, summary.CommonBytes, summary.Snapshots, summary.Contents)
+		percent := 0.0
+		if summary.LogicalBytes > 0 {
+			percent = float64(summary.SavedBytes) * 100 / float64(summary.LogicalBytes)
+		}
+		cmd.Output().Printf("%d bytes in all snapshots, %d bytes if hardlinked: %d bytes (%.1f%%) saved\n", summary.LogicalBytes, summary.LinkedBytes, summary.SavedBytes, percent)
+	}
+	slog.Debug("command done")
+	return nil
+}
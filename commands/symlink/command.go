@@ -0,0 +1,411 @@
+package symlink
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/journal"
+	"github.com/dihedron/dedup/privileges"
+)
+
+// action is the name of the symlink action in the journal.
+const action = "symlink"
+
+// Symlink is the command that replaces the duplicate copies of each content
+// with symbolic links to the copy to keep; all replacements are journaled, so
+// that they can be undone. Resource forks paired with their data file at
+// indexing time are never replaced on their own, and stay next to the link.
+type Symlink struct {
+	base.Command
+	base.Database
+	base.Preview
+	// Prefer is the ordered list of path prefixes the keeper is preferably chosen from.
+	Prefer []string `short:"P" long:"prefer" description:"Prefer keeping the copy under this path prefix; repeat in order of priority (defaults to keeping the oldest copy)." optional:"true"`
+	// PreferOwners is the ordered list of users whose copy is preferably kept.
+	PreferOwners []string `long:"prefer-owner" description:"Prefer keeping the copy owned by this user, by name or identifier, among those equally preferred by path; repeat in order of priority (needs index --ownership)." optional:"true"`
+	// Bucket restricts the replacement to the duplicates in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose duplicates should be replaced (all buckets if not specified)." optional:"true"`
+	// Within restricts the replacement to the duplicates under the given directory.
+	Within string `short:"W" long:"within" description:"Only act on duplicates under this directory." optional:"true"`
+	// Against restricts the replacement to the files having a copy in the given bucket.
+	Against string `short:"g" long:"against" description:"Only act on files having a copy in this bucket, which is kept untouched." optional:"true"`
+	// Groups restricts the replacement to the duplicate groups with the given identifiers.
+	Groups []int64 `short:"G" long:"group" description:"Only act on the duplicate group with this identifier (repeatable)." optional:"true"`
+	// Tagged restricts the replacement to the duplicate groups having any of the given tags.
+	Tagged []string `long:"tagged" description:"Only act on the duplicate groups having this tag, on the group or any of its files (repeatable)." optional:"true"`
+	// Untagged excludes from the replacement the duplicate groups having any of the given tags.
+	Untagged []string `long:"untagged" description:"Skip the duplicate groups having this tag, on the group or any of its files (repeatable)." optional:"true"`
+	// Sidecars leaves the sidecar files of the duplicates next to the links.
+	Sidecars bool `long:"sidecars" description:"Attach sidecar files (XMP, THM, SRT, AAE) to their media file: they are left next to the symlink instead of being replaced on their own." optional:"true"`
+	// Relative creates links relative to the directory of the duplicate.
+	Relative bool `short:"r" long:"relative" description:"Create relative symlinks instead of absolute ones." optional:"true"`
+	// Unsafe are additional patterns of path components where symlinks must not be created.
+	Unsafe []string `short:"u" long:"unsafe" description:"Additional pattern of path components where symlinks are known to break (repeatable)." optional:"true"`
+	// Force replaces duplicates even where symlinks are known to break.
+	Force bool `long:"force" description:"Replace duplicates even where symlinks are known to break applications." optional:"true"`
+	// Simulate computes the space that would be reclaimed without changing anything.
+	Simulate bool `short:"s" long:"simulate" description:"Only compute the space that would be reclaimed, without writing anything to disk or to the database." optional:"true"`
+	// Undo reverts the last run, replacing the symlinks with copies of the keeper.
+	Undo bool `long:"undo" description:"Undo the last run, restoring the duplicates from their keepers." optional:"true"`
+	// Hash is the hash algorithm of the buckets indexed before it was recorded.
+	Hash string `long:"hash" description:"The hash algorithm the index was built with, for buckets that do not record it." optional:"true" default:"sha256"`
+}
+
+// Summary contains the outcome of a symlink run.
+type Summary struct {
+	Run      string `json:"run"`
+	Groups   int    `json:"groups"`
+	Replaced int    `json:"replaced,omitempty"`
+	Restored int    `json:"restored,omitempty"`
+	Unsafe   int    `json:"unsafe,omitempty"`
+	Denied   int    `json:"denied,omitempty"`
+	Changed  int    `json:"changed,omitempty"`
+	Failed   int    `json:"failed"`
+	Bytes    int64  `json:"bytes"`
+	// Reclaimed is the space actually freed on disk (or that would be freed,
+	// when simulating), which accounts for hard links and allocation.
+	Reclaimed int64 `json:"reclaimed"`
+	Simulated bool  `json:"simulated,omitempty"`
+}
+
+// Execute is the real implementation of the Symlink command.
+func (cmd *Symlink) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running symlink command", "database", cmd.Database, "bucket", cmd.Bucket, "undo", cmd.Undo)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	cmd.Simulate = cmd.Simulate || cmd.DryRun
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var summary *Summary
+	if cmd.Undo && cmd.Simulate {
+		err := fmt.Errorf("cannot simulate an undo")
+		slog.Error("invalid options", "error", err)
+		return err
+	}
+	if cmd.Undo {
+		summary, err = cmd.undo(db)
+	} else {
+		summary, err = cmd.replace(db)
+	}
+	if err != nil {
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if cmd.Undo {
+		cmd.Output().Printf("restored %d duplicates (%d bytes) of run %s, %d failed\n", summary.Restored, summary.Bytes, summary.Run, summary.Failed)
+	} else if cmd.Simulate {
+		cmd.Output().Printf("would replace %d duplicates (%d bytes) from %d groups with symlinks, reclaiming %d bytes on disk (%d unsafe, %d denied)\n", summary.Replaced, summary.Bytes, summary.Groups, summary.Reclaimed, summary.Unsafe, summary.Denied)
+	} else {
+		cmd.Output().Printf("replaced %d duplicates (%d bytes) from %d groups with symlinks, reclaiming %d bytes on disk, %d unsafe, %d denied, %d changed, %d failed (run %s)\n", summary.Replaced, summary.Bytes, summary.Groups, summary.Reclaimed, summary.Unsafe, summary.Denied, summary.Changed, summary.Failed, summary.Run)
+	}
+	slog.Debug("command done")
+	if cmd.Simulate {
+		return nil
+	}
+	return failures.Partial(int64(summary.Failed + summary.Denied))
+}
+
+// replace replaces duplicates with symlinks to their keeper.
+func (cmd *Symlink) replace(db *sql.DB) (*Summary, error) {
+	patterns := append(append([]string{}, unsafePatterns...), cmd.Unsafe...)
+
+	owners, err := duplicates.LookupOwners(cmd.PreferOwners)
+	if err != nil {
+		slog.Error("error looking up preferred owners", "owners", cmd.PreferOwners, "error", err)
+		return nil, err
+	}
+	// collect the groups first, since replacing updates the entries being read
+	var groups []*duplicates.Group
+	if err := duplicates.Scan(db, &duplicates.Options{Bucket: cmd.Bucket, Prefer: cmd.Prefer, Owners: owners, Within: cmd.Within, Against: cmd.Against, Groups: cmd.Groups, Tagged: cmd.Tagged, Untagged: cmd.Untagged, Sidecars: cmd.Sidecars}, func(group *duplicates.Group) error {
+		groups = append(groups, group)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// check all the replacements up-front, so that those that would fail for
+	// lack of privileges are reported before anything is changed
+	denied := map[string]error{}
+	for _, group := range groups {
+		for _, entry := range group.Duplicates {
+			if err := privileges.CanReplace(entry.Path); err != nil {
+				denied[entry.Path] = err
+				if !cmd.DryRun {
+					slog.Warn("duplicate cannot be replaced, skipping", "path", entry.Path, "error", err)
+				}
+			}
+		}
+	}
+
+	j := journal.New(db)
+	summary := &Summary{Run: j.Run(), Simulated: cmd.Simulate}
+	for _, group := range groups {
+		summary.Groups++
+		if !cmd.Simulate {
+			// the duplicates are destroyed, so the contents must still be the
+			// indexed ones, on both sides
+			if err := cmd.verify(db, group, denied); err != nil {
+				slog.Warn("files changed since indexing, skipping group", "hash", group.Hash, "keeper", group.Keeper.Path, "error", err)
+				summary.Changed += len(group.Duplicates)
+				continue
+			}
+		}
+		replaced := []*duplicates.Entry{}
+		for _, entry := range group.Duplicates {
+			if err, ok := denied[entry.Path]; ok {
+				if cmd.DryRun {
+					cmd.WouldFail("replace with symlink", entry.Path, group.Keeper.Path, entry.Size, err)
+				}
+				summary.Denied++
+				continue
+			}
+			if !cmd.Force {
+				if unsafe, pattern := isUnsafe(entry.Path, patterns); unsafe {
+					slog.Warn("symlinks are known to break here, skipping", "path", entry.Path, "pattern", pattern)
+					summary.Unsafe++
+					continue
+				}
+				if unsafe, pattern := isUnsafe(group.Keeper.Path, patterns); unsafe {
+					slog.Warn("symlinks to this keeper are known to break, skipping", "path", entry.Path, "keeper", group.Keeper.Path, "pattern", pattern)
+					summary.Unsafe++
+					continue
+				}
+			}
+			if cmd.Simulate {
+				slog.Info("duplicate would be replaced with symlink", "path", entry.Path, "keeper", group.Keeper.Path)
+				if cmd.DryRun {
+					cmd.Would("replace with symlink", entry.Path, group.Keeper.Path, entry.Size)
+				}
+				replaced = append(replaced, entry)
+				summary.Replaced++
+				summary.Bytes += entry.Size
+				continue
+			}
+			if err := cmd.link(db, j, group, entry); errors.Is(err, duplicates.ErrSameFile) {
+				slog.Warn("duplicate is its keeper under another path, skipping", "path", entry.Path, "keeper", group.Keeper.Path)
+				continue
+			} else if err != nil {
+				slog.Error("error replacing duplicate with symlink", "path", entry.Path, "keeper", group.Keeper.Path, "error", err)
+				summary.Failed++
+				continue
+			}
+			slog.Info("duplicate replaced with symlink", "path", entry.Path, "keeper", group.Keeper.Path)
+			for _, fork := range entry.Forks {
+				slog.Debug("resource fork left next to symlink", "path", fork, "symlink", entry.Path)
+			}
+			replaced = append(replaced, entry)
+			summary.Replaced++
+			summary.Bytes += entry.Size
+		}
+		summary.Reclaimed += (&duplicates.Group{Hash: group.Hash, Size: group.Size, Keeper: group.Keeper, Duplicates: replaced}).Reclaimable()
+	}
+	if cmd.Simulate {
+		summary.Run = ""
+	}
+	return summary, nil
+}
+
+// algorithm returns the hash algorithm of the given bucket, or the one given
+// on the command line if the bucket does not record it.
+func (cmd *Symlink) algorithm(db *sql.DB, bucket string) (string, error) {
+	metadata, err := buckets.Load(db, bucket)
+	if err != nil {
+		return "", err
+	}
+	if metadata == nil || metadata.Algorithm == "" {
+		return cmd.Hash, nil
+	}
+	return metadata.Algorithm, nil
+}
+
+// verify hashes the keeper and the duplicates of the group again, and returns
+// an error unless they all still have the indexed contents; duplicates that
+// are not going to be replaced are not checked.
+func (cmd *Symlink) verify(db *sql.DB, group *duplicates.Group, denied map[string]error) error {
+	for _, entry := range append([]*duplicates.Entry{group.Keeper}, group.Duplicates...) {
+		if _, ok := denied[entry.Path]; ok {
+			continue
+		}
+		algorithm, err := cmd.algorithm(db, entry.Bucket)
+		if err != nil {
+			return err
+		}
+		if _, err := index.Verify(entry.Path, algorithm, entry.Hash, entry.Size); err != nil {
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+	}
+	return nil
+}
+
+// link atomically replaces the duplicate with a symlink to the keeper of its
+// group, unless it is the keeper itself under another path.
+func (cmd *Symlink) link(db *sql.DB, j *journal.Journal, group *duplicates.Group, entry *duplicates.Entry) error {
+	keeper := group.Keeper
+	if err := duplicates.SameFile(entry.Path, keeper.Path); err != nil {
+		return err
+	}
+	target, err := filepath.Abs(keeper.Path)
+	if err != nil {
+		return err
+	}
+	if cmd.Relative {
+		// indexed paths are relative to the current directory if the index
+		// was built from a relative root
+		dir, err := filepath.Abs(filepath.Dir(entry.Path))
+		if err != nil {
+			return err
+		}
+		if target, err = filepath.Rel(dir, target); err != nil {
+			return err
+		}
+	}
+	info, err := os.Lstat(entry.Path)
+	if err != nil {
+		return err
+	}
+	// create the link next to the duplicate, then rename it over the duplicate
+	temporary := filepath.Join(filepath.Dir(entry.Path), fmt.Sprintf(".%s.dedup-%d", filepath.Base(entry.Path), os.Getpid()))
+	if err := os.Symlink(target, temporary); err != nil {
+		return err
+	}
+	// the link belongs to the owner of the duplicate, as far as allowed
+	if err := privileges.Own(info, temporary); err != nil {
+		slog.Warn("error preserving owner and group of symlink", "path", entry.Path, "error", err)
+	}
+	if err := os.Rename(temporary, entry.Path); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	if err := j.Add(&journal.Record{
+		Action: action,
+		Path:   entry.Path,
+		Target: keeper.Path,
+		Hash:   entry.Hash,
+		Bucket: entry.Bucket,
+		Size:   entry.Size,
+		Group:  group.ID,
+	}); err != nil {
+		return err
+	}
+	// symlinks are not indexed, so the duplicate is not an entry anymore
+	if _, err := db.Exec("delete from entries where hash = ? and path = ?", entry.Hash, entry.Path); err != nil {
+		slog.Error("error removing replaced entry", "path", entry.Path, "error", err)
+		return err
+	}
+	return nil
+}
+
+// undo restores the duplicates replaced in the last run as copies of their
+// keepers, verifying that the keepers still have the original contents.
+func (cmd *Symlink) undo(db *sql.DB) (*Summary, error) {
+	run, err := journal.LastRun(db, action)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{Run: run}
+	if run == "" {
+		slog.Info("nothing to undo")
+		return summary, nil
+	}
+	records, err := journal.Records(db, run, action)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err := cmd.restore(db, record); err != nil {
+			slog.Error("error restoring duplicate", "path", record.Path, "keeper", record.Target, "error", err)
+			summary.Failed++
+			continue
+		}
+		slog.Info("duplicate restored", "path", record.Path, "keeper", record.Target)
+		summary.Restored++
+		summary.Bytes += record.Size
+	}
+	return summary, nil
+}
+
+// restore replaces the symlink with a copy of the keeper.
+func (cmd *Symlink) restore(db *sql.DB, record *journal.Record) error {
+	if info, err := os.Lstat(record.Path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s is not a symlink anymore", record.Path)
+	}
+	in, err := os.Open(record.Target)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	temporary := filepath.Join(filepath.Dir(record.Path), fmt.Sprintf(".%s.dedup-%d", filepath.Base(record.Path), os.Getpid()))
+	out, err := os.OpenFile(temporary, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	algorithm, err := cmd.algorithm(db, record.Bucket)
+	if err != nil {
+		out.Close()
+		os.Remove(temporary)
+		return err
+	}
+	h, err := index.NewHasher(algorithm)
+	if err != nil {
+		out.Close()
+		os.Remove(temporary)
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(out, h), in); err != nil {
+		out.Close()
+		os.Remove(temporary)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	if hash := hex.EncodeToString(h.Sum(nil)); hash != record.Hash {
+		os.Remove(temporary)
+		return fmt.Errorf("keeper %s has changed (hash %s, expected %s)", record.Target, hash, record.Hash)
+	}
+	if err := privileges.Preserve(record.Target, info, temporary); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	os.Chtimes(temporary, info.ModTime(), info.ModTime())
+	if err := os.Rename(temporary, record.Path); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	if _, err := db.Exec("insert or replace into entries(hash, path, bucket, size) values(?, ?, ?, ?)", record.Hash, record.Path, record.Bucket, record.Size); err != nil {
+		slog.Error("error restoring entry", "path", record.Path, "error", err)
+		return err
+	}
+	return journal.Undone(db, record)
+}
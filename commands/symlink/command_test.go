@@ -0,0 +1,66 @@
+package symlink
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dihedron/dedup/duplicates"
+	"github.com/dihedron/dedup/internal/testutil"
+	"github.com/dihedron/dedup/journal"
+)
+
+func TestLinkSameFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// duplicate is the path of the duplicate of keeper a, relative to
+		// the current directory, which holds files a and b and the hard link
+		// l to a; $ stands for the current directory.
+		duplicate string
+		err       error
+	}{
+		{name: "absolute path of the keeper", duplicate: "$/a", err: duplicates.ErrSameFile},
+		{name: "unclean path of the keeper", duplicate: "./a", err: duplicates.ErrSameFile},
+		{name: "hard link to the keeper", duplicate: "l", err: duplicates.ErrSameFile},
+		{name: "copy", duplicate: "b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			dir := t.TempDir()
+			t.Chdir(dir)
+			for _, name := range []string{"a", "b"} {
+				if err := os.WriteFile(name, []byte("contents"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Link("a", "l"); err != nil {
+				t.Fatal(err)
+			}
+			path := test.duplicate
+			if path[0] == '$' {
+				path = dir + path[1:]
+			}
+			keeper := &duplicates.Entry{Hash: "h", Path: "a", Size: 8}
+			entry := &duplicates.Entry{Hash: "h", Path: path, Size: 8}
+			group := &duplicates.Group{Hash: "h", Size: 8, Keeper: keeper, Duplicates: []*duplicates.Entry{entry}}
+
+			err := (&Symlink{}).link(db, journal.New(db), group, entry)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			// the keeper always keeps its contents
+			if data, err := os.ReadFile(filepath.Join(dir, "a")); err != nil || string(data) != "contents" {
+				t.Fatalf("keeper lost: %q, %v", data, err)
+			}
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced := info.Mode()&os.ModeSymlink != 0; replaced != (test.err == nil) {
+				t.Errorf("expected replaced %t, got %t", test.err == nil, replaced)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package symlink
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// unsafePatterns are the patterns of path components under which replacing
+// files with symlinks is known to break applications: sync clients upload the
+// link rather than the content, packages and repositories get corrupted.
+var unsafePatterns = []string{
+	"Dropbox",
+	"OneDrive*",
+	"Google Drive",
+	"iCloud Drive",
+	"Mobile Documents",
+	"*.photoslibrary",
+	"*.app",
+	".git",
+	".svn",
+	".hg",
+}
+
+// isUnsafe returns whether any of the components of the given path matches any
+// of the given patterns.
+func isUnsafe(path string, patterns []string) (bool, string) {
+	for _, component := range strings.Split(filepath.ToSlash(path), "/") {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, component); matched {
+				return true, pattern
+			}
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,54 @@
+package tag
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Add is the command that attaches a tag to entries and duplicate groups;
+// adding a tag that is already attached replaces its note.
+type Add struct {
+	base.Command
+	base.Database
+	Targets
+	// Label is the label of the tag.
+	Label string `short:"l" long:"label" description:"The label of the tag (e.g. reviewed, keep-both)." required:"true"`
+	// Note is an optional free-text note.
+	Note string `short:"n" long:"note" description:"A free-text note to attach along with the label." optional:"true"`
+}
+
+// Execute is the real implementation of the Add command.
+func (cmd *Add) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running tag add command", "database", cmd.Database, "label", cmd.Label)
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	targets, err := cmd.resolve(db)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		err := errors.New("no paths or groups to tag")
+		slog.Error("nothing to tag", "error", err)
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, t := range targets {
+		if _, err := db.Exec("insert into tags(kind, target, label, note, created) values(?, ?, ?, nullif(?, ''), ?) on conflict(kind, target, label) do update set note = excluded.note",
+			t.kind, t.id, cmd.Label, cmd.Note, now); err != nil {
+			slog.Error("error adding tag", "kind", t.kind, "target", t.id, "label", cmd.Label, "error", err)
+			return err
+		}
+		slog.Info("tag added", "kind", t.kind, "target", t.id, "label", cmd.Label)
+	}
+	slog.Debug("command done")
+	return nil
+}
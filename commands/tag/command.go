@@ -0,0 +1,79 @@
+package tag
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// KindEntry is the kind of tags attached to entries, by path.
+	KindEntry = "entry"
+	// KindGroup is the kind of tags attached to duplicate groups, by id.
+	KindGroup = "group"
+)
+
+// Tag is the command that attaches labels and free-text notes to entries and
+// duplicate groups (e.g. "reviewed", "keep-both"); tags can then be used to
+// filter reports and the actions on duplicates.
+type Tag struct {
+	// Add attaches a tag.
+	Add Add `command:"add" description:"Attach a tag to entries and duplicate groups."`
+	// Remove detaches tags.
+	Remove Remove `command:"remove" alias:"rm" description:"Remove tags from entries and duplicate groups."`
+	// List lists tags.
+	List List `command:"list" alias:"ls" description:"List the tags attached to entries and duplicate groups."`
+}
+
+// Targets are the entries and groups a tag is attached to.
+type Targets struct {
+	// Groups are the identifiers of the duplicate groups.
+	Groups []int64 `short:"G" long:"group" description:"The identifier of a duplicate group (repeatable)." optional:"true"`
+	// Arguments are the paths of the entries.
+	Arguments struct {
+		Paths []string `positional-arg-name:"path" description:"The path of an indexed file."`
+	} `positional-args:"yes"`
+}
+
+// target is an entry or group a tag is attached to.
+type target struct {
+	kind string
+	id   string
+}
+
+// resolve returns the targets, checking that they are in the index.
+func (t *Targets) resolve(db *sql.DB) ([]target, error) {
+	targets := []target{}
+	for _, id := range t.Groups {
+		var count int
+		if err := db.QueryRow("select count(*) from duplicate_groups where id = ?", id).Scan(&count); err != nil {
+			slog.Error("error looking up duplicate group", "group", id, "error", err)
+			return nil, err
+		}
+		if count == 0 {
+			err := fmt.Errorf("unknown duplicate group %d", id)
+			slog.Error("invalid tag target", "error", err)
+			return nil, err
+		}
+		targets = append(targets, target{kind: KindGroup, id: strconv.FormatInt(id, 10)})
+	}
+	for _, path := range t.Arguments.Paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		var count int
+		if err := db.QueryRow("select count(*) from entries where path = ?", path).Scan(&count); err != nil {
+			slog.Error("error looking up entry", "path", path, "error", err)
+			return nil, err
+		}
+		if count == 0 {
+			err := fmt.Errorf("file %s is not indexed", path)
+			slog.Error("invalid tag target", "error", err)
+			return nil, err
+		}
+		targets = append(targets, target{kind: KindEntry, id: path})
+	}
+	return targets, nil
+}
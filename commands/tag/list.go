@@ -0,0 +1,90 @@
+package tag
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// List is the command that lists the tags attached to entries and duplicate
+// groups.
+type List struct {
+	base.Command
+	base.Database
+	Targets
+	// Label restricts the list to the tags with the given label.
+	Label string `short:"l" long:"label" description:"Only list the tags with this label." optional:"true"`
+}
+
+// Info describes a tag.
+type Info struct {
+	Kind    string `json:"kind"`
+	Target  string `json:"target"`
+	Label   string `json:"label"`
+	Note    string `json:"note,omitempty"`
+	Created string `json:"created"`
+}
+
+// Execute is the real implementation of the List command.
+func (cmd *List) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running tag list command", "database", cmd.Database, "label", cmd.Label)
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	targets, err := cmd.resolve(db)
+	if err != nil {
+		return err
+	}
+	query := "select kind, target, label, coalesce(note, ''), created from tags where 1 = 1"
+	params := []any{}
+	if cmd.Label != "" {
+		query += " and label = ?"
+		params = append(params, cmd.Label)
+	}
+	if len(targets) > 0 {
+		conditions := []string{}
+		for _, t := range targets {
+			conditions = append(conditions, "(kind = ? and target = ?)")
+			params = append(params, t.kind, t.id)
+		}
+		query += " and (" + strings.Join(conditions, " or ") + ")"
+	}
+	query += " order by label, kind, target"
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		slog.Error("error querying tags", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		info := &Info{}
+		if err := rows.Scan(&info.Kind, &info.Target, &info.Label, &info.Note, &info.Created); err != nil {
+			slog.Error("error reading tag", "error", err)
+			return err
+		}
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(info)
+			if err != nil {
+				slog.Error("error marshalling tag to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else {
+			cmd.Output().Printf("%s\t%s\t%s\t%s\n", info.Label, info.Kind, info.Target, info.Note)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading tags", "error", err)
+		return err
+	}
+	slog.Debug("command done")
+	return nil
+}
@@ -0,0 +1,48 @@
+package tag
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// Remove is the command that detaches tags from entries and duplicate groups.
+type Remove struct {
+	base.Command
+	base.Database
+	Targets
+	// Label is the label of the tag to remove; all tags are removed if empty.
+	Label string `short:"l" long:"label" description:"The label of the tag to remove (all tags if not specified)." optional:"true"`
+}
+
+// Execute is the real implementation of the Remove command.
+func (cmd *Remove) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running tag remove command", "database", cmd.Database, "label", cmd.Label)
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	targets, err := cmd.resolve(db)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		err := errors.New("no paths or groups to remove tags from")
+		slog.Error("nothing to untag", "error", err)
+		return err
+	}
+	for _, t := range targets {
+		if _, err := db.Exec("delete from tags where kind = ? and target = ? and (? = '' or label = ?)", t.kind, t.id, cmd.Label, cmd.Label); err != nil {
+			slog.Error("error removing tag", "kind", t.kind, "target", t.id, "label", cmd.Label, "error", err)
+			return err
+		}
+		slog.Info("tag removed", "kind", t.kind, "target", t.id, "label", cmd.Label)
+	}
+	slog.Debug("command done")
+	return nil
+}
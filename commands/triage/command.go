@@ -0,0 +1,188 @@
+package triage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/jessevdk/go-flags"
+)
+
+// Downloads is the command that triages a downloads (or any other scratch)
+// folder: it indexes the folder into a bucket of its own, then reports which
+// of its files are safe to delete because identical contents are already
+// indexed in the archive buckets.
+type Downloads struct {
+	base.Command
+	base.Database
+	// Bucket is the bucket the folder is indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index the folder into." optional:"true" default:"downloads"`
+	// Archives are the buckets to look for copies in.
+	Archives []string `short:"a" long:"archive" description:"The bucket to look for identical copies in (repeatable; all other buckets if not specified)." optional:"true"`
+	// All also reports the files having no copy elsewhere.
+	All bool `long:"all" description:"Also list the files that have no identical copy elsewhere." optional:"true"`
+	// Arguments hold the folder to triage.
+	Arguments struct {
+		Folder string `positional-arg-name:"dir" description:"The folder to triage."`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Verdict is the outcome of the triage of a file.
+type Verdict struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+	// Copy is the path of an identical copy in the archive buckets, if any.
+	Copy string `json:"copy,omitempty"`
+	// Safe is whether the file can be deleted.
+	Safe bool `json:"safe"`
+}
+
+// Summary contains the outcome of a triage.
+type Summary struct {
+	Files int   `json:"files"`
+	Safe  int   `json:"safe"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Execute is the real implementation of the Downloads command.
+func (cmd *Downloads) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running triage-downloads command", "folder", cmd.Arguments.Folder, "database", cmd.Database, "bucket", cmd.Bucket, "archives", cmd.Archives)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	folder, err := filepath.Abs(cmd.Arguments.Folder)
+	if err != nil {
+		slog.Error("invalid folder", "folder", cmd.Arguments.Folder, "error", err)
+		return err
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	algorithm, err := cmd.algorithm(db)
+	db.Close()
+	if err != nil {
+		return err
+	}
+
+	// index the folder with the same defaults as the index command, hashing
+	// like the archives so that their contents can be compared
+	idx := &index.Index{}
+	if _, err := flags.NewParser(idx, flags.None).ParseArgs([]string{"--path=" + folder, "--bucket=" + cmd.Bucket, "--hash=" + algorithm}); err != nil {
+		slog.Error("error preparing index run", "error", err)
+		return err
+	}
+	idx.Command = cmd.Command
+	idx.Database = cmd.Database
+	if err := idx.Execute(nil); err != nil {
+		return err
+	}
+
+	db, err = cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query := "select e.path, coalesce(e.size, 0), e.hash, (select o.path from entries o where o.hash = e.hash and coalesce(o.size, 0) = coalesce(e.size, 0) and coalesce(o.bucket, '') != ?"
+	params := []any{cmd.Bucket}
+	if len(cmd.Archives) > 0 {
+		query += " and o.bucket in (?" + strings.Repeat(", ?", len(cmd.Archives)-1) + ")"
+		for _, archive := range cmd.Archives {
+			params = append(params, archive)
+		}
+	}
+	query += " order by o.path limit 1) from entries e where e.bucket = ? and substr(e.path, 1, ?) = ? order by e.path"
+	prefix := strings.TrimSuffix(folder, string(filepath.Separator)) + string(filepath.Separator)
+	params = append(params, cmd.Bucket, len(prefix), prefix)
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		slog.Error("error querying copies", "error", err)
+		return err
+	}
+	defer rows.Close()
+	summary := &Summary{}
+	output := cmd.Output()
+	for rows.Next() {
+		v := &Verdict{}
+		var copy sql.NullString
+		if err := rows.Scan(&v.Path, &v.Size, &v.Hash, &copy); err != nil {
+			slog.Error("error reading entry", "error", err)
+			return err
+		}
+		summary.Files++
+		v.Copy, v.Safe = copy.String, copy.Valid
+		if v.Safe {
+			summary.Safe++
+			summary.Bytes += v.Size
+		} else if !cmd.All {
+			continue
+		}
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(v)
+			if err != nil {
+				slog.Error("error marshalling verdict to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else if v.Safe {
+			output.Printf("%s %s\t%d\t(copy at %s)\n", output.Paint(base.Green, "safe"), v.Path, v.Size, v.Copy)
+		} else {
+			output.Printf("%s %s\t%d\n", output.Paint(base.Yellow, "keep"), v.Path, v.Size)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading entries", "error", err)
+		return err
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		output.Printf("%d of %d files (%d bytes) are safe to delete, since identical copies exist elsewhere (see clean --if-present-in)\n", summary.Safe, summary.Files, summary.Bytes)
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// algorithm returns the hash algorithm of the archive buckets, which must all
+// agree on it, or the default one.
+func (cmd *Downloads) algorithm(db *sql.DB) (string, error) {
+	if len(cmd.Archives) == 0 {
+		var algorithm sql.NullString
+		if err := db.QueryRow("select max(algorithm) from buckets where name != ? and name in (select distinct bucket from entries)", cmd.Bucket).Scan(&algorithm); err != nil {
+			slog.Error("error reading bucket algorithm", "error", err)
+			return "", err
+		}
+		if algorithm.Valid && algorithm.String != "" {
+			return algorithm.String, nil
+		}
+		return index.DefaultAlgorithm, nil
+	}
+	if err := buckets.Compatible(db, cmd.Archives...); err != nil {
+		return "", err
+	}
+	for _, archive := range cmd.Archives {
+		metadata, err := buckets.Load(db, archive)
+		if err != nil {
+			return "", err
+		}
+		if metadata != nil && metadata.Algorithm != "" {
+			return metadata.Algorithm, nil
+		}
+	}
+	return index.DefaultAlgorithm, nil
+}
@@ -0,0 +1,243 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/version"
+	"github.com/dihedron/dedup/manifest"
+)
+
+// SelfUpdate is the command that replaces the running binary with the latest
+// release, for installations without a package manager (e.g. on NAS devices):
+// the downloaded binary is checked against the checksums published with the
+// release, which must be signed with the given key unless explicitly allowed
+// otherwise, and atomically renamed over the current one.
+type SelfUpdate struct {
+	base.Command
+	// URL is the endpoint describing the latest release.
+	URL string `long:"url" description:"The endpoint describing the latest release, in the GitHub releases API format." optional:"true" default:"https://api.github.com/repos/dihedron/dedup/releases/latest"`
+	// Key is the public key the release checksums must be signed with.
+	Key string `short:"k" long:"key" description:"The public key file the release checksums must be signed with (required to install, unless --insecure is given)." optional:"true"`
+	// Insecure allows installing releases without checking their signature.
+	Insecure bool `long:"insecure" description:"Install the release without checking the signature of its checksums (not recommended)." optional:"true"`
+	// Check only reports whether an update is available.
+	Check bool `long:"check" description:"Only check whether a newer release is available." optional:"true"`
+	// Force installs the latest release even if it is not newer.
+	Force bool `long:"force" description:"Install the latest release even if it is not newer than the running one." optional:"true"`
+	// Timeout is how long the update may take.
+	Timeout time.Duration `long:"timeout" description:"How long checking and downloading the release may take." optional:"true" default:"5m"`
+}
+
+// Outcome is the outcome of a self-update.
+type Outcome struct {
+	Current   string `json:"current"`
+	Latest    string `json:"latest"`
+	Available bool   `json:"available"`
+	Updated   bool   `json:"updated"`
+	Path      string `json:"path,omitempty"`
+}
+
+// Execute is the real implementation of the SelfUpdate command.
+func (cmd *SelfUpdate) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running self-update command", "url", cmd.URL, "check", cmd.Check)
+
+	var key ed25519.PublicKey
+	if cmd.Key != "" {
+		var err error
+		if key, err = manifest.LoadPublicKey(cmd.Key); err != nil {
+			slog.Error("error loading public key", "path", cmd.Key, "error", err)
+			return err
+		}
+	} else if !cmd.Check && !cmd.Insecure {
+		err := fmt.Errorf("a public key (--key) is required to verify the release, or --insecure to skip the check")
+		slog.Error("error updating", "error", err)
+		return err
+	} else if !cmd.Check {
+		slog.Warn("INSECURE: the release checksums will not be verified against a signature, the binary could be tampered with")
+		out := cmd.Output()
+		out.Warnf("%s: installing without verifying the release signature (--insecure)\n", out.Paint(base.Red, "WARNING"))
+	}
+
+	ctx := context.Background()
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+	client := &http.Client{}
+
+	r, err := latest(ctx, client, cmd.URL)
+	if err != nil {
+		slog.Error("error checking latest release", "url", cmd.URL, "error", err)
+		return err
+	}
+	outcome := &Outcome{
+		Current: fmt.Sprintf("v%s.%s.%s", version.VersionMajor, version.VersionMinor, version.VersionPatch),
+		Latest:  r.Tag,
+	}
+	outcome.Available = newer(outcome.Latest, outcome.Current)
+	slog.Debug("latest release", "current", outcome.Current, "latest", outcome.Latest, "available", outcome.Available)
+
+	if !cmd.Check && (outcome.Available || cmd.Force) {
+		if outcome.Path, err = cmd.install(ctx, client, r, key); err != nil {
+			return err
+		}
+		outcome.Updated = true
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(outcome)
+		if err != nil {
+			slog.Error("error marshalling outcome to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else if outcome.Updated {
+		cmd.Output().Printf("updated %s from %s to %s\n", outcome.Path, outcome.Current, outcome.Latest)
+	} else if outcome.Available {
+		cmd.Output().Printf("%s is available (running %s): run self-update to install it\n", outcome.Latest, outcome.Current)
+	} else {
+		cmd.Output().Printf("%s is up to date (latest release is %s)\n", outcome.Current, outcome.Latest)
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// install downloads the binary of the release for this platform, verifies it
+// and replaces the running executable with it.
+func (cmd *SelfUpdate) install(ctx context.Context, client *http.Client, r *release, key ed25519.PublicKey) (string, error) {
+	name := binary()
+	a := r.find(name)
+	if a == nil {
+		err := fmt.Errorf("release %s has no binary for this platform (%s)", r.Tag, name)
+		slog.Error("error updating", "error", err)
+		return "", err
+	}
+	expected, err := cmd.expected(ctx, client, r, name, key)
+	if err != nil {
+		return "", err
+	}
+
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		slog.Error("error locating running executable", "error", err)
+		return "", err
+	}
+	info, err := os.Stat(executable)
+	if err != nil {
+		slog.Error("error reading running executable", "path", executable, "error", err)
+		return "", err
+	}
+
+	// download next to the executable, so that it can be renamed over it
+	temporary, err := os.CreateTemp(filepath.Dir(executable), ".dedup-update-*")
+	if err != nil {
+		slog.Error("error creating temporary file", "directory", filepath.Dir(executable), "error", err)
+		return "", err
+	}
+	defer os.Remove(temporary.Name())
+	body, err := open(ctx, client, a.URL)
+	if err != nil {
+		temporary.Close()
+		slog.Error("error downloading release", "url", a.URL, "error", err)
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(temporary, h), body)
+	body.Close()
+	if err == nil {
+		err = temporary.Chmod(info.Mode().Perm())
+	}
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		slog.Error("error downloading release", "url", a.URL, "error", err)
+		return "", err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		err := fmt.Errorf("checksum mismatch for %s: got %s, expected %s", name, actual, expected)
+		slog.Error("error verifying release", "error", err)
+		return "", err
+	}
+
+	if err := replace(temporary.Name(), executable); err != nil {
+		slog.Error("error replacing executable", "path", executable, "error", err)
+		return "", err
+	}
+	slog.Info("executable updated", "path", executable, "release", r.Tag)
+	return executable, nil
+}
+
+// expected returns the checksum of the named binary published with the
+// release, after verifying the signature of the checksums if a key is given.
+func (cmd *SelfUpdate) expected(ctx context.Context, client *http.Client, r *release, name string, key ed25519.PublicKey) (string, error) {
+	a := r.find(checksums)
+	if a == nil {
+		err := fmt.Errorf("release %s has no %s, refusing to install an unverified binary", r.Tag, checksums)
+		slog.Error("error updating", "error", err)
+		return "", err
+	}
+	document, err := fetch(ctx, client, a.URL, 1<<20)
+	if err != nil {
+		slog.Error("error downloading checksums", "url", a.URL, "error", err)
+		return "", err
+	}
+	if key != nil {
+		s := r.find(signature)
+		if s == nil {
+			err := fmt.Errorf("release %s has no %s", r.Tag, signature)
+			slog.Error("error updating", "error", err)
+			return "", err
+		}
+		encoded, err := fetch(ctx, client, s.URL, 1<<10)
+		if err != nil {
+			slog.Error("error downloading signature", "url", s.URL, "error", err)
+			return "", err
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil || !ed25519.Verify(key, document, sig) {
+			err := fmt.Errorf("invalid signature of %s in release %s", checksums, r.Tag)
+			slog.Error("error verifying release", "error", err)
+			return "", err
+		}
+	}
+	return checksum(document, name)
+}
+
+// replace atomically renames the new binary over the executable; where the
+// running executable cannot be replaced (e.g. on Windows), it is moved aside
+// first.
+func replace(binary string, executable string) error {
+	if err := os.Rename(binary, executable); err == nil {
+		return nil
+	}
+	old := executable + ".old"
+	os.Remove(old)
+	if err := os.Rename(executable, old); err != nil {
+		return err
+	}
+	if err := os.Rename(binary, executable); err != nil {
+		os.Rename(old, executable)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// release is a release as described by the GitHub releases API.
+type release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []asset `json:"assets"`
+}
+
+// asset is a file attached to a release.
+type asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Names of the assets attached to each release.
+const (
+	// checksums lists the SHA-256 of the binaries, in sha256sum format.
+	checksums = "SHA256SUMS"
+	// signature is the base64 ed25519 signature of the checksums.
+	signature = "SHA256SUMS.sig"
+)
+
+// binary returns the name of the release asset for this platform, e.g.
+// dedup_linux_arm64.
+func binary() string {
+	name := fmt.Sprintf("dedup_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// find returns the asset with the given name, or nil.
+func (r *release) find(name string) *asset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// latest fetches the description of the latest release.
+func latest(ctx context.Context, client *http.Client, url string) (*release, error) {
+	data, err := fetch(ctx, client, url, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	r := &release{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("invalid release description: %w", err)
+	}
+	if r.Tag == "" {
+		return nil, fmt.Errorf("release description has no tag")
+	}
+	return r, nil
+}
+
+// fetch downloads a small document, up to the given number of bytes.
+func fetch(ctx context.Context, client *http.Client, url string, limit int64) ([]byte, error) {
+	body, err := open(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(io.LimitReader(body, limit))
+}
+
+// open starts downloading the document at the given URL.
+func open(ctx context.Context, client *http.Client, url string) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json, application/octet-stream")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return response.Body, nil
+}
+
+// checksum returns the hex-encoded SHA-256 of the named file in a checksums
+// document in sha256sum format.
+func checksum(document []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(document))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s in %s", name, checksums)
+}
+
+// newer returns whether version a (e.g. v1.2.3) is more recent than b.
+func newer(a string, b string) bool {
+	va, vb := parse(a), parse(b)
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i]
+		}
+	}
+	return false
+}
+
+// parse splits a version like v1.2.3 into its numbers; missing or invalid
+// numbers are 0.
+func parse(version string) [3]int {
+	var numbers [3]int
+	version, _, _ = strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	for i, part := range strings.SplitN(version, ".", 3) {
+		numbers[i], _ = strconv.Atoi(part)
+	}
+	return numbers
+}
@@ -12,11 +12,9 @@ import (
 )
 
 // Version is the command that prints information about the application
-// or plugin to the console; it support both compact and verbose mode.
+// or plugin to the console; it support both compact and verbose (-v) mode.
 type Version struct {
 	base.Command
-	// Verbose prints extensive information about this application or plugin.
-	Verbose bool `short:"v" long:"verbose" description:"Print extensive information about this application."`
 }
 
 type ShortInfo struct {
@@ -85,10 +83,11 @@ func (cmd *Version) Execute(args []string) error {
 		}
 	}
 	GoVersion = bi.GoVersion
+	verbose := len(cmd.Verbose) > 0
 
 	if cmd.AutomationFriendly {
 		var info interface{}
-		if !cmd.Verbose {
+		if !verbose {
 			// short
 			info = &ShortInfo{
 				Name:        Name,
@@ -129,30 +128,31 @@ func (cmd *Version) Execute(args []string) error {
 			return err
 		}
 		slog.Debug("marshalling data to JSON", "data", string(data))
-		fmt.Println(string(data))
+		cmd.Output().Println(string(data))
 	} else {
-		if !cmd.Verbose {
-			fmt.Printf("\n  %s %s - %s - %s\n\n", path.Base(os.Args[0]), GitTag, Copyright, Description)
+		out := cmd.Output()
+		if !verbose {
+			out.Printf("\n  %s %s - %s - %s\n\n", path.Base(os.Args[0]), GitTag, Copyright, Description)
 		} else {
 			if GitTag != "" {
-				fmt.Printf("\n  %s %s - %s - %s\n\n", path.Base(os.Args[0]), GitTag, Copyright, Description)
+				out.Printf("\n  %s %s - %s - %s\n\n", path.Base(os.Args[0]), GitTag, Copyright, Description)
 			} else {
-				fmt.Printf("\n  %s - %s - %s\n\n", path.Base(os.Args[0]), Copyright, Description)
+				out.Printf("\n  %s - %s - %s\n\n", path.Base(os.Args[0]), Copyright, Description)
 			}
-			fmt.Printf("  - Name             : %s\n", Name)
-			fmt.Printf("  - Description      : %s\n", Description)
-			fmt.Printf("  - Copyright        : %s\n", Copyright)
-			fmt.Printf("  - Major Version    : %s\n", VersionMajor)
-			fmt.Printf("  - Minor Version    : %s\n", VersionMinor)
-			fmt.Printf("  - Patch Version    : %s\n", VersionPatch)
-			fmt.Printf("  - Build Time       : %s\n", BuildTime)
-			fmt.Printf("  - Compiler         : %s\n", GoVersion)
-			fmt.Printf("  - Operating System : %s\n", GoOS)
-			fmt.Printf("  - Architecture     : %s\n", GoArch)
-			fmt.Printf("  - Git Tag          : %s\n", GitTag)
-			fmt.Printf("  - Git Time         : %s\n", GitTime)
-			fmt.Printf("  - Git Commit       : %s\n", GitCommit)
-			fmt.Printf("  - Git Modified     : %s\n", GitModified)
+			out.Printf("  - Name             : %s\n", Name)
+			out.Printf("  - Description      : %s\n", Description)
+			out.Printf("  - Copyright        : %s\n", Copyright)
+			out.Printf("  - Major Version    : %s\n", VersionMajor)
+			out.Printf("  - Minor Version    : %s\n", VersionMinor)
+			out.Printf("  - Patch Version    : %s\n", VersionPatch)
+			out.Printf("  - Build Time       : %s\n", BuildTime)
+			out.Printf("  - Compiler         : %s\n", GoVersion)
+			out.Printf("  - Operating System : %s\n", GoOS)
+			out.Printf("  - Architecture     : %s\n", GoArch)
+			out.Printf("  - Git Tag          : %s\n", GitTag)
+			out.Printf("  - Git Time         : %s\n", GitTime)
+			out.Printf("  - Git Commit       : %s\n", GitCommit)
+			out.Printf("  - Git Modified     : %s\n", GitModified)
 		}
 	}
 	slog.Debug("command done")
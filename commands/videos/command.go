@@ -0,0 +1,236 @@
+package videos
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/commands/base"
+)
+
+// extensions are the file extensions of the videos to fingerprint.
+var extensions = []string{".mp4", ".m4v", ".mov", ".mkv", ".avi", ".wmv", ".webm", ".mpg", ".mpeg", ".ts", ".3gp", ".flv"}
+
+// SimilarVideos is the command that finds videos with the same footage even
+// if their contents differ (different containers, resolutions or encodings):
+// each indexed video is fingerprinted with its duration and the perceptual
+// hashes of a handful of frames, extracted with ffmpeg, and videos with close
+// durations and frames are grouped together.
+type SimilarVideos struct {
+	base.Command
+	base.Database
+	// Bucket restricts the search to the entries in the given bucket.
+	Bucket string `short:"b" long:"bucket" description:"The bucket whose videos should be compared (all buckets if not specified)." optional:"true"`
+	// FFmpeg is the path to the ffmpeg executable.
+	FFmpeg string `long:"ffmpeg" description:"The ffmpeg executable used to extract frames." optional:"true" default:"ffmpeg"`
+	// FFprobe is the path to the ffprobe executable.
+	FFprobe string `long:"ffprobe" description:"The ffprobe executable used to read durations." optional:"true" default:"ffprobe"`
+	// Frames is the number of frames sampled from each video.
+	Frames int `long:"frames" description:"The number of frames sampled from each video." optional:"true" default:"5"`
+	// Threshold is the maximum average number of differing bits between frames.
+	Threshold float64 `long:"threshold" description:"The maximum average number of differing bits (out of 64) between the frames of similar videos." optional:"true" default:"10"`
+	// Tolerance is the maximum relative difference in duration.
+	Tolerance float64 `long:"tolerance" description:"The maximum difference in duration between similar videos, as a fraction of the duration (at least one second)." optional:"true" default:"0.02"`
+	// Refresh recomputes the fingerprints already in the index.
+	Refresh bool `long:"refresh" description:"Recompute the fingerprints already stored in the index." optional:"true"`
+}
+
+// Video is a video in a group of similar ones.
+type Video struct {
+	Hash     string  `json:"hash"`
+	Path     string  `json:"path"`
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration"`
+
+	fingerprint *fingerprint
+}
+
+// Similar is a group of similar videos.
+type Similar struct {
+	Videos []*Video `json:"videos"`
+}
+
+// Execute is the real implementation of the SimilarVideos command.
+func (cmd *SimilarVideos) Execute(args []string) error {
+	cmd.Init()
+	slog.Debug("running similar-videos command", "database", cmd.Database, "bucket", cmd.Bucket, "frames", cmd.Frames)
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	if cmd.Frames < 1 {
+		err := fmt.Errorf("invalid number of frames %d", cmd.Frames)
+		slog.Error("invalid number of frames", "frames", cmd.Frames, "error", err)
+		return err
+	}
+	for _, tool := range []*string{&cmd.FFmpeg, &cmd.FFprobe} {
+		path, err := exec.LookPath(*tool)
+		if err != nil {
+			err = fmt.Errorf("%q not found, install ffmpeg from https://ffmpeg.org or use --ffmpeg and --ffprobe: %w", *tool, err)
+			slog.Error("error locating ffmpeg", "error", err)
+			return err
+		}
+		*tool = path
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	videos, err := cmd.videos(db)
+	if err != nil {
+		return err
+	}
+	if err := cmd.fingerprint(db, videos); err != nil {
+		return err
+	}
+	groups := cmd.group(videos)
+
+	for i, group := range groups {
+		if cmd.AutomationFriendly {
+			data, err := json.Marshal(group)
+			if err != nil {
+				slog.Error("error marshalling group to JSON", "error", err)
+				return err
+			}
+			cmd.Output().Println(string(data))
+		} else {
+			if i > 0 {
+				cmd.Output().Println()
+			}
+			cmd.Output().Printf("similar videos (%.1fs):\n", group.Videos[0].Duration)
+			for _, video := range group.Videos {
+				cmd.Output().Printf("  %s (%.1fs, %d bytes)\n", video.Path, video.Duration, video.Size)
+			}
+		}
+	}
+	if !cmd.AutomationFriendly {
+		cmd.Output().Printf("%d groups of similar videos\n", len(groups))
+	}
+	slog.Debug("command done")
+	return nil
+}
+
+// videos returns one indexed video per distinct content, along with its stored
+// fingerprint, if any.
+func (cmd *SimilarVideos) videos(db *sql.DB) ([]*Video, error) {
+	conditions := []string{}
+	params := []any{}
+	for _, extension := range extensions {
+		conditions = append(conditions, "lower(path) like ?")
+		params = append(params, "%"+extension)
+	}
+	query := "select entries.hash, min(path), coalesce(max(size), 0), videos.duration, videos.frames from entries left join videos on videos.hash = entries.hash where (" + strings.Join(conditions, " or ") + ")"
+	if cmd.Bucket != "" {
+		query += " and bucket = ?"
+		params = append(params, cmd.Bucket)
+	}
+	rows, err := db.Query(query+" group by entries.hash order by min(path)", params...)
+	if err != nil {
+		slog.Error("error querying videos", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	videos := []*Video{}
+	for rows.Next() {
+		video := &Video{}
+		var duration sql.NullFloat64
+		var frames sql.NullString
+		if err := rows.Scan(&video.Hash, &video.Path, &video.Size, &duration, &frames); err != nil {
+			slog.Error("error reading video", "error", err)
+			return nil, err
+		}
+		if duration.Valid && frames.Valid && !cmd.Refresh {
+			if f, err := decode(duration.Float64, frames.String); err == nil && len(f.frames) == cmd.Frames {
+				video.fingerprint = f
+				video.Duration = f.duration
+			}
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// fingerprint computes and stores the missing fingerprints; videos that cannot
+// be read are left out of the comparison.
+func (cmd *SimilarVideos) fingerprint(db *sql.DB, videos []*Video) error {
+	for _, video := range videos {
+		if video.fingerprint != nil {
+			continue
+		}
+		f, err := compute(cmd.FFmpeg, cmd.FFprobe, video.Path, cmd.Frames)
+		if err != nil {
+			slog.Warn("error fingerprinting video", "path", video.Path, "error", err)
+			continue
+		}
+		video.fingerprint = f
+		video.Duration = f.duration
+		if _, err := db.Exec("insert or replace into videos(hash, duration, frames, created) values(?, ?, ?, ?)", video.Hash, f.duration, f.encode(), time.Now().UTC().Format(time.RFC3339)); err != nil {
+			slog.Error("error storing video fingerprint", "path", video.Path, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// group clusters the videos with close durations and similar frames.
+func (cmd *SimilarVideos) group(videos []*Video) []*Similar {
+	candidates := []*Video{}
+	for _, video := range videos {
+		if video.fingerprint != nil {
+			candidates = append(candidates, video)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Duration < candidates[j].Duration
+	})
+
+	// union-find over the pairs of similar videos; since candidates are sorted
+	// by duration, only the following ones within the tolerance are compared
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i, a := range candidates {
+		tolerance := math.Max(1, a.Duration*cmd.Tolerance)
+		for j := i + 1; j < len(candidates) && candidates[j].Duration-a.Duration <= tolerance; j++ {
+			if a.fingerprint.distance(candidates[j].fingerprint) <= cmd.Threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	clusters := map[int]*Similar{}
+	groups := []*Similar{}
+	for i, video := range candidates {
+		root := find(i)
+		group, ok := clusters[root]
+		if !ok {
+			group = &Similar{}
+			clusters[root] = group
+			groups = append(groups, group)
+		}
+		group.Videos = append(group.Videos, video)
+	}
+	result := groups[:0]
+	for _, group := range groups {
+		if len(group.Videos) > 1 {
+			result = append(result, group)
+		}
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package videos
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// fingerprint is the duration of a video and the perceptual hashes of frames
+// sampled at regular intervals.
+type fingerprint struct {
+	duration float64
+	frames   []uint64
+}
+
+// probe returns the duration of the given video, in seconds.
+func probe(ffprobe string, path string) (float64, error) {
+	output, err := exec.Command(ffprobe, "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("error probing %s: %w", path, err)
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %q for %s", strings.TrimSpace(string(output)), path)
+	}
+	return duration, nil
+}
+
+// frameHash extracts the frame at the given time as a 9x8 grayscale image and
+// returns its difference hash: one bit per pair of horizontally adjacent
+// pixels, set if the left one is brighter; the hash survives re-encoding,
+// rescaling and container changes.
+func frameHash(ffmpeg string, path string, at float64) (uint64, error) {
+	output, err := exec.Command(ffmpeg, "-v", "error", "-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", path, "-frames:v", "1", "-vf", "scale=9:8,format=gray", "-f", "rawvideo", "-").Output()
+	if err != nil {
+		return 0, fmt.Errorf("error extracting frame at %.3fs from %s: %w", at, path, err)
+	}
+	if len(output) < 72 {
+		return 0, fmt.Errorf("short frame at %.3fs from %s", at, path)
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if output[y*9+x] > output[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// compute fingerprints the given video, sampling evenly spaced frames,
+// away from the very beginning and end (often black or title cards).
+func compute(ffmpeg string, ffprobe string, path string, frames int) (*fingerprint, error) {
+	duration, err := probe(ffprobe, path)
+	if err != nil {
+		return nil, err
+	}
+	f := &fingerprint{duration: duration}
+	for i := 0; i < frames; i++ {
+		hash, err := frameHash(ffmpeg, path, duration*(float64(i)+0.5)/float64(frames))
+		if err != nil {
+			return nil, err
+		}
+		f.frames = append(f.frames, hash)
+	}
+	return f, nil
+}
+
+// encode returns the frame hashes as comma-separated hex values.
+func (f *fingerprint) encode() string {
+	var b bytes.Buffer
+	for i, frame := range f.frames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%016x", frame)
+	}
+	return b.String()
+}
+
+// decode parses comma-separated hex frame hashes.
+func decode(duration float64, frames string) (*fingerprint, error) {
+	f := &fingerprint{duration: duration}
+	for _, value := range strings.Split(frames, ",") {
+		hash, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		f.frames = append(f.frames, hash)
+	}
+	return f, nil
+}
+
+// distance returns the average number of differing bits between the
+// corresponding frames of the two fingerprints.
+func (f *fingerprint) distance(other *fingerprint) float64 {
+	n := len(f.frames)
+	if len(other.frames) < n {
+		n = len(other.frames)
+	}
+	if n == 0 {
+		return 64
+	}
+	total := 0
+	for i := 0; i < n; i++ {
+		total += bits.OnesCount64(f.frames[i] ^ other.frames[i])
+	}
+	return float64(total) / float64(n)
+}
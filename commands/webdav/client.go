@@ -0,0 +1,205 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resource is a file or collection on the WebDAV server.
+type resource struct {
+	// URL is the absolute URL of the resource.
+	URL *url.URL
+	// Collection is whether the resource is a collection (a directory).
+	Collection bool
+	// Size is the size of the file.
+	Size int64
+	// Modified is when the file was last modified, if reported.
+	Modified time.Time
+}
+
+// multistatus is the body of a PROPFIND response.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind is the body of the PROPFIND requests, asking only for the
+// properties needed to index files.
+const propfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// client lists and downloads the resources on a WebDAV server, under the root
+// collection.
+type client struct {
+	http     *http.Client
+	root     *url.URL
+	user     string
+	password string
+	limiter  *limiter
+}
+
+// contains returns whether the given URL is on the same server as the root
+// collection and inside it; servers may list resources anywhere, but they are
+// only ever requested, with the credentials, there.
+func (c *client) contains(target *url.URL) bool {
+	return target.Scheme == c.root.Scheme && target.Host == c.root.Host && strings.HasPrefix(target.Path, c.root.Path)
+}
+
+// list returns the members of the given collection, excluding the collection
+// itself.
+func (c *client) list(ctx context.Context, collection *url.URL) ([]*resource, error) {
+	request, err := http.NewRequestWithContext(ctx, "PROPFIND", collection.String(), strings.NewReader(propfind))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Depth", "1")
+	request.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	c.authenticate(request)
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("listing %s: unexpected status %s", collection, response.Status)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	var status multistatus
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&status); err != nil {
+		return nil, fmt.Errorf("listing %s: %w", collection, err)
+	}
+	resources := []*resource{}
+	for _, r := range status.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: invalid href %q: %w", collection, r.Href, err)
+		}
+		target := collection.ResolveReference(href)
+		if strings.TrimSuffix(target.Path, "/") == strings.TrimSuffix(collection.Path, "/") {
+			continue
+		}
+		if !c.contains(target) {
+			slog.Warn("resource outside of the indexed collection, skipping", "collection", collection.String(), "url", target.Redacted())
+			continue
+		}
+		res := &resource{URL: target}
+		for _, propstat := range r.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			if prop.ResourceType.Collection != nil {
+				res.Collection = true
+			}
+			if prop.ContentLength != "" {
+				res.Size, _ = strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64)
+			}
+			if prop.LastModified != "" {
+				res.Modified, _ = http.ParseTime(prop.LastModified)
+			}
+		}
+		if res.Collection && !strings.HasSuffix(res.URL.Path, "/") {
+			res.URL.Path += "/"
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+// get streams the contents of the given file into the writer, within the
+// bandwidth limit, and returns the number of bytes read.
+func (c *client) get(ctx context.Context, file *url.URL, w io.Writer) (int64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, file.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	c.authenticate(request)
+	response, err := c.http.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("downloading %s: unexpected status %s", file, response.Status)
+	}
+	var body io.Reader = response.Body
+	if c.limiter != nil {
+		body = &throttled{reader: body, limiter: c.limiter}
+	}
+	return io.Copy(w, body)
+}
+
+// authenticate adds the credentials, if any, to the request.
+func (c *client) authenticate(request *http.Request) {
+	if c.user != "" {
+		request.SetBasicAuth(c.user, c.password)
+	}
+}
+
+// limiter caps the number of bytes per second read by all downloads together.
+type limiter struct {
+	lock  sync.Mutex
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+// newLimiter returns a limiter allowing the given number of bytes per second.
+func newLimiter(rate int64) *limiter {
+	return &limiter{rate: rate, start: time.Now()}
+}
+
+// wait accounts for n bytes read, sleeping as long as needed to stay within
+// the rate.
+func (l *limiter) wait(n int) {
+	l.lock.Lock()
+	l.read += int64(n)
+	due := l.start.Add(time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second)))
+	l.lock.Unlock()
+	if delay := time.Until(due); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttled is a reader whose throughput is capped by a limiter.
+type throttled struct {
+	reader  io.Reader
+	limiter *limiter
+}
+
+// Read implements io.Reader.
+func (t *throttled) Read(p []byte) (int, error) {
+	// read in small chunks, so that the pace is smooth
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		t.limiter.wait(n)
+	}
+	return n, err
+}
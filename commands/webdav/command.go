@@ -0,0 +1,207 @@
+package webdav
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+	"github.com/dihedron/dedup/commands/base"
+	"github.com/dihedron/dedup/commands/index"
+	"github.com/dihedron/dedup/failures"
+)
+
+// WebDAV is the command that indexes the files on a WebDAV server (e.g.
+// Nextcloud or ownCloud), walking its collections and streaming the contents
+// of each file to hash it, so that self-hosted cloud storage can be compared
+// with local files. Files are indexed by URL; since they are not on disk, use
+// report --offline to list duplicates.
+type WebDAV struct {
+	base.Command
+	base.Database
+	// URL is the collection to index.
+	URL string `short:"u" long:"url" description:"The URL of the WebDAV collection to index, e.g. https://cloud.example.com/remote.php/dav/files/alice/." required:"true"`
+	// User is the user name to authenticate with.
+	User string `long:"user" description:"The user name to authenticate with." optional:"true"`
+	// Password is the password (or app token) to authenticate with.
+	Password string `long:"password" description:"The password or app token to authenticate with (better set through DEDUP_WEBDAV_PASSWORD)." optional:"true"`
+	// Bucket is the bucket files are indexed into.
+	Bucket string `short:"b" long:"bucket" description:"The bucket to index files into." optional:"true" default:"webdav"`
+	// Hash is the hash algorithm to compute.
+	Hash string `long:"hash" description:"The hash algorithm to compute, which should match the one of the buckets to compare with." optional:"true" default:"sha256"`
+	// Workers is the number of files downloaded at the same time.
+	Workers int `long:"workers" description:"The number of files downloaded and hashed at the same time." optional:"true" default:"4"`
+	// Bandwidth is the maximum number of bytes per second downloaded.
+	Bandwidth int64 `long:"bandwidth" description:"The maximum number of bytes per second downloaded by all workers together (0 for no limit)." optional:"true" default:"0"`
+	// Timeout is how long each request may take.
+	Timeout time.Duration `long:"timeout" description:"How long listing a collection or downloading a file may take (0 for no limit)." optional:"true" default:"0s"`
+}
+
+// Summary contains the outcome of a WebDAV indexing run.
+type Summary struct {
+	Collections int   `json:"collections"`
+	Files       int   `json:"files"`
+	Bytes       int64 `json:"bytes"`
+	Failed      int   `json:"failed"`
+}
+
+// Execute is the real implementation of the WebDAV command.
+func (cmd *WebDAV) Execute(args []string) error {
+	cmd.Init()
+	defer cmd.ProfileCPU().Close()
+	defer cmd.ProfileMemory()
+
+	root, err := url.Parse(cmd.URL)
+	if err != nil || (root.Scheme != "http" && root.Scheme != "https") {
+		// the URL may hold credentials, and is not logged
+		err = fmt.Errorf("invalid WebDAV URL, must be an http or https URL")
+		slog.Error("error parsing URL", "error", err)
+		return err
+	}
+	if root.User != nil {
+		// credentials are never stored in the index
+		if cmd.User == "" {
+			cmd.User = root.User.Username()
+			cmd.Password, _ = root.User.Password()
+		}
+		root.User = nil
+	}
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+	slog.Debug("running webdav command", "url", root.String(), "database", cmd.Database, "bucket", cmd.Bucket, "workers", cmd.Workers, "bandwidth", cmd.Bandwidth)
+	if _, err := index.NewHasher(cmd.Hash); err != nil {
+		slog.Error("invalid hash algorithm", "error", err)
+		return err
+	}
+	if cmd.Workers < 1 {
+		cmd.Workers = 1
+	}
+
+	db, err := cmd.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := buckets.Register(db, cmd.Bucket, cmd.Hash, []string{root.String()}, map[string]any{"webdav": true}); err != nil {
+		return err
+	}
+	stmt, err := db.Prepare("insert or replace into entries(hash, path, bucket, size, modified, indexed) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		slog.Error("error preparing database insert statement", "error", err)
+		return err
+	}
+	defer stmt.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := &client{http: &http.Client{Timeout: cmd.Timeout}, root: root, user: cmd.User, password: cmd.Password}
+	if cmd.Bandwidth > 0 {
+		c.limiter = newLimiter(cmd.Bandwidth)
+	}
+
+	summary := &Summary{}
+	var lock sync.Mutex
+	files := make(chan *resource, cmd.Workers)
+	var wg sync.WaitGroup
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i := 0; i < cmd.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				hash, size, err := cmd.digest(ctx, c, file)
+				lock.Lock()
+				if err != nil {
+					slog.Error("error hashing file", "url", file.URL.String(), "error", &failures.HashError{Path: file.URL.String(), Err: err})
+					summary.Failed++
+					lock.Unlock()
+					continue
+				}
+				var modified any
+				if !file.Modified.IsZero() {
+					modified = file.Modified.UTC().Format(time.RFC3339)
+				}
+				if _, err := stmt.Exec(hash, file.URL.String(), cmd.Bucket, size, modified, now); err != nil {
+					slog.Error("error executing database insert statement", "url", file.URL.String(), "error", err)
+					summary.Failed++
+				} else {
+					slog.Debug("file indexed", "url", file.URL.String(), "hash", hash, "size", size)
+					summary.Files++
+					summary.Bytes += size
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	// walk the collections breadth first, feeding the files to the workers
+	collections := []*url.URL{root}
+	for len(collections) > 0 && ctx.Err() == nil {
+		collection := collections[0]
+		collections = collections[1:]
+		resources, err := c.list(ctx, collection)
+		lock.Lock()
+		if err != nil {
+			slog.Error("error listing collection", "url", collection.String(), "error", &failures.WalkError{Path: collection.String(), Err: err})
+			summary.Failed++
+			lock.Unlock()
+			continue
+		}
+		summary.Collections++
+		lock.Unlock()
+		for _, r := range resources {
+			if r.Collection {
+				collections = append(collections, r.URL)
+				continue
+			}
+			select {
+			case files <- r:
+			case <-ctx.Done():
+			}
+		}
+	}
+	close(files)
+	wg.Wait()
+	if ctx.Err() != nil {
+		slog.Warn("indexing interrupted")
+	}
+
+	if cmd.AutomationFriendly {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			slog.Error("error marshalling summary to JSON", "error", err)
+			return err
+		}
+		cmd.Output().Println(string(data))
+	} else {
+		cmd.Output().Printf("indexed %d files (%d bytes) in %d collections, %d failed\n", summary.Files, summary.Bytes, summary.Collections, summary.Failed)
+	}
+	slog.Debug("command done")
+	return failures.Partial(int64(summary.Failed))
+}
+
+// digest downloads the given file and returns its hash and size.
+func (cmd *WebDAV) digest(ctx context.Context, c *client, file *resource) (string, int64, error) {
+	h, err := index.NewHasher(cmd.Hash)
+	if err != nil {
+		return "", 0, err
+	}
+	size, err := c.get(ctx, file.URL, h)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,453 @@
+// Package duplicates loads the groups of indexed entries sharing the same
+// content and selects, in each group, the copy to keep.
+package duplicates
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dihedron/dedup/buckets"
+)
+
+// Entry is an indexed file.
+type Entry struct {
+	// Hash is the hash of the file contents.
+	Hash string
+	// Path is the path of the file.
+	Path string
+	// Bucket is the bucket the file was indexed into.
+	Bucket string
+	// Size is the size of the file.
+	Size int64
+	// Modified is the modification time of the file, as found on disk when
+	// the group was loaded.
+	Modified time.Time
+	// Allocated is the space allocated on disk for the file and not shared
+	// with other files.
+	Allocated int64
+	// Device and Inode identify the file contents on disk, shared by all the
+	// hard links to the same file.
+	Device uint64
+	Inode  uint64
+	// Links is the number of hard links to the file contents.
+	Links uint64
+	// Reference is whether the file is in a read-only reference bucket.
+	Reference bool
+	// Source is the database the entry comes from, when several databases
+	// are federated.
+	Source string
+	// Sidecars are the paths of the sidecar files attached to the entry, when
+	// sidecars are attached to their media files.
+	Sidecars []string
+	// UID and GID are the owner and group of the file, and Mode its mode
+	// bits, as recorded at indexing time (see index --ownership): UID and
+	// GID are -1 and Mode is 0 when they were not recorded.
+	UID  int64
+	GID  int64
+	Mode int64
+	// ACL is the access control list of the file, as recorded at indexing
+	// time (see index --acls).
+	ACL string
+	// Forks are the paths of the AppleDouble files holding the resource fork
+	// of the entry, when they were paired with it at indexing time (see index
+	// --macos-metadata=pair).
+	Forks []string
+}
+
+// Group is a set of entries having the same content.
+type Group struct {
+	// ID is the stable identifier of the group, which does not change across
+	// runs as long as the contents are in the index.
+	ID int64
+	// Hash is the hash of the contents shared by the entries.
+	Hash string
+	// Size is the size of the contents.
+	Size int64
+	// Keeper is the entry to be kept.
+	Keeper *Entry
+	// Duplicates are the entries other than the keeper that can be acted upon.
+	Duplicates []*Entry
+	// References are the entries other than the keeper that belong to the
+	// reference set, and must never be acted upon.
+	References []*Entry
+}
+
+// Archived returns whether the keeper is in the reference set, that is
+// whether the duplicates are already in the archive.
+func (g *Group) Archived() bool {
+	return g.Keeper.Reference
+}
+
+// Options restricts the entries that are considered when loading groups and
+// drives the selection of the keeper.
+type Options struct {
+	// Bucket restricts the groups to the entries in the given bucket.
+	Bucket string
+	// Prefer is the ordered list of path prefixes where the keeper is
+	// preferably chosen from; when no entry matches, the oldest one is kept.
+	Prefer []string
+	// Owners is the ordered list of the identifiers of the users whose copy
+	// is preferably kept, among the copies equally preferred by path; it
+	// only applies to entries whose owner was recorded.
+	Owners []int64
+	// Within restricts the groups to the entries under the given directory.
+	Within string
+	// Against is the bucket acting as reference set: only groups having a copy
+	// in it are loaded, the keeper is chosen among those copies, and only the
+	// copies outside of it are duplicates.
+	Against string
+	// Groups restricts the groups to the ones with the given identifiers.
+	Groups []int64
+	// Tagged restricts the groups to the ones having any of the given tags,
+	// attached to the group itself or to any of its entries.
+	Tagged []string
+	// Untagged excludes the groups having any of the given tags, attached to
+	// the group itself or to any of its entries.
+	Untagged []string
+	// Accepted includes the groups that were accepted as they are, which are
+	// otherwise skipped.
+	Accepted bool
+	// Federated is whether the entries come from several databases, see
+	// Federate.
+	Federated bool
+	// Offline skips checking the files on disk, e.g. for indexes of other
+	// machines; the keeper is then chosen by path only, and the space taken
+	// up by the files is assumed to be their size.
+	Offline bool
+	// Sidecars attaches sidecar files (e.g. XMP, THM) to their media file:
+	// they are listed with it instead of being grouped on their own, so that
+	// they can be handled along with it.
+	Sidecars bool
+}
+
+// tagged returns the SQL condition selecting the hashes of the groups having
+// any of the given tags, along with its parameters.
+func tagged(labels []string) (string, []any) {
+	in := "?" + strings.Repeat(", ?", len(labels)-1)
+	condition := fmt.Sprintf("(hash in (select g.hash from duplicate_groups g join tags t on t.kind = 'group' and t.target = cast(g.id as text) where t.label in (%s)) or hash in (select e.hash from entries e join tags t on t.kind = 'entry' and t.target = e.path where t.label in (%s)))", in, in)
+	params := []any{}
+	for i := 0; i < 2; i++ {
+		for _, label := range labels {
+			params = append(params, label)
+		}
+	}
+	return condition, params
+}
+
+// scope returns the SQL condition and parameters selecting the entries that
+// can be acted upon; entries outside the Within directory are left out by
+// Scan, since indexed paths may be relative.
+func (o *Options) scope() (string, []any) {
+	conditions := []string{"1 = 1"}
+	params := []any{}
+	if o.Bucket != "" {
+		conditions = append(conditions, "bucket = ?")
+		params = append(params, o.Bucket)
+	}
+	if o.Against != "" {
+		conditions = append(conditions, "coalesce(bucket, '') != ?")
+		params = append(params, o.Against)
+	}
+	return strings.Join(conditions, " and "), params
+}
+
+// query returns the SQL query and parameters loading the entries of the
+// duplicate groups, ordered by hash.
+func (o *Options) query() (string, []any) {
+	scope, params := o.scope()
+	source := "''"
+	if o.Federated {
+		source = "source"
+	}
+	columns := fmt.Sprintf("select (select id from duplicate_groups where hash = entries.hash), hash, path, coalesce(bucket, ''), coalesce(size, 0), coalesce(allocated, size, 0), coalesce((select reference from buckets where name = entries.bucket), 0), %s, coalesce(fork_of, ''), coalesce(uid, -1), coalesce(gid, -1), coalesce(mode, 0), coalesce(acl, '') from entries", source)
+	var query string
+	var all []any
+	if o.Against == "" {
+		query = fmt.Sprintf("%s where (%s and hash in (select hash from entries where %s group by hash having count(*) > 1))", columns, scope, scope)
+		all = append(append(all, params...), params...)
+	} else {
+		query = fmt.Sprintf("%s where ((%s and hash in (select hash from entries where bucket = ?)) or (bucket = ? and hash in (select hash from entries where %s)))", columns, scope, scope)
+		all = append(all, params...)
+		all = append(all, o.Against, o.Against)
+		all = append(all, params...)
+	}
+	if len(o.Groups) > 0 {
+		query += " and hash in (select hash from duplicate_groups where id in (?" + strings.Repeat(", ?", len(o.Groups)-1) + "))"
+		for _, id := range o.Groups {
+			all = append(all, id)
+		}
+	}
+	if !o.Accepted {
+		query += " and hash not in (select g.hash from duplicate_groups g join accepted a on a.group_id = g.id)"
+	}
+	if len(o.Tagged) > 0 {
+		condition, params := tagged(o.Tagged)
+		query += " and " + condition
+		all = append(all, params...)
+	}
+	if len(o.Untagged) > 0 {
+		condition, params := tagged(o.Untagged)
+		query += " and not " + condition
+		all = append(all, params...)
+	}
+	return query + " order by hash, path", all
+}
+
+// Scan loads the duplicate groups matching the options and calls the given
+// function on each of them, in order of hash; entries no longer present on
+// disk with the indexed size are left out, and groups with fewer than two
+// remaining entries are skipped.
+func Scan(db *sql.DB, options *Options, fn func(*Group) error) error {
+	if options == nil {
+		options = &Options{}
+	}
+	// only compare buckets whose hashes are comparable
+	names := []string{}
+	if options.Bucket != "" {
+		names = append(names, options.Bucket)
+		if options.Against != "" {
+			names = append(names, options.Against)
+		}
+	}
+	if err := buckets.Compatible(db, names...); err != nil {
+		return err
+	}
+	// assign stable identifiers to the groups seen for the first time
+	if _, err := db.Exec("insert or ignore into duplicate_groups(hash, created) select hash, ? from entries group by hash having count(*) > 1", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		slog.Error("error assigning duplicate group identifiers", "error", err)
+		return err
+	}
+	// federated databases are restricted to a single connection, so the
+	// forks must be loaded before the entries are
+	forks, err := pairedForks(db)
+	if err != nil {
+		return err
+	}
+	query, params := options.query()
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		slog.Error("error querying duplicate entries", "error", err)
+		return err
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	var id int64
+	dirs := directories{}
+	emit := func() error {
+		if group := newGroup(entries, options); group != nil {
+			group.ID = id
+			if err := fn(group); err != nil {
+				return err
+			}
+		}
+		entries = nil
+		return nil
+	}
+	for rows.Next() {
+		entry := &Entry{}
+		var next int64
+		var forkOf string
+		if err := rows.Scan(&next, &entry.Hash, &entry.Path, &entry.Bucket, &entry.Size, &entry.Allocated, &entry.Reference, &entry.Source, &forkOf, &entry.UID, &entry.GID, &entry.Mode, &entry.ACL); err != nil {
+			slog.Error("error reading duplicate entry", "error", err)
+			return err
+		}
+		if len(entries) > 0 && entries[0].Hash != entry.Hash {
+			if err := emit(); err != nil {
+				return err
+			}
+		}
+		id = next
+		// resource forks are handled along with their data file
+		if forkOf != "" {
+			slog.Debug("resource fork paired with its data file, ignoring", "path", entry.Path, "data file", forkOf)
+			continue
+		}
+		// copies in the bucket compared against are never acted upon, and
+		// are kept wherever they are
+		if options.Within != "" && (options.Against == "" || entry.Bucket != options.Against) && !Under(entry.Path, options.Within) {
+			continue
+		}
+		entry.Forks = slices.Clone(forks[entry.Path])
+		if options.Offline {
+			// the allocated size recorded at indexing time is the best guess
+			entry.Links = 1
+			entries = append(entries, entry)
+			continue
+		}
+		info, err := os.Stat(entry.Path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != entry.Size {
+			slog.Warn("file changed since indexing, ignoring", "path", entry.Path)
+			continue
+		}
+		entry.Modified = info.ModTime()
+		fileID(entry, info)
+		entry.Forks = slices.DeleteFunc(entry.Forks, func(fork string) bool {
+			_, err := os.Lstat(fork)
+			return err != nil
+		})
+		// extents already shared with other files (reflinks, snapshots) are
+		// not freed by removing the file
+		if shared := sharedBytes(entry.Path); shared > 0 {
+			entry.Allocated = max(entry.Allocated-shared, 0)
+		}
+		if options.Sidecars {
+			if dirs.attached(entry.Path) {
+				slog.Debug("sidecar attached to its media file, ignoring", "path", entry.Path)
+				continue
+			}
+			entry.Sidecars = dirs.sidecars(entry.Path)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading duplicate entries", "error", err)
+		return err
+	}
+	return emit()
+}
+
+// pairedForks returns the paths of the AppleDouble files paired with their
+// data file at indexing time, by path of the data file.
+func pairedForks(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query("select path, fork_of from entries where fork_of is not null order by path")
+	if err != nil {
+		slog.Error("error querying resource forks", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	forks := map[string][]string{}
+	for rows.Next() {
+		var path, forkOf string
+		if err := rows.Scan(&path, &forkOf); err != nil {
+			slog.Error("error reading resource fork", "error", err)
+			return nil, err
+		}
+		forks[forkOf] = append(forks[forkOf], path)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("error reading resource forks", "error", err)
+		return nil, err
+	}
+	return forks, nil
+}
+
+// newGroup creates a group out of the given entries, selecting the keeper, or
+// returns nil if there are not enough entries to have duplicates.
+func newGroup(entries []*Entry, options *Options) *Group {
+	if len(entries) < 2 {
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		pi, pj := priority(entries[i].Path, options.Prefer), priority(entries[j].Path, options.Prefer)
+		if pi != pj {
+			return pi < pj
+		}
+		if oi, oj := owned(entries[i], options.Owners), owned(entries[j], options.Owners); oi != oj {
+			return oi < oj
+		}
+		return before(entries[i], entries[j])
+	})
+	// copies in the bucket compared against come first among the references
+	against, references, duplicates := []*Entry{}, []*Entry{}, []*Entry{}
+	for _, entry := range entries {
+		switch {
+		case options.Against != "" && entry.Bucket == options.Against:
+			against = append(against, entry)
+		case entry.Reference:
+			references = append(references, entry)
+		default:
+			duplicates = append(duplicates, entry)
+		}
+	}
+	if options.Against != "" && len(against) == 0 {
+		return nil
+	}
+	references = append(against, references...)
+	// the keeper is the best copy in the reference set, if any
+	var keeper *Entry
+	if len(references) == 0 {
+		keeper, duplicates = duplicates[0], duplicates[1:]
+	} else {
+		keeper, references = references[0], references[1:]
+	}
+	duplicates = distinct(keeper, references, duplicates)
+	if len(duplicates) == 0 {
+		return nil
+	}
+	return &Group{
+		Hash:       keeper.Hash,
+		Size:       keeper.Size,
+		Keeper:     keeper,
+		Duplicates: duplicates,
+		References: references,
+	}
+}
+
+// distinct returns the duplicates that are not the keeper, or one of the
+// references, under another path: the same file may be indexed under several
+// paths (relative and absolute, hard links, bind mounts), and acting on it as
+// a duplicate would destroy the copy it duplicates. Paths listed more than
+// once are only returned once.
+func distinct(keeper *Entry, references []*Entry, duplicates []*Entry) []*Entry {
+	protected := map[any]bool{keeper.id(): true}
+	for _, entry := range references {
+		protected[entry.id()] = true
+	}
+	seen := map[location]bool{}
+	result := []*Entry{}
+	for _, entry := range duplicates {
+		path := location{source: entry.Source, path: Absolute(entry.Path)}
+		if protected[entry.id()] || seen[path] {
+			slog.Debug("duplicate is the same file as another copy, ignoring", "path", entry.Path, "keeper", keeper.Path)
+			continue
+		}
+		seen[path] = true
+		result = append(result, entry)
+	}
+	return result
+}
+
+// priority returns the index of the first of the preferred prefixes the given
+// path is under, or the number of prefixes if it is under none of them.
+func priority(path string, prefer []string) int {
+	for i, prefix := range prefer {
+		prefix = filepath.Clean(prefix)
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, string(filepath.Separator))+string(filepath.Separator)) {
+			return i
+		}
+	}
+	return len(prefer)
+}
+
+// owned returns the index of the owner of the given entry among the preferred
+// owners, or the number of owners if it is owned by none of them.
+func owned(entry *Entry, owners []int64) int {
+	if entry.UID >= 0 {
+		for i, uid := range owners {
+			if entry.UID == uid {
+				return i
+			}
+		}
+	}
+	return len(owners)
+}
+
+// before returns whether entry a is a better keeper than entry b: the oldest
+// copy is preferred, then the one with the shortest path.
+func before(a, b *Entry) bool {
+	if !a.Modified.Equal(b.Modified) {
+		return a.Modified.Before(b.Modified)
+	}
+	if len(a.Path) != len(b.Path) {
+		return len(a.Path) < len(b.Path)
+	}
+	return a.Path < b.Path
+}
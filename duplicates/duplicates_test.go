@@ -0,0 +1,110 @@
+package duplicates
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/dihedron/dedup/internal/testutil"
+)
+
+func TestScanSameFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// paths are indexed with the same contents; relative paths are
+		// relative to the current directory, which holds files a and b, and
+		// the hard link l to a.
+		paths []string
+		// groups are the expected groups, each listing the keeper first and
+		// then the duplicates.
+		groups [][]string
+	}{
+		{name: "relative and absolute", paths: []string{"a", "$/a"}},
+		{name: "relative, absolute and unclean", paths: []string{"a", "$/a", "./x/../a"}},
+		{name: "hard link", paths: []string{"a", "l"}},
+		{name: "relative and absolute with a copy", paths: []string{"a", "$/a", "b"}, groups: [][]string{{"a", "b"}}},
+		{name: "copy relative and absolute", paths: []string{"a", "b", "$/b"}, groups: [][]string{{"a", "b"}}},
+		{name: "hard link with a copy", paths: []string{"a", "l", "b"}, groups: [][]string{{"a", "b"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testutil.Database(t)
+			dir := t.TempDir()
+			t.Chdir(dir)
+			for _, name := range []string{"a", "b"} {
+				if err := os.WriteFile(name, []byte("contents"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Mkdir("x", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Link("a", "l"); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range test.paths {
+				if len(path) > 0 && path[0] == '$' {
+					path = dir + path[1:]
+				}
+				if _, err := db.Exec("insert into entries(hash, path, bucket, size) values('h', ?, 'b', 8)", path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// all the copies have the same modification time, so the keeper
+			// is the one with the shortest path
+			var groups [][]string
+			err := Scan(db, nil, func(group *Group) error {
+				names := []string{filepath.Base(group.Keeper.Path)}
+				for _, entry := range group.Duplicates {
+					names = append(names, filepath.Base(entry.Path))
+				}
+				groups = append(groups, names)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(groups, test.groups, slices.Equal) {
+				t.Errorf("expected groups %v, got %v", test.groups, groups)
+			}
+		})
+	}
+}
+
+func TestSameFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(name, []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link("a", "l"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a"), "s"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		keeper string
+		same   bool
+	}{
+		{"a", "a", true},
+		{"a", filepath.Join(dir, "a"), true},
+		{"./x/../a", "a", true},
+		{"l", "a", true},
+		{"s", "a", true},
+		{"b", "a", false},
+		{filepath.Join(dir, "b"), "a", false},
+	}
+	for _, test := range tests {
+		t.Run(test.path+" "+test.keeper, func(t *testing.T) {
+			err := SameFile(test.path, test.keeper)
+			if same := err == ErrSameFile; same != test.same || (err != nil && !same) {
+				t.Errorf("expected same file %t, got %v", test.same, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package duplicates
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fsIocFiemap is the FS_IOC_FIEMAP ioctl request.
+	fsIocFiemap = 0xC020660B
+	// fiemapExtentLast marks the last extent of a file.
+	fiemapExtentLast = 0x1
+	// fiemapExtentShared marks an extent shared with other files.
+	fiemapExtentShared = 0x2000
+	// fiemapBatch is the number of extents requested per call.
+	fiemapBatch = 64
+)
+
+// fiemapExtent is struct fiemap_extent from linux/fiemap.h.
+type fiemapExtent struct {
+	Logical  uint64
+	Physical uint64
+	Length   uint64
+	_        [2]uint64
+	Flags    uint32
+	_        [3]uint32
+}
+
+// fiemap is struct fiemap from linux/fiemap.h, followed by room for a batch
+// of extents.
+type fiemap struct {
+	Start         uint64
+	Length        uint64
+	Flags         uint32
+	MappedExtents uint32
+	ExtentCount   uint32
+	_             uint32
+	Extents       [fiemapBatch]fiemapExtent
+}
+
+// sharedBytes returns the number of bytes of the file stored in extents that
+// are shared with other files, e.g. reflinked copies or snapshots on btrfs and
+// XFS; it returns 0 if the filesystem cannot tell.
+func sharedBytes(path string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	var shared int64
+	m := &fiemap{Length: ^uint64(0)}
+	for {
+		m.MappedExtents = 0
+		m.ExtentCount = fiemapBatch
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(m))); errno != 0 {
+			return 0
+		}
+		if m.MappedExtents == 0 {
+			return shared
+		}
+		for _, e := range m.Extents[:m.MappedExtents] {
+			if e.Flags&fiemapExtentShared != 0 {
+				shared += int64(e.Length)
+			}
+			if e.Flags&fiemapExtentLast != 0 {
+				return shared
+			}
+		}
+		last := m.Extents[m.MappedExtents-1]
+		m.Start = last.Logical + last.Length
+	}
+}
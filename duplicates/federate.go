@@ -0,0 +1,44 @@
+package duplicates
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Federate attaches the given databases to the (main) database, and shadows
+// the entries and buckets tables with temporary views spanning all of them,
+// so that duplicates can be computed across several indexes without merging
+// them; each entry records the database it comes from in its Source. Group
+// identifiers, tags and accepted groups are those of the main database.
+// Attached databases and temporary views only exist on the connection they
+// were created on, so the database is restricted to a single connection.
+func Federate(db *sql.DB, main string, others []string) error {
+	db.SetMaxOpenConns(1)
+	entries := []string{fmt.Sprintf("select hash, path, bucket, size, allocated, fork_of, uid, gid, mode, acl, %s as source from main.entries", quote(main))}
+	buckets := []string{"select name, reference, algorithm from main.buckets"}
+	for i, path := range others {
+		schema := fmt.Sprintf("federated%d", i+1)
+		if _, err := db.Exec(fmt.Sprintf("attach database ? as %s", schema), path); err != nil {
+			slog.Error("error attaching database", "path", path, "error", err)
+			return err
+		}
+		entries = append(entries, fmt.Sprintf("select hash, path, bucket, size, allocated, fork_of, uid, gid, mode, acl, %s from %s.entries", quote(path), schema))
+		buckets = append(buckets, fmt.Sprintf("select name, reference, algorithm from %s.buckets", schema))
+	}
+	if _, err := db.Exec("create temp view entries as " + strings.Join(entries, " union all ")); err != nil {
+		slog.Error("error creating federated entries view", "error", err)
+		return err
+	}
+	if _, err := db.Exec("create temp view buckets as " + strings.Join(buckets, " union all ")); err != nil {
+		slog.Error("error creating federated buckets view", "error", err)
+		return err
+	}
+	return nil
+}
+
+// quote returns the given value as an SQL string literal.
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package duplicates
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID fills in the storage details of the entry from the given file info:
+// the device and inode identify the file contents, which are shared by all
+// the hard links to the same file.
+func fileID(entry *Entry, info fs.FileInfo) {
+	entry.Allocated = info.Size()
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.Device = uint64(stat.Dev)
+		entry.Inode = uint64(stat.Ino)
+		entry.Links = uint64(stat.Nlink)
+		entry.Allocated = int64(stat.Blocks) * 512
+	}
+}
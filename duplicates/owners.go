@@ -0,0 +1,130 @@
+package duplicates
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Ownership is the owner, group, mode and ACL of a file, as recorded at
+// indexing time (see index --ownership and --acls).
+type Ownership struct {
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+	Mode  string `json:"mode,omitempty"`
+	ACL   string `json:"acl,omitempty"`
+}
+
+// String returns the ownership as user:group mode, with a trailing + if the
+// file has an ACL, like ls does.
+func (o *Ownership) String() string {
+	fields := []string{}
+	if o.User != "" {
+		fields = append(fields, o.User+":"+o.Group)
+	}
+	switch {
+	case o.Mode != "" && o.ACL != "":
+		fields = append(fields, o.Mode+"+")
+	case o.Mode != "":
+		fields = append(fields, o.Mode)
+	case o.ACL != "":
+		fields = append(fields, "acl")
+	}
+	return strings.Join(fields, " ")
+}
+
+// Ownership returns the recorded ownership of the entry, or nil if neither
+// its owner nor its ACL were recorded.
+func (e *Entry) Ownership() *Ownership {
+	if e.UID < 0 && e.ACL == "" {
+		return nil
+	}
+	o := &Ownership{ACL: e.ACL}
+	if e.UID >= 0 {
+		o.User = names.user(e.UID)
+		o.Group = names.group(e.GID)
+	}
+	if e.Mode != 0 {
+		o.Mode = fmt.Sprintf("%04o", e.Mode&0o7777)
+	}
+	return o
+}
+
+// Owner returns the name of the recorded owner of the entry, or its numeric
+// identifier if it is not a known user, or an empty string if the owner was
+// not recorded.
+func (e *Entry) Owner() string {
+	if e.UID < 0 {
+		return ""
+	}
+	return names.user(e.UID)
+}
+
+// LookupOwners returns the user identifiers of the given users, given by name
+// or by numeric identifier.
+func LookupOwners(users []string) ([]int64, error) {
+	uids := []int64{}
+	for _, name := range users {
+		if uid, err := strconv.ParseInt(name, 10, 64); err == nil {
+			uids = append(uids, uid)
+			continue
+		}
+		u, err := user.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		uid, err := strconv.ParseInt(u.Uid, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("user %s has no numeric identifier (%s)", name, u.Uid)
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+// directory caches the names of users and groups, which are looked up once
+// per identifier.
+type directory struct {
+	lock   sync.Mutex
+	users  map[int64]string
+	groups map[int64]string
+}
+
+// names is the cache of the names of users and groups.
+var names = &directory{users: map[int64]string{}, groups: map[int64]string{}}
+
+// user returns the name of the user with the given identifier, or the
+// identifier itself if there is no such user.
+func (d *directory) user(uid int64) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if name, ok := d.users[uid]; ok {
+		return name
+	}
+	name := strconv.FormatInt(uid, 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	d.users[uid] = name
+	return name
+}
+
+// group returns the name of the group with the given identifier, or the
+// identifier itself if there is no such group.
+func (d *directory) group(gid int64) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if name, ok := d.groups[gid]; ok {
+		return name
+	}
+	name := strconv.FormatInt(gid, 10)
+	if gid < 0 {
+		name = ""
+	} else if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	d.groups[gid] = name
+	return name
+}
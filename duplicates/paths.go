@@ -0,0 +1,54 @@
+package duplicates
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSameFile is returned when a duplicate turns out to be the very file it
+// is a duplicate of, indexed under another path.
+var ErrSameFile = errors.New("duplicate is the same file as its keeper")
+
+// Absolute returns the absolute form of an indexed path: indexes built from
+// relative roots store paths relative to the directory index was run from,
+// which are resolved against the current directory, as when the files are
+// opened.
+func Absolute(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return filepath.Clean(path)
+}
+
+// Under returns whether the indexed path is the given directory or is inside
+// it, once both are made absolute, so that relative and absolute paths to the
+// same files compare equal.
+func Under(path string, dir string) bool {
+	path, dir = Absolute(path), Absolute(dir)
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// SameFile returns ErrSameFile if the two indexed paths are the same file on
+// disk: the same path once made absolute, or the same device and inode, as
+// with hard links and bind mounts; removing or replacing the duplicate would
+// then destroy its keeper too. Other errors mean that the files could not be
+// compared.
+func SameFile(path string, keeper string) error {
+	if Absolute(path) == Absolute(keeper) {
+		return ErrSameFile
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	keeperInfo, err := os.Stat(keeper)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(info, keeperInfo) {
+		return ErrSameFile
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package duplicates
+
+// inode identifies the contents of a file on a device.
+type inode struct {
+	device uint64
+	inode  uint64
+}
+
+// location identifies a file in a database, when inodes are not available.
+type location struct {
+	source string
+	path   string
+}
+
+// id returns the identifier of the contents of the entry; on platforms where
+// inodes are not available (or files are not checked on disk), each path,
+// once made absolute, is assumed to have its own contents.
+func (e *Entry) id() any {
+	if e.Inode == 0 {
+		return location{source: e.Source, path: Absolute(e.Path)}
+	}
+	return inode{device: e.Device, inode: e.Inode}
+}
+
+// Reclaimable returns the number of bytes that would actually be freed on
+// disk by removing (or replacing with links) all the duplicates in the group:
+// duplicates that are hard links to the keeper free nothing, and other files
+// are only freed when all their hard links are among the duplicates; the
+// allocated size is used, so that sparse files are not overestimated.
+func (g *Group) Reclaimable() int64 {
+	keeper := g.Keeper.id()
+	paths := map[any]uint64{}
+	sizes := map[any]*Entry{}
+	for _, entry := range g.Duplicates {
+		id := entry.id()
+		if id == keeper {
+			continue
+		}
+		paths[id]++
+		sizes[id] = entry
+	}
+	var total int64
+	for id, entry := range sizes {
+		if paths[id] >= entry.Links {
+			total += entry.Allocated
+		}
+	}
+	return total
+}
+
+// Shareable returns the number of bytes that would be freed by sharing the
+// extents of the keeper with the duplicates (e.g. with reflinks): each file
+// other than the keeper is freed once, regardless of its hard links.
+func (g *Group) Shareable() int64 {
+	keeper := g.Keeper.id()
+	seen := map[any]bool{keeper: true}
+	var total int64
+	for _, entry := range g.Duplicates {
+		if id := entry.id(); !seen[id] {
+			seen[id] = true
+			total += entry.Allocated
+		}
+	}
+	return total
+}
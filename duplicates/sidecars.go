@@ -0,0 +1,103 @@
+package duplicates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SidecarExtensions are the extensions of the files holding metadata about a
+// media file with the same name: XMP (edits and ratings), THM (camera video
+// thumbnails), SRT (subtitles, drone telemetry) and AAE (iOS edits).
+var SidecarExtensions = []string{".xmp", ".thm", ".srt", ".aae"}
+
+// IsSidecar returns whether the given path has a sidecar extension.
+func IsSidecar(path string) bool {
+	extension := strings.ToLower(filepath.Ext(path))
+	for _, candidate := range SidecarExtensions {
+		if extension == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// directories caches the names of the files in directories, so that sidecars
+// are looked up without listing the same directory over and over.
+type directories map[string][]string
+
+// names returns the names of the regular files in the given directory.
+func (d directories) names(dir string) []string {
+	if names, ok := d[dir]; ok {
+		return names
+	}
+	names := []string{}
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	d[dir] = names
+	return names
+}
+
+// sidecars returns the paths of the sidecar files of the given media file,
+// named either after the whole file name (IMG_1234.JPG.xmp) or after its stem
+// (IMG_1234.xmp), in any letter case.
+func (d directories) sidecars(path string) []string {
+	if IsSidecar(path) {
+		return nil
+	}
+	dir, name := filepath.Split(path)
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	result := []string{}
+	for _, candidate := range d.names(filepath.Clean(dir)) {
+		if !IsSidecar(candidate) {
+			continue
+		}
+		base := strings.TrimSuffix(candidate, filepath.Ext(candidate))
+		if strings.EqualFold(base, name) || strings.EqualFold(base, stem) {
+			result = append(result, filepath.Join(dir, candidate))
+		}
+	}
+	return result
+}
+
+// attached returns whether the given file is the sidecar of a media file in
+// the same directory.
+func (d directories) attached(path string) bool {
+	if !IsSidecar(path) {
+		return false
+	}
+	dir, name := filepath.Split(path)
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, candidate := range d.names(filepath.Clean(dir)) {
+		if IsSidecar(candidate) {
+			continue
+		}
+		if strings.EqualFold(candidate, base) || strings.EqualFold(strings.TrimSuffix(candidate, filepath.Ext(candidate)), base) {
+			return true
+		}
+	}
+	return false
+}
+
+// SidecarName returns the name a sidecar of the given media file should have
+// once the media file is renamed to target, keeping the naming scheme (after
+// the whole name or after the stem) and the sidecar extension.
+func SidecarName(sidecar string, media string, target string) string {
+	name, original := filepath.Base(sidecar), filepath.Base(media)
+	renamed := filepath.Base(target)
+	if prefix := name[:len(name)-len(filepath.Ext(name))]; strings.EqualFold(prefix, original) {
+		return renamed + filepath.Ext(name)
+	}
+	return strings.TrimSuffix(renamed, filepath.Ext(renamed)) + filepath.Ext(name)
+}
+
+// ForkName returns the name the AppleDouble file holding the resource fork of
+// a file should have once the file is renamed to target.
+func ForkName(target string) string {
+	return "._" + filepath.Base(target)
+}
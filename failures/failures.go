@@ -0,0 +1,117 @@
+// Package failures defines the errors affecting single files, which commands
+// record and get past, and the error reporting that a command completed with
+// some of them.
+package failures
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// WalkError is an error visiting a path while walking a directory tree.
+type WalkError struct {
+	Path string
+	Err  error
+}
+
+func (e *WalkError) Error() string { return fmt.Sprintf("walking %s: %v", e.Path, e.Err) }
+func (e *WalkError) Unwrap() error { return e.Err }
+
+// HashError is an error reading or hashing the contents of a file.
+type HashError struct {
+	Path string
+	Err  error
+}
+
+func (e *HashError) Error() string { return fmt.Sprintf("hashing %s: %v", e.Path, e.Err) }
+func (e *HashError) Unwrap() error { return e.Err }
+
+// StoreError is an error storing the entry of a file in the index.
+type StoreError struct {
+	Path string
+	Err  error
+}
+
+func (e *StoreError) Error() string { return fmt.Sprintf("storing %s: %v", e.Path, e.Err) }
+func (e *StoreError) Unwrap() error { return e.Err }
+
+// ActionError is an error acting on a file (e.g. moving or linking it).
+type ActionError struct {
+	Action string
+	Path   string
+	Err    error
+}
+
+func (e *ActionError) Error() string { return fmt.Sprintf("%s %s: %v", e.Action, e.Path, e.Err) }
+func (e *ActionError) Unwrap() error { return e.Err }
+
+// Tally counts the failures by category; it is safe for concurrent use.
+type Tally struct {
+	Walk   int64 `json:"walk,omitempty"`
+	Hash   int64 `json:"hash,omitempty"`
+	Store  int64 `json:"store,omitempty"`
+	Action int64 `json:"action,omitempty"`
+	Other  int64 `json:"other,omitempty"`
+}
+
+// Add counts the given failure in its category.
+func (t *Tally) Add(err error) {
+	var (
+		walk   *WalkError
+		hash   *HashError
+		store  *StoreError
+		action *ActionError
+	)
+	switch {
+	case errors.As(err, &walk):
+		atomic.AddInt64(&t.Walk, 1)
+	case errors.As(err, &hash):
+		atomic.AddInt64(&t.Hash, 1)
+	case errors.As(err, &store):
+		atomic.AddInt64(&t.Store, 1)
+	case errors.As(err, &action):
+		atomic.AddInt64(&t.Action, 1)
+	default:
+		atomic.AddInt64(&t.Other, 1)
+	}
+}
+
+// String returns the non-zero counts, e.g. "2 walk, 1 hash".
+func (t *Tally) String() string {
+	s := ""
+	for _, c := range []struct {
+		name  string
+		count int64
+	}{{"walk", t.Walk}, {"hash", t.Hash}, {"store", t.Store}, {"action", t.Action}, {"other", t.Other}} {
+		if c.count == 0 {
+			continue
+		}
+		if s != "" {
+			s += ", "
+		}
+		s += fmt.Sprintf("%d %s", c.count, c.name)
+	}
+	return s
+}
+
+// PartialError is returned by commands that completed, but failed on some
+// files; the process then exits with ExitPartial.
+type PartialError struct {
+	Failed int64
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("completed with %d failures", e.Failed)
+}
+
+// Partial returns a PartialError if any file failed, nil otherwise.
+func Partial(failed int64) error {
+	if failed == 0 {
+		return nil
+	}
+	return &PartialError{Failed: failed}
+}
+
+// ExitPartial is the exit code of a command that failed on some files only.
+const ExitPartial = 2
@@ -0,0 +1,54 @@
+// Package testutil provides the fixtures shared by the tests of the other
+// packages.
+package testutil
+
+import (
+	"database/sql"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Database returns a new database in a temporary directory, opened as the
+// commands open it and migrated up; it is closed when the test ends.
+func Database(t *testing.T) *sql.DB {
+	t.Helper()
+	db, _ := DatabasePath(t)
+	return db
+}
+
+// DatabasePath is like Database, and also returns the path of the database,
+// for running commands that open it themselves.
+func DatabasePath(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	db, err := sql.Open("sqlite3", path+"?_journal=WAL&_timeout=5000&_fk=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration, err := migrate.NewWithDatabaseInstance("file://"+migrations(), "sqlite3", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migration.Up(); err != nil {
+		t.Fatal(err)
+	}
+	return db, path
+}
+
+// migrations returns the path of the migrations directory, wherever the
+// tests are run from.
+func migrations() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "migrations")
+}
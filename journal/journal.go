@@ -0,0 +1,107 @@
+// Package journal records the actions performed on the filesystem, so that
+// they can be reviewed and undone.
+package journal
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Record is an action performed on a file.
+type Record struct {
+	// ID is the unique identifier of the record.
+	ID int64
+	// Run identifies the command execution the action was performed in.
+	Run string
+	// Action is the kind of action (e.g. "symlink").
+	Action string
+	// Path is the path of the file the action was performed on.
+	Path string
+	// Target is the path of the other file involved in the action, if any
+	// (e.g. the file a symlink points to).
+	Target string
+	// Hash is the hash of the contents of the file.
+	Hash string
+	// Bucket is the bucket the file was indexed into.
+	Bucket string
+	// Size is the size of the file.
+	Size int64
+	// Group is the identifier of the duplicate group the file belonged to,
+	// if any.
+	Group int64
+}
+
+// Journal records actions in the database, grouping them by run.
+type Journal struct {
+	db  *sql.DB
+	run string
+}
+
+// New creates a journal for a new run.
+func New(db *sql.DB) *Journal {
+	return &Journal{
+		db:  db,
+		run: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// Run returns the identifier of the run.
+func (j *Journal) Run() string {
+	return j.run
+}
+
+// Add records an action.
+func (j *Journal) Add(record *Record) error {
+	_, err := j.db.Exec("insert into journal(run, action, path, target, hash, bucket, size, group_id, created) values(?, ?, ?, ?, ?, ?, ?, nullif(?, 0), ?)",
+		j.run, record.Action, record.Path, record.Target, record.Hash, record.Bucket, record.Size, record.Group, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		slog.Error("error adding journal record", "path", record.Path, "action", record.Action, "error", err)
+	}
+	return err
+}
+
+// LastRun returns the identifier of the most recent run that performed the
+// given action and was not undone, or an empty string; runs are ordered by
+// when their records were added, since identifiers with a variable number of
+// fractional digits do not sort chronologically as strings.
+func LastRun(db *sql.DB, action string) (string, error) {
+	var run string
+	err := db.QueryRow("select run from journal where action = ? and undone = 0 order by id desc limit 1", action).Scan(&run)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("error querying last journal run", "action", action, "error", err)
+		return "", err
+	}
+	return run, nil
+}
+
+// Records returns the records of the given run and action that were not
+// undone, most recent first.
+func Records(db *sql.DB, run string, action string) ([]*Record, error) {
+	rows, err := db.Query("select id, run, action, path, coalesce(target, ''), coalesce(hash, ''), coalesce(bucket, ''), coalesce(size, 0), coalesce(group_id, 0) from journal where run = ? and action = ? and undone = 0 order by id desc", run, action)
+	if err != nil {
+		slog.Error("error querying journal records", "run", run, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+	records := []*Record{}
+	for rows.Next() {
+		record := &Record{}
+		if err := rows.Scan(&record.ID, &record.Run, &record.Action, &record.Path, &record.Target, &record.Hash, &record.Bucket, &record.Size, &record.Group); err != nil {
+			slog.Error("error reading journal record", "error", err)
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// Undone marks the given record as undone.
+func Undone(db *sql.DB, record *Record) error {
+	_, err := db.Exec("update journal set undone = 1 where id = ?", record.ID)
+	if err != nil {
+		slog.Error("error marking journal record as undone", "id", record.ID, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package locale
+
+import (
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message/catalog"
+)
+
+// it is the Italian catalog.
+func init() {
+	register(language.Italian, map[string]string{
+		// report
+		"%s %s (%d bytes, %d reclaimable)\n": "%s %s (%d byte, %d recuperabili)\n",
+		"  %s on %s\n":                       "  %s su %s\n",
+		"%s: some duplicates are on %s, which compresses or deduplicates data: logical savings may differ from physical savings\n": "%s: alcuni duplicati si trovano su %s, che comprime o deduplica i dati: lo spazio recuperato su disco può differire da quello stimato\n",
+		// triage-downloads
+		"%s %s\t%d\t(copy at %s)\n": "%s %s\t%d\t(copia in %s)\n",
+		// doctor
+		"       run doctor --fix to fix them\n":                                                               "       eseguire doctor --fix per correggerli\n",
+		"problems reported by the SQLite integrity check":                                                     "problemi segnalati dal controllo di integrità di SQLite",
+		"entries in buckets that are not registered":                                                          "voci in bucket non registrati",
+		"entries not in any bucket":                                                                           "voci non assegnate ad alcun bucket",
+		"paths indexed with more than one content":                                                            "percorsi indicizzati con più di un contenuto",
+		"entries whose hash was not computed with the algorithm of their bucket":                              "voci il cui hash non è stato calcolato con l'algoritmo del loro bucket",
+		"accepted groups that do not exist":                                                                   "gruppi accettati inesistenti",
+		"tags on entries or groups that do not exist":                                                         "etichette su voci o gruppi inesistenti",
+		"pending plan decisions on entries that do not exist":                                                 "decisioni di plan in sospeso su voci inesistenti",
+		"video and photo fingerprints of contents no longer indexed":                                          "impronte di video e foto di contenuti non più indicizzati",
+		"checkpoints of interrupted runs on buckets that do not exist":                                        "checkpoint di esecuzioni interrotte su bucket inesistenti",
+		"index their paths again with --bucket, or delete them with the query command":                        "indicizzarne di nuovo i percorsi con --bucket, o eliminarle con il comando query",
+		"index the bucket again from scratch with the --hash it was registered with":                          "indicizzare di nuovo da zero il bucket con l'algoritmo --hash con cui è stato registrato",
+		"restore the database from a backup (see replicate), or recover it with the sqlite3 .recover command": "ripristinare il database da una copia (vedi replicate), o recuperarlo con il comando .recover di sqlite3",
+	}, map[string][]catalog.Message{
+		// report
+		"%d duplicates in %d groups, %d bytes reclaimable\n": {
+			catalog.Var("duplicates", plural.Selectf(1, "%d", plural.One, "duplicato", plural.Other, "duplicati")),
+			catalog.Var("groups", plural.Selectf(2, "%d", plural.One, "gruppo", plural.Other, "gruppi")),
+			catalog.Var("reclaimable", plural.Selectf(3, "%d", plural.One, "recuperabile", plural.Other, "recuperabili")),
+			catalog.String("%[1]d ${duplicates} in %[2]d ${groups}, %[3]d byte ${reclaimable}\n"),
+		},
+		// triage-downloads
+		"%d of %d files (%d bytes) are safe to delete, since identical copies exist elsewhere (see clean --if-present-in)\n": {
+			catalog.Var("deleted", plural.Selectf(1, "%d", plural.One, "può essere eliminato", plural.Other, "possono essere eliminati")),
+			catalog.String("%[1]d file su %[2]d (%[3]d byte) ${deleted}, perché ne esistono copie identiche altrove (vedi clean --if-present-in)\n"),
+		},
+	})
+}
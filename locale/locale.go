@@ -0,0 +1,116 @@
+// Package locale translates the user-facing output of the commands: messages
+// are written in English in the code and looked up, by their exact text, in
+// the catalog of the selected language, falling back to English for messages
+// that have not been translated yet. Catalogs are golang.org/x/text message
+// catalogs, so translations can select the plural form of the language for the
+// numbers they print, and numbers are formatted the way the language does.
+//
+// Only the summaries of the report, triage-downloads and doctor commands have
+// been translated so far.
+package locale
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+var (
+	lock sync.RWMutex
+	// builder holds the catalogs of all the languages.
+	builder = catalog.NewBuilder(catalog.Fallback(language.English))
+	// languages are the languages with a catalog, besides English.
+	languages = map[string]language.Tag{}
+	// current prints in the selected language, nil for English.
+	current *message.Printer
+)
+
+// register adds the translations of the given language: messages maps each
+// message to its translation, plurals to the sequence of catalog messages
+// that select the plural forms of its translation.
+func register(tag language.Tag, messages map[string]string, plurals map[string][]catalog.Message) {
+	lock.Lock()
+	defer lock.Unlock()
+	for key, translation := range messages {
+		if err := builder.SetString(tag, key, translation); err != nil {
+			panic(fmt.Sprintf("invalid translation of %q: %v", key, err))
+		}
+	}
+	for key, translation := range plurals {
+		if err := builder.Set(tag, key, translation...); err != nil {
+			panic(fmt.Sprintf("invalid translation of %q: %v", key, err))
+		}
+	}
+	base, _ := tag.Base()
+	languages[base.String()] = tag
+}
+
+// Languages returns the languages with a catalog, besides English.
+func Languages() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Detect returns the language of the user from the environment, as set by
+// LC_ALL, LC_MESSAGES or LANG (e.g. it_IT.UTF-8), or an empty string.
+func Detect() string {
+	for _, variable := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(variable); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Set selects the language of the output, given as a language code (e.g. it)
+// or a POSIX locale (e.g. it_IT.UTF-8); it returns false, and falls back to
+// English, if there is no catalog for the language.
+func Set(name string) bool {
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, "_")
+	name = strings.ToLower(name)
+	lock.Lock()
+	defer lock.Unlock()
+	current = nil
+	if tag, ok := languages[name]; ok {
+		current = message.NewPrinter(tag, message.Catalog(builder))
+	}
+	return current != nil || name == "" || name == "en" || name == "c" || name == "posix"
+}
+
+// Fprintf formats the message in the selected language, or in English, and
+// writes it to w.
+func Fprintf(w io.Writer, format string, args ...any) (int, error) {
+	lock.RLock()
+	printer := current
+	lock.RUnlock()
+	if printer == nil {
+		return fmt.Fprintf(w, format, args...)
+	}
+	return printer.Fprintf(w, format, args...)
+}
+
+// Translate returns the translation of the message, which must not hold any
+// formatting verb, in the selected language, or the message itself.
+func Translate(text string) string {
+	lock.RLock()
+	printer := current
+	lock.RUnlock()
+	if printer == nil {
+		return text
+	}
+	return printer.Sprintf(text)
+}
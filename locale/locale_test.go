@@ -0,0 +1,33 @@
+package locale
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFprintf(t *testing.T) {
+	const format = "%d duplicates in %d groups, %d bytes reclaimable\n"
+	tests := []struct {
+		language string
+		args     []any
+		expected string
+	}{
+		{"en", []any{2, 1, 1234}, "2 duplicates in 1 groups, 1234 bytes reclaimable\n"},
+		{"it_IT.UTF-8", []any{1, 1, 1}, "1 duplicato in 1 gruppo, 1 byte recuperabile\n"},
+		{"it", []any{2, 3, 1234}, "2 duplicati in 3 gruppi, 1.234 byte recuperabili\n"},
+		{"xx", []any{2, 3, 1234}, "2 duplicates in 3 groups, 1234 bytes reclaimable\n"},
+	}
+	defer Set("")
+	for _, test := range tests {
+		t.Run(test.language, func(t *testing.T) {
+			Set(test.language)
+			var buffer bytes.Buffer
+			if _, err := Fprintf(&buffer, format, test.args...); err != nil {
+				t.Fatal(err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, buffer.String())
+			}
+		})
+	}
+}
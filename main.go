@@ -1,16 +1,24 @@
 package main
 
 import (
+	"errors"
 	"os"
 
 	command "github.com/dihedron/dedup/commands"
+	"github.com/dihedron/dedup/failures"
+	"github.com/dihedron/dedup/plugins"
 	"github.com/jessevdk/go-flags"
 )
 
 func main() {
 
 	options := command.Commands{}
-	if _, err := flags.NewParser(&options, flags.Default).Parse(); err != nil {
+	parser := flags.NewParser(&options, flags.Default)
+	command.Environment(parser)
+	_, err := parser.Parse()
+	// plugin processes do not outlive the command
+	plugins.Close()
+	if err != nil {
 		switch flagsErr := err.(type) {
 		case flags.ErrorType:
 			if flagsErr == flags.ErrHelp {
@@ -18,6 +26,11 @@ func main() {
 			}
 			os.Exit(1)
 		default:
+			// commands that got past failures on single files tell it apart
+			var partial *failures.PartialError
+			if errors.As(err, &partial) {
+				os.Exit(failures.ExitPartial)
+			}
 			os.Exit(1)
 		}
 	}
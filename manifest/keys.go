@@ -0,0 +1,58 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GenerateKey creates a new Ed25519 key pair, writing the private key to the
+// given path and the public key to the same path with a .pub extension.
+func GenerateKey(path string) error {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(private)+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".pub", []byte(base64.StdEncoding.EncodeToString(public)+"\n"), 0644)
+}
+
+// LoadPrivateKey reads a private key written by GenerateKey.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := load(path, ed25519.PrivateKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PrivateKey(data), nil
+}
+
+// LoadPublicKey reads a public key written by GenerateKey.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := load(path, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// load reads a base64-encoded key of the given size.
+func load(path string, size int) ([]byte, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in %s: %w", path, err)
+	}
+	if len(data) != size {
+		return nil, errors.New("invalid key size in " + path)
+	}
+	return data, nil
+}
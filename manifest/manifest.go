@@ -0,0 +1,206 @@
+// Package manifest reads and writes portable index manifests: compact text
+// files listing the hash, size and path relative to its root of each file,
+// optionally signed with an Ed25519 key, so that indexes can be carried to
+// and compared at another site without sharing the database. Manifests
+// can also list contents only (hash and size, without roots or paths), for
+// comparing sites without disclosing how their files are named or organised.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the version of the manifest format.
+const Version = 1
+
+const (
+	headerKeyword    = "dedup-manifest"
+	algorithmKeyword = "algorithm"
+	createdKeyword   = "created"
+	rootKeyword      = "root"
+	signatureKeyword = "signature"
+)
+
+// ErrInvalidSignature is returned when the signature of a manifest does not
+// match its contents or the given key.
+var ErrInvalidSignature = errors.New("invalid manifest signature")
+
+// ErrUnsigned is returned when a signature is required but the manifest is
+// not signed.
+var ErrUnsigned = errors.New("manifest is not signed")
+
+// Entry is a file listed in a manifest.
+type Entry struct {
+	// Hash is the hash of the file contents.
+	Hash string
+	// Size is the size of the file.
+	Size int64
+	// Path is the path of the file, relative to its root.
+	Path string
+}
+
+// Root is a directory tree listed in a manifest.
+type Root struct {
+	// Path is the absolute path of the root at the site the manifest was
+	// created at.
+	Path string
+	// Entries are the files under the root.
+	Entries []*Entry
+}
+
+// Manifest is a list of files, grouped by root.
+type Manifest struct {
+	// Algorithm is the hash algorithm the hashes were computed with.
+	Algorithm string
+	// Created is when the manifest was created.
+	Created time.Time
+	// Roots are the directory trees in the manifest.
+	Roots []*Root
+	// ContentsOnly is whether the manifest lists contents only: a single root
+	// with no path holds the entries, which have no path either.
+	ContentsOnly bool
+	// Signed is whether the manifest was signed, when read.
+	Signed bool
+}
+
+// Write writes the manifest, signing it if a private key is given.
+func (m *Manifest) Write(w io.Writer, key ed25519.PrivateKey) error {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %d\n", headerKeyword, Version)
+	fmt.Fprintf(&buffer, "%s %s\n", algorithmKeyword, m.Algorithm)
+	fmt.Fprintf(&buffer, "%s %s\n", createdKeyword, m.Created.UTC().Format(time.RFC3339))
+	for _, root := range m.Roots {
+		if m.ContentsOnly {
+			for _, entry := range root.Entries {
+				fmt.Fprintf(&buffer, "%s %d\n", entry.Hash, entry.Size)
+			}
+			continue
+		}
+		fmt.Fprintf(&buffer, "%s %s\n", rootKeyword, encode(root.Path))
+		for _, entry := range root.Entries {
+			fmt.Fprintf(&buffer, "%s %d %s\n", entry.Hash, entry.Size, encode(entry.Path))
+		}
+	}
+	if key != nil {
+		signature := ed25519.Sign(key, buffer.Bytes())
+		fmt.Fprintf(&buffer, "%s ed25519 %s\n", signatureKeyword, base64.StdEncoding.EncodeToString(signature))
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// Read reads a manifest; if a public key is given, the manifest must be
+// signed with the corresponding private key.
+func Read(r io.Reader, key ed25519.PublicKey) (*Manifest, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// the signature, if any, is on the last line and covers all the others
+	content := data
+	var signature []byte
+	if i := bytes.LastIndex(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")); i >= 0 && bytes.HasPrefix(data[i+1:], []byte(signatureKeyword+" ")) {
+		content = data[:i+1]
+		fields := strings.Fields(string(data[i+1:]))
+		if len(fields) != 3 || fields[1] != "ed25519" {
+			return nil, fmt.Errorf("%w: unsupported signature line", ErrInvalidSignature)
+		}
+		if signature, err = base64.StdEncoding.DecodeString(fields[2]); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+	}
+	if key != nil {
+		if signature == nil {
+			return nil, ErrUnsigned
+		}
+		if !ed25519.Verify(key, content, signature) {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	m := &Manifest{Signed: signature != nil}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var root *Root
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if line == 1 {
+			if text != fmt.Sprintf("%s %d", headerKeyword, Version) {
+				return nil, fmt.Errorf("not a version %d manifest", Version)
+			}
+			continue
+		}
+		keyword, rest, _ := strings.Cut(text, " ")
+		switch keyword {
+		case algorithmKeyword:
+			m.Algorithm = rest
+		case createdKeyword:
+			if m.Created, err = time.Parse(time.RFC3339, rest); err != nil {
+				return nil, fmt.Errorf("line %d: invalid creation time: %w", line, err)
+			}
+		case rootKeyword:
+			if m.ContentsOnly {
+				return nil, fmt.Errorf("line %d: root in contents only manifest", line)
+			}
+			path, err := decode(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid root: %w", line, err)
+			}
+			root = &Root{Path: path}
+			m.Roots = append(m.Roots, root)
+		default:
+			fields := strings.SplitN(text, " ", 3)
+			// entries with no path are only valid in contents only manifests,
+			// which have no roots
+			switch {
+			case len(fields) == 2 && (root == nil || m.ContentsOnly):
+				if root == nil {
+					m.ContentsOnly = true
+					root = &Root{}
+					m.Roots = append(m.Roots, root)
+				}
+			case len(fields) == 3 && root != nil && !m.ContentsOnly:
+			default:
+				return nil, fmt.Errorf("line %d: invalid entry", line)
+			}
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid size: %w", line, err)
+			}
+			entry := &Entry{Hash: fields[0], Size: size}
+			if len(fields) == 3 {
+				if entry.Path, err = decode(fields[2]); err != nil {
+					return nil, fmt.Errorf("line %d: invalid path: %w", line, err)
+				}
+			}
+			root.Entries = append(root.Entries, entry)
+		}
+	}
+	return m, scanner.Err()
+}
+
+// encode returns the path as is, or quoted if it could be misread (e.g. it
+// contains line breaks or starts with a quote).
+func encode(path string) string {
+	if strings.HasPrefix(path, "\"") || !strconv.CanBackquote(path) {
+		return strconv.Quote(path)
+	}
+	return path
+}
+
+// decode reverses encode.
+func decode(path string) (string, error) {
+	if strings.HasPrefix(path, "\"") {
+		return strconv.Unquote(path)
+	}
+	return path, nil
+}
@@ -0,0 +1,145 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sign   ed25519.PrivateKey
+		tamper func(string) string
+		verify ed25519.PublicKey
+		signed bool
+		err    error
+	}{
+		{name: "signed and verified", sign: private, verify: public, signed: true},
+		{name: "signed, not verified", sign: private, signed: true},
+		{name: "unsigned, not verified"},
+		{name: "unsigned, verified", verify: public, err: ErrUnsigned},
+		{name: "wrong key", sign: private, verify: other, err: ErrInvalidSignature},
+		{
+			name:   "tampered size",
+			sign:   private,
+			verify: public,
+			tamper: func(s string) string { return strings.Replace(s, " 42 ", " 43 ", 1) },
+			err:    ErrInvalidSignature,
+		},
+		{
+			name:   "tampered path",
+			sign:   private,
+			verify: public,
+			tamper: func(s string) string { return strings.Replace(s, "a/b.txt", "a/c.txt", 1) },
+			err:    ErrInvalidSignature,
+		},
+		{
+			name:   "tampered signature",
+			sign:   private,
+			verify: public,
+			tamper: func(s string) string { return strings.Replace(s, "ed25519 ", "ed25519 AAAA", 1) },
+			err:    ErrInvalidSignature,
+		},
+		{
+			name:   "unsupported signature",
+			sign:   private,
+			verify: public,
+			tamper: func(s string) string { return strings.Replace(s, "ed25519 ", "rsa ", 1) },
+			err:    ErrInvalidSignature,
+		},
+		{
+			name:   "signature stripped",
+			sign:   private,
+			verify: public,
+			tamper: func(s string) string { return s[:strings.LastIndex(s, signatureKeyword)] },
+			err:    ErrUnsigned,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Manifest{
+				Algorithm: "sha256",
+				Created:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+				Roots: []*Root{
+					{Path: "/data", Entries: []*Entry{{Hash: "abc", Size: 42, Path: "a/b.txt"}, {Hash: "def", Size: 7, Path: "line\nbreak"}}},
+				},
+			}
+			var buffer bytes.Buffer
+			if err := m.Write(&buffer, test.sign); err != nil {
+				t.Fatal(err)
+			}
+			text := buffer.String()
+			if test.tamper != nil {
+				text = test.tamper(text)
+			}
+			read, err := Read(strings.NewReader(text), test.verify)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if read.Signed != test.signed {
+				t.Errorf("expected signed %t, got %t", test.signed, read.Signed)
+			}
+			if read.Algorithm != m.Algorithm || !read.Created.Equal(m.Created) {
+				t.Errorf("expected %s at %s, got %s at %s", m.Algorithm, m.Created, read.Algorithm, read.Created)
+			}
+			if len(read.Roots) != 1 || read.Roots[0].Path != "/data" || len(read.Roots[0].Entries) != 2 {
+				t.Fatalf("unexpected roots %+v", read.Roots)
+			}
+			for i, entry := range read.Roots[0].Entries {
+				if *entry != *m.Roots[0].Entries[i] {
+					t.Errorf("expected entry %+v, got %+v", m.Roots[0].Entries[i], entry)
+				}
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	path := t.TempDir() + "/key"
+	if err := GenerateKey(path); err != nil {
+		t.Fatal(err)
+	}
+	private, err := LoadPrivateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := LoadPublicKey(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	if err := (&Manifest{Algorithm: "sha256", Roots: []*Root{{Path: "/data", Entries: []*Entry{{Hash: "abc", Size: 1, Path: "a"}}}}}).Write(&buffer, private); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Read(&buffer, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Signed || len(m.Roots) != 1 || len(m.Roots[0].Entries) != 1 {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+	// keys of the wrong kind are rejected
+	if _, err := LoadPublicKey(path); err == nil {
+		t.Error("expected error loading private key as public key")
+	}
+	if _, err := LoadPrivateKey(path + ".pub"); err == nil {
+		t.Error("expected error loading public key as private key")
+	}
+}
@@ -0,0 +1,149 @@
+// Package messages reads email messages out of mbox files and Maildir folders,
+// and computes hashes of their canonical form, so that the same message is
+// recognised even when stored by different mail clients or servers.
+package messages
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// transient are the headers added or rewritten while the message is delivered
+// or stored, which differ between copies of the same message.
+var transient = map[string]bool{
+	"Received":               true,
+	"Return-Path":            true,
+	"Delivered-To":           true,
+	"X-Original-To":          true,
+	"Status":                 true,
+	"X-Status":               true,
+	"X-Keywords":             true,
+	"X-Uid":                  true,
+	"X-Mozilla-Status":       true,
+	"X-Mozilla-Status2":      true,
+	"X-Mozilla-Keys":         true,
+	"X-Gmail-Labels":         true,
+	"X-Gm-Thrid":             true,
+	"Content-Length":         true,
+	"Lines":                  true,
+	"X-Spam-Status":          true,
+	"X-Spam-Flag":            true,
+	"X-Spam-Level":           true,
+	"X-Spam-Checker-Version": true,
+}
+
+// whitespace matches runs of whitespace in header values.
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Hash returns the hex-encoded SHA-256 hash of the canonical form of the given
+// raw message: line endings are normalised, transient headers are dropped, the
+// remaining ones are sorted and their whitespace collapsed, and trailing blank
+// lines are removed from the body.
+func Hash(raw []byte) (string, error) {
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	headers := []string{}
+	for key, values := range message.Header {
+		if transient[key] || strings.HasPrefix(key, "X-Evolution") {
+			continue
+		}
+		for _, value := range values {
+			headers = append(headers, strings.ToLower(key)+": "+strings.TrimSpace(whitespace.ReplaceAllString(value, " ")))
+		}
+	}
+	sort.Strings(headers)
+	body, err := io.ReadAll(message.Body)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, header := range headers {
+		io.WriteString(h, header)
+		io.WriteString(h, "\n")
+	}
+	io.WriteString(h, "\n")
+	h.Write(bytes.TrimRight(body, "\n"))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// IsMbox returns whether the given file looks like an mbox file.
+func IsMbox(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(f, prefix); err != nil {
+		return false
+	}
+	return string(prefix) == "From "
+}
+
+// IsMaildirMessage returns whether the given file is a message in a Maildir
+// folder, i.e. it is in the cur or new subdirectory of a directory having all
+// of cur, new and tmp.
+func IsMaildirMessage(path string) bool {
+	dir := filepath.Dir(path)
+	if name := filepath.Base(dir); name != "cur" && name != "new" {
+		return false
+	}
+	maildir := filepath.Dir(dir)
+	for _, name := range []string{"cur", "new", "tmp"} {
+		if info, err := os.Stat(filepath.Join(maildir, name)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// unescape matches the escaped "From " lines in mbox message bodies.
+var unescape = regexp.MustCompile(`(?m)^>(>*From )`)
+
+// Mbox calls the given function for each message in the mbox, with its 1-based
+// position in the file and its raw contents; the "From " separator line is not
+// part of the message, and escaped ">From " lines are unescaped.
+func Mbox(r io.Reader, fn func(n int, raw []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var message bytes.Buffer
+	n := 0
+	blank := true
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		return fn(n, unescape.ReplaceAll(message.Bytes(), []byte("$1")))
+	}
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if blank && bytes.HasPrefix(line, []byte("From ")) {
+			if err := flush(); err != nil {
+				return err
+			}
+			message.Reset()
+			n++
+			blank = false
+			continue
+		}
+		blank = len(bytes.TrimRight(line, "\r")) == 0
+		message.Write(line)
+		message.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
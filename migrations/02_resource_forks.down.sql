@@ -0,0 +1 @@
+ALTER TABLE entries DROP COLUMN fork_of;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE entries ADD COLUMN fork_of TEXT;
\ No newline at end of file
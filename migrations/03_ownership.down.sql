@@ -0,0 +1,4 @@
+ALTER TABLE entries DROP COLUMN acl;
+ALTER TABLE entries DROP COLUMN mode;
+ALTER TABLE entries DROP COLUMN gid;
+ALTER TABLE entries DROP COLUMN uid;
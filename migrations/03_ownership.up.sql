@@ -0,0 +1,4 @@
+ALTER TABLE entries ADD COLUMN uid INT;
+ALTER TABLE entries ADD COLUMN gid INT;
+ALTER TABLE entries ADD COLUMN mode INT;
+ALTER TABLE entries ADD COLUMN acl TEXT;
@@ -0,0 +1 @@
+ALTER TABLE entries DROP COLUMN allocated;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE entries ADD COLUMN allocated INT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE checkpoints (
+    bucket  TEXT NOT NULL PRIMARY KEY,
+    files   INT,
+    bytes   INT,
+    created TEXT
+);
@@ -0,0 +1,5 @@
+ALTER TABLE entries DROP COLUMN blake3;
+ALTER TABLE entries DROP COLUMN sha512;
+ALTER TABLE entries DROP COLUMN sha256;
+ALTER TABLE entries DROP COLUMN sha1;
+ALTER TABLE entries DROP COLUMN md5;
@@ -0,0 +1,5 @@
+ALTER TABLE entries ADD COLUMN md5 TEXT;
+ALTER TABLE entries ADD COLUMN sha1 TEXT;
+ALTER TABLE entries ADD COLUMN sha256 TEXT;
+ALTER TABLE entries ADD COLUMN sha512 TEXT;
+ALTER TABLE entries ADD COLUMN blake3 TEXT;
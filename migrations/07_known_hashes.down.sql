@@ -0,0 +1 @@
+ALTER TABLE entries DROP COLUMN known;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE entries ADD COLUMN known TEXT;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE entries DROP COLUMN entropy;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE entries ADD COLUMN entropy REAL;
\ No newline at end of file
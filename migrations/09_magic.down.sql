@@ -0,0 +1 @@
+ALTER TABLE entries DROP COLUMN magic;
\ No newline at end of file
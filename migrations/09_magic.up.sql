@@ -0,0 +1 @@
+ALTER TABLE entries ADD COLUMN magic TEXT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP VIEW IF EXISTS v_buckets;
+DROP VIEW IF EXISTS v_wasted_space;
+DROP VIEW IF EXISTS v_largest_groups;
+DROP VIEW IF EXISTS v_duplicates;
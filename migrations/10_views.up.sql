@@ -0,0 +1,37 @@
+-- every entry whose content is present more than once in the index
+CREATE VIEW v_duplicates AS
+SELECT e.hash, e.path, e.bucket, e.size, e.allocated, g.copies
+FROM entries e
+JOIN (SELECT hash, COUNT(*) AS copies FROM entries GROUP BY hash HAVING COUNT(*) > 1) g
+ON e.hash = g.hash;
+
+-- duplicate groups, by decreasing space taken up by the redundant copies;
+-- sparse files account for their allocated size, not their apparent size
+CREATE VIEW v_largest_groups AS
+SELECT hash,
+    COUNT(*) AS copies,
+    MAX(size) AS size,
+    (COUNT(*) - 1) * MAX(COALESCE(allocated, size)) AS wasted,
+    GROUP_CONCAT(path, ', ') AS paths
+FROM entries
+GROUP BY hash
+HAVING COUNT(*) > 1
+ORDER BY wasted DESC;
+
+-- overall space that could be reclaimed by removing redundant copies
+CREATE VIEW v_wasted_space AS
+SELECT COUNT(*) AS groups,
+    COALESCE(SUM(copies - 1), 0) AS duplicates,
+    COALESCE(SUM((copies - 1) * size), 0) AS apparent_size,
+    COALESCE(SUM(wasted), 0) AS wasted
+FROM v_largest_groups;
+
+-- statistics per bucket
+CREATE VIEW v_buckets AS
+SELECT bucket,
+    COUNT(*) AS files,
+    SUM(size) AS size,
+    SUM(COALESCE(allocated, size)) AS allocated,
+    COUNT(DISTINCT hash) AS contents
+FROM entries
+GROUP BY bucket;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_journal_run;
+DROP TABLE IF EXISTS journal;
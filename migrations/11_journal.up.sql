@@ -0,0 +1,15 @@
+CREATE TABLE journal (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    run     TEXT NOT NULL,
+    action  TEXT NOT NULL,
+    path    TEXT NOT NULL,
+    target  TEXT,
+    hash    TEXT,
+    bucket  TEXT,
+    size    INT,
+    created TEXT NOT NULL,
+    undone  INT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_journal_run
+ON journal (run);
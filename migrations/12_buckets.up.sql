@@ -0,0 +1,4 @@
+CREATE TABLE buckets (
+    name      TEXT PRIMARY KEY,
+    reference INT NOT NULL DEFAULT 0
+);
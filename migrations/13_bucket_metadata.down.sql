@@ -0,0 +1,5 @@
+ALTER TABLE buckets DROP COLUMN updated;
+ALTER TABLE buckets DROP COLUMN created;
+ALTER TABLE buckets DROP COLUMN options;
+ALTER TABLE buckets DROP COLUMN roots;
+ALTER TABLE buckets DROP COLUMN algorithm;
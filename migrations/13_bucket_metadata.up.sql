@@ -0,0 +1,5 @@
+ALTER TABLE buckets ADD COLUMN algorithm TEXT;
+ALTER TABLE buckets ADD COLUMN roots TEXT;
+ALTER TABLE buckets ADD COLUMN options TEXT;
+ALTER TABLE buckets ADD COLUMN created TEXT;
+ALTER TABLE buckets ADD COLUMN updated TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE journal DROP COLUMN group_id;
+DROP TABLE IF EXISTS duplicate_groups;
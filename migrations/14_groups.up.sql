@@ -0,0 +1,8 @@
+-- stable identifiers of the duplicate groups, by content hash
+CREATE TABLE duplicate_groups (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    hash    TEXT NOT NULL UNIQUE,
+    created TEXT NOT NULL
+);
+
+ALTER TABLE journal ADD COLUMN group_id INT;
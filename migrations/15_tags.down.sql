@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tags_label;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,13 @@
+-- labels and notes attached to entries (by path) or duplicate groups (by id)
+CREATE TABLE tags (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind    TEXT NOT NULL,
+    target  TEXT NOT NULL,
+    label   TEXT NOT NULL,
+    note    TEXT,
+    created TEXT NOT NULL,
+    UNIQUE (kind, target, label)
+);
+
+CREATE INDEX idx_tags_label
+ON tags (label);
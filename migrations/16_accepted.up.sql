@@ -0,0 +1,7 @@
+-- duplicate groups accepted as they are, and hidden from reports and actions
+CREATE TABLE accepted (
+    group_id INTEGER PRIMARY KEY REFERENCES duplicate_groups(id),
+    user     TEXT,
+    reason   TEXT,
+    created  TEXT NOT NULL
+);
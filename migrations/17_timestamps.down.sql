@@ -0,0 +1,2 @@
+ALTER TABLE entries DROP COLUMN indexed;
+ALTER TABLE entries DROP COLUMN modified;
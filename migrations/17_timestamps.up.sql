@@ -0,0 +1,3 @@
+-- modification time of the file and time it was indexed, in RFC3339 format
+ALTER TABLE entries ADD COLUMN modified TEXT;
+ALTER TABLE entries ADD COLUMN indexed TEXT;
@@ -0,0 +1,16 @@
+-- original paths of the files stored in content-addressed archives; the
+-- contents themselves are objects named by hash in the archive directory
+CREATE TABLE archived (
+    store     TEXT NOT NULL,
+    path      TEXT NOT NULL,
+    algorithm TEXT NOT NULL,
+    hash      TEXT NOT NULL,
+    size      INT,
+    mode      INT,
+    modified  TEXT,
+    archived  TEXT NOT NULL,
+    PRIMARY KEY(store, path)
+);
+
+CREATE INDEX idx_archived_hash
+ON archived (store, hash);
@@ -0,0 +1,8 @@
+-- fingerprints of video contents: duration and perceptual hashes of frames
+-- sampled at regular intervals, for detecting re-encoded copies
+CREATE TABLE videos (
+    hash     TEXT PRIMARY KEY,
+    duration REAL NOT NULL,
+    frames   TEXT NOT NULL,
+    created  TEXT NOT NULL
+);
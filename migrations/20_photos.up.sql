@@ -0,0 +1,8 @@
+-- fingerprints of photo contents: capture time and perceptual hash, for
+-- detecting series of near-identical shots
+CREATE TABLE photos (
+    hash    TEXT PRIMARY KEY,
+    taken   TEXT,
+    phash   TEXT NOT NULL,
+    created TEXT NOT NULL
+);
@@ -0,0 +1,10 @@
+-- Bloom filter of the hashes in the index, along with the highest entry rowid
+-- it accounts for, so that stale filters can be detected and rebuilt
+CREATE TABLE bloom (
+    id       INTEGER PRIMARY KEY CHECK (id = 1),
+    capacity INT NOT NULL,
+    hashes   INT NOT NULL,
+    data     BLOB NOT NULL,
+    rowid    INT NOT NULL,
+    updated  TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- entries are looked up by path when re-indexed, to detect changes
+CREATE INDEX idx_entries_path
+ON entries (path);
@@ -0,0 +1,18 @@
+-- history of the index runs, to report what changed from one run to the next
+CREATE TABLE runs (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    bucket     TEXT NOT NULL,
+    started    TEXT NOT NULL,
+    finished   TEXT NOT NULL,
+    files      INT,
+    bytes      INT,
+    inserted   INT,
+    updated    INT,
+    unchanged  INT,
+    removed    INT,
+    groups     INT,
+    incomplete INT
+);
+
+CREATE INDEX idx_runs_bucket
+ON runs (bucket);
@@ -0,0 +1,12 @@
+-- decisions taken on each file while reviewing a report, to be applied later
+CREATE TABLE decisions (
+    path     TEXT PRIMARY KEY,
+    hash     TEXT NOT NULL,
+    group_id INT,
+    decision TEXT NOT NULL,
+    imported TEXT NOT NULL,
+    applied  TEXT
+);
+
+CREATE INDEX idx_decisions_hash
+ON decisions (hash);
@@ -0,0 +1,2 @@
+ALTER TABLE entries DROP COLUMN changed;
+ALTER TABLE entries DROP COLUMN accessed;
@@ -0,0 +1,2 @@
+ALTER TABLE entries ADD COLUMN accessed TEXT;
+ALTER TABLE entries ADD COLUMN changed TEXT;
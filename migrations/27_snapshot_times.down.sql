@@ -0,0 +1 @@
+ALTER TABLE buckets DROP COLUMN taken;
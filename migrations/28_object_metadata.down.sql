@@ -0,0 +1,4 @@
+ALTER TABLE entries DROP COLUMN version_id;
+ALTER TABLE entries DROP COLUMN storage_class;
+ALTER TABLE entries DROP COLUMN checksum;
+ALTER TABLE entries DROP COLUMN etag;
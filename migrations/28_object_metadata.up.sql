@@ -0,0 +1,4 @@
+ALTER TABLE entries ADD COLUMN etag TEXT;
+ALTER TABLE entries ADD COLUMN checksum TEXT;
+ALTER TABLE entries ADD COLUMN storage_class TEXT;
+ALTER TABLE entries ADD COLUMN version_id TEXT;
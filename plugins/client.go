@@ -0,0 +1,133 @@
+package plugins
+
+import (
+	"fmt"
+	"hash"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+)
+
+var (
+	// lock protects started.
+	lock sync.Mutex
+	// started are the plugins started so far, by kind and name.
+	started = map[Kind]map[string]any{}
+)
+
+// open starts the plugin of the given kind and name, or returns the one
+// already started, which is shared by all callers.
+func open(kind Kind, name string) (any, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if impl, ok := started[kind][name]; ok {
+		return impl, nil
+	}
+	infos, err := Discover()
+	if err != nil {
+		slog.Error("error discovering plugins", "directory", Directory(), "error", err)
+		return nil, err
+	}
+	var found *Info
+	for _, info := range infos {
+		if info.Kind == kind && info.Name == name {
+			found = info
+			break
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no %s plugin %q in %s", kind, name, Directory())
+	}
+
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          plugin.PluginSet{string(kind): pluginOf(kind)},
+		Cmd:              exec.Command(found.Path),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Managed:          true,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   Prefix + string(kind) + "-" + name,
+			Output: os.Stderr,
+			Level:  hclog.Warn,
+		}),
+	})
+	protocol, err := client.Client()
+	if err != nil {
+		slog.Error("error starting plugin", "path", found.Path, "error", err)
+		client.Kill()
+		return nil, err
+	}
+	impl, err := protocol.Dispense(string(kind))
+	if err != nil {
+		slog.Error("error dispensing plugin", "path", found.Path, "error", err)
+		client.Kill()
+		return nil, err
+	}
+	slog.Debug("plugin started", "kind", kind, "name", name, "path", found.Path)
+	if started[kind] == nil {
+		started[kind] = map[string]any{}
+	}
+	started[kind][name] = impl
+	return impl, nil
+}
+
+// pluginOf returns the plugin dispensing clients of the given kind.
+func pluginOf(kind Kind) plugin.Plugin {
+	switch kind {
+	case KindSource:
+		return &sourcePlugin{}
+	case KindFilter:
+		return &filterPlugin{}
+	case KindHasher:
+		return &hasherPlugin{}
+	default:
+		return &actionPlugin{}
+	}
+}
+
+// OpenSource starts the source plugin with the given name.
+func OpenSource(name string) (Source, error) {
+	impl, err := open(KindSource, name)
+	if err != nil {
+		return nil, err
+	}
+	return impl.(Source), nil
+}
+
+// OpenFilter starts the filter plugin with the given name.
+func OpenFilter(name string) (Filter, error) {
+	impl, err := open(KindFilter, name)
+	if err != nil {
+		return nil, err
+	}
+	return impl.(Filter), nil
+}
+
+// OpenAction starts the action plugin with the given name.
+func OpenAction(name string) (Action, error) {
+	impl, err := open(KindAction, name)
+	if err != nil {
+		return nil, err
+	}
+	return impl.(Action), nil
+}
+
+// OpenHasher starts the hasher plugin with the given name, and returns the
+// constructor of the hashes it computes.
+func OpenHasher(name string) (func() hash.Hash, error) {
+	impl, err := open(KindHasher, name)
+	if err != nil {
+		return nil, err
+	}
+	client := impl.(*hasherClient)
+	return func() hash.Hash { return client.New() }, nil
+}
+
+// Close stops all the plugins started.
+func Close() {
+	plugin.CleanupClients()
+}
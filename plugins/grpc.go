@@ -0,0 +1,425 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// The services are described by hand instead of being generated from .proto
+// files, so that no code generation is needed to build the tool: messages
+// are well-known types, structured values being carried as Struct messages
+// holding the JSON encoding of the types in this package.
+const (
+	sourceService = "dedup.plugins.v1.Source"
+	filterService = "dedup.plugins.v1.Filter"
+	hasherService = "dedup.plugins.v1.Hasher"
+	actionService = "dedup.plugins.v1.Action"
+)
+
+// listRequest is the request of Source.List.
+type listRequest struct {
+	Root      string `json:"root"`
+	Algorithm string `json:"algorithm"`
+}
+
+// applyRequest is the request of Action.Apply.
+type applyRequest struct {
+	Group  Group `json:"group"`
+	DryRun bool  `json:"dry_run"`
+}
+
+// encode returns the given value as a Struct message.
+func encode(v any) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+// decode sets the given value from a Struct message.
+func decode(message *structpb.Struct, v any) error {
+	data, err := json.Marshal(message.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// unary returns the handler of a unary method, decoding the request into a
+// message of the same type as request and passing it to handle.
+func unary[T any](service, method string, request func() T, handle func(srv any, ctx context.Context, request T) (any, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := request()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return handle(srv, ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/" + method}
+			return interceptor(ctx, in, info, func(ctx context.Context, in any) (any, error) {
+				return handle(srv, ctx, in.(T))
+			})
+		},
+	}
+}
+
+// source
+
+var sourceDesc = grpc.ServiceDesc{
+	ServiceName: sourceService,
+	HandlerType: (*any)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "List",
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			request := &structpb.Struct{}
+			if err := stream.RecvMsg(request); err != nil {
+				return err
+			}
+			args := &listRequest{}
+			if err := decode(request, args); err != nil {
+				return err
+			}
+			return srv.(Source).List(args.Root, args.Algorithm, func(file File) error {
+				message, err := encode(file)
+				if err != nil {
+					return err
+				}
+				return stream.SendMsg(message)
+			})
+		},
+	}},
+}
+
+// sourcePlugin serves and dispenses Source plugins.
+type sourcePlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	impl Source
+}
+
+func (p *sourcePlugin) GRPCServer(broker *plugin.GRPCBroker, server *grpc.Server) error {
+	server.RegisterService(&sourceDesc, p.impl)
+	return nil
+}
+
+func (p *sourcePlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn) (any, error) {
+	return &sourceClient{conn: conn}, nil
+}
+
+// sourceClient is the Source implemented by a plugin process.
+type sourceClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *sourceClient) List(root string, algorithm string, fn func(File) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := c.conn.NewStream(ctx, &sourceDesc.Streams[0], "/"+sourceService+"/List")
+	if err != nil {
+		return err
+	}
+	request, err := encode(&listRequest{Root: root, Algorithm: algorithm})
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(request); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		message := &structpb.Struct{}
+		if err := stream.RecvMsg(message); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		file := File{}
+		if err := decode(message, &file); err != nil {
+			return err
+		}
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+}
+
+// filter
+
+var filterDesc = grpc.ServiceDesc{
+	ServiceName: filterService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(filterService, "Keep", func() *structpb.Struct { return &structpb.Struct{} }, func(srv any, ctx context.Context, request *structpb.Struct) (any, error) {
+			file := File{}
+			if err := decode(request, &file); err != nil {
+				return nil, err
+			}
+			keep, err := srv.(Filter).Keep(file)
+			if err != nil {
+				return nil, err
+			}
+			return wrapperspb.Bool(keep), nil
+		}),
+	},
+}
+
+// filterPlugin serves and dispenses Filter plugins.
+type filterPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	impl Filter
+}
+
+func (p *filterPlugin) GRPCServer(broker *plugin.GRPCBroker, server *grpc.Server) error {
+	server.RegisterService(&filterDesc, p.impl)
+	return nil
+}
+
+func (p *filterPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn) (any, error) {
+	return &filterClient{conn: conn}, nil
+}
+
+// filterClient is the Filter implemented by a plugin process.
+type filterClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *filterClient) Keep(file File) (bool, error) {
+	request, err := encode(file)
+	if err != nil {
+		return false, err
+	}
+	response := &wrapperspb.BoolValue{}
+	if err := c.conn.Invoke(context.Background(), "/"+filterService+"/Keep", request, response); err != nil {
+		return false, err
+	}
+	return response.Value, nil
+}
+
+// hasher
+
+var hasherDesc = grpc.ServiceDesc{
+	ServiceName: hasherService,
+	HandlerType: (*any)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Hash",
+		ClientStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			// the contents are streamed in chunks, and read by the plugin
+			// as they arrive
+			r, w := io.Pipe()
+			go func() {
+				for {
+					chunk := &wrapperspb.BytesValue{}
+					if err := stream.RecvMsg(chunk); errors.Is(err, io.EOF) {
+						w.Close()
+						return
+					} else if err != nil {
+						w.CloseWithError(err)
+						return
+					}
+					if _, err := w.Write(chunk.Value); err != nil {
+						return
+					}
+				}
+			}()
+			sum, err := srv.(Hasher).Hash(r)
+			// in case the plugin did not read the contents to the end
+			r.Close()
+			if err != nil {
+				return err
+			}
+			return stream.SendMsg(wrapperspb.Bytes(sum))
+		},
+	}},
+}
+
+// hasherPlugin serves and dispenses Hasher plugins.
+type hasherPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	impl Hasher
+}
+
+func (p *hasherPlugin) GRPCServer(broker *plugin.GRPCBroker, server *grpc.Server) error {
+	server.RegisterService(&hasherDesc, p.impl)
+	return nil
+}
+
+func (p *hasherPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn) (any, error) {
+	return &hasherClient{conn: conn}, nil
+}
+
+// hasherClient is the Hasher implemented by a plugin process.
+type hasherClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *hasherClient) Hash(r io.Reader) ([]byte, error) {
+	h := c.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	sum := h.Sum(nil)
+	return sum, h.Err()
+}
+
+// New returns a hash.Hash streaming the contents written to it to the plugin.
+func (c *hasherClient) New() *Hash {
+	return &Hash{conn: c.conn}
+}
+
+// Hash is a hash.Hash computed by a plugin process, to which the contents
+// are streamed as they are written. Since hash.Hash cannot report errors
+// when summing, Err must be checked after Sum.
+type Hash struct {
+	conn   *grpc.ClientConn
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	sum    []byte
+	err    error
+}
+
+// open starts streaming the contents to the plugin.
+func (h *Hash) open() error {
+	if h.stream != nil || h.err != nil {
+		return h.err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.stream, h.err = h.conn.NewStream(ctx, &hasherDesc.Streams[0], "/"+hasherService+"/Hash")
+	h.cancel = cancel
+	return h.err
+}
+
+// Write streams p to the plugin.
+func (h *Hash) Write(p []byte) (int, error) {
+	if err := h.open(); err != nil {
+		return 0, err
+	}
+	if h.sum != nil {
+		h.err = errors.New("write after sum")
+		return 0, h.err
+	}
+	if err := h.stream.SendMsg(wrapperspb.Bytes(p)); err != nil {
+		if errors.Is(err, io.EOF) {
+			// the actual error is returned when receiving
+			err = h.stream.RecvMsg(&wrapperspb.BytesValue{})
+		}
+		h.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Sum returns the hash computed by the plugin appended to b, or b if it
+// failed (see Err).
+func (h *Hash) Sum(b []byte) []byte {
+	if h.sum == nil && h.open() == nil {
+		if h.err = h.stream.CloseSend(); h.err == nil {
+			response := &wrapperspb.BytesValue{}
+			if h.err = h.stream.RecvMsg(response); h.err == nil {
+				h.sum = response.Value
+			}
+		}
+		h.cancel()
+	}
+	return append(b, h.sum...)
+}
+
+// Err returns the error that occurred streaming the contents to the plugin
+// or summing them, if any.
+func (h *Hash) Err() error {
+	return h.err
+}
+
+// Reset discards the contents written so far.
+func (h *Hash) Reset() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+	*h = Hash{conn: h.conn}
+}
+
+// Size returns the size of the hash, which is only known once summed.
+func (h *Hash) Size() int {
+	return len(h.sum)
+}
+
+// BlockSize returns the block size of the hash, which is unknown.
+func (h *Hash) BlockSize() int {
+	return 1
+}
+
+// action
+
+var actionDesc = grpc.ServiceDesc{
+	ServiceName: actionService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(actionService, "Apply", func() *structpb.Struct { return &structpb.Struct{} }, func(srv any, ctx context.Context, request *structpb.Struct) (any, error) {
+			args := &applyRequest{}
+			if err := decode(request, args); err != nil {
+				return nil, err
+			}
+			result, err := srv.(Action).Apply(args.Group, args.DryRun)
+			if err != nil {
+				return nil, err
+			}
+			if result == nil {
+				result = &Result{}
+			}
+			return encode(result)
+		}),
+	},
+}
+
+// actionPlugin serves and dispenses Action plugins.
+type actionPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	impl Action
+}
+
+func (p *actionPlugin) GRPCServer(broker *plugin.GRPCBroker, server *grpc.Server) error {
+	server.RegisterService(&actionDesc, p.impl)
+	return nil
+}
+
+func (p *actionPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn) (any, error) {
+	return &actionClient{conn: conn}, nil
+}
+
+// actionClient is the Action implemented by a plugin process.
+type actionClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *actionClient) Apply(group Group, dryRun bool) (*Result, error) {
+	request, err := encode(&applyRequest{Group: group, DryRun: dryRun})
+	if err != nil {
+		return nil, err
+	}
+	response := &structpb.Struct{}
+	if err := c.conn.Invoke(context.Background(), "/"+actionService+"/Apply", request, response); err != nil {
+		return nil, err
+	}
+	result := &Result{}
+	if err := decode(response, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,195 @@
+// Package plugins defines the protocol third parties implement to extend the
+// tool without forking it: plugins are executables in the plugins directory,
+// run as child processes and talked to over gRPC (see hashicorp/go-plugin).
+// Each one provides a single kind of extension, given by its name:
+//
+//   - dedup-source-<name> lists the files of a storage the tool cannot walk,
+//     with their hashes (see the source command);
+//   - dedup-filter-<name> tells which of the files found while indexing are
+//     indexed (see index --filter);
+//   - dedup-hasher-<name> computes the <name> hash algorithm, which can then
+//     be used wherever built-in algorithms are (e.g. index --hash=<name>);
+//   - dedup-action-<name> acts on groups of duplicates (see the action
+//     command).
+//
+// Plugins are written in Go by implementing Source, Filter, Hasher or Action
+// and calling Serve from their main function.
+package plugins
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// Kind is the kind of extension a plugin provides.
+type Kind string
+
+const (
+	// KindSource is the kind of the plugins listing files.
+	KindSource Kind = "source"
+	// KindFilter is the kind of the plugins selecting the files to index.
+	KindFilter Kind = "filter"
+	// KindHasher is the kind of the plugins computing a hash algorithm.
+	KindHasher Kind = "hasher"
+	// KindAction is the kind of the plugins acting on duplicates.
+	KindAction Kind = "action"
+)
+
+// Kinds are all the kinds of plugins.
+var Kinds = []Kind{KindSource, KindFilter, KindHasher, KindAction}
+
+// Prefix is the prefix of the names of the plugin executables.
+const Prefix = "dedup-"
+
+// Handshake is the configuration the tool and its plugins must agree on; the
+// protocol version is raised whenever an incompatible change is made.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "DEDUP_PLUGIN",
+	MagicCookieValue: "a6f3c1f4-5b2e-4d8a-9f0e-2c7d1b8e4a90",
+}
+
+// File is a file as seen by plugins.
+type File struct {
+	// Path is the path (or URL) of the file.
+	Path string `json:"path"`
+	// Size is the size of the file.
+	Size int64 `json:"size"`
+	// Modified is the modification time of the file, in RFC3339 format, if
+	// known.
+	Modified string `json:"modified,omitempty"`
+	// Hash is the hex-encoded hash of the file contents, if known.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Group is a group of files having the same contents, as seen by plugins.
+type Group struct {
+	// ID is the stable identifier of the group.
+	ID int64 `json:"id"`
+	// Hash is the hash of the contents.
+	Hash string `json:"hash"`
+	// Size is the size of the contents.
+	Size int64 `json:"size"`
+	// Keeper is the copy to keep.
+	Keeper File `json:"keeper"`
+	// Duplicates are the other copies, which can be acted upon.
+	Duplicates []File `json:"duplicates"`
+}
+
+// Result is the outcome of acting on a group of duplicates.
+type Result struct {
+	// Done are the paths of the duplicates acted upon (or that would be, in
+	// a dry run).
+	Done []string `json:"done,omitempty"`
+	// Failed maps the paths of the duplicates that could not be acted upon
+	// to the reason.
+	Failed map[string]string `json:"failed,omitempty"`
+	// Bytes is the space reclaimed (or that would be).
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+// Source lists the files of a storage the tool cannot walk by itself.
+type Source interface {
+	// List calls fn with each file under the given root, hashed with the
+	// given algorithm; it stops at the first error returned by fn.
+	List(root string, algorithm string, fn func(File) error) error
+}
+
+// Filter selects the files to index.
+type Filter interface {
+	// Keep returns whether the given file must be indexed.
+	Keep(file File) (bool, error)
+}
+
+// Hasher computes a hash algorithm.
+type Hasher interface {
+	// Hash returns the hash of the contents read from r.
+	Hash(r io.Reader) ([]byte, error)
+}
+
+// Action acts on groups of duplicates.
+type Action interface {
+	// Apply acts on the duplicates of the given group, or only tells what it
+	// would do if dryRun is set.
+	Apply(group Group, dryRun bool) (*Result, error)
+}
+
+// directory is the directory plugins are discovered in.
+var directory string
+
+// SetDirectory sets the directory plugins are discovered in; if empty, it is
+// dedup/plugins in the user configuration directory.
+func SetDirectory(dir string) {
+	directory = dir
+}
+
+// Directory returns the directory plugins are discovered in.
+func Directory() string {
+	if directory != "" {
+		return directory
+	}
+	if config, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(config, "dedup", "plugins")
+	}
+	return "plugins"
+}
+
+// Info describes a plugin found in the plugins directory.
+type Info struct {
+	// Kind is the kind of the plugin.
+	Kind Kind `json:"kind"`
+	// Name is the name of the plugin.
+	Name string `json:"name"`
+	// Path is the path of the plugin executable.
+	Path string `json:"path"`
+}
+
+// Discover returns the plugins in the plugins directory, by kind and name; a
+// missing directory has no plugins.
+func Discover() ([]*Info, error) {
+	paths, err := plugin.Discover(Prefix+"*", Directory())
+	if err != nil {
+		return nil, err
+	}
+	result := []*Info{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), Prefix), ".exe")
+		for _, kind := range Kinds {
+			if rest, ok := strings.CutPrefix(name, string(kind)+"-"); ok && rest != "" {
+				if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+					result = append(result, &Info{Kind: kind, Name: rest, Path: path})
+				}
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// Serve serves the given implementation, which must implement Source,
+// Filter, Hasher or Action, to the tool; it is called by the main function
+// of plugins, and never returns.
+func Serve(impl any) {
+	set := plugin.PluginSet{}
+	if source, ok := impl.(Source); ok {
+		set[string(KindSource)] = &sourcePlugin{impl: source}
+	}
+	if filter, ok := impl.(Filter); ok {
+		set[string(KindFilter)] = &filterPlugin{impl: filter}
+	}
+	if hasher, ok := impl.(Hasher); ok {
+		set[string(KindHasher)] = &hasherPlugin{impl: hasher}
+	}
+	if action, ok := impl.(Action); ok {
+		set[string(KindAction)] = &actionPlugin{impl: action}
+	}
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         set,
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
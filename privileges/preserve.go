@@ -0,0 +1,26 @@
+package privileges
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// Preserve gives the regular file at target the owner, group, permissions and
+// extended attributes of the source file described by info; failures to set
+// the owner are only logged, since they require root privileges, whereas the
+// permissions must be preserved.
+func Preserve(source string, info fs.FileInfo, target string) error {
+	if err := Own(info, target); err != nil {
+		slog.Warn("error preserving owner and group", "path", target, "error", err)
+	}
+	// permissions are set after the owner, since changing the owner clears
+	// the setuid and setgid bits
+	if err := os.Chmod(target, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+	if err := copyXattrs(source, target); err != nil {
+		slog.Warn("error preserving extended attributes", "path", target, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// Package privileges checks up-front whether the current user is allowed to
+// replace, remove or create files, so that commands can report the actions
+// that would fail before starting, and preserves the ownership, permissions
+// and extended attributes of files that are recreated.
+package privileges
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrDenied is returned when the current user lacks the privileges for an
+// action on a file.
+var ErrDenied = errors.New("insufficient privileges")
+
+// denied returns an error wrapping ErrDenied with the reason.
+func denied(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrDenied, fmt.Sprintf(format, args...))
+}
+
+// CanCreate returns an error wrapping ErrDenied if a file cannot be created at
+// the given path; if its parent directories are missing, it checks that they
+// can be created in the closest existing ancestor, without creating them.
+func CanCreate(path string) error {
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return writable(dir)
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package privileges
+
+import (
+	"io/fs"
+)
+
+// writable returns nil, since privileges cannot be checked up-front on this
+// platform: actions that are not allowed fail when attempted.
+func writable(dir string) error {
+	return nil
+}
+
+// CanReplace returns nil, since privileges cannot be checked up-front on this
+// platform: actions that are not allowed fail when attempted.
+func CanReplace(path string) error {
+	return nil
+}
+
+// Own does nothing, since files have no owner and group on this platform.
+func Own(info fs.FileInfo, path string) error {
+	return nil
+}
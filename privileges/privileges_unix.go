@@ -0,0 +1,67 @@
+//go:build unix
+
+package privileges
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// writable returns an error wrapping ErrDenied if entries cannot be added to
+// or removed from the given directory by the current user.
+func writable(dir string) error {
+	if err := unix.Faccessat(unix.AT_FDCWD, dir, unix.W_OK|unix.X_OK, unix.AT_EACCESS); err != nil {
+		return denied("cannot write to directory %s", dir)
+	}
+	return nil
+}
+
+// CanReplace returns an error wrapping ErrDenied if the file at the given path
+// cannot be removed, renamed over or replaced by the current user.
+func CanReplace(path string) error {
+	dir := filepath.Dir(path)
+	if err := writable(dir); err != nil {
+		return err
+	}
+	// in sticky directories (e.g. /tmp), only the owners of the file or of
+	// the directory can remove it
+	parent, err := os.Stat(dir)
+	if err != nil || parent.Mode()&fs.ModeSticky == 0 {
+		return nil
+	}
+	euid := uint32(os.Geteuid())
+	if euid == 0 {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	if owner(info) != euid && owner(parent) != euid {
+		return denied("%s is not owned by the current user in sticky directory %s", path, dir)
+	}
+	return nil
+}
+
+// owner returns the owner of the file.
+func owner(info fs.FileInfo) uint32 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Uid
+	}
+	return ^uint32(0)
+}
+
+// Own gives the file (or symbolic link) at the given path the owner and group
+// of the file described by info, if they differ from those of the current
+// user; this requires root privileges.
+func Own(info fs.FileInfo, path string) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || (int(stat.Uid) == os.Geteuid() && int(stat.Gid) == os.Getegid()) {
+		return nil
+	}
+	return os.Lchown(path, int(stat.Uid), int(stat.Gid))
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package privileges
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies the extended attributes of the source file to the target
+// file; attributes that cannot be set by the current user (e.g. in the
+// trusted or security namespaces) are skipped.
+func copyXattrs(source string, target string) error {
+	size, err := unix.Llistxattr(source, nil)
+	if err != nil || size == 0 {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+	list := make([]byte, size)
+	if size, err = unix.Llistxattr(source, list); err != nil {
+		return err
+	}
+	var failed error
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attribute := string(name)
+		length, err := unix.Lgetxattr(source, attribute, nil)
+		if err != nil {
+			failed = err
+			continue
+		}
+		value := make([]byte, length)
+		if length, err = unix.Lgetxattr(source, attribute, value); err != nil {
+			failed = err
+			continue
+		}
+		if err := unix.Lsetxattr(target, attribute, value[:length], 0); err != nil && !errors.Is(err, unix.EPERM) {
+			failed = err
+		}
+	}
+	return failed
+}
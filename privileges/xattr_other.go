@@ -0,0 +1,9 @@
+//go:build !linux
+
+package privileges
+
+// copyXattrs does nothing, since extended attributes are not copied on this
+// platform.
+func copyXattrs(source string, target string) error {
+	return nil
+}